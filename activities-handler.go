@@ -81,7 +81,7 @@ func (s *APIServer) CreateActivity(w http.ResponseWriter, r *http.Request, bodyB
 		return respondWithError(requestLog, "name already in use", nil)
 	}
 
-	activity, err := s.Storage.ActivitiesStorage.Create(reqBody)
+	activity, err := s.Storage.ActivitiesStorage.Create(reqBody, s.newAudit(r, "activities", auditActionCreate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -124,10 +124,11 @@ func (s *APIServer) UpdateActivity(w http.ResponseWriter, r *http.Request, bodyB
 		return respondWithError(requestLog, "name already in use", nil)
 	}
 
-	updatedActivity, err := s.Storage.ActivitiesStorage.Update(id, reqBody)
+	updatedActivity, err := s.Storage.ActivitiesStorage.Update(id, reqBody, s.newAudit(r, "activities", auditActionUpdate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
 	// if updatedActivity == nil {
 	// 	return respondWithError(requestLog, "data activities not found", err)
 	// }
@@ -152,10 +153,11 @@ func (s *APIServer) DeleteActivity(w http.ResponseWriter, r *http.Request, bodyB
 		return respondWithError(requestLog, message, err)
 	}
 
-	deletedActivity, err := s.Storage.ActivitiesStorage.Delete(id)
+	deletedActivity, err := s.Storage.ActivitiesStorage.Delete(id, s.newAudit(r, "activities", auditActionDelete))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
 	// if deletedActivity == nil {
 	// 	return respondWithError(requestLog, "data activities not found", err)
 	// }
@@ -184,7 +186,7 @@ func (s *APIServer) UpdateActivityStatusByID(w http.ResponseWriter, r *http.Requ
 		return respondWithError(requestLog, message, err)
 	}
 
-	updatedActivity, err := s.Storage.ActivitiesStorage.UpdateActive(id, reqBody)
+	updatedActivity, err := s.Storage.ActivitiesStorage.UpdateActive(id, reqBody, s.newAudit(r, "activities", auditActionUpdate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -7,10 +7,10 @@ import (
 )
 
 type ActivitiesStorage interface {
-	Create(*Activities) (*Activities, error)
-	Delete(int64) (*Activities, error)
-	Update(int64, *Activities) (*Activities, error)
-	UpdateActive(int64, *Activities) (*Activities, error)
+	Create(*Activities, *Audit) (*Activities, error)
+	Delete(int64, *Audit) (*Activities, error)
+	Update(int64, *Activities, *Audit) (*Activities, error)
+	UpdateActive(int64, *Activities, *Audit) (*Activities, error)
 	GetById(int64) (*Activities, error)
 	GetAll() ([]*Activities, error)
 	GetByName(string) (*Activities, error)
@@ -67,52 +67,51 @@ func (s *ActivitiesStore) GetAll() ([]*Activities, error) {
 	return scanActivities(rows)
 }
 
-func (s *ActivitiesStore) GetById(id int64) (*Activities, error) {
+func getActivityById(q querier, id int64) (*Activities, error) {
 	query := `SELECT id, name, description, is_active, created_at, updated_at FROM activities WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+	row := q.QueryRow(query, id)
 	return scanActivity(row)
 }
 
-func (s *ActivitiesStore) Create(activity *Activities) (*Activities, error) {
+func (s *ActivitiesStore) GetById(id int64) (*Activities, error) {
+	return getActivityById(s.db, id)
+}
+
+func (s *ActivitiesStore) Create(activity *Activities, audit *Audit) (*Activities, error) {
 	query := `INSERT INTO activities (name, description, is_active, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`
-	result, err := s.db.Exec(query, activity.Name, activity.Description, activity.IsActive, time.Now(), time.Now())
+	_, created, err := auditedExec(s.db, audit, getActivityById, 0, query, activity.Name, activity.Description, activity.IsActive, time.Now(), time.Now())
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert activity: %w", err)
 	}
-	lastInsertID, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get last insert id: %w", err)
-	}
-	return s.GetById(lastInsertID)
+	return created, nil
 }
 
-func (s *ActivitiesStore) Delete(id int64) (*Activities, error) {
-	activity, _ := s.GetById(id)
-	if activity == nil {
-		return nil, fmt.Errorf("activity not found")
-	}
+func (s *ActivitiesStore) Delete(id int64, audit *Audit) (*Activities, error) {
 	query := `DELETE FROM activities WHERE id = ?`
-	_, err := s.db.Exec(query, id)
+	activity, _, err := auditedExec(s.db, audit, getActivityById, id, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete activity: %w", err)
 	}
+	if activity == nil {
+		return nil, fmt.Errorf("activity not found")
+	}
 	return activity, nil
 }
 
-func (s *ActivitiesStore) Update(id int64, activity *Activities) (*Activities, error) {
+func (s *ActivitiesStore) Update(id int64, activity *Activities, audit *Audit) (*Activities, error) {
 	query := `UPDATE activities SET name = ?, description = ?, is_active = ?, updated_at = ? WHERE id = ?`
-	_, err := s.db.Exec(query, activity.Name, activity.Description, activity.IsActive, time.Now(), id)
+	_, updated, err := auditedExec(s.db, audit, getActivityById, id, query, activity.Name, activity.Description, activity.IsActive, time.Now(), id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update activity: %w", err)
 	}
-	return s.GetById(id)
+	return updated, nil
 }
 
-func (s *ActivitiesStore) UpdateActive(id int64, activity *Activities) (*Activities, error) {
+func (s *ActivitiesStore) UpdateActive(id int64, activity *Activities, audit *Audit) (*Activities, error) {
 	query := `UPDATE activities SET is_active = ?, updated_at = ? WHERE id = ?`
-	_, err := s.db.Exec(query, activity.IsActive, time.Now(), id)
+	_, updated, err := auditedExec(s.db, audit, getActivityById, id, query, activity.IsActive, time.Now(), id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update activity: %w", err)
 	}
-	return s.GetById(id)
+	return updated, nil
 }
@@ -39,14 +39,22 @@ func WriteJSON(w http.ResponseWriter, status int, data interface{}) error {
 }
 
 type APIServer struct {
-	ListenAddr string
-	Storage    Storage
+	ListenAddr        string
+	Storage           Storage
+	LoginThrottle     *LoginThrottle
+	RateLimiter       *RateLimiter
+	OIDC              *OIDCProvider
+	LocalLoginEnabled bool
 }
 
-func NewAPIServer(listenAddr string, storage *Storage) *APIServer {
+func NewAPIServer(listenAddr string, storage *Storage, rateLimiter *RateLimiter) *APIServer {
 	return &APIServer{
-		ListenAddr: listenAddr,
-		Storage:    *storage,
+		ListenAddr:        listenAddr,
+		Storage:           *storage,
+		LoginThrottle:     NewLoginThrottle(),
+		RateLimiter:       rateLimiter,
+		OIDC:              NewOIDCProvider(),
+		LocalLoginEnabled: boolFromEnv("LOCAL_LOGIN_ENABLED", true),
 	}
 }
 
@@ -54,9 +62,56 @@ func (s *APIServer) Run() {
 	router := mux.NewRouter()
 
 	router.Use(s.addJobid)
+	router.Use(s.RateLimit)
+	router.HandleFunc("/.well-known/jwks.json", s.GetJWKS).Methods("GET")
+
 	// User routes
 	userRouter := router.PathPrefix("/user").Subrouter()
 	userRouter.HandleFunc("/login", s.prepareAndHandleRequest(s.UserLogin)).Methods("POST")
+	userRouter.HandleFunc("/login/2fa", s.prepareAndHandleRequest(s.UserLoginTwoFactor)).Methods("POST")
+	userRouter.HandleFunc("/login/2fa/enroll", s.prepareAndHandleRequest(s.UserLoginTwoFactorEnroll)).Methods("POST")
+	userRouter.HandleFunc("/login/2fa/activate", s.prepareAndHandleRequest(s.UserLoginTwoFactorActivate)).Methods("POST")
+	userRouter.HandleFunc("/oidc/login", s.prepareAndHandleRequest(s.UserOIDCLogin)).Methods("GET")
+	userRouter.HandleFunc("/oidc/callback", s.prepareAndHandleRequest(s.UserOIDCCallback)).Methods("GET", "POST")
+	userRouter.Handle("/oidc/link", s.Authenticate(s.prepareAndHandleRequest(s.UserOIDCLink))).Methods("POST")
+	userRouter.HandleFunc("/refresh", s.prepareAndHandleRequest(s.UserRefresh)).Methods("POST")
+	userRouter.Handle("/logout", s.Authenticate(s.prepareAndHandleRequest(s.UserLogout))).Methods("POST")
+	userRouter.Handle("/password", s.Authenticate(s.prepareAndHandleRequest(s.UserChangePassword))).Methods("PUT")
+	userRouter.Handle("/2fa/enroll", s.Authenticate(s.prepareAndHandleRequest(s.UserTwoFactorEnroll))).Methods("POST")
+	userRouter.Handle("/2fa/activate", s.Authenticate(s.prepareAndHandleRequest(s.UserTwoFactorActivate))).Methods("POST")
+	userRouter.Handle("/2fa/disable", s.Authenticate(s.prepareAndHandleRequest(s.UserTwoFactorDisable))).Methods("POST")
+	userRouter.Handle("/unlock", s.Authenticate(s.RequireRole("admin")(s.prepareAndHandleRequest(s.UserUnlock)))).Methods("PUT")
+
+	// API keys routes
+	apiKeysRouter := router.PathPrefix("/api-keys").Subrouter()
+	apiKeysRouter.Use(s.Authenticate)
+	apiKeysRouter.Use(s.RequireRole("admin"))
+	apiKeysRouter.HandleFunc("", s.prepareAndHandleRequest(s.GetAllApiKeys)).Methods("GET")
+	apiKeysRouter.HandleFunc("", s.prepareAndHandleRequest(s.CreateApiKey)).Methods("POST")
+	apiKeysRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.GetApiKeyByID)).Methods("GET")
+	apiKeysRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.RevokeApiKey)).Methods("DELETE")
+
+	// User identities routes
+	userIdentitiesRouter := router.PathPrefix("/user-identities").Subrouter()
+	userIdentitiesRouter.Use(s.Authenticate)
+	userIdentitiesRouter.Use(s.RequireRole("admin"))
+	userIdentitiesRouter.HandleFunc("", s.prepareAndHandleRequest(s.GetAllUserIdentities)).Methods("GET")
+	userIdentitiesRouter.HandleFunc("", s.prepareAndHandleRequest(s.CreateUserIdentity)).Methods("POST")
+	userIdentitiesRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.DeleteUserIdentity)).Methods("DELETE")
+
+	// Roles routes
+	rolesRouter := router.PathPrefix("/roles").Subrouter()
+	rolesRouter.Use(s.Authenticate)
+	rolesRouter.Use(s.RequireRole("admin"))
+	rolesRouter.HandleFunc("", s.prepareAndHandleRequest(s.GetAllRoles)).Methods("GET")
+	rolesRouter.HandleFunc("/{id}/require-2fa", s.prepareAndHandleRequest(s.UpdateRoleTwoFactor)).Methods("PUT")
+
+	// Audit routes
+	auditRouter := router.PathPrefix("/audit").Subrouter()
+	auditRouter.Use(s.Authenticate)
+	auditRouter.Use(s.RequireRole("admin", "auditor"))
+	auditRouter.HandleFunc("", s.prepareAndHandleRequest(s.GetAllAuditLogs)).Methods("GET")
+	auditRouter.HandleFunc("/verify", s.prepareAndHandleRequest(s.VerifyAuditLogs)).Methods("GET")
 
 	// Budgets routes
 	budgetsRouter := router.PathPrefix("/budgets").Subrouter()
@@ -66,7 +121,17 @@ func (s *APIServer) Run() {
 	budgetsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.GetBudgetByID)).Methods("GET")
 	budgetsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.UpdateBudget)).Methods("PUT")
 	budgetsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.DeleteBudget)).Methods("DELETE")
-	budgetsRouter.HandleFunc("/approve/{id}", s.prepareAndHandleRequest(s.UpdateBudgetApproval)).Methods("PUT")
+	budgetsRouter.HandleFunc("/{id}/transitions", s.prepareAndHandleRequest(s.GetBudgetTransitions)).Methods("GET")
+	budgetsRouter.HandleFunc("/{id}/cap-utilization", s.prepareAndHandleRequest(s.GetBudgetCapUtilization)).Methods("GET")
+	budgetsRouter.HandleFunc("/{id}/ledger-summary", s.prepareAndHandleRequest(s.GetBudgetLedgerSummary)).Methods("GET")
+	budgetsRouter.HandleFunc("/{id}/clone", s.prepareAndHandleRequest(s.CloneBudget)).Methods("POST")
+	budgetsRouter.HandleFunc("/{id}/revisions", s.prepareAndHandleRequest(s.GetBudgetRevisions)).Methods("GET")
+	budgetsRouter.HandleFunc("/{id}/revisions", s.prepareAndHandleRequest(s.CreateBudgetRevision)).Methods("POST")
+	budgetsRouter.HandleFunc("/{id}/revisions/diff", s.prepareAndHandleRequest(s.GetBudgetRevisionDiff)).Methods("GET")
+	budgetsRouter.HandleFunc("/{id}/revisions/{revision:[0-9]+}", s.prepareAndHandleRequest(s.GetBudgetRevision)).Methods("GET")
+	for action := range budgetWorkflow.Transitions {
+		budgetsRouter.HandleFunc("/{id}/"+action, s.prepareAndHandleRequest(s.BudgetTransition(action))).Methods("POST")
+	}
 
 	// Activities routes
 	activitiesRouter := router.PathPrefix("/activities").Subrouter()
@@ -88,6 +153,16 @@ func (s *APIServer) Run() {
 	budgetPostsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.DeleteBudgetPost)).Methods("DELETE")
 	budgetPostsRouter.HandleFunc("/active/{id}", s.prepareAndHandleRequest(s.UpdateBudgetPostActiveByID)).Methods("PUT")
 
+	// Fiscal periods routes
+	fiscalPeriodsRouter := router.PathPrefix("/fiscal-periods").Subrouter()
+	fiscalPeriodsRouter.Use(s.Authenticate)
+	requireAdmin := s.RequireRole("admin")
+	fiscalPeriodsRouter.HandleFunc("", s.prepareAndHandleRequest(s.GetAllFiscalPeriods)).Methods("GET")
+	fiscalPeriodsRouter.Handle("", requireAdmin(s.prepareAndHandleRequest(s.CreateFiscalPeriod))).Methods("POST")
+	fiscalPeriodsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.GetFiscalPeriodByID)).Methods("GET")
+	fiscalPeriodsRouter.Handle("/{id}", requireAdmin(s.prepareAndHandleRequest(s.UpdateFiscalPeriod))).Methods("PUT")
+	fiscalPeriodsRouter.Handle("/{id}", requireAdmin(s.prepareAndHandleRequest(s.DeleteFiscalPeriod))).Methods("DELETE")
+
 	// Budget caps routes
 	budgetCapsRouter := router.PathPrefix("/budget-caps").Subrouter()
 	budgetCapsRouter.Use(s.Authenticate)
@@ -97,6 +172,24 @@ func (s *APIServer) Run() {
 	budgetCapsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.UpdateBudgetCap)).Methods("PUT")
 	budgetCapsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.DeleteBudgetCap)).Methods("DELETE")
 
+	// Ledger routes
+	ledgerRouter := router.PathPrefix("/ledger").Subrouter()
+	ledgerRouter.Use(s.Authenticate)
+	ledgerRouter.HandleFunc("/entries", s.prepareAndHandleRequest(s.GetLedgerEntries)).Methods("GET")
+	ledgerRouter.HandleFunc("/balances", s.prepareAndHandleRequest(s.GetLedgerBalances)).Methods("GET")
+	ledgerRouter.HandleFunc("/trial-balance", s.prepareAndHandleRequest(s.GetLedgerTrialBalance)).Methods("GET")
+
+	// Budget reallocations routes
+	budgetReallocationsRouter := router.PathPrefix("/budget-reallocations").Subrouter()
+	budgetReallocationsRouter.Use(s.Authenticate)
+	budgetReallocationsRouter.HandleFunc("", s.prepareAndHandleRequest(s.GetAllBudgetReallocations)).Methods("GET")
+	budgetReallocationsRouter.HandleFunc("", s.prepareAndHandleRequest(s.CreateBudgetReallocation)).Methods("POST")
+	budgetReallocationsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.GetBudgetReallocationByID)).Methods("GET")
+	budgetReallocationsRouter.HandleFunc("/{id}/transitions", s.prepareAndHandleRequest(s.GetBudgetReallocationTransitions)).Methods("GET")
+	for action := range reallocationWorkflow.Transitions {
+		budgetReallocationsRouter.HandleFunc("/{id}/"+action, s.prepareAndHandleRequest(s.BudgetReallocationTransition(action))).Methods("POST")
+	}
+
 	// Budget details routes
 	budgetDetailsRouter := router.PathPrefix("/budget-details").Subrouter()
 	budgetDetailsRouter.Use(s.Authenticate)
@@ -114,6 +207,18 @@ func (s *APIServer) Run() {
 	budgetDetailsPostsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.GetBudgetDetailPostByID)).Methods("GET")
 	budgetDetailsPostsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.UpdateBudgetDetailPost)).Methods("PUT")
 	budgetDetailsPostsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.DeleteBudgetDetailPost)).Methods("DELETE")
+	budgetDetailsPostsRouter.Handle("/{id}/consolidate", s.RequireRole("budget_reviewer", "budget_approver")(s.prepareAndHandleRequest(s.ConsolidateBudgetDetailPost))).Methods("POST")
+	budgetDetailsPostsRouter.HandleFunc("/{id}/consolidations", s.prepareAndHandleRequest(s.GetBudgetDetailPostConsolidations)).Methods("GET")
+
+	// Consolidation policies routes
+	consolidationPoliciesRouter := router.PathPrefix("/consolidation-policies").Subrouter()
+	consolidationPoliciesRouter.Use(s.Authenticate)
+	consolidationPoliciesRouter.Use(s.RequireRole("admin"))
+	consolidationPoliciesRouter.HandleFunc("", s.prepareAndHandleRequest(s.GetAllConsolidationPolicies)).Methods("GET")
+	consolidationPoliciesRouter.HandleFunc("", s.prepareAndHandleRequest(s.CreateConsolidationPolicy)).Methods("POST")
+	consolidationPoliciesRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.GetConsolidationPolicyByID)).Methods("GET")
+	consolidationPoliciesRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.UpdateConsolidationPolicy)).Methods("PUT")
+	consolidationPoliciesRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.DeleteConsolidationPolicy)).Methods("DELETE")
 
 	// Fund requests routes
 	fundRequestsRouter := router.PathPrefix("/fund-requests").Subrouter()
@@ -123,6 +228,28 @@ func (s *APIServer) Run() {
 	fundRequestsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.GetFundRequestByID)).Methods("GET")
 	fundRequestsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.UpdateFundRequest)).Methods("PUT")
 	fundRequestsRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.DeleteFundRequest)).Methods("DELETE")
+	fundRequestsRouter.HandleFunc("/{id}/transitions", s.prepareAndHandleRequest(s.GetFundRequestTransitions)).Methods("GET")
+	fundRequestsRouter.HandleFunc("/{id}/approvals", s.prepareAndHandleRequest(s.GetFundRequestApprovals)).Methods("GET")
+	fundRequestsRouter.HandleFunc("/{id}/settlement", s.prepareAndHandleRequest(s.GetFundRequestSettlement)).Methods("GET")
+	fundRequestsRouter.HandleFunc("/{id}/settlement", s.prepareAndHandleRequest(s.CreateFundRequestSettlement)).Methods("POST")
+	fundRequestsRouter.HandleFunc("/{id}/settlement", s.prepareAndHandleRequest(s.UpdateFundRequestSettlement)).Methods("PUT")
+	fundRequestsRouter.HandleFunc("/{id}/settlement/transitions", s.prepareAndHandleRequest(s.GetFundRequestSettlementTransitions)).Methods("GET")
+	for action := range settlementWorkflow.Transitions {
+		fundRequestsRouter.HandleFunc("/{id}/settlement/"+action, s.prepareAndHandleRequest(s.FundRequestSettlementTransition(action))).Methods("POST")
+	}
+	for action := range fundRequestWorkflow.Transitions {
+		fundRequestsRouter.HandleFunc("/{id}/"+action, s.prepareAndHandleRequest(s.FundRequestTransition(action))).Methods("POST")
+	}
+
+	// Approval matrix routes
+	approvalMatrixRouter := router.PathPrefix("/approval-matrix").Subrouter()
+	approvalMatrixRouter.Use(s.Authenticate)
+	approvalMatrixRouter.Use(s.RequireRole("admin"))
+	approvalMatrixRouter.HandleFunc("", s.prepareAndHandleRequest(s.GetAllApprovalMatrix)).Methods("GET")
+	approvalMatrixRouter.HandleFunc("", s.prepareAndHandleRequest(s.CreateApprovalMatrix)).Methods("POST")
+	approvalMatrixRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.GetApprovalMatrixByID)).Methods("GET")
+	approvalMatrixRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.UpdateApprovalMatrix)).Methods("PUT")
+	approvalMatrixRouter.HandleFunc("/{id}", s.prepareAndHandleRequest(s.DeleteApprovalMatrix)).Methods("DELETE")
 
 	// Fund request details routes
 	fundRequestDetailsRouter := router.PathPrefix("/fund-request-details").Subrouter()
@@ -166,6 +293,11 @@ func (s *APIServer) Run() {
 	log.Fatal(http.ListenAndServe(s.ListenAddr, router))
 }
 
+func (s *APIServer) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"keys": keyRing.JWKS()})
+}
+
 func (s *APIServer) addJobid(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		jobID := JobID()
@@ -179,7 +311,7 @@ func (s *APIServer) prepareAndHandleRequest(handlerFunc func(http.ResponseWriter
 		jobID := r.Header.Get("jobID")
 		bodyBytes, requestLog, err := s.prepareRequest(r)
 		if err != nil {
-			AppLog(LogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": err.Error()}))
+			AppLogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": err.Error()})
 			WriteJSON(w, http.StatusBadRequest, APIError{
 				Status:  "error",
 				JobID:   jobID,
@@ -221,6 +353,22 @@ func (s *APIServer) GetID(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+func (s *APIServer) GetUserClaims(r *http.Request) (*UserClaims, error) {
+	claims, ok := r.Context().Value(userContextKey).(*UserClaims)
+	if !ok {
+		return nil, fmt.Errorf("user not found in request")
+	}
+	return claims, nil
+}
+
+func (s *APIServer) GetUserID(r *http.Request) (int64, error) {
+	claims, err := s.GetUserClaims(r)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UsersID()
+}
+
 func ReadAndRestoreRequestBody(r *http.Request) ([]byte, error) {
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const apiKeyPrefix = "bk"
+
+var apiKeyScopePattern = regexp.MustCompile(`^(\*|[a-z-]+:(read|write|\*))$`)
+
+func validateApiKeysRequest(reqBody *ApiKeys) error {
+	if reqBody.Name == "" {
+		return fmt.Errorf("name must be filled")
+	} else if len(reqBody.Name) > 255 {
+		return fmt.Errorf("max length name 255")
+	} else if reqBody.UsersID <= 0 {
+		return fmt.Errorf("users id must be filled")
+	} else if len(reqBody.Scopes) == 0 {
+		return fmt.Errorf("scopes must be filled")
+	} else if reqBody.ExpiresAt != nil && reqBody.ExpiresAt.Before(time.Now()) {
+		return fmt.Errorf("expires at must be in the future")
+	}
+	for _, scope := range reqBody.Scopes {
+		if !apiKeyScopePattern.MatchString(scope) {
+			return fmt.Errorf("invalid scope: %s", scope)
+		}
+	}
+	return nil
+}
+
+func (s *APIServer) GetAllApiKeys(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	apiKeys, err := s.Storage.ApiKeysStorage.GetAll()
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, apiKeys)
+
+}
+
+func (s *APIServer) GetApiKeyByID(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	apiKey, err := s.Storage.ApiKeysStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, apiKey)
+
+}
+
+func (s *APIServer) CreateApiKey(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	createdBy, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	reqBody := &ApiKeys{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateApiKeysRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	user, err := s.Storage.UsersStorage.GetById(reqBody.UsersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if user == nil {
+		return respondWithError(requestLog, "user not found", nil)
+	}
+
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return respondWithError(requestLog, "error creating api key", err)
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+
+	secret, err := newRandomToken(32)
+	if err != nil {
+		return respondWithError(requestLog, "error creating api key", err)
+	}
+
+	key := apiKeyPrefix + "_" + prefix + "_" + secret
+	reqBody.Prefix = prefix
+	reqBody.KeyHash = hashToken(key)
+	reqBody.CreatedBy = createdBy
+
+	apiKey, err := s.Storage.ApiKeysStorage.Create(reqBody, s.newAudit(r, "api-keys", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	// The key itself is only ever returned here.
+	return respondWithSuccessStruct(requestLog, map[string]interface{}{
+		"status":  "success",
+		"data":    apiKey,
+		"api_key": key,
+	})
+
+}
+
+func (s *APIServer) RevokeApiKey(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	apiKey, err := s.Storage.ApiKeysStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if apiKey == nil {
+		return respondWithError(requestLog, "api key not found", nil)
+	}
+
+	revokedApiKey, err := s.Storage.ApiKeysStorage.Revoke(id, s.newAudit(r, "api-keys", auditActionRevoke))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, revokedApiKey)
+
+}
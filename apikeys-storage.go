@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type ApiKeysStorage interface {
+	Create(*ApiKeys, *Audit) (*ApiKeys, error)
+	Revoke(int64, *Audit) (*ApiKeys, error)
+	GetById(int64) (*ApiKeys, error)
+	GetByPrefix(string) (*ApiKeys, error)
+	GetAll() ([]*ApiKeys, error)
+	TouchLastUsed(int64) error
+}
+
+type ApiKeysStore struct {
+	db *sql.DB
+}
+
+func NewApiKeysStorage(db *sql.DB) *ApiKeysStore {
+	return &ApiKeysStore{
+		db: db,
+	}
+}
+
+type apiKeyScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanApiKey(row apiKeyScanner) (*ApiKeys, error) {
+	apiKey := &ApiKeys{}
+	var scopes string
+	var expiresAt, revokedAt, lastUsedAt sql.NullTime
+	err := row.Scan(&apiKey.ID, &apiKey.Name, &apiKey.UsersID, &apiKey.Prefix, &apiKey.KeyHash, &scopes, &expiresAt, &revokedAt, &lastUsedAt, &apiKey.CreatedBy, &apiKey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	apiKey.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		apiKey.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		apiKey.RevokedAt = &revokedAt.Time
+	}
+	if lastUsedAt.Valid {
+		apiKey.LastUsedAt = &lastUsedAt.Time
+	}
+	return apiKey, nil
+}
+
+func getApiKey(q querier, query string, args ...any) (*ApiKeys, error) {
+	apiKey, err := scanApiKey(q.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return apiKey, nil
+}
+
+func (s *ApiKeysStore) GetAll() ([]*ApiKeys, error) {
+	query := `SELECT id, name, users_id, key_prefix, key_hash, scopes, expires_at, revoked_at, last_used_at, created_by, created_at FROM api_keys`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var apiKeys []*ApiKeys
+	for rows.Next() {
+		apiKey, err := scanApiKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return apiKeys, nil
+}
+
+func getApiKeyById(q querier, id int64) (*ApiKeys, error) {
+	query := `SELECT id, name, users_id, key_prefix, key_hash, scopes, expires_at, revoked_at, last_used_at, created_by, created_at FROM api_keys WHERE id = ?`
+	return getApiKey(q, query, id)
+}
+
+func (s *ApiKeysStore) GetById(id int64) (*ApiKeys, error) {
+	return getApiKeyById(s.db, id)
+}
+
+func (s *ApiKeysStore) GetByPrefix(prefix string) (*ApiKeys, error) {
+	query := `SELECT id, name, users_id, key_prefix, key_hash, scopes, expires_at, revoked_at, last_used_at, created_by, created_at FROM api_keys WHERE key_prefix = ?`
+	return getApiKey(s.db, query, prefix)
+}
+
+func (s *ApiKeysStore) Create(apiKey *ApiKeys, audit *Audit) (*ApiKeys, error) {
+	query := `INSERT INTO api_keys (name, users_id, key_prefix, key_hash, scopes, expires_at, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, now())`
+	_, created, err := auditedExec(s.db, audit, getApiKeyById, 0, query, apiKey.Name, apiKey.UsersID, apiKey.Prefix, apiKey.KeyHash, strings.Join(apiKey.Scopes, " "), apiKey.ExpiresAt, apiKey.CreatedBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert api key: %w", err)
+	}
+	return created, nil
+}
+
+func (s *ApiKeysStore) Revoke(id int64, audit *Audit) (*ApiKeys, error) {
+	query := `UPDATE api_keys SET revoked_at = now() WHERE id = ? AND revoked_at IS NULL`
+	_, revoked, err := auditedExec(s.db, audit, getApiKeyById, id, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	return revoked, nil
+}
+
+func (s *ApiKeysStore) TouchLastUsed(id int64) error {
+	query := `UPDATE api_keys SET last_used_at = now() WHERE id = ?`
+	_, err := s.db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to update api key usage: %w", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,7 +11,6 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 )
@@ -112,7 +112,18 @@ func AppLog(v ...any) {
 		applog = NewLogger("./log", "log", true)
 		defer applog.End()
 	}
-	applog.WriteLine(v...)
+	applog.WriteString(logRedactor.scrub(fmt.Sprint(v...)))
+}
+
+// AppLogRequestResponse writes a request/response pair. The pair is redacted
+// field by field, so the line is not scrubbed again; that would mask large
+// amounts in the JSON as if they were card numbers.
+func AppLogRequestResponse(requestLog, responseLog map[string]interface{}) {
+	if applog == nil {
+		applog = NewLogger("./log", "log", true)
+		defer applog.End()
+	}
+	applog.WriteString(LogRequestResponse(requestLog, responseLog))
 }
 
 // ------------------------------ HANDLE REQUEST RESPONSE REST API -----------------------------------------------------
@@ -138,30 +149,21 @@ func BodyToJSONSlices(body io.Reader) ([]map[string]interface{}, error) {
 func LogRequest(r *http.Request, bodyBytes []byte) map[string]interface{} {
 	bodyJSON, err := BodyToJSONSlices(bytes.NewBuffer(bodyBytes))
 	if err != nil {
-		rawBody := string(bodyBytes)
-		rawBody = strings.ReplaceAll(rawBody, "\n", "")
-		rawBody = strings.ReplaceAll(rawBody, "\r", "")
+		// A body that does not parse cannot be redacted field by field, so
+		// only its size and a hash are logged.
+		sum := sha256.Sum256(bodyBytes)
 		bodyJSON = []map[string]interface{}{
-			{"raw_body": rawBody},
+			{"unparsed_body": fmt.Sprintf("%d bytes, sha256 %x", len(bodyBytes), sum)},
 		}
 	}
 
-	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		clientIP = r.RemoteAddr
-	}
+	clientIP := ClientIP(r)
 
 	userAgent := r.UserAgent()
 
 	currentTime := time.Now().Format("2006-01-02 15:04:05 .000")
 
-	headersCopy := make(http.Header)
-	for key, values := range r.Header {
-		if key == "Authorization" {
-			continue
-		}
-		headersCopy[key] = values
-	}
+	headersCopy := r.Header.Clone()
 
 	requestLog := map[string]interface{}{
 		"body":      bodyJSON,
@@ -173,9 +175,21 @@ func LogRequest(r *http.Request, bodyBytes []byte) map[string]interface{} {
 		"agent":     userAgent,
 	}
 
+	if claims, ok := r.Context().Value(userContextKey).(*UserClaims); ok && claims.APIKeyID > 0 {
+		requestLog["api_key_id"] = claims.APIKeyID
+	}
+
 	return requestLog
 }
 
+func ClientIP(r *http.Request) string {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	return clientIP
+}
+
 func LogResponseError(status string, message string) map[string]interface{} {
 
 	responseLog := map[string]interface{}{
@@ -193,27 +207,22 @@ func LogResponseSuccess(data interface{}) map[string]interface{} {
 	return responseLog
 }
 
+// LogResponseSuccessMap copies the response for logging; secrets are masked
+// by LogRequestResponse.
 func LogResponseSuccessMap(responseLog map[string]interface{}) map[string]interface{} {
-	sensitiveKeys := map[string]bool{"token": true, "pwd": true, "password": true}
-
-	response := make(map[string]interface{})
+	response := make(map[string]interface{}, len(responseLog))
 	for k, v := range responseLog {
-		if _, exists := sensitiveKeys[k]; exists {
-			response[k] = "*"
-		} else {
-			response[k] = v
-		}
+		response[k] = v
 	}
-
 	return response
 }
 
 func LogRequestResponse(requestLog, responseLog map[string]interface{}) string {
 
-	logData := map[string]interface{}{
+	logData := logRedactor.Redact(map[string]interface{}{
 		"request":  requestLog,
 		"response": responseLog,
-	}
+	})
 
 	logJSON, err := json.Marshal(logData)
 	if err != nil {
@@ -234,18 +243,18 @@ func respondWithError(requestLog map[string]interface{}, message string, err err
 
 	AppLog("ini apa", emptyErr)
 	responseLog := LogResponseError("error", emptyErr)
-	AppLog(LogRequestResponse(requestLog, responseLog))
+	AppLogRequestResponse(requestLog, responseLog)
 	return nil, fmt.Errorf("%s", message)
 }
 
 func respondWithSuccess(requestLog map[string]interface{}, data interface{}) (interface{}, error) {
 	responseLog := LogResponseSuccess(data)
-	AppLog(LogRequestResponse(requestLog, responseLog))
+	AppLogRequestResponse(requestLog, responseLog)
 	return responseLog, nil
 }
 
 func respondWithSuccessStruct(requestLog map[string]interface{}, mapData map[string]interface{}) (interface{}, error) {
 	checkMapData := LogResponseSuccessMap(mapData)
-	AppLog(LogRequestResponse(requestLog, checkMapData))
+	AppLogRequestResponse(requestLog, checkMapData)
 	return mapData, nil
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func validateApprovalMatrixRequest(reqBody *ApprovalMatrix) error {
+	reqBody.ApproverRole = strings.TrimSpace(reqBody.ApproverRole)
+	if reqBody.Level < 1 {
+		return fmt.Errorf("level must be at least 1")
+	} else if reqBody.ApproverRole == "" {
+		return fmt.Errorf("approver role must be filled")
+	} else if reqBody.MinAmount < 0 {
+		return fmt.Errorf("min amount must not be negative")
+	} else if reqBody.MaxAmount != nil && *reqBody.MaxAmount <= reqBody.MinAmount {
+		return fmt.Errorf("max amount must be greater than min amount")
+	} else if reqBody.UnitsID < 0 || reqBody.BudgetPostsID < 0 {
+		return fmt.Errorf("invalid units id or budget posts id")
+	}
+	return nil
+}
+
+func (s *APIServer) GetAllApprovalMatrix(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	rules, err := s.Storage.ApprovalMatrixStorage.GetAll()
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, rules)
+
+}
+
+func (s *APIServer) GetApprovalMatrixByID(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	rule, err := s.Storage.ApprovalMatrixStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if rule == nil {
+		return respondWithError(requestLog, "approval matrix not found", nil)
+	}
+	return respondWithSuccess(requestLog, rule)
+
+}
+
+func (s *APIServer) CreateApprovalMatrix(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &ApprovalMatrix{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateApprovalMatrixRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	rule, err := s.Storage.ApprovalMatrixStorage.Create(reqBody, s.newAudit(r, "approval-matrix", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, rule)
+
+}
+
+func (s *APIServer) UpdateApprovalMatrix(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reqBody := &ApprovalMatrix{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateApprovalMatrixRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	before, err := s.Storage.ApprovalMatrixStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if before == nil {
+		return respondWithError(requestLog, "approval matrix not found", nil)
+	}
+
+	updatedRule, err := s.Storage.ApprovalMatrixStorage.Update(id, reqBody, s.newAudit(r, "approval-matrix", auditActionUpdate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, updatedRule)
+
+}
+
+func (s *APIServer) DeleteApprovalMatrix(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	deletedRule, err := s.Storage.ApprovalMatrixStorage.Delete(id, s.newAudit(r, "approval-matrix", auditActionDelete))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if deletedRule == nil {
+		return respondWithError(requestLog, "approval matrix not found", nil)
+	}
+
+	return respondWithSuccess(requestLog, deletedRule)
+
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+type ApprovalMatrixStorage interface {
+	Create(*ApprovalMatrix, *Audit) (*ApprovalMatrix, error)
+	Delete(int64, *Audit) (*ApprovalMatrix, error)
+	Update(int64, *ApprovalMatrix, *Audit) (*ApprovalMatrix, error)
+	GetById(int64) (*ApprovalMatrix, error)
+	GetAll() ([]*ApprovalMatrix, error)
+	Match(int64, int64, float64) ([]*ApprovalMatrix, error)
+}
+
+type ApprovalMatrixStore struct {
+	db *sql.DB
+}
+
+func NewApprovalMatrixStorage(db *sql.DB) *ApprovalMatrixStore {
+	return &ApprovalMatrixStore{
+		db: db,
+	}
+}
+
+type approvalMatrixScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanApprovalMatrix(row approvalMatrixScanner) (*ApprovalMatrix, error) {
+	rule := &ApprovalMatrix{}
+	var unitsID, budgetPostsID sql.NullInt64
+	var maxAmount sql.NullFloat64
+	err := row.Scan(&rule.ID, &unitsID, &budgetPostsID, &rule.MinAmount, &maxAmount, &rule.Level, &rule.ApproverRole, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	rule.UnitsID = unitsID.Int64
+	rule.BudgetPostsID = budgetPostsID.Int64
+	if maxAmount.Valid {
+		rule.MaxAmount = &maxAmount.Float64
+	}
+	return rule, nil
+}
+
+func (s *ApprovalMatrixStore) query(query string, args ...any) ([]*ApprovalMatrix, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get approval matrix: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []*ApprovalMatrix
+	for rows.Next() {
+		rule, err := scanApprovalMatrix(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan approval matrix: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (s *ApprovalMatrixStore) GetAll() ([]*ApprovalMatrix, error) {
+	query := `SELECT id, units_id, budget_posts_id, min_amount, max_amount, level, approver_role, created_at, updated_at FROM approval_matrix ORDER BY units_id, budget_posts_id, min_amount, level`
+	return s.query(query)
+}
+
+func getApprovalMatrixById(q querier, id int64) (*ApprovalMatrix, error) {
+	query := `SELECT id, units_id, budget_posts_id, min_amount, max_amount, level, approver_role, created_at, updated_at FROM approval_matrix WHERE id = ?`
+	rule, err := scanApprovalMatrix(q.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get approval matrix: %w", err)
+	}
+	return rule, nil
+}
+
+func (s *ApprovalMatrixStore) GetById(id int64) (*ApprovalMatrix, error) {
+	return getApprovalMatrixById(s.db, id)
+}
+
+// Match returns the approver levels for a request, ordered by level. Rules for
+// the exact unit and budget post win over rules that leave either open.
+func (s *ApprovalMatrixStore) Match(unitsID int64, budgetPostsID int64, amount float64) ([]*ApprovalMatrix, error) {
+	query := `SELECT id, units_id, budget_posts_id, min_amount, max_amount, level, approver_role, created_at, updated_at FROM approval_matrix
+		WHERE (units_id IS NULL OR units_id = ?) AND (budget_posts_id IS NULL OR budget_posts_id = ?)
+		AND min_amount <= ? AND (max_amount IS NULL OR max_amount > ?)`
+	rules, err := s.query(query, unitsID, budgetPostsID, amount, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	specificity := func(rule *ApprovalMatrix) int {
+		score := 0
+		if rule.UnitsID > 0 {
+			score += 2
+		}
+		if rule.BudgetPostsID > 0 {
+			score++
+		}
+		return score
+	}
+	best := -1
+	for _, rule := range rules {
+		if score := specificity(rule); score > best {
+			best = score
+		}
+	}
+
+	var matched []*ApprovalMatrix
+	for _, rule := range rules {
+		if specificity(rule) == best {
+			matched = append(matched, rule)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Level < matched[j].Level })
+	return matched, nil
+}
+
+func (s *ApprovalMatrixStore) Create(rule *ApprovalMatrix, audit *Audit) (*ApprovalMatrix, error) {
+	query := `INSERT INTO approval_matrix (units_id, budget_posts_id, min_amount, max_amount, level, approver_role, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, now(), now())`
+	_, created, err := auditedExec(s.db, audit, getApprovalMatrixById, 0, query, nullInt64(rule.UnitsID), nullInt64(rule.BudgetPostsID), rule.MinAmount, rule.MaxAmount, rule.Level, rule.ApproverRole)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert approval matrix: %w", err)
+	}
+	return created, nil
+}
+
+func (s *ApprovalMatrixStore) Update(id int64, rule *ApprovalMatrix, audit *Audit) (*ApprovalMatrix, error) {
+	query := `UPDATE approval_matrix SET units_id = ?, budget_posts_id = ?, min_amount = ?, max_amount = ?, level = ?, approver_role = ?, updated_at = now() WHERE id = ?`
+	_, updated, err := auditedExec(s.db, audit, getApprovalMatrixById, id, query, nullInt64(rule.UnitsID), nullInt64(rule.BudgetPostsID), rule.MinAmount, rule.MaxAmount, rule.Level, rule.ApproverRole, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update approval matrix: %w", err)
+	}
+	return updated, nil
+}
+
+func (s *ApprovalMatrixStore) Delete(id int64, audit *Audit) (*ApprovalMatrix, error) {
+	query := `DELETE FROM approval_matrix WHERE id = ?`
+	rule, _, err := auditedExec(s.db, audit, getApprovalMatrixById, id, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete approval matrix: %w", err)
+	}
+	return rule, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// parseAuditTime accepts RFC 3339 timestamps or plain dates.
+func parseAuditTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return &parsed, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time: %s", value)
+}
+
+func parseAuditFilter(r *http.Request) (*AuditFilter, error) {
+	query := r.URL.Query()
+	filter := &AuditFilter{
+		Entity: query.Get("entity"),
+		Limit:  auditDefaultLimit,
+	}
+
+	var err error
+	if value := query.Get("entity_id"); value != "" {
+		if filter.EntityID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid entity_id")
+		}
+	}
+	if value := query.Get("user"); value != "" {
+		if filter.UsersID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid user")
+		}
+	}
+	if filter.From, err = parseAuditTime(query.Get("from")); err != nil {
+		return nil, err
+	}
+	if filter.To, err = parseAuditTime(query.Get("to")); err != nil {
+		return nil, err
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			return nil, fmt.Errorf("invalid limit")
+		}
+		if filter.Limit > auditMaxLimit {
+			filter.Limit = auditMaxLimit
+		}
+	}
+	return filter, nil
+}
+
+func (s *APIServer) GetAllAuditLogs(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	auditLogs, err := s.Storage.AuditLogsStorage.GetAll(filter)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, auditLogs)
+
+}
+
+func (s *APIServer) VerifyAuditLogs(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	result, err := verifyAuditChain(s.Storage.AuditLogsStorage)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, result)
+
+}
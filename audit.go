@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+const (
+	auditActionCreate = "create"
+	auditActionUpdate = "update"
+	auditActionDelete = "delete"
+	auditActionRevoke = "revoke"
+)
+
+// auditIgnoredFields change on every write and would only add noise.
+var auditIgnoredFields = map[string]bool{"updated_at": true}
+
+// auditDiff compares the JSON form of two records field by field. Either side
+// may be nil, for creates and deletes.
+func auditDiff(before interface{}, after interface{}) (map[string]AuditChange, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]AuditChange{}
+	for field, value := range beforeFields {
+		if auditIgnoredFields[field] {
+			continue
+		}
+		if afterValue, ok := afterFields[field]; !ok || !reflect.DeepEqual(value, afterValue) {
+			changes[field] = AuditChange{Before: value, After: afterFields[field]}
+		}
+	}
+	for field, value := range afterFields {
+		if auditIgnoredFields[field] {
+			continue
+		}
+		if _, ok := beforeFields[field]; !ok {
+			changes[field] = AuditChange{After: value}
+		}
+	}
+	return changes, nil
+}
+
+func auditFields(record interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if record == nil || reflect.ValueOf(record).Kind() == reflect.Ptr && reflect.ValueOf(record).IsNil() {
+		return fields, nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// Audit says who makes a change. Storage methods write it in the change's
+// transaction, so the change and its audit record commit or fail together.
+// Workflow transitions use their action name, e.g. approve.
+type Audit struct {
+	JobID     string
+	UsersID   int64
+	ApiKeysID int64
+	Entity    string
+	Action    string
+}
+
+func (s *APIServer) newAudit(r *http.Request, entity string, action string) *Audit {
+	audit := &Audit{
+		JobID:  r.Header.Get("jobID"),
+		Entity: entity,
+		Action: action,
+	}
+	if claims, err := s.GetUserClaims(r); err == nil {
+		audit.UsersID, _ = claims.UsersID()
+		audit.ApiKeysID = claims.APIKeyID
+	}
+	return audit
+}
+
+func (a *Audit) record(entityID int64, before interface{}, after interface{}) (*AuditLogs, error) {
+	changes, err := auditDiff(before, after)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build audit diff: %w", err)
+	}
+	return &AuditLogs{
+		UsersID:   a.UsersID,
+		ApiKeysID: a.ApiKeysID,
+		JobID:     a.JobID,
+		Entity:    a.Entity,
+		EntityID:  entityID,
+		Action:    a.Action,
+		Changes:   changes,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// write adds the audit record of a change to tx. A nil Audit writes nothing,
+// for changes made outside a request such as the maintenance commands.
+func (a *Audit) write(tx *sql.Tx, entityID int64, before interface{}, after interface{}) error {
+	if a == nil {
+		return nil
+	}
+	auditLog, err := a.record(entityID, before, after)
+	if err != nil {
+		return err
+	}
+	return insertAuditLog(tx, auditLog)
+}
+
+// auditEvent records something that is not itself a stored change, e.g. a
+// period lock override.
+func (s *APIServer) auditEvent(r *http.Request, entity string, entityID int64, action string, after interface{}) error {
+	auditLog, err := s.newAudit(r, entity, action).record(entityID, nil, after)
+	if err != nil {
+		return err
+	}
+	return s.Storage.AuditLogsStorage.Create(auditLog)
+}
+
+// auditedExec runs one statement that changes row id of an entity and writes
+// the audit record of the change in the same transaction. An id of 0 means the
+// statement inserts the row. get reads the row inside the transaction; before
+// is nil for an insert and after is nil for a delete.
+func auditedExec[T any](db *sql.DB, audit *Audit, get func(querier, int64) (*T, error), id int64, query string, args ...any) (*T, *T, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var before *T
+	if id > 0 {
+		before, err = get(tx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if id == 0 {
+		id, err = result.LastInsertId()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get last insert id: %w", err)
+		}
+	}
+	after, err := get(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if before != nil || after != nil {
+		if err := audit.write(tx, id, before, after); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return before, after, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type AuditSigner struct {
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+var auditSigner *AuditSigner
+
+// LoadAuditSigner reads the optional Ed25519 keys for audit signatures:
+//
+//	AUDIT_SIGNING_KEY_FILE PEM private key; records are signed when set
+//	AUDIT_VERIFY_KEY_FILE  PEM public key, for verifying without the private key
+//
+// Without either, records are still hash chained but not signed.
+func LoadAuditSigner() error {
+	signer := &AuditSigner{}
+
+	if path := os.Getenv("AUDIT_SIGNING_KEY_FILE"); path != "" {
+		privateKey, err := readPrivateKey(path)
+		if err != nil {
+			return err
+		}
+		key, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return fmt.Errorf("AUDIT_SIGNING_KEY_FILE must hold an Ed25519 key")
+		}
+		signer.privateKey = key
+		signer.publicKey = key.Public().(ed25519.PublicKey)
+	}
+
+	if path := os.Getenv("AUDIT_VERIFY_KEY_FILE"); path != "" {
+		publicKey, err := readPublicKey(path)
+		if err != nil {
+			return err
+		}
+		key, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("AUDIT_VERIFY_KEY_FILE must hold an Ed25519 key")
+		}
+		signer.publicKey = key
+	}
+
+	if signer.publicKey != nil {
+		auditSigner = signer
+	}
+	return nil
+}
+
+// auditHash covers every stored field except the ID, chained to the hash of
+// the previous record. Changes are hashed in Go's canonical JSON encoding, so
+// MySQL normalizing the JSON column does not affect the result.
+func auditHash(auditLog *AuditLogs, prevHash string) (string, error) {
+	changes, err := json.Marshal(auditLog.Changes)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal([]interface{}{
+		prevHash,
+		auditLog.UsersID,
+		auditLog.ApiKeysID,
+		auditLog.JobID,
+		auditLog.Entity,
+		auditLog.EntityID,
+		auditLog.Action,
+		string(changes),
+		auditLog.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// sealAuditLog links the record to prevHash and signs it when a signing key
+// is configured.
+func sealAuditLog(auditLog *AuditLogs, prevHash string) error {
+	// DATETIME(6) keeps microseconds; round first so the stored value hashes
+	// the same on verification.
+	auditLog.CreatedAt = auditLog.CreatedAt.UTC().Truncate(time.Microsecond)
+
+	hash, err := auditHash(auditLog, prevHash)
+	if err != nil {
+		return fmt.Errorf("failed to hash audit log: %w", err)
+	}
+	auditLog.PrevHash = prevHash
+	auditLog.Hash = hash
+	auditLog.Signature = ""
+	if auditSigner != nil && auditSigner.privateKey != nil {
+		auditLog.Signature = base64.RawURLEncoding.EncodeToString(ed25519.Sign(auditSigner.privateKey, []byte(hash)))
+	}
+	return nil
+}
+
+const auditVerifyBatchSize = 1000
+
+// verifyAuditChain walks the whole audit log in ID order and stops at the
+// first record whose link, hash or signature does not match.
+func verifyAuditChain(storage AuditLogsStorage) (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true}
+
+	// Records appended while the walk runs are left for the next run.
+	headID, headHash, err := storage.GetChainHead()
+	if err != nil {
+		return nil, err
+	}
+
+	prevHash := ""
+	chained := false
+	signed := false
+	var lastID, lastChainedID int64
+
+	for {
+		auditLogs, err := storage.GetChain(lastID, auditVerifyBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, auditLog := range auditLogs {
+			lastID = auditLog.ID
+
+			if auditLog.ID > headID && auditLog.Hash != "" {
+				continue
+			}
+			if !chained && auditLog.Hash == "" {
+				result.Unchained++
+				continue
+			}
+			chained = true
+			result.Checked++
+
+			if reason := verifyAuditLog(auditLog, prevHash, &signed); reason != "" {
+				result.Valid = false
+				result.BrokenID = auditLog.ID
+				result.Reason = reason
+				return result, nil
+			}
+			prevHash = auditLog.Hash
+			lastChainedID = auditLog.ID
+		}
+
+		if len(auditLogs) < auditVerifyBatchSize {
+			break
+		}
+	}
+
+	if headID > 0 && (lastChainedID != headID || prevHash != headHash) {
+		result.Valid = false
+		result.BrokenID = headID
+		result.Reason = "chain head does not match the last record, records were removed"
+		return result, nil
+	}
+
+	result.LastHash = prevHash
+	return result, nil
+}
+
+func verifyAuditLog(auditLog *AuditLogs, prevHash string, signed *bool) string {
+	if auditLog.PrevHash != prevHash {
+		return "previous hash does not match, a record was removed or reordered"
+	}
+
+	hash, err := auditHash(auditLog, auditLog.PrevHash)
+	if err != nil || hash != auditLog.Hash {
+		return "hash does not match, the record was modified"
+	}
+
+	if auditSigner == nil {
+		return ""
+	}
+	if auditLog.Signature == "" {
+		// Records before the signing key was introduced are unsigned, but
+		// once signing started every record must carry a signature.
+		if *signed {
+			return "signature missing"
+		}
+		return ""
+	}
+	*signed = true
+	signature, err := base64.RawURLEncoding.DecodeString(auditLog.Signature)
+	if err != nil || !ed25519.Verify(auditSigner.publicKey, []byte(auditLog.Hash), signature) {
+		return "signature does not match"
+	}
+	return ""
+}
+
+// runVerifyAudit implements the verify-audit command and returns the process
+// exit code.
+func runVerifyAudit(storage AuditLogsStorage) int {
+	result, err := verifyAuditChain(storage)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "verify-audit:", err)
+		return 2
+	}
+
+	output, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(output))
+	if !result.Valid {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type AuditLogsStorage interface {
+	Create(*AuditLogs) error
+	GetAll(*AuditFilter) ([]*AuditLogs, error)
+	GetChain(int64, int) ([]*AuditLogs, error)
+	GetChainHead() (int64, string, error)
+}
+
+type AuditLogsStore struct {
+	db *sql.DB
+}
+
+func NewAuditLogsStorage(db *sql.DB) *AuditLogsStore {
+	return &AuditLogsStore{
+		db: db,
+	}
+}
+
+// Create appends a record that has no change of its own to commit with,
+// e.g. a period lock override.
+func (s *AuditLogsStore) Create(auditLog *AuditLogs) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertAuditLog(tx, auditLog); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// insertAuditLog appends the record to the hash chain inside tx. The chain
+// head row stays locked until tx ends, which serializes concurrent writers.
+func insertAuditLog(tx *sql.Tx, auditLog *AuditLogs) error {
+	var prevHash string
+	err := tx.QueryRow(`SELECT last_hash FROM audit_chain_head WHERE id = 1 FOR UPDATE`).Scan(&prevHash)
+	if err != nil {
+		return fmt.Errorf("failed to lock audit chain head: %w", err)
+	}
+
+	if err := sealAuditLog(auditLog, prevHash); err != nil {
+		return err
+	}
+
+	changes, err := json.Marshal(auditLog.Changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit changes: %w", err)
+	}
+
+	query := `INSERT INTO audit_logs (users_id, api_keys_id, job_id, entity, entity_id, action, changes, created_at, prev_hash, hash, signature) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, nullInt64(auditLog.UsersID), nullInt64(auditLog.ApiKeysID), auditLog.JobID, auditLog.Entity, auditLog.EntityID, auditLog.Action, changes, auditLog.CreatedAt, auditLog.PrevHash, auditLog.Hash, auditLog.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit log: %w", err)
+	}
+	auditLog.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE audit_chain_head SET last_id = ?, last_hash = ? WHERE id = 1`, auditLog.ID, auditLog.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to update audit chain head: %w", err)
+	}
+	return nil
+}
+
+func (s *AuditLogsStore) GetAll(filter *AuditFilter) ([]*AuditLogs, error) {
+	conditions := []string{}
+	args := []any{}
+	if filter.Entity != "" {
+		conditions = append(conditions, "entity = ?")
+		args = append(args, filter.Entity)
+	}
+	if filter.EntityID > 0 {
+		conditions = append(conditions, "entity_id = ?")
+		args = append(args, filter.EntityID)
+	}
+	if filter.UsersID > 0 {
+		conditions = append(conditions, "users_id = ?")
+		args = append(args, filter.UsersID)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *filter.To)
+	}
+
+	query := `SELECT id, users_id, api_keys_id, job_id, entity, entity_id, action, changes, created_at, prev_hash, hash, signature FROM audit_logs`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanAuditLogs(rows)
+}
+
+// GetChain returns records after the given ID in chain order.
+func (s *AuditLogsStore) GetChain(afterID int64, limit int) ([]*AuditLogs, error) {
+	query := `SELECT id, users_id, api_keys_id, job_id, entity, entity_id, action, changes, created_at, prev_hash, hash, signature FROM audit_logs WHERE id > ? ORDER BY id LIMIT ?`
+	rows, err := s.db.Query(query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanAuditLogs(rows)
+}
+
+func (s *AuditLogsStore) GetChainHead() (int64, string, error) {
+	var lastID int64
+	var lastHash string
+	err := s.db.QueryRow(`SELECT last_id, last_hash FROM audit_chain_head WHERE id = 1`).Scan(&lastID, &lastHash)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get audit chain head: %w", err)
+	}
+	return lastID, lastHash, nil
+}
+
+func scanAuditLogs(rows *sql.Rows) ([]*AuditLogs, error) {
+	var auditLogs []*AuditLogs
+	for rows.Next() {
+		auditLog := &AuditLogs{}
+		var usersID, apiKeysID sql.NullInt64
+		var changes []byte
+		err := rows.Scan(&auditLog.ID, &usersID, &apiKeysID, &auditLog.JobID, &auditLog.Entity, &auditLog.EntityID, &auditLog.Action, &changes, &auditLog.CreatedAt, &auditLog.PrevHash, &auditLog.Hash, &auditLog.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit log: %w", err)
+		}
+		auditLog.UsersID = usersID.Int64
+		auditLog.ApiKeysID = apiKeysID.Int64
+		if err := json.Unmarshal(changes, &auditLog.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode audit changes: %w", err)
+		}
+		auditLogs = append(auditLogs, auditLog)
+	}
+	return auditLogs, nil
+}
+
+func nullInt64(value int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: value, Valid: value > 0}
+}
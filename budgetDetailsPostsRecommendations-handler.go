@@ -43,9 +43,33 @@ func (s *APIServer) validateBDPRFForeignKey(primaryKey *PrimaryKeyID, validateSe
 	return "ok", nil
 }
 
+func (s *APIServer) validateBudgetDetailPostRecsScope(r *http.Request, id int64) (string, error) {
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return "unauthorized", err
+	}
+
+	stored, err := s.Storage.BudgetDetailPostRecStorage.GetByIdInScope(id, usersID)
+	if err != nil {
+		return "database error", err
+	}
+
+	if stored == nil {
+		return "budget detail post recommendation not found", fmt.Errorf("budget detail post recommendation not found")
+	}
+
+	return "ok", nil
+}
+
 func (s *APIServer) GetAllBudgetDetailPostRecs(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
 
-	budgetDetailsPostsRecommendations, err := s.Storage.BudgetDetailPostRecStorage.GetAll()
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budgetDetailsPostsRecommendations, err := s.Storage.BudgetDetailPostRecStorage.GetAllInScope(usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -60,10 +84,18 @@ func (s *APIServer) GetBudgetDetailPostRecByID(w http.ResponseWriter, r *http.Re
 		return respondWithError(requestLog, "invalid ID", err)
 	}
 
-	budgetDetailsPostsRecommendation, err := s.Storage.BudgetDetailPostRecStorage.GetById(id)
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budgetDetailsPostsRecommendation, err := s.Storage.BudgetDetailPostRecStorage.GetByIdInScope(id, usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+	if budgetDetailsPostsRecommendation == nil {
+		return respondWithError(requestLog, "budget detail post recommendation not found", nil)
+	}
 	return respondWithSuccess(requestLog, budgetDetailsPostsRecommendation)
 
 }
@@ -88,7 +120,17 @@ func (s *APIServer) CreateBudgetDetailPostRec(w http.ResponseWriter, r *http.Req
 		return respondWithError(requestLog, message, err)
 	}
 
-	budgetDetailsPostsRecommendation, err := s.Storage.BudgetDetailPostRecStorage.Create(reqBody)
+	message, err = s.validateBudgetDetailsPostsScope(r, reqBody.BudgetDetailsPostsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailPostPeriodWritable(r, reqBody.BudgetDetailsPostsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	budgetDetailsPostsRecommendation, err := s.Storage.BudgetDetailPostRecStorage.Create(reqBody, s.newAudit(r, "budget-details-posts-recommendations", auditActionCreate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -123,10 +165,36 @@ func (s *APIServer) UpdateBudgetDetailPostRec(w http.ResponseWriter, r *http.Req
 		return respondWithError(requestLog, message, err)
 	}
 
-	updatedBudgetDetailsPostsRecommendation, err := s.Storage.BudgetDetailPostRecStorage.Update(id, reqBody)
+	message, err = s.validateBudgetDetailPostRecsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateBudgetDetailsPostsScope(r, reqBody.BudgetDetailsPostsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	before, err := s.Storage.BudgetDetailPostRecStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	message, err = s.checkBudgetDetailPostPeriodWritable(r, before.BudgetDetailsPostsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailPostPeriodWritable(r, reqBody.BudgetDetailsPostsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	updatedBudgetDetailsPostsRecommendation, err := s.Storage.BudgetDetailPostRecStorage.Update(id, reqBody, s.newAudit(r, "budget-details-posts-recommendations", auditActionUpdate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
 	// if updatedBudgetDetailsPostsRecommendation == nil {
 	// 	return respondWithError(requestLog, "data budget details posts recommendation not found", err)
 	// }
@@ -151,7 +219,22 @@ func (s *APIServer) DeleteBudgetDetailPostRec(w http.ResponseWriter, r *http.Req
 		return respondWithError(requestLog, message, err)
 	}
 
-	deletedBudgetDetailsPostsRecommendation, err := s.Storage.BudgetDetailPostRecStorage.Delete(id)
+	message, err = s.validateBudgetDetailPostRecsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	budgetDetailsPostsRecommendation, err := s.Storage.BudgetDetailPostRecStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	message, err = s.checkBudgetDetailPostPeriodWritable(r, budgetDetailsPostsRecommendation.BudgetDetailsPostsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	deletedBudgetDetailsPostsRecommendation, err := s.Storage.BudgetDetailPostRecStorage.Delete(id, s.newAudit(r, "budget-details-posts-recommendations", auditActionDelete))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -6,11 +6,14 @@ import (
 )
 
 type BudgetDetailPostRecStorage interface {
-	Create(*BudgetDetailsPostsRecommendations) (*BudgetDetailsPostsRecommendations, error)
-	Delete(int64) (*BudgetDetailsPostsRecommendations, error)
-	Update(int64, *BudgetDetailsPostsRecommendations) (*BudgetDetailsPostsRecommendations, error)
+	Create(*BudgetDetailsPostsRecommendations, *Audit) (*BudgetDetailsPostsRecommendations, error)
+	Delete(int64, *Audit) (*BudgetDetailsPostsRecommendations, error)
+	Update(int64, *BudgetDetailsPostsRecommendations, *Audit) (*BudgetDetailsPostsRecommendations, error)
 	GetById(int64) (*BudgetDetailsPostsRecommendations, error)
 	GetAll() ([]*BudgetDetailsPostsRecommendations, error)
+	GetAllInScope(int64) ([]*BudgetDetailsPostsRecommendations, error)
+	GetByIdInScope(int64, int64) (*BudgetDetailsPostsRecommendations, error)
+	GetByDetailsPost(int64) ([]*BudgetDetailsPostsRecommendations, error)
 }
 
 type BudgetDetailPostRecStore struct {
@@ -23,14 +26,7 @@ func NewBudgetDetailPostRecStorage(db *sql.DB) *BudgetDetailPostRecStore {
 	}
 }
 
-func (s *BudgetDetailPostRecStore) GetAll() ([]*BudgetDetailsPostsRecommendations, error) {
-	query := `SELECT id, budget_details_posts_id, user_groups_id, recommendation, created_at, updated_at FROM budget_details_posts_recommendations`
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get budget detail post recommendations: %w", err)
-	}
-	defer rows.Close()
-
+func scanBudgetDetailPostRecs(rows *sql.Rows) ([]*BudgetDetailsPostsRecommendations, error) {
 	var recs []*BudgetDetailsPostsRecommendations
 	for rows.Next() {
 		rec := &BudgetDetailsPostsRecommendations{}
@@ -50,9 +46,44 @@ func (s *BudgetDetailPostRecStore) GetAll() ([]*BudgetDetailsPostsRecommendation
 	return recs, nil
 }
 
-func (s *BudgetDetailPostRecStore) GetById(id int64) (*BudgetDetailsPostsRecommendations, error) {
+func (s *BudgetDetailPostRecStore) GetAll() ([]*BudgetDetailsPostsRecommendations, error) {
+	query := `SELECT id, budget_details_posts_id, user_groups_id, recommendation, created_at, updated_at FROM budget_details_posts_recommendations`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget detail post recommendations: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgetDetailPostRecs(rows)
+}
+
+func (s *BudgetDetailPostRecStore) GetAllInScope(usersID int64) ([]*BudgetDetailsPostsRecommendations, error) {
+	query := unitScopeCTE + `SELECT r.id, r.budget_details_posts_id, r.user_groups_id, r.recommendation, r.created_at, r.updated_at
+		FROM budget_details_posts_recommendations r
+		JOIN budget_details_posts bdp ON bdp.id = r.budget_details_posts_id
+		JOIN budget_details bd ON bd.id = bdp.budget_details_id
+		JOIN budgets b ON b.id = bd.budgets_id
+		WHERE b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget detail post recommendations: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgetDetailPostRecs(rows)
+}
+
+func (s *BudgetDetailPostRecStore) GetByDetailsPost(budgetDetailsPostsID int64) ([]*BudgetDetailsPostsRecommendations, error) {
+	query := `SELECT id, budget_details_posts_id, user_groups_id, recommendation, created_at, updated_at FROM budget_details_posts_recommendations WHERE budget_details_posts_id = ? ORDER BY id`
+	rows, err := s.db.Query(query, budgetDetailsPostsID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget detail post recommendations: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgetDetailPostRecs(rows)
+}
+
+func getBudgetDetailPostRecById(q querier, id int64) (*BudgetDetailsPostsRecommendations, error) {
 	query := `SELECT id, budget_details_posts_id, user_groups_id, recommendation, created_at, updated_at FROM budget_details_posts_recommendations WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+	row := q.QueryRow(query, id)
 
 	rec := &BudgetDetailsPostsRecommendations{}
 	err := row.Scan(
@@ -72,37 +103,56 @@ func (s *BudgetDetailPostRecStore) GetById(id int64) (*BudgetDetailsPostsRecomme
 	return rec, nil
 }
 
-func (s *BudgetDetailPostRecStore) Create(rec *BudgetDetailsPostsRecommendations) (*BudgetDetailsPostsRecommendations, error) {
-	query := `INSERT INTO budget_details_posts_recommendations (budget_details_posts_id, user_groups_id, recommendation, created_at, updated_at) VALUES (?, ?, ?, now(), now())`
-	result, err := s.db.Exec(query, rec.BudgetDetailsPostsID, rec.UserGroupsID, rec.Recommendation)
+func (s *BudgetDetailPostRecStore) GetById(id int64) (*BudgetDetailsPostsRecommendations, error) {
+	return getBudgetDetailPostRecById(s.db, id)
+}
+
+func (s *BudgetDetailPostRecStore) GetByIdInScope(id int64, usersID int64) (*BudgetDetailsPostsRecommendations, error) {
+	query := unitScopeCTE + `SELECT r.id, r.budget_details_posts_id, r.user_groups_id, r.recommendation, r.created_at, r.updated_at
+		FROM budget_details_posts_recommendations r
+		JOIN budget_details_posts bdp ON bdp.id = r.budget_details_posts_id
+		JOIN budget_details bd ON bd.id = bdp.budget_details_id
+		JOIN budgets b ON b.id = bd.budgets_id
+		WHERE r.id = ? AND b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert budget detail post recommendation: %w", err)
+		return nil, fmt.Errorf("failed to get budget detail post recommendation by id: %w", err)
 	}
-	lastInsertID, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	defer rows.Close()
+
+	recs, err := scanBudgetDetailPostRecs(rows)
+	if err != nil || len(recs) == 0 {
+		return nil, err
 	}
-	return s.GetById(lastInsertID)
+	return recs[0], nil
 }
 
-func (s *BudgetDetailPostRecStore) Delete(id int64) (*BudgetDetailsPostsRecommendations, error) {
-	rec, _ := s.GetById(id)
-	if rec == nil {
-		return nil, fmt.Errorf("budget detail post recommendation not found")
+func (s *BudgetDetailPostRecStore) Create(rec *BudgetDetailsPostsRecommendations, audit *Audit) (*BudgetDetailsPostsRecommendations, error) {
+	query := `INSERT INTO budget_details_posts_recommendations (budget_details_posts_id, user_groups_id, recommendation, created_at, updated_at) VALUES (?, ?, ?, now(), now())`
+	_, created, err := auditedExec(s.db, audit, getBudgetDetailPostRecById, 0, query, rec.BudgetDetailsPostsID, rec.UserGroupsID, rec.Recommendation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert budget detail post recommendation: %w", err)
 	}
+	return created, nil
+}
+
+func (s *BudgetDetailPostRecStore) Delete(id int64, audit *Audit) (*BudgetDetailsPostsRecommendations, error) {
 	query := `DELETE FROM budget_details_posts_recommendations WHERE id = ?`
-	_, err := s.db.Exec(query, id)
+	rec, _, err := auditedExec(s.db, audit, getBudgetDetailPostRecById, id, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete budget detail post recommendation: %w", err)
 	}
+	if rec == nil {
+		return nil, fmt.Errorf("budget detail post recommendation not found")
+	}
 	return rec, nil
 }
 
-func (s *BudgetDetailPostRecStore) Update(id int64, rec *BudgetDetailsPostsRecommendations) (*BudgetDetailsPostsRecommendations, error) {
+func (s *BudgetDetailPostRecStore) Update(id int64, rec *BudgetDetailsPostsRecommendations, audit *Audit) (*BudgetDetailsPostsRecommendations, error) {
 	query := `UPDATE budget_details_posts_recommendations SET budget_details_posts_id = ?, user_groups_id = ?, recommendation = ?, updated_at = now() WHERE id = ?`
-	_, err := s.db.Exec(query, rec.BudgetDetailsPostsID, rec.UserGroupsID, rec.Recommendation, id)
+	_, updated, err := auditedExec(s.db, audit, getBudgetDetailPostRecById, id, query, rec.BudgetDetailsPostsID, rec.UserGroupsID, rec.Recommendation, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update budget detail post recommendation: %w", err)
 	}
-	return s.GetById(id)
+	return updated, nil
 }
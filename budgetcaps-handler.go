@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 )
@@ -18,6 +19,34 @@ func validateBudgetCapsRequest(reqBody *BudgetCaps) error {
 	return nil
 }
 
+// limitError returns the cap, planned total or detail total violation
+// wrapped in err, or nil when err is anything else.
+func limitError(err error) error {
+	var capErr *CapExceededError
+	var planErr *PlanExceedsCapError
+	var totalErr *PlanExceedsTotalError
+	var detailsErr *DetailsExceedRequestError
+	if errors.As(err, &capErr) {
+		return capErr
+	} else if errors.As(err, &planErr) {
+		return planErr
+	} else if errors.As(err, &totalErr) {
+		return totalErr
+	} else if errors.As(err, &detailsErr) {
+		return detailsErr
+	}
+	return nil
+}
+
+// limitErrorMessage keeps limit violations visible to the caller and hides
+// everything else behind the usual database error.
+func limitErrorMessage(err error) string {
+	if limitErr := limitError(err); limitErr != nil {
+		return limitErr.Error()
+	}
+	return "database error"
+}
+
 func (s *APIServer) validateBudgetsCapsForeignKey(primaryKey *PrimaryKeyID, validateSelfID bool, checkSelfOnly bool) (string, error) {
 
 	newKey := &PrimaryKeyID{
@@ -48,9 +77,33 @@ func (s *APIServer) validateBudgetsCapsForeignKey(primaryKey *PrimaryKeyID, vali
 	return "ok", nil
 }
 
+func (s *APIServer) validateBudgetCapsScope(r *http.Request, id int64) (string, error) {
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return "unauthorized", err
+	}
+
+	stored, err := s.Storage.BudgetCapsStorage.GetByIdInScope(id, usersID)
+	if err != nil {
+		return "database error", err
+	}
+
+	if stored == nil {
+		return "budget caps not found", fmt.Errorf("budget caps not found")
+	}
+
+	return "ok", nil
+}
+
 func (s *APIServer) GetAllBudgetCaps(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
 
-	budgetCaps, err := s.Storage.BudgetCapsStorage.GetAll()
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budgetCaps, err := s.Storage.BudgetCapsStorage.GetAllInScope(usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -65,10 +118,18 @@ func (s *APIServer) GetBudgetCapByID(w http.ResponseWriter, r *http.Request, bod
 		return respondWithError(requestLog, "invalid ID", err)
 	}
 
-	budgetCap, err := s.Storage.BudgetCapsStorage.GetById(id)
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budgetCap, err := s.Storage.BudgetCapsStorage.GetByIdInScope(id, usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+	if budgetCap == nil {
+		return respondWithError(requestLog, "budget caps not found", nil)
+	}
 	return respondWithSuccess(requestLog, budgetCap)
 
 }
@@ -94,7 +155,22 @@ func (s *APIServer) CreateBudgetCap(w http.ResponseWriter, r *http.Request, body
 		return respondWithError(requestLog, message, err)
 	}
 
-	budgetCap, err := s.Storage.BudgetCapsStorage.Create(reqBody)
+	message, err = s.validateBudgetsScope(r, reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetEditable(reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, reqBody.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	budgetCap, err := s.Storage.BudgetCapsStorage.Create(reqBody, s.newAudit(r, "budget-caps", auditActionCreate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -130,10 +206,46 @@ func (s *APIServer) UpdateBudgetCap(w http.ResponseWriter, r *http.Request, body
 		return respondWithError(requestLog, message, err)
 	}
 
-	updatedBudgetCap, err := s.Storage.BudgetCapsStorage.Update(id, reqBody)
+	message, err = s.validateBudgetCapsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateBudgetsScope(r, reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	before, err := s.Storage.BudgetCapsStorage.GetById(id)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
+	message, err = s.checkBudgetEditable(before.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, before.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetEditable(reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, reqBody.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	updatedBudgetCap, err := s.Storage.BudgetCapsStorage.Update(id, reqBody, s.newAudit(r, "budget-caps", auditActionUpdate))
+	if err != nil {
+		return respondWithError(requestLog, limitErrorMessage(err), err)
+	}
+
 	// if updatedBudgetCap == nil {
 	// 	return respondWithError(requestLog, "data budget cap not found", err)
 	// }
@@ -158,10 +270,31 @@ func (s *APIServer) DeleteBudgetCap(w http.ResponseWriter, r *http.Request, body
 		return respondWithError(requestLog, message, err)
 	}
 
-	deletedBudgetCap, err := s.Storage.BudgetCapsStorage.Delete(id)
+	message, err = s.validateBudgetCapsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	budgetCap, err := s.Storage.BudgetCapsStorage.GetById(id)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
+	message, err = s.checkBudgetEditable(budgetCap.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, budgetCap.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	deletedBudgetCap, err := s.Storage.BudgetCapsStorage.Delete(id, s.newAudit(r, "budget-caps", auditActionDelete))
+	if err != nil {
+		return respondWithError(requestLog, limitErrorMessage(err), err)
+	}
+
 	// if deletedBudgetCap == nil {
 	// 	return respondWithError(requestLog, "data budget cap not found", err)
 	// }
@@ -7,12 +7,15 @@ import (
 )
 
 type BudgetCapsStorage interface {
-	Create(*BudgetCaps) (*BudgetCaps, error)
-	Delete(int64) (*BudgetCaps, error)
-	Update(int64, *BudgetCaps) (*BudgetCaps, error)
+	Create(*BudgetCaps, *Audit) (*BudgetCaps, error)
+	Delete(int64, *Audit) (*BudgetCaps, error)
+	Update(int64, *BudgetCaps, *Audit) (*BudgetCaps, error)
 	UpdateAmount(int64, *BudgetCaps) (*BudgetCaps, error)
 	GetById(int64) (*BudgetCaps, error)
 	GetAll() ([]*BudgetCaps, error)
+	GetAllInScope(int64) ([]*BudgetCaps, error)
+	GetByIdInScope(int64, int64) (*BudgetCaps, error)
+	GetUtilization(int64) ([]*CapUtilization, error)
 }
 
 type BudgetCapsStore struct {
@@ -60,15 +63,45 @@ func (s *BudgetCapsStore) GetAll() ([]*BudgetCaps, error) {
 	return scanBudgetCaps(rows)
 }
 
-func (s *BudgetCapsStore) GetById(id int64) (*BudgetCaps, error) {
+func getBudgetCapById(q querier, id int64) (*BudgetCaps, error) {
 	query := `SELECT id, budgets_id, budget_posts_id, amount, created_at, updated_at FROM budget_caps WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+	row := q.QueryRow(query, id)
+	return scanBudgetCap(row)
+}
+
+func (s *BudgetCapsStore) GetById(id int64) (*BudgetCaps, error) {
+	return getBudgetCapById(s.db, id)
+}
+
+func (s *BudgetCapsStore) GetAllInScope(usersID int64) ([]*BudgetCaps, error) {
+	query := unitScopeCTE + `SELECT bc.id, bc.budgets_id, bc.budget_posts_id, bc.amount, bc.created_at, bc.updated_at
+		FROM budget_caps bc JOIN budgets b ON b.id = bc.budgets_id
+		WHERE b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget caps: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgetCaps(rows)
+}
+
+func (s *BudgetCapsStore) GetByIdInScope(id int64, usersID int64) (*BudgetCaps, error) {
+	query := unitScopeCTE + `SELECT bc.id, bc.budgets_id, bc.budget_posts_id, bc.amount, bc.created_at, bc.updated_at
+		FROM budget_caps bc JOIN budgets b ON b.id = bc.budgets_id
+		WHERE bc.id = ? AND b.units_id IN (SELECT id FROM scoped_units)`
+	row := s.db.QueryRow(query, usersID, id)
 	return scanBudgetCap(row)
 }
 
-func (s *BudgetCapsStore) Create(budgetCap *BudgetCaps) (*BudgetCaps, error) {
+func (s *BudgetCapsStore) Create(budgetCap *BudgetCaps, audit *Audit) (*BudgetCaps, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `INSERT INTO budget_caps (budgets_id, budget_posts_id, amount, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`
-	result, err := s.db.Exec(query, budgetCap.BudgetsID, budgetCap.BudgetPostsID, budgetCap.Amount, time.Now(), time.Now())
+	result, err := tx.Exec(query, budgetCap.BudgetsID, budgetCap.BudgetPostsID, budgetCap.Amount, time.Now(), time.Now())
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert budget cap: %w", err)
 	}
@@ -76,36 +109,312 @@ func (s *BudgetCapsStore) Create(budgetCap *BudgetCaps) (*BudgetCaps, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
-	return s.GetById(lastInsertID)
+	if err := postCapAllocation(tx, ledgerEntryAllocation, lastInsertID, budgetCap.BudgetsID, budgetCap.BudgetPostsID, budgetCap.Amount, "budget cap created"); err != nil {
+		return nil, err
+	}
+	created, err := getBudgetCapById(tx, lastInsertID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, lastInsertID, nil, created); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return created, nil
 }
 
-func (s *BudgetCapsStore) Delete(id int64) (*BudgetCaps, error) {
-	budgetCap, _ := s.GetById(id)
+func (s *BudgetCapsStore) Delete(id int64, audit *Audit) (*BudgetCaps, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	budgetCap, err := getBudgetCapById(tx, id)
+	if err != nil {
+		return nil, err
+	}
 	if budgetCap == nil {
 		return nil, fmt.Errorf("budget cap not found")
 	}
+
+	if err := checkPlannedWithinCap(tx, budgetCap.BudgetsID, budgetCap.BudgetPostsID, id, 0, 0, 0); err != nil {
+		return nil, err
+	}
+	if err := checkCapBalance(tx, budgetCap.BudgetsID, budgetCap.BudgetPostsID, 0, budgetCap.Amount); err != nil {
+		return nil, err
+	}
+
 	query := `DELETE FROM budget_caps WHERE id = ?`
-	_, err := s.db.Exec(query, id)
+	_, err = tx.Exec(query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete budget cap: %w", err)
 	}
+	if err := postCapAllocation(tx, ledgerEntryReversal, id, budgetCap.BudgetsID, budgetCap.BudgetPostsID, -budgetCap.Amount, "budget cap deleted"); err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, id, budgetCap, nil); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return budgetCap, nil
 }
 
-func (s *BudgetCapsStore) Update(id int64, budgetCap *BudgetCaps) (*BudgetCaps, error) {
+// Update refuses to lower a cap below what is already planned for its budget
+// post, or by more than the post still has available in the ledger,
+// including the post the cap is moved away from.
+func (s *BudgetCapsStore) Update(id int64, budgetCap *BudgetCaps, audit *Audit) (*BudgetCaps, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := lockBudgetCap(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	original, err := getBudgetCapById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPlannedWithinCap(tx, budgetCap.BudgetsID, budgetCap.BudgetPostsID, id, budgetCap.Amount, 0, 0); err != nil {
+		return nil, err
+	}
+	if before.BudgetsID != budgetCap.BudgetsID || before.BudgetPostsID != budgetCap.BudgetPostsID {
+		if err := checkPlannedWithinCap(tx, before.BudgetsID, before.BudgetPostsID, id, 0, 0, 0); err != nil {
+			return nil, err
+		}
+		if err := checkCapBalance(tx, before.BudgetsID, before.BudgetPostsID, 0, before.Amount); err != nil {
+			return nil, err
+		}
+	} else if budgetCap.Amount < before.Amount {
+		if err := checkCapBalance(tx, before.BudgetsID, before.BudgetPostsID, 0, before.Amount-budgetCap.Amount); err != nil {
+			return nil, err
+		}
+	}
+
 	query := `UPDATE budget_caps SET budgets_id = ?, budget_posts_id = ?, amount = ?, updated_at = ? WHERE id = ?`
-	_, err := s.db.Exec(query, budgetCap.BudgetsID, budgetCap.BudgetPostsID, budgetCap.Amount, time.Now(), id)
+	_, err = tx.Exec(query, budgetCap.BudgetsID, budgetCap.BudgetPostsID, budgetCap.Amount, time.Now(), id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update budget cap: %w", err)
 	}
-	return s.GetById(id)
+	if err := postCapChange(tx, id, before, budgetCap); err != nil {
+		return nil, err
+	}
+	updated, err := getBudgetCapById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, id, original, updated); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, nil
 }
 
 func (s *BudgetCapsStore) UpdateAmount(id int64, budgetCap *BudgetCaps) (*BudgetCaps, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := lockBudgetCap(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `UPDATE budget_caps SET amount = ?, updated_at = ? WHERE id = ?`
-	_, err := s.db.Exec(query, budgetCap.Amount, time.Now(), id)
+	_, err = tx.Exec(query, budgetCap.Amount, time.Now(), id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update budget cap amount: %w", err)
 	}
+	after := *before
+	after.Amount = budgetCap.Amount
+	if err := postCapChange(tx, id, before, &after); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return s.GetById(id)
 }
+
+func lockBudgetCap(tx *sql.Tx, id int64) (*BudgetCaps, error) {
+	budgetCap := &BudgetCaps{ID: id}
+	query := `SELECT budgets_id, budget_posts_id, amount FROM budget_caps WHERE id = ? FOR UPDATE`
+	if err := tx.QueryRow(query, id).Scan(&budgetCap.BudgetsID, &budgetCap.BudgetPostsID, &budgetCap.Amount); err != nil {
+		return nil, fmt.Errorf("failed to get budget cap: %w", err)
+	}
+	return budgetCap, nil
+}
+
+// CapExceededError reports a fund request amount above what is left of the
+// budget cap for its budget post.
+type CapExceededError struct {
+	Available float64
+}
+
+func (e *CapExceededError) Error() string {
+	return fmt.Sprintf("amount exceeds budget cap, remaining %.2f", e.Available)
+}
+
+// checkCapBalance fails when amount exceeds the balance of a budget post: its
+// available account in the ledger (allocations and transfers less what
+// approved fund requests have committed or spent) less the open fund requests
+// that are not approved yet, leaving out excludeFundRequestID. The budget is
+// locked while checking.
+func checkCapBalance(tx *sql.Tx, budgetsID int64, budgetPostsID int64, excludeFundRequestID int64, amount float64) error {
+	available, err := lockLedgerAvailable(tx, budgetsID, budgetPostsID)
+	if err != nil {
+		return err
+	}
+	reserved, err := lockOpenFundRequestAmount(tx, budgetsID, budgetPostsID, excludeFundRequestID)
+	if err != nil {
+		return err
+	}
+	available = roundCents(available - reserved)
+	if amount > available {
+		return &CapExceededError{Available: available}
+	}
+	return nil
+}
+
+// lockOpenFundRequestAmount locks and sums the fund requests of a budget post
+// that are open but not yet committed in the ledger, leaving out excludeID.
+func lockOpenFundRequestAmount(tx *sql.Tx, budgetsID int64, budgetPostsID int64, excludeID int64) (float64, error) {
+	query := `SELECT id, amount FROM fund_requests WHERE budgets_id = ? AND budget_posts_id = ? AND status IN (?, ?, ?) FOR UPDATE`
+	rows, err := tx.Query(query, budgetsID, budgetPostsID, fundRequestStatusDraft, fundRequestStatusSubmitted, fundRequestStatusVerified)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock fund requests: %w", err)
+	}
+	defer rows.Close()
+
+	var total float64
+	for rows.Next() {
+		var id int64
+		var amount float64
+		if err := rows.Scan(&id, &amount); err != nil {
+			return 0, fmt.Errorf("failed to scan fund request: %w", err)
+		}
+		if id != excludeID {
+			total += amount
+		}
+	}
+	return total, rows.Err()
+}
+
+// PlanExceedsCapError reports planned detail post amounts above the budget
+// cap of their budget post.
+type PlanExceedsCapError struct {
+	Cap     float64
+	Planned float64
+}
+
+func (e *PlanExceedsCapError) Error() string {
+	return fmt.Sprintf("planned total %.2f exceeds budget cap %.2f", e.Planned, e.Cap)
+}
+
+// lockCapAmount locks and sums the cap rows of a budget post, leaving out
+// excludeCapID.
+func lockCapAmount(tx *sql.Tx, budgetsID int64, budgetPostsID int64, excludeCapID int64) (float64, error) {
+	query := `SELECT id, amount FROM budget_caps WHERE budgets_id = ? AND budget_posts_id = ? FOR UPDATE`
+	rows, err := tx.Query(query, budgetsID, budgetPostsID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock budget caps: %w", err)
+	}
+	defer rows.Close()
+
+	var total float64
+	for rows.Next() {
+		var id int64
+		var amount float64
+		if err := rows.Scan(&id, &amount); err != nil {
+			return 0, fmt.Errorf("failed to scan budget cap: %w", err)
+		}
+		if id != excludeCapID {
+			total += amount
+		}
+	}
+	return total, rows.Err()
+}
+
+// checkPlannedWithinCap fails when the planned amounts of a budget post add
+// up to more than its caps. The excluded cap and detail post are replaced by
+// capAmount and plannedAmount, which lets callers check a pending change.
+func checkPlannedWithinCap(tx *sql.Tx, budgetsID int64, budgetPostsID int64, excludeCapID int64, capAmount float64, excludeDetailsPostID int64, plannedAmount float64) error {
+	caps, err := lockCapAmount(tx, budgetsID, budgetPostsID, excludeCapID)
+	if err != nil {
+		return err
+	}
+
+	query := `SELECT COALESCE(SUM(bdp.planned_amount), 0) FROM budget_details_posts bdp
+		JOIN budget_details bd ON bd.id = bdp.budget_details_id
+		WHERE bd.budgets_id = ? AND bdp.budget_posts_id = ? AND bdp.id <> ?`
+	var planned float64
+	if err := tx.QueryRow(query, budgetsID, budgetPostsID, excludeDetailsPostID).Scan(&planned); err != nil {
+		return fmt.Errorf("failed to sum planned amounts: %w", err)
+	}
+
+	caps += capAmount
+	planned += plannedAmount
+	if planned > caps {
+		return &PlanExceedsCapError{Cap: caps, Planned: planned}
+	}
+	return nil
+}
+
+// GetUtilization reports per budget post the money in the ledger (allocated,
+// available, committed and spent) next to the planned, approved and used
+// amounts of the budget's detail posts. Spending that is not usage of any
+// detail post is reported as unassigned.
+func (s *BudgetCapsStore) GetUtilization(budgetsID int64) ([]*CapUtilization, error) {
+	query := `SELECT bp.id, bp.name, COALESCE(l.available, 0), COALESCE(l.committed, 0), COALESCE(l.spent, 0), COALESCE(d.planned, 0), COALESCE(d.approved, 0), COALESCE(d.used, 0)
+		FROM budget_posts bp
+		LEFT JOIN (SELECT budget_posts_id,
+				SUM(CASE WHEN account_type = ? THEN debit - credit ELSE 0 END) AS available,
+				SUM(CASE WHEN account_type = ? THEN debit - credit ELSE 0 END) AS committed,
+				SUM(CASE WHEN account_type = ? THEN debit - credit ELSE 0 END) AS spent
+			FROM journal_lines WHERE budgets_id = ? AND account_type IN (?, ?, ?) GROUP BY budget_posts_id) l
+			ON l.budget_posts_id = bp.id
+		LEFT JOIN (SELECT bdp.budget_posts_id, SUM(bdp.planned_amount) AS planned, SUM(bdp.approved_amount) AS approved, SUM(bdp.usage_amount) AS used
+			FROM budget_details_posts bdp JOIN budget_details bd ON bd.id = bdp.budget_details_id
+			WHERE bd.budgets_id = ? GROUP BY bdp.budget_posts_id) d
+			ON d.budget_posts_id = bp.id
+		WHERE l.budget_posts_id IS NOT NULL OR d.budget_posts_id IS NOT NULL
+		ORDER BY bp.id`
+	rows, err := s.db.Query(query,
+		ledgerAccountAvailable, ledgerAccountCommitted, ledgerAccountSpent,
+		budgetsID,
+		ledgerAccountAvailable, ledgerAccountCommitted, ledgerAccountSpent,
+		budgetsID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cap utilization: %w", err)
+	}
+	defer rows.Close()
+
+	var utilization []*CapUtilization
+	for rows.Next() {
+		row := &CapUtilization{}
+		var detailUsage float64
+		if err := rows.Scan(&row.BudgetPostsID, &row.BudgetPostName, &row.Available, &row.Committed, &row.Used, &row.Planned, &row.Approved, &detailUsage); err != nil {
+			return nil, fmt.Errorf("failed to scan cap utilization: %w", err)
+		}
+		row.Cap = roundCents(row.Available + row.Committed + row.Used)
+		row.Unassigned = roundCents(row.Used - detailUsage)
+		row.Unplanned = row.Cap - row.Planned
+		utilization = append(utilization, row)
+	}
+	return utilization, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestBudgetCapsStoreGetUtilization(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM budget_posts bp`)).
+		WithArgs(ledgerAccountAvailable, ledgerAccountCommitted, ledgerAccountSpent, int64(1), ledgerAccountAvailable, ledgerAccountCommitted, ledgerAccountSpent, int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "available", "committed", "spent", "planned", "approved", "used"}).
+			AddRow(2, "Travel", 300, 200, 500, 900, 800, 300).
+			AddRow(3, "Equipment", 1000, 0, 0, 400, 0, 0).
+			AddRow(4, "Training", 0, 0, 250, 250, 250, 250))
+
+	tests := []struct {
+		name           string
+		wantCap        float64
+		wantUsed       float64
+		wantUnassigned float64
+		wantUnplanned  float64
+	}{
+		{name: "Travel", wantCap: 1000, wantUsed: 500, wantUnassigned: 200, wantUnplanned: 100},
+		{name: "Equipment", wantCap: 1000, wantUnplanned: 600},
+		{name: "Training", wantCap: 250, wantUsed: 250},
+	}
+
+	utilization, err := (&BudgetCapsStore{db: db}).GetUtilization(1)
+	if err != nil {
+		t.Fatalf("GetUtilization() error = %v", err)
+	}
+	if len(utilization) != len(tests) {
+		t.Fatalf("len(GetUtilization()) = %d, want %d", len(utilization), len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := utilization[i]
+			if row.Cap != tt.wantCap || row.Used != tt.wantUsed || row.Unassigned != tt.wantUnassigned || row.Unplanned != tt.wantUnplanned {
+				t.Errorf("row = cap %.2f used %.2f unassigned %.2f unplanned %.2f, want %.2f %.2f %.2f %.2f",
+					row.Cap, row.Used, row.Unassigned, row.Unplanned, tt.wantCap, tt.wantUsed, tt.wantUnassigned, tt.wantUnplanned)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+func upliftAmount(amount float64, percent float64) float64 {
+	return math.Round(amount*(1+percent/100)*100) / 100
+}
+
+// Clone deep-copies a budget with its details, detail posts and caps into a
+// new draft budget in one transaction. Usage always starts at zero because it
+// is derived from the new budget's fund requests; recommendations are not
+// copied. Amounts are uplifted and rounded one by one, so the copy is checked
+// against the planned total and cap limits before it is committed.
+func (s *BudgetsStore) Clone(id int64, options *BudgetCloneRequest, unitsID int64, audit *Audit) (*BudgetCloneResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &BudgetCloneResult{
+		IDMap: map[string]map[int64]int64{
+			"budgets":              {},
+			"budget_details":       {},
+			"budget_details_posts": {},
+			"budget_caps":          {},
+		},
+	}
+
+	var description string
+	if err := tx.QueryRow(`SELECT description FROM budgets WHERE id = ?`, id).Scan(&description); err != nil {
+		return nil, fmt.Errorf("failed to get budget: %w", err)
+	}
+	query := `INSERT INTO budgets (name, description, fiscal_periods_id, status, units_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, now(), now())`
+	inserted, err := tx.Exec(query, options.Name, description, options.FiscalPeriodsID, budgetStatusDraft, unitsID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert budget: %w", err)
+	}
+	newBudgetID, err := inserted.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+	result.IDMap["budgets"][id] = newBudgetID
+
+	inactiveFilter := ""
+	if options.DropInactive {
+		inactiveFilter = ` AND a.is_active = TRUE`
+	}
+	query = `SELECT bd.id, bd.activities_id, bd.description, bd.target, bd.quantity, bd.unit_value, bd.terms
+		FROM budget_details bd JOIN activities a ON a.id = bd.activities_id
+		WHERE bd.budgets_id = ?` + inactiveFilter + ` ORDER BY bd.id`
+	rows, err := tx.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget details: %w", err)
+	}
+	var details []*BudgetDetails
+	for rows.Next() {
+		detail := &BudgetDetails{}
+		if err := rows.Scan(&detail.ID, &detail.ActivitiesID, &detail.Description, &detail.Target, &detail.Quantity, &detail.UnitValue, &detail.Terms); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan budget detail: %w", err)
+		}
+		details = append(details, detail)
+	}
+	rows.Close()
+
+	for _, detail := range details {
+		if options.ShiftTarget {
+			detail.Target = detail.Target.AddDate(1, 0, 0)
+		}
+		detail.UnitValue = upliftAmount(detail.UnitValue, options.UpliftPercent)
+		detail.Total = budgetDetailTotal(detail.Quantity, detail.UnitValue, detail.Terms)
+
+		query := `INSERT INTO budget_details (budgets_id, activities_id, description, target, quantity, unit_value, total, terms, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, now(), now())`
+		inserted, err := tx.Exec(query, newBudgetID, detail.ActivitiesID, detail.Description, detail.Target, detail.Quantity, detail.UnitValue, detail.Total, detail.Terms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert budget detail: %w", err)
+		}
+		newID, err := inserted.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get last insert id: %w", err)
+		}
+		result.IDMap["budget_details"][detail.ID] = newID
+	}
+
+	postFilter := ""
+	if options.DropInactive {
+		postFilter = ` AND bp.is_active = TRUE`
+	}
+	query = `SELECT bdp.id, bdp.budget_details_id, bdp.budget_posts_id, bdp.planned_amount, bdp.approved_amount
+		FROM budget_details_posts bdp
+		JOIN budget_details bd ON bd.id = bdp.budget_details_id
+		JOIN budget_posts bp ON bp.id = bdp.budget_posts_id
+		WHERE bd.budgets_id = ?` + postFilter + ` ORDER BY bdp.id`
+	rows, err = tx.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget details posts: %w", err)
+	}
+	var posts []*BudgetDetailsPosts
+	for rows.Next() {
+		post := &BudgetDetailsPosts{}
+		if err := rows.Scan(&post.ID, &post.BudgetDetailsID, &post.BudgetPostsID, &post.PlannedAmount, &post.ApprovedAmount); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan budget details post: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	rows.Close()
+
+	for _, post := range posts {
+		newDetailID, ok := result.IDMap["budget_details"][post.BudgetDetailsID]
+		if !ok {
+			continue
+		}
+		approved := upliftAmount(post.ApprovedAmount, options.UpliftPercent)
+		if options.ResetApprovals {
+			approved = 0
+		}
+
+		query := `INSERT INTO budget_details_posts (budget_details_id, budget_posts_id, planned_amount, approved_amount, usage_amount, created_at, updated_at) VALUES (?, ?, ?, ?, 0, now(), now())`
+		inserted, err := tx.Exec(query, newDetailID, post.BudgetPostsID, upliftAmount(post.PlannedAmount, options.UpliftPercent), approved)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert budget details post: %w", err)
+		}
+		newID, err := inserted.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get last insert id: %w", err)
+		}
+		result.IDMap["budget_details_posts"][post.ID] = newID
+	}
+
+	query = `SELECT bc.id, bc.budget_posts_id, bc.amount FROM budget_caps bc
+		JOIN budget_posts bp ON bp.id = bc.budget_posts_id
+		WHERE bc.budgets_id = ?` + postFilter + ` ORDER BY bc.id`
+	rows, err = tx.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget caps: %w", err)
+	}
+	var caps []*BudgetCaps
+	for rows.Next() {
+		budgetCap := &BudgetCaps{}
+		if err := rows.Scan(&budgetCap.ID, &budgetCap.BudgetPostsID, &budgetCap.Amount); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan budget cap: %w", err)
+		}
+		caps = append(caps, budgetCap)
+	}
+	rows.Close()
+
+	for _, budgetCap := range caps {
+		amount := upliftAmount(budgetCap.Amount, options.UpliftPercent)
+		query := `INSERT INTO budget_caps (budgets_id, budget_posts_id, amount, created_at, updated_at) VALUES (?, ?, ?, now(), now())`
+		inserted, err := tx.Exec(query, newBudgetID, budgetCap.BudgetPostsID, amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert budget cap: %w", err)
+		}
+		newID, err := inserted.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get last insert id: %w", err)
+		}
+		result.IDMap["budget_caps"][budgetCap.ID] = newID
+		if err := postCapAllocation(tx, ledgerEntryAllocation, newID, newBudgetID, budgetCap.BudgetPostsID, amount, "budget cloned"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, detail := range details {
+		if err := checkPlannedWithinTotal(tx, result.IDMap["budget_details"][detail.ID], detail.Total, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+	var budgetPostsIDs []int64
+	for _, post := range posts {
+		budgetPostsIDs = append(budgetPostsIDs, post.BudgetPostsID)
+	}
+	for _, budgetCap := range caps {
+		budgetPostsIDs = append(budgetPostsIDs, budgetCap.BudgetPostsID)
+	}
+	checkedPosts := map[int64]bool{}
+	for _, budgetPostsID := range budgetPostsIDs {
+		if checkedPosts[budgetPostsID] {
+			continue
+		}
+		checkedPosts[budgetPostsID] = true
+		if err := checkPlannedWithinCap(tx, newBudgetID, budgetPostsID, 0, 0, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Budget, err = getBudgetById(tx, newBudgetID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, newBudgetID, nil, result.Budget); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return result, nil
+}
@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 )
 
+// budgetDetailTotal is quantity × unit value × terms rounded half away from
+// zero to two decimals.
+func budgetDetailTotal(quantity float64, unitValue float64, terms float64) float64 {
+	return math.Round(quantity*unitValue*terms*100) / 100
+}
+
 func validateBudgetDetailsRequest(reqBody *BudgetDetails) error {
 	if reqBody.BudgetsID <= 0 {
 		return fmt.Errorf("budgets id must be filled")
@@ -20,11 +27,15 @@ func validateBudgetDetailsRequest(reqBody *BudgetDetails) error {
 		return fmt.Errorf("quantity must be greater than 0")
 	} else if reqBody.UnitValue <= 0 {
 		return fmt.Errorf("unit value must be greater than 0")
-	} else if reqBody.Total <= 0 {
-		return fmt.Errorf("total must be greater than 0")
 	} else if reqBody.Terms <= 0 {
 		return fmt.Errorf("terms must be greater than 0")
 	}
+
+	total := budgetDetailTotal(reqBody.Quantity, reqBody.UnitValue, reqBody.Terms)
+	if reqBody.Total != 0 && math.Round(reqBody.Total*100) != math.Round(total*100) {
+		return fmt.Errorf("total must equal quantity × unit value × terms (%.2f)", total)
+	}
+	reqBody.Total = total
 	return nil
 }
 
@@ -57,9 +68,33 @@ func (s *APIServer) validateBudgetDetailsForeignKey(primaryKey *PrimaryKeyID, va
 	return "ok", nil
 }
 
+func (s *APIServer) validateBudgetDetailsScope(r *http.Request, id int64) (string, error) {
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return "unauthorized", err
+	}
+
+	stored, err := s.Storage.BudgetDetailsStorage.GetByIdInScope(id, usersID)
+	if err != nil {
+		return "database error", err
+	}
+
+	if stored == nil {
+		return "budget details not found", fmt.Errorf("budget details not found")
+	}
+
+	return "ok", nil
+}
+
 func (s *APIServer) GetAllBudgetDetails(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
 
-	budgetDetails, err := s.Storage.BudgetDetailsStorage.GetAll()
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budgetDetails, err := s.Storage.BudgetDetailsStorage.GetAllInScope(usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -74,10 +109,18 @@ func (s *APIServer) GetBudgetDetailByID(w http.ResponseWriter, r *http.Request,
 		return respondWithError(requestLog, "invalid ID", err)
 	}
 
-	budgetDetail, err := s.Storage.BudgetDetailsStorage.GetById(id)
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budgetDetail, err := s.Storage.BudgetDetailsStorage.GetByIdInScope(id, usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+	if budgetDetail == nil {
+		return respondWithError(requestLog, "budget details not found", nil)
+	}
 	return respondWithSuccess(requestLog, budgetDetail)
 
 }
@@ -102,7 +145,22 @@ func (s *APIServer) CreateBudgetDetail(w http.ResponseWriter, r *http.Request, b
 		return respondWithError(requestLog, message, err)
 	}
 
-	budgetDetail, err := s.Storage.BudgetDetailsStorage.Create(reqBody)
+	message, err = s.validateBudgetsScope(r, reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetEditable(reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, reqBody.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	budgetDetail, err := s.Storage.BudgetDetailsStorage.Create(reqBody, s.newAudit(r, "budget-details", auditActionCreate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -138,11 +196,46 @@ func (s *APIServer) UpdateBudgetDetail(w http.ResponseWriter, r *http.Request, b
 		return respondWithError(requestLog, message, err)
 	}
 
-	updatedBudgetDetail, err := s.Storage.BudgetDetailsStorage.Update(id, reqBody)
+	message, err = s.validateBudgetDetailsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateBudgetsScope(r, reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	before, err := s.Storage.BudgetDetailsStorage.GetById(id)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
 
+	message, err = s.checkBudgetEditable(before.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, before.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetEditable(reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, reqBody.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	updatedBudgetDetail, err := s.Storage.BudgetDetailsStorage.Update(id, reqBody, s.newAudit(r, "budget-details", auditActionUpdate))
+	if err != nil {
+		return respondWithError(requestLog, limitErrorMessage(err), err)
+	}
+
 	// if updatedBudgetDetail == nil {
 	// 	return respondWithError(requestLog, "data budget details not found", err)
 	// }
@@ -166,10 +259,26 @@ func (s *APIServer) DeleteBudgetDetail(w http.ResponseWriter, r *http.Request, b
 		return respondWithError(requestLog, message, err)
 	}
 
-	deletedBudgetDetail, err := s.Storage.BudgetDetailsStorage.Delete(id)
+	message, err = s.validateBudgetDetailsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailEditable(id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailPeriodWritable(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	deletedBudgetDetail, err := s.Storage.BudgetDetailsStorage.Delete(id, s.newAudit(r, "budget-details", auditActionDelete))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
 	// if deletedBudgetDetail == nil {
 	// 	return respondWithError(requestLog, "data budget details not found", err)
 	// }
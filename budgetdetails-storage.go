@@ -2,15 +2,21 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"math"
+	"os"
 )
 
 type BudgetDetailsStorage interface {
-	Create(*BudgetDetails) (*BudgetDetails, error)
-	Delete(int64) (*BudgetDetails, error)
-	Update(int64, *BudgetDetails) (*BudgetDetails, error)
+	Create(*BudgetDetails, *Audit) (*BudgetDetails, error)
+	Delete(int64, *Audit) (*BudgetDetails, error)
+	Update(int64, *BudgetDetails, *Audit) (*BudgetDetails, error)
 	GetById(int64) (*BudgetDetails, error)
 	GetAll() ([]*BudgetDetails, error)
+	GetAllInScope(int64) ([]*BudgetDetails, error)
+	GetByIdInScope(int64, int64) (*BudgetDetails, error)
+	RecalculateTotals() (int64, error)
 }
 
 type BudgetDetailsStore struct {
@@ -23,14 +29,7 @@ func NewBudgetDetailsStorage(db *sql.DB) *BudgetDetailsStore {
 	}
 }
 
-func (s *BudgetDetailsStore) GetAll() ([]*BudgetDetails, error) {
-	query := `SELECT id, budgets_id, activities_id, description, target, quantity, unit_value, total, terms, created_at, updated_at FROM budget_details`
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get budget details: %w", err)
-	}
-	defer rows.Close()
-
+func scanBudgetDetails(rows *sql.Rows) ([]*BudgetDetails, error) {
 	var budgetDetailsList []*BudgetDetails
 	for rows.Next() {
 		budgetDetail := &BudgetDetails{}
@@ -55,9 +54,31 @@ func (s *BudgetDetailsStore) GetAll() ([]*BudgetDetails, error) {
 	return budgetDetailsList, nil
 }
 
-func (s *BudgetDetailsStore) GetById(id int64) (*BudgetDetails, error) {
+func (s *BudgetDetailsStore) GetAll() ([]*BudgetDetails, error) {
+	query := `SELECT id, budgets_id, activities_id, description, target, quantity, unit_value, total, terms, created_at, updated_at FROM budget_details`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget details: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgetDetails(rows)
+}
+
+func (s *BudgetDetailsStore) GetAllInScope(usersID int64) ([]*BudgetDetails, error) {
+	query := unitScopeCTE + `SELECT bd.id, bd.budgets_id, bd.activities_id, bd.description, bd.target, bd.quantity, bd.unit_value, bd.total, bd.terms, bd.created_at, bd.updated_at
+		FROM budget_details bd JOIN budgets b ON b.id = bd.budgets_id
+		WHERE b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget details: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgetDetails(rows)
+}
+
+func getBudgetDetailById(q querier, id int64) (*BudgetDetails, error) {
 	query := `SELECT id, budgets_id, activities_id, description, target, quantity, unit_value, total, terms, created_at, updated_at FROM budget_details WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+	row := q.QueryRow(query, id)
 
 	budgetDetail := &BudgetDetails{}
 	err := row.Scan(
@@ -82,38 +103,169 @@ func (s *BudgetDetailsStore) GetById(id int64) (*BudgetDetails, error) {
 	return budgetDetail, nil
 }
 
-func (s *BudgetDetailsStore) Create(budgetDetail *BudgetDetails) (*BudgetDetails, error) {
-	query := `INSERT INTO budget_details (budgets_id, activities_id, description, target, quantity, unit_value, total, terms, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, now(), now())`
-	result, err := s.db.Exec(query, budgetDetail.BudgetsID, budgetDetail.ActivitiesID, budgetDetail.Description, budgetDetail.Target, budgetDetail.Quantity, budgetDetail.UnitValue, budgetDetail.Total, budgetDetail.Terms)
+func (s *BudgetDetailsStore) GetById(id int64) (*BudgetDetails, error) {
+	return getBudgetDetailById(s.db, id)
+}
+
+func (s *BudgetDetailsStore) GetByIdInScope(id int64, usersID int64) (*BudgetDetails, error) {
+	query := unitScopeCTE + `SELECT bd.id, bd.budgets_id, bd.activities_id, bd.description, bd.target, bd.quantity, bd.unit_value, bd.total, bd.terms, bd.created_at, bd.updated_at
+		FROM budget_details bd JOIN budgets b ON b.id = bd.budgets_id
+		WHERE bd.id = ? AND b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert budget detail: %w", err)
+		return nil, fmt.Errorf("failed to get budget detail by id: %w", err)
 	}
-	lastInsertID, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	defer rows.Close()
+
+	budgetDetailsList, err := scanBudgetDetails(rows)
+	if err != nil || len(budgetDetailsList) == 0 {
+		return nil, err
 	}
-	return s.GetById(lastInsertID)
+	return budgetDetailsList[0], nil
 }
 
-func (s *BudgetDetailsStore) Delete(id int64) (*BudgetDetails, error) {
-	budgetDetail, _ := s.GetById(id)
-	if budgetDetail == nil {
-		return nil, fmt.Errorf("budget detail not found")
+func (s *BudgetDetailsStore) Create(budgetDetail *BudgetDetails, audit *Audit) (*BudgetDetails, error) {
+	query := `INSERT INTO budget_details (budgets_id, activities_id, description, target, quantity, unit_value, total, terms, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, now(), now())`
+	_, created, err := auditedExec(s.db, audit, getBudgetDetailById, 0, query, budgetDetail.BudgetsID, budgetDetail.ActivitiesID, budgetDetail.Description, budgetDetail.Target, budgetDetail.Quantity, budgetDetail.UnitValue, budgetDetail.Total, budgetDetail.Terms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert budget detail: %w", err)
 	}
+	return created, nil
+}
+
+func (s *BudgetDetailsStore) Delete(id int64, audit *Audit) (*BudgetDetails, error) {
 	query := `DELETE FROM budget_details WHERE id = ?`
-	_, err := s.db.Exec(query, id)
+	budgetDetail, _, err := auditedExec(s.db, audit, getBudgetDetailById, id, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete budget detail: %w", err)
 	}
+	if budgetDetail == nil {
+		return nil, fmt.Errorf("budget detail not found")
+	}
 	return budgetDetail, nil
 }
 
-func (s *BudgetDetailsStore) Update(id int64, budgetDetail *BudgetDetails) (*BudgetDetails, error) {
+// Update refuses a total below the planned amounts already booked on the
+// detail's posts.
+func (s *BudgetDetailsStore) Update(id int64, budgetDetail *BudgetDetails, audit *Audit) (*BudgetDetails, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var lockedID int64
+	if err := tx.QueryRow(`SELECT id FROM budget_details WHERE id = ? FOR UPDATE`, id).Scan(&lockedID); err != nil {
+		return nil, fmt.Errorf("failed to get budget detail: %w", err)
+	}
+	before, err := getBudgetDetailById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPlannedWithinTotal(tx, id, budgetDetail.Total, 0, 0); err != nil {
+		return nil, err
+	}
+
 	query := `UPDATE budget_details SET budgets_id = ?, activities_id = ?, description = ?, target = ?, quantity = ?, unit_value = ?, total = ?, terms = ?, updated_at = now() WHERE id = ?`
-	_, err := s.db.Exec(query, budgetDetail.BudgetsID, budgetDetail.ActivitiesID, budgetDetail.Description, budgetDetail.Target, budgetDetail.Quantity, budgetDetail.UnitValue, budgetDetail.Total, budgetDetail.Terms, id)
+	_, err = tx.Exec(query, budgetDetail.BudgetsID, budgetDetail.ActivitiesID, budgetDetail.Description, budgetDetail.Target, budgetDetail.Quantity, budgetDetail.UnitValue, budgetDetail.Total, budgetDetail.Terms, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update budget detail: %w", err)
 	}
+	updated, err := getBudgetDetailById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, id, before, updated); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, nil
+}
+
+// RecalculateTotals stores budgetDetailTotal for every budget detail whose
+// total differs from it and returns how many rows changed. Totals are only
+// computed in Go, so a stored total always matches what validation expects.
+func (s *BudgetDetailsStore) RecalculateTotals() (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, quantity, unit_value, terms, total FROM budget_details ORDER BY id FOR UPDATE`
+	rows, err := tx.Query(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get budget details: %w", err)
+	}
+	var changed []*BudgetDetails
+	for rows.Next() {
+		detail := &BudgetDetails{}
+		if err := rows.Scan(&detail.ID, &detail.Quantity, &detail.UnitValue, &detail.Terms, &detail.Total); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan budget detail: %w", err)
+		}
+		total := budgetDetailTotal(detail.Quantity, detail.UnitValue, detail.Terms)
+		if total != detail.Total {
+			detail.Total = total
+			changed = append(changed, detail)
+		}
+	}
+	rows.Close()
+
+	query = `UPDATE budget_details SET total = ?, updated_at = now() WHERE id = ?`
+	for _, detail := range changed {
+		if _, err := tx.Exec(query, detail.Total, detail.ID); err != nil {
+			return 0, fmt.Errorf("failed to update budget detail total: %w", err)
+		}
+	}
 
-	return s.GetById(id)
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int64(len(changed)), nil
+}
+
+// runRecalculateTotals brings the stored total of every budget detail in line
+// with budgetDetailTotal.
+func runRecalculateTotals(storage BudgetDetailsStorage) int {
+	updated, err := storage.RecalculateTotals()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "recalculate-totals:", err)
+		return 1
+	}
+
+	output, _ := json.MarshalIndent(map[string]int64{"updated": updated}, "", "  ")
+	fmt.Println(string(output))
+	return 0
+}
+
+// PlanExceedsTotalError reports detail post planned amounts above the total
+// of their budget detail.
+type PlanExceedsTotalError struct {
+	Total   float64
+	Planned float64
+}
+
+func (e *PlanExceedsTotalError) Error() string {
+	return fmt.Sprintf("planned total %.2f exceeds budget detail total %.2f", e.Planned, e.Total)
+}
+
+// checkPlannedWithinTotal fails when the planned amounts of a detail's posts
+// add up to more than total. The excluded detail post is replaced by
+// plannedAmount.
+func checkPlannedWithinTotal(tx *sql.Tx, budgetDetailsID int64, total float64, excludeDetailsPostID int64, plannedAmount float64) error {
+	query := `SELECT COALESCE(SUM(planned_amount), 0) FROM budget_details_posts WHERE budget_details_id = ? AND id <> ?`
+	var planned float64
+	if err := tx.QueryRow(query, budgetDetailsID, excludeDetailsPostID).Scan(&planned); err != nil {
+		return fmt.Errorf("failed to sum planned amounts: %w", err)
+	}
+
+	planned += plannedAmount
+	if math.Round(planned*100) > math.Round(total*100) {
+		return &PlanExceedsTotalError{Total: total, Planned: planned}
+	}
+	return nil
 }
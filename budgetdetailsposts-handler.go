@@ -16,8 +16,6 @@ func validateBudgetDetailsPost(reqBody *BudgetDetailsPosts) error {
 		return fmt.Errorf("planned amount must be greater than 0")
 	} else if reqBody.ApprovedAmount <= 0 {
 		return fmt.Errorf("approved amount must be greater than 0")
-	} else if reqBody.UsageAmount <= 0 {
-		return fmt.Errorf("usage amount must be greater than 0")
 	}
 
 	return nil
@@ -51,9 +49,33 @@ func (s *APIServer) validateBudgetDetailsPostsForeignKey(primaryKey *PrimaryKeyI
 	return "ok", nil
 }
 
+func (s *APIServer) validateBudgetDetailsPostsScope(r *http.Request, id int64) (string, error) {
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return "unauthorized", err
+	}
+
+	stored, err := s.Storage.BudgetDetailsPostsStorage.GetByIdInScope(id, usersID)
+	if err != nil {
+		return "database error", err
+	}
+
+	if stored == nil {
+		return "data budget detail post not found", fmt.Errorf("data budget detail post not found")
+	}
+
+	return "ok", nil
+}
+
 func (s *APIServer) GetAllBudgetDetailPosts(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
 
-	budgetDetailsPosts, err := s.Storage.BudgetDetailsPostsStorage.GetAll()
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budgetDetailsPosts, err := s.Storage.BudgetDetailsPostsStorage.GetAllInScope(usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -68,10 +90,18 @@ func (s *APIServer) GetBudgetDetailPostByID(w http.ResponseWriter, r *http.Reque
 		return respondWithError(requestLog, "invalid ID", err)
 	}
 
-	budgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.GetById(id)
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.GetByIdInScope(id, usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+	if budgetDetailsPost == nil {
+		return respondWithError(requestLog, "data budget detail post not found", nil)
+	}
 	return respondWithSuccess(requestLog, budgetDetailsPost)
 
 }
@@ -97,9 +127,24 @@ func (s *APIServer) CreateBudgetDetailPost(w http.ResponseWriter, r *http.Reques
 		return respondWithError(requestLog, message, err)
 	}
 
-	budgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.Create(reqBody)
+	message, err = s.validateBudgetDetailsScope(r, reqBody.BudgetDetailsID)
 	if err != nil {
-		return respondWithError(requestLog, "database error", err)
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailEditable(reqBody.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailPeriodWritable(r, reqBody.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	budgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.Create(reqBody, s.newAudit(r, "budget-details-posts", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, limitErrorMessage(err), err)
 	}
 
 	return respondWithSuccess(requestLog, budgetDetailsPost)
@@ -133,11 +178,46 @@ func (s *APIServer) UpdateBudgetDetailPost(w http.ResponseWriter, r *http.Reques
 		return respondWithError(requestLog, message, err)
 	}
 
-	updatedBudgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.Update(id, reqBody)
+	message, err = s.validateBudgetDetailsPostsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateBudgetDetailsScope(r, reqBody.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	before, err := s.Storage.BudgetDetailsPostsStorage.GetById(id)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
 
+	message, err = s.checkBudgetDetailEditable(before.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailPeriodWritable(r, before.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailEditable(reqBody.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailPeriodWritable(r, reqBody.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	updatedBudgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.Update(id, reqBody, s.newAudit(r, "budget-details-posts", auditActionUpdate))
+	if err != nil {
+		return respondWithError(requestLog, limitErrorMessage(err), err)
+	}
+
 	return respondWithSuccess(requestLog, updatedBudgetDetailsPost)
 
 }
@@ -158,7 +238,27 @@ func (s *APIServer) DeleteBudgetDetailPost(w http.ResponseWriter, r *http.Reques
 		return respondWithError(requestLog, message, err)
 	}
 
-	deletedBudgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.Delete(id)
+	message, err = s.validateBudgetDetailsPostsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	budgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	message, err = s.checkBudgetDetailEditable(budgetDetailsPost.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetDetailPeriodWritable(r, budgetDetailsPost.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	deletedBudgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.Delete(id, s.newAudit(r, "budget-details-posts", auditActionDelete))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -166,3 +266,127 @@ func (s *APIServer) DeleteBudgetDetailPost(w http.ResponseWriter, r *http.Reques
 	return respondWithSuccess(requestLog, deletedBudgetDetailsPost)
 
 }
+
+// validateConsolidationBudget checks that the budget of a detail is in the
+// caller's scope and still open for changes.
+func (s *APIServer) validateConsolidationBudget(r *http.Request, budgetDetailsID int64) (string, error) {
+
+	budgetDetail, err := s.Storage.BudgetDetailsStorage.GetById(budgetDetailsID)
+	if err != nil {
+		return "database error", err
+	}
+	if budgetDetail == nil {
+		return "data budget details not found", fmt.Errorf("data budget details not found")
+	}
+
+	message, err := s.validateBudgetsScope(r, budgetDetail.BudgetsID)
+	if err != nil {
+		return message, err
+	}
+
+	return s.checkBudgetEditable(budgetDetail.BudgetsID)
+}
+
+// ConsolidateBudgetDetailPost turns the recommendations of a detail post into
+// its approved amount using the requested or the default policy. With
+// preview the result is returned without being saved.
+func (s *APIServer) ConsolidateBudgetDetailPost(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reqBody := &ConsolidateRequest{}
+	if len(bodyBytes) > 0 {
+		if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+			return respondWithError(requestLog, "invalid data request", err)
+		}
+	}
+	if r.URL.Query().Get("preview") == "true" {
+		reqBody.Preview = true
+	}
+
+	before, err := s.Storage.BudgetDetailsPostsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if before == nil {
+		return respondWithError(requestLog, "data budget detail post not found", nil)
+	}
+
+	message, err := s.validateConsolidationBudget(r, before.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	var policy *ConsolidationPolicies
+	if reqBody.ConsolidationPoliciesID > 0 {
+		policy, err = s.Storage.ConsolidationPoliciesStorage.GetById(reqBody.ConsolidationPoliciesID)
+	} else {
+		policy, err = s.Storage.ConsolidationPoliciesStorage.GetDefault()
+	}
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if policy == nil {
+		return respondWithError(requestLog, "consolidation policy not found", nil)
+	}
+
+	recs, err := s.Storage.BudgetDetailPostRecStorage.GetByDetailsPost(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	consolidation, err := consolidateRecommendations(policy, recs)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+	consolidation.BudgetDetailsPostsID = id
+	consolidation.UsersID, _ = s.GetUserID(r)
+	if reqBody.Preview {
+		consolidation.Preview = true
+		return respondWithSuccess(requestLog, consolidation)
+	}
+
+	message, err = s.checkBudgetDetailPeriodWritable(r, before.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	consolidation, err = s.Storage.RecommendationConsolidationsStorage.Apply(consolidation, s.newAudit(r, "budget-details-posts", "consolidate"))
+	if err != nil {
+		return respondWithError(requestLog, limitErrorMessage(err), err)
+	}
+
+	return respondWithSuccess(requestLog, consolidation)
+
+}
+
+func (s *APIServer) GetBudgetDetailPostConsolidations(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	budgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if budgetDetailsPost == nil {
+		return respondWithError(requestLog, "data budget detail post not found", nil)
+	}
+
+	message, err := s.validateBudgetDetailsScope(r, budgetDetailsPost.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	consolidations, err := s.Storage.RecommendationConsolidationsStorage.GetByDetailsPost(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, consolidations)
+
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeBudgetDetailsPostsStorage struct {
+	BudgetDetailsPostsStorage
+	posts map[int64]*BudgetDetailsPosts
+}
+
+func (f *fakeBudgetDetailsPostsStorage) GetById(id int64) (*BudgetDetailsPosts, error) {
+	return f.posts[id], nil
+}
+
+type fakeBudgetDetailsStorage struct {
+	BudgetDetailsStorage
+	details map[int64]*BudgetDetails
+}
+
+func (f *fakeBudgetDetailsStorage) GetById(id int64) (*BudgetDetails, error) {
+	return f.details[id], nil
+}
+
+type fakeConsolidationPoliciesStorage struct {
+	ConsolidationPoliciesStorage
+	policies map[int64]*ConsolidationPolicies
+}
+
+func (f *fakeConsolidationPoliciesStorage) GetById(id int64) (*ConsolidationPolicies, error) {
+	return f.policies[id], nil
+}
+
+func (f *fakeConsolidationPoliciesStorage) GetDefault() (*ConsolidationPolicies, error) {
+	for _, policy := range f.policies {
+		if policy.IsDefault {
+			return policy, nil
+		}
+	}
+	return nil, nil
+}
+
+type fakeBudgetDetailPostRecStorage struct {
+	BudgetDetailPostRecStorage
+	recs []*BudgetDetailsPostsRecommendations
+}
+
+func (f *fakeBudgetDetailPostRecStorage) GetByDetailsPost(budgetDetailsPostsID int64) ([]*BudgetDetailsPostsRecommendations, error) {
+	return f.recs, nil
+}
+
+type fakeRecommendationConsolidationsStorage struct {
+	RecommendationConsolidationsStorage
+	applied []*RecommendationConsolidations
+}
+
+func (f *fakeRecommendationConsolidationsStorage) Apply(consolidation *RecommendationConsolidations, audit *Audit) (*RecommendationConsolidations, error) {
+	f.applied = append(f.applied, consolidation)
+	return consolidation, nil
+}
+
+func TestConsolidateBudgetDetailPost(t *testing.T) {
+	groups := []*ConsolidationPolicyGroups{
+		{UserGroupsID: 1, Weight: 1, Rank: 1},
+		{UserGroupsID: 2, Weight: 3, Rank: 2},
+	}
+	policies := map[int64]*ConsolidationPolicies{
+		1: {ID: 1, Method: consolidationFinalAuthority, Groups: groups},
+		2: {ID: 2, Method: consolidationMinimum, Groups: groups},
+		3: {ID: 3, Method: consolidationAverage, Groups: groups, IsDefault: true},
+		4: {ID: 4, Method: consolidationWeighted, Groups: groups},
+	}
+	// Group 1 revised its 400 to 600; group 3 is not part of any policy.
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	recs := []*BudgetDetailsPostsRecommendations{
+		{ID: 11, BudgetDetailsPostsID: 5, UserGroupsID: 1, Recommendation: 400, UpdatedAt: day},
+		{ID: 12, BudgetDetailsPostsID: 5, UserGroupsID: 2, Recommendation: 800, UpdatedAt: day},
+		{ID: 13, BudgetDetailsPostsID: 5, UserGroupsID: 1, Recommendation: 600, UpdatedAt: day.Add(time.Hour)},
+		{ID: 14, BudgetDetailsPostsID: 5, UserGroupsID: 3, Recommendation: 100, UpdatedAt: day},
+	}
+
+	tests := []struct {
+		name         string
+		body         string
+		query        string
+		budgetStatus string
+		wantErr      string
+		wantAmount   float64
+		wantUsed     []int64
+		wantApplied  bool
+	}{
+		{name: "final authority", body: `{"consolidation_policies_id":1}`, budgetStatus: budgetStatusDraft, wantAmount: 800, wantUsed: []int64{12}, wantApplied: true},
+		{name: "minimum", body: `{"consolidation_policies_id":2}`, budgetStatus: budgetStatusDraft, wantAmount: 600, wantUsed: []int64{13}, wantApplied: true},
+		{name: "default policy", budgetStatus: budgetStatusDraft, wantAmount: 700, wantUsed: []int64{12, 13}, wantApplied: true},
+		{name: "weighted", body: `{"consolidation_policies_id":4}`, budgetStatus: budgetStatusRevisionRequested, wantAmount: 750, wantUsed: []int64{12, 13}, wantApplied: true},
+		{name: "preview", body: `{"consolidation_policies_id":4}`, query: "?preview=true", budgetStatus: budgetStatusDraft, wantAmount: 750, wantUsed: []int64{12, 13}},
+		{name: "unknown policy", body: `{"consolidation_policies_id":8}`, budgetStatus: budgetStatusDraft, wantErr: "consolidation policy not found"},
+		{name: "approved budget", body: `{"consolidation_policies_id":1}`, budgetStatus: budgetStatusApproved, wantErr: "budget can only be changed in status draft or revision_requested"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consolidations := &fakeRecommendationConsolidationsStorage{}
+			s := &APIServer{Storage: Storage{
+				BudgetDetailsPostsStorage: &fakeBudgetDetailsPostsStorage{posts: map[int64]*BudgetDetailsPosts{
+					5: {ID: 5, BudgetDetailsID: 3, BudgetPostsID: 2, PlannedAmount: 900},
+				}},
+				BudgetDetailsStorage: &fakeBudgetDetailsStorage{details: map[int64]*BudgetDetails{
+					3: {ID: 3, BudgetsID: 1, Total: 1000},
+				}},
+				BudgetsStorage: &fakeBudgetsStorage{budgets: map[int64]*Budgets{
+					1: {ID: 1, FiscalPeriodsID: 1, Status: tt.budgetStatus},
+				}},
+				FiscalPeriodsStorage:                &fakeFiscalPeriodsStorage{},
+				ConsolidationPoliciesStorage:        &fakeConsolidationPoliciesStorage{policies: policies},
+				BudgetDetailPostRecStorage:          &fakeBudgetDetailPostRecStorage{recs: recs},
+				RecommendationConsolidationsStorage: consolidations,
+			}}
+			claims := &UserClaims{}
+			claims.Subject = "10"
+
+			r := httptest.NewRequest("POST", "/budget-details-posts/5/consolidate"+tt.query, nil)
+			r = mux.SetURLVars(r, map[string]string{"id": "5"})
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey, claims))
+
+			response, err := s.ConsolidateBudgetDetailPost(httptest.NewRecorder(), r, []byte(tt.body), map[string]interface{}{})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("ConsolidateBudgetDetailPost() error = %v, want %s", err, tt.wantErr)
+				}
+				if len(consolidations.applied) != 0 {
+					t.Errorf("applied = %d, want none", len(consolidations.applied))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConsolidateBudgetDetailPost() error = %v", err)
+			}
+
+			consolidation := response.(map[string]interface{})["data"].(*RecommendationConsolidations)
+			if consolidation.ApprovedAmount != tt.wantAmount {
+				t.Errorf("approved amount = %v, want %v", consolidation.ApprovedAmount, tt.wantAmount)
+			}
+			if consolidation.Preview == tt.wantApplied {
+				t.Errorf("preview = %v, want %v", consolidation.Preview, !tt.wantApplied)
+			}
+			if applied := len(consolidations.applied) == 1; applied != tt.wantApplied {
+				t.Errorf("applied = %v, want %v", applied, tt.wantApplied)
+			}
+			if consolidation.BudgetDetailsPostsID != 5 || consolidation.UsersID != 10 {
+				t.Errorf("consolidation = post %d by user %d, want post 5 by user 10", consolidation.BudgetDetailsPostsID, consolidation.UsersID)
+			}
+
+			// Every recommendation is recorded as a source; only those that
+			// produced the result are marked used.
+			var used []int64
+			for _, source := range consolidation.Sources {
+				if source.Used {
+					used = append(used, source.RecommendationsID)
+				}
+			}
+			if len(consolidation.Sources) != len(recs) || len(used) != len(tt.wantUsed) {
+				t.Fatalf("sources = %d, used = %v, want %d sources and %v used", len(consolidation.Sources), used, len(recs), tt.wantUsed)
+			}
+			for i := range used {
+				if used[i] != tt.wantUsed[i] {
+					t.Errorf("used = %v, want %v", used, tt.wantUsed)
+				}
+			}
+		})
+	}
+}
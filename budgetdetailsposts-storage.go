@@ -6,11 +6,14 @@ import (
 )
 
 type BudgetDetailsPostsStorage interface {
-	Create(*BudgetDetailsPosts) (*BudgetDetailsPosts, error)
-	Delete(int64) (*BudgetDetailsPosts, error)
-	Update(int64, *BudgetDetailsPosts) (*BudgetDetailsPosts, error)
+	Create(*BudgetDetailsPosts, *Audit) (*BudgetDetailsPosts, error)
+	Delete(int64, *Audit) (*BudgetDetailsPosts, error)
+	Update(int64, *BudgetDetailsPosts, *Audit) (*BudgetDetailsPosts, error)
 	GetById(int64) (*BudgetDetailsPosts, error)
 	GetAll() ([]*BudgetDetailsPosts, error)
+	GetAllInScope(int64) ([]*BudgetDetailsPosts, error)
+	GetByIdInScope(int64, int64) (*BudgetDetailsPosts, error)
+	RecalculateUsage() (int64, error)
 }
 
 type BudgetDetailsPostsStore struct {
@@ -23,14 +26,7 @@ func NewBudgetDetailsPostsStorage(db *sql.DB) *BudgetDetailsPostsStore {
 	}
 }
 
-func (s *BudgetDetailsPostsStore) GetAll() ([]*BudgetDetailsPosts, error) {
-	query := `SELECT id, budget_details_id, budget_posts_id, planned_amount, approved_amount, usage_amount, created_at, updated_at FROM budget_details_posts`
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get budget details posts: %w", err)
-	}
-	defer rows.Close()
-
+func scanBudgetDetailsPosts(rows *sql.Rows) ([]*BudgetDetailsPosts, error) {
 	var budgetDetailsPostsList []*BudgetDetailsPosts
 	for rows.Next() {
 		budgetDetailsPost := &BudgetDetailsPosts{}
@@ -52,9 +48,33 @@ func (s *BudgetDetailsPostsStore) GetAll() ([]*BudgetDetailsPosts, error) {
 	return budgetDetailsPostsList, nil
 }
 
-func (s *BudgetDetailsPostsStore) GetById(id int64) (*BudgetDetailsPosts, error) {
+func (s *BudgetDetailsPostsStore) GetAll() ([]*BudgetDetailsPosts, error) {
+	query := `SELECT id, budget_details_id, budget_posts_id, planned_amount, approved_amount, usage_amount, created_at, updated_at FROM budget_details_posts`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget details posts: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgetDetailsPosts(rows)
+}
+
+func (s *BudgetDetailsPostsStore) GetAllInScope(usersID int64) ([]*BudgetDetailsPosts, error) {
+	query := unitScopeCTE + `SELECT bdp.id, bdp.budget_details_id, bdp.budget_posts_id, bdp.planned_amount, bdp.approved_amount, bdp.usage_amount, bdp.created_at, bdp.updated_at
+		FROM budget_details_posts bdp
+		JOIN budget_details bd ON bd.id = bdp.budget_details_id
+		JOIN budgets b ON b.id = bd.budgets_id
+		WHERE b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget details posts: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgetDetailsPosts(rows)
+}
+
+func getBudgetDetailsPostById(q querier, id int64) (*BudgetDetailsPosts, error) {
 	query := `SELECT id, budget_details_id, budget_posts_id, planned_amount, approved_amount, usage_amount, created_at, updated_at FROM budget_details_posts WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+	row := q.QueryRow(query, id)
 
 	budgetDetailsPost := &BudgetDetailsPosts{}
 	err := row.Scan(
@@ -76,9 +96,42 @@ func (s *BudgetDetailsPostsStore) GetById(id int64) (*BudgetDetailsPosts, error)
 	return budgetDetailsPost, nil
 }
 
-func (s *BudgetDetailsPostsStore) Create(post *BudgetDetailsPosts) (*BudgetDetailsPosts, error) {
-	query := `INSERT INTO budget_details_posts (budget_details_id, budget_posts_id, planned_amount, approved_amount, usage_amount, created_at, updated_at) VALUES (?, ?, ?, ?, ?, now(), now())`
-	result, err := s.db.Exec(query, post.BudgetDetailsID, post.BudgetPostsID, post.PlannedAmount, post.ApprovedAmount, post.UsageAmount)
+func (s *BudgetDetailsPostsStore) GetById(id int64) (*BudgetDetailsPosts, error) {
+	return getBudgetDetailsPostById(s.db, id)
+}
+
+func (s *BudgetDetailsPostsStore) GetByIdInScope(id int64, usersID int64) (*BudgetDetailsPosts, error) {
+	query := unitScopeCTE + `SELECT bdp.id, bdp.budget_details_id, bdp.budget_posts_id, bdp.planned_amount, bdp.approved_amount, bdp.usage_amount, bdp.created_at, bdp.updated_at
+		FROM budget_details_posts bdp
+		JOIN budget_details bd ON bd.id = bdp.budget_details_id
+		JOIN budgets b ON b.id = bd.budgets_id
+		WHERE bdp.id = ? AND b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget details post by id: %w", err)
+	}
+	defer rows.Close()
+
+	budgetDetailsPostsList, err := scanBudgetDetailsPosts(rows)
+	if err != nil || len(budgetDetailsPostsList) == 0 {
+		return nil, err
+	}
+	return budgetDetailsPostsList[0], nil
+}
+
+func (s *BudgetDetailsPostsStore) Create(post *BudgetDetailsPosts, audit *Audit) (*BudgetDetailsPosts, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkDetailsPostWithinCap(tx, 0, post); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO budget_details_posts (budget_details_id, budget_posts_id, planned_amount, approved_amount, usage_amount, created_at, updated_at) VALUES (?, ?, ?, ?, 0, now(), now())`
+	result, err := tx.Exec(query, post.BudgetDetailsID, post.BudgetPostsID, post.PlannedAmount, post.ApprovedAmount)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert budget details post: %w", err)
 	}
@@ -86,27 +139,98 @@ func (s *BudgetDetailsPostsStore) Create(post *BudgetDetailsPosts) (*BudgetDetai
 	if err != nil {
 		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
-	return s.GetById(lastInsertID)
-}
+	if err := refreshUsage(tx, post.BudgetDetailsID, post.BudgetPostsID); err != nil {
+		return nil, err
+	}
+	created, err := getBudgetDetailsPostById(tx, lastInsertID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, lastInsertID, nil, created); err != nil {
+		return nil, err
+	}
 
-func (s *BudgetDetailsPostsStore) Delete(id int64) (*BudgetDetailsPosts, error) {
-	post, _ := s.GetById(id)
-	if post == nil {
-		return nil, fmt.Errorf("budget details post not found")
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	return created, nil
+}
+
+func (s *BudgetDetailsPostsStore) Delete(id int64, audit *Audit) (*BudgetDetailsPosts, error) {
 	query := `DELETE FROM budget_details_posts WHERE id = ?`
-	_, err := s.db.Exec(query, id)
+	post, _, err := auditedExec(s.db, audit, getBudgetDetailsPostById, id, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete budget details post: %w", err)
 	}
+	if post == nil {
+		return nil, fmt.Errorf("budget details post not found")
+	}
 	return post, nil
 }
 
-func (s *BudgetDetailsPostsStore) Update(id int64, post *BudgetDetailsPosts) (*BudgetDetailsPosts, error) {
-	query := `UPDATE budget_details_posts SET budget_details_id = ?, budget_posts_id = ?, planned_amount = ?, approved_amount = ?, usage_amount = ?, updated_at = now() WHERE id = ?`
-	_, err := s.db.Exec(query, post.BudgetDetailsID, post.BudgetPostsID, post.PlannedAmount, post.ApprovedAmount, post.UsageAmount, id)
+func (s *BudgetDetailsPostsStore) Update(id int64, post *BudgetDetailsPosts, audit *Audit) (*BudgetDetailsPosts, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := getBudgetDetailsPostById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkDetailsPostWithinCap(tx, id, post); err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE budget_details_posts SET budget_details_id = ?, budget_posts_id = ?, planned_amount = ?, approved_amount = ?, updated_at = now() WHERE id = ?`
+	_, err = tx.Exec(query, post.BudgetDetailsID, post.BudgetPostsID, post.PlannedAmount, post.ApprovedAmount, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update budget details post: %w", err)
 	}
-	return s.GetById(id)
+	if err := refreshUsage(tx, post.BudgetDetailsID, post.BudgetPostsID); err != nil {
+		return nil, err
+	}
+	updated, err := getBudgetDetailsPostById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, id, before, updated); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, nil
+}
+
+// checkDetailsPostWithinCap checks the planned amount of post, replacing the
+// stored row id, against the total of its budget detail and the cap of its
+// budget post.
+func checkDetailsPostWithinCap(tx *sql.Tx, id int64, post *BudgetDetailsPosts) error {
+	var budgetsID int64
+	var total float64
+	query := `SELECT budgets_id, total FROM budget_details WHERE id = ? FOR UPDATE`
+	if err := tx.QueryRow(query, post.BudgetDetailsID).Scan(&budgetsID, &total); err != nil {
+		return fmt.Errorf("failed to get budget details: %w", err)
+	}
+	if err := checkPlannedWithinTotal(tx, post.BudgetDetailsID, total, id, post.PlannedAmount); err != nil {
+		return err
+	}
+	return checkPlannedWithinCap(tx, budgetsID, post.BudgetPostsID, 0, 0, id, post.PlannedAmount)
+}
+
+// RecalculateUsage rebuilds usage_amount of every detail post and returns how
+// many rows changed.
+func (s *BudgetDetailsPostsStore) RecalculateUsage() (int64, error) {
+	query := `UPDATE budget_details_posts bdp SET usage_amount = (` + usageAmountQuery + `), updated_at = now()
+		WHERE bdp.usage_amount <> (` + usageAmountQuery + `)`
+	args := append(append([]any{}, usageAmountArgs...), usageAmountArgs...)
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to recalculate usage: %w", err)
+	}
+	return result.RowsAffected()
 }
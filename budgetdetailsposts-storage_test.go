@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestBudgetDetailsPostsStoreRecalculateUsage(t *testing.T) {
+	db, mock := newMockDB(t)
+	// Usage is rebuilt from fund request detail lines and settlement lines,
+	// not from the ledger.
+	mock.ExpectExec(`UPDATE budget_details_posts bdp SET usage_amount = \(SELECT .* FROM fund_request_details frd`).
+		WithArgs(settlementStatusApproved, settlementStatusSettled, fundRequestStatusDisbursed, fundRequestStatusSettled,
+			settlementStatusApproved, settlementStatusSettled, fundRequestStatusDisbursed, fundRequestStatusSettled).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+
+	updated, err := (&BudgetDetailsPostsStore{db: db}).RecalculateUsage()
+	if err != nil {
+		t.Fatalf("RecalculateUsage() error = %v", err)
+	}
+	if updated != 4 {
+		t.Errorf("RecalculateUsage() = %d, want 4", updated)
+	}
+}
@@ -81,7 +81,7 @@ func (s *APIServer) CreateBudgetPost(w http.ResponseWriter, r *http.Request, bod
 		return respondWithError(requestLog, "name already in use", nil)
 	}
 
-	budgetPost, err := s.Storage.BudgetPostsStorage.Create(reqBody)
+	budgetPost, err := s.Storage.BudgetPostsStorage.Create(reqBody, s.newAudit(r, "budget-posts", auditActionCreate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -124,7 +124,7 @@ func (s *APIServer) UpdateBudgetPost(w http.ResponseWriter, r *http.Request, bod
 		return respondWithError(requestLog, "name already in use", nil)
 	}
 
-	updatedBudgetPost, err := s.Storage.BudgetPostsStorage.Update(id, reqBody)
+	updatedBudgetPost, err := s.Storage.BudgetPostsStorage.Update(id, reqBody, s.newAudit(r, "budget-posts", auditActionUpdate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -152,7 +152,7 @@ func (s *APIServer) DeleteBudgetPost(w http.ResponseWriter, r *http.Request, bod
 		return respondWithError(requestLog, message, err)
 	}
 
-	deletedBudgetPost, err := s.Storage.BudgetPostsStorage.Delete(id)
+	deletedBudgetPost, err := s.Storage.BudgetPostsStorage.Delete(id, s.newAudit(r, "budget-posts", auditActionDelete))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -186,10 +186,11 @@ func (s *APIServer) UpdateBudgetPostActiveByID(w http.ResponseWriter, r *http.Re
 		return respondWithError(requestLog, message, err)
 	}
 
-	updatedBudgetPost, err := s.Storage.BudgetPostsStorage.UpdateActive(id, reqBody)
+	updatedBudgetPost, err := s.Storage.BudgetPostsStorage.UpdateActive(id, reqBody, s.newAudit(r, "budget-posts", auditActionUpdate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
 	return respondWithSuccess(requestLog, updatedBudgetPost)
 
 }
@@ -6,10 +6,10 @@ import (
 )
 
 type BudgetPostsStorage interface {
-	Create(*BudgetPosts) (*BudgetPosts, error)
-	Delete(int64) (*BudgetPosts, error)
-	Update(int64, *BudgetPosts) (*BudgetPosts, error)
-	UpdateActive(int64, *BudgetPosts) (*BudgetPosts, error)
+	Create(*BudgetPosts, *Audit) (*BudgetPosts, error)
+	Delete(int64, *Audit) (*BudgetPosts, error)
+	Update(int64, *BudgetPosts, *Audit) (*BudgetPosts, error)
+	UpdateActive(int64, *BudgetPosts, *Audit) (*BudgetPosts, error)
 	GetById(int64) (*BudgetPosts, error)
 	GetAll() ([]*BudgetPosts, error)
 	GetByName(string) (*BudgetPosts, error)
@@ -58,9 +58,9 @@ func (s *BudgetPostsStore) GetAll() ([]*BudgetPosts, error) {
 	return budgetPostsList, nil
 }
 
-func (s *BudgetPostsStore) GetById(id int64) (*BudgetPosts, error) {
+func getBudgetPostById(q querier, id int64) (*BudgetPosts, error) {
 	query := `SELECT id, name, description, is_active, created_at, updated_at FROM budget_posts WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+	row := q.QueryRow(query, id)
 
 	budgetPost := &BudgetPosts{}
 	err := row.Scan(&budgetPost.ID, &budgetPost.Name, &budgetPost.Description, &budgetPost.IsActive, &budgetPost.CreatedAt, &budgetPost.UpdatedAt)
@@ -73,7 +73,11 @@ func (s *BudgetPostsStore) GetById(id int64) (*BudgetPosts, error) {
 	return budgetPost, nil
 }
 
-func (s *BudgetPostsStore) Create(budgetPost *BudgetPosts) (*BudgetPosts, error) {
+func (s *BudgetPostsStore) GetById(id int64) (*BudgetPosts, error) {
+	return getBudgetPostById(s.db, id)
+}
+
+func (s *BudgetPostsStore) Create(budgetPost *BudgetPosts, audit *Audit) (*BudgetPosts, error) {
 	existingBudgetPost, err := s.GetByName(budgetPost.Name)
 	if err != nil {
 		return nil, fmt.Errorf("error checking name: %w", err)
@@ -83,33 +87,27 @@ func (s *BudgetPostsStore) Create(budgetPost *BudgetPosts) (*BudgetPosts, error)
 	}
 
 	query := `INSERT INTO budget_posts (name, description, is_active, created_at, updated_at) VALUES (?, ?, ?, now(), now())`
-	result, err := s.db.Exec(query, budgetPost.Name, budgetPost.Description, budgetPost.IsActive)
+	_, created, err := auditedExec(s.db, audit, getBudgetPostById, 0, query, budgetPost.Name, budgetPost.Description, budgetPost.IsActive)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert budget post: %w", err)
 	}
-	lastInsertID, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get last insert id: %w", err)
-	}
-	return s.GetById(lastInsertID)
+	return created, nil
 }
 
-func (s *BudgetPostsStore) Delete(id int64) (*BudgetPosts, error) {
-	budgetPost, _ := s.GetById(id)
-	if budgetPost == nil {
-		return nil, fmt.Errorf("budget post not found")
-	}
-
+func (s *BudgetPostsStore) Delete(id int64, audit *Audit) (*BudgetPosts, error) {
 	query := `DELETE FROM budget_posts WHERE id = ?`
-	_, err := s.db.Exec(query, id)
+	budgetPost, _, err := auditedExec(s.db, audit, getBudgetPostById, id, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete budget post: %w", err)
 	}
+	if budgetPost == nil {
+		return nil, fmt.Errorf("budget post not found")
+	}
 
 	return budgetPost, nil
 }
 
-func (s *BudgetPostsStore) Update(id int64, budgetPost *BudgetPosts) (*BudgetPosts, error) {
+func (s *BudgetPostsStore) Update(id int64, budgetPost *BudgetPosts, audit *Audit) (*BudgetPosts, error) {
 	existingBudgetPost, err := s.GetByName(budgetPost.Name)
 	if err != nil {
 		return nil, fmt.Errorf("error checking name: %w", err)
@@ -119,20 +117,20 @@ func (s *BudgetPostsStore) Update(id int64, budgetPost *BudgetPosts) (*BudgetPos
 	}
 
 	query := `UPDATE budget_posts SET name = ?, description = ?, is_active = ?, updated_at = now() WHERE id = ?`
-	_, err = s.db.Exec(query, budgetPost.Name, budgetPost.Description, budgetPost.IsActive, id)
+	_, updated, err := auditedExec(s.db, audit, getBudgetPostById, id, query, budgetPost.Name, budgetPost.Description, budgetPost.IsActive, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update budget post: %w", err)
 	}
 
-	return s.GetById(id)
+	return updated, nil
 }
 
-func (s *BudgetPostsStore) UpdateActive(id int64, budgetPost *BudgetPosts) (*BudgetPosts, error) {
+func (s *BudgetPostsStore) UpdateActive(id int64, budgetPost *BudgetPosts, audit *Audit) (*BudgetPosts, error) {
 	query := `UPDATE budget_posts SET is_active = ?, updated_at = now() WHERE id = ?`
-	_, err := s.db.Exec(query, budgetPost.IsActive, id)
+	_, updated, err := auditedExec(s.db, audit, getBudgetPostById, id, query, budgetPost.IsActive, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update budget post: %w", err)
 	}
 
-	return s.GetById(id)
+	return updated, nil
 }
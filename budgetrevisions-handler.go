@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// diffAmounts compares the named amounts of two versions of a line.
+func diffAmounts(before map[string]float64, after map[string]float64) map[string]AuditChange {
+	changes := map[string]AuditChange{}
+	for name, value := range after {
+		if before[name] != value {
+			changes[name] = AuditChange{Before: before[name], After: value}
+		}
+	}
+	return changes
+}
+
+func detailAmounts(detail *BudgetDetails) map[string]float64 {
+	return map[string]float64{"quantity": detail.Quantity, "unit_value": detail.UnitValue, "terms": detail.Terms, "total": detail.Total}
+}
+
+func detailPostAmounts(post *BudgetDetailsPosts) map[string]float64 {
+	return map[string]float64{"planned_amount": post.PlannedAmount, "approved_amount": post.ApprovedAmount, "usage_amount": post.UsageAmount}
+}
+
+func capAmounts(budgetCap *BudgetCaps) map[string]float64 {
+	return map[string]float64{"amount": budgetCap.Amount}
+}
+
+// diffLines matches lines of two snapshots by ID and reports added, removed
+// and amount-changed ones.
+func diffLines(entity string, from map[int64]map[string]float64, to map[int64]map[string]float64, order []int64) []*RevisionDiffLine {
+	var lines []*RevisionDiffLine
+	for _, id := range order {
+		before, inFrom := from[id]
+		after, inTo := to[id]
+		switch {
+		case inFrom && !inTo:
+			removed := map[string]AuditChange{}
+			for name, value := range before {
+				removed[name] = AuditChange{Before: value, After: nil}
+			}
+			lines = append(lines, &RevisionDiffLine{Entity: entity, ID: id, Change: "removed", Amounts: removed})
+		case !inFrom && inTo:
+			added := map[string]AuditChange{}
+			for name, value := range after {
+				added[name] = AuditChange{Before: nil, After: value}
+			}
+			lines = append(lines, &RevisionDiffLine{Entity: entity, ID: id, Change: "added", Amounts: added})
+		default:
+			if changes := diffAmounts(before, after); len(changes) > 0 {
+				lines = append(lines, &RevisionDiffLine{Entity: entity, ID: id, Change: "changed", Amounts: changes})
+			}
+		}
+	}
+	return lines
+}
+
+func diffBudgetSnapshots(from *BudgetSnapshot, to *BudgetSnapshot) []*RevisionDiffLine {
+	lines := []*RevisionDiffLine{}
+
+	collect := func(seen map[int64]bool, order *[]int64, id int64) {
+		if !seen[id] {
+			seen[id] = true
+			*order = append(*order, id)
+		}
+	}
+
+	fromDetails, toDetails, seen, order := map[int64]map[string]float64{}, map[int64]map[string]float64{}, map[int64]bool{}, []int64{}
+	for _, detail := range from.Details {
+		fromDetails[detail.ID] = detailAmounts(detail)
+		collect(seen, &order, detail.ID)
+	}
+	for _, detail := range to.Details {
+		toDetails[detail.ID] = detailAmounts(detail)
+		collect(seen, &order, detail.ID)
+	}
+	lines = append(lines, diffLines("budget_details", fromDetails, toDetails, order)...)
+
+	fromPosts, toPosts, seen, order := map[int64]map[string]float64{}, map[int64]map[string]float64{}, map[int64]bool{}, []int64{}
+	for _, post := range from.DetailPosts {
+		fromPosts[post.ID] = detailPostAmounts(post)
+		collect(seen, &order, post.ID)
+	}
+	for _, post := range to.DetailPosts {
+		toPosts[post.ID] = detailPostAmounts(post)
+		collect(seen, &order, post.ID)
+	}
+	lines = append(lines, diffLines("budget_details_posts", fromPosts, toPosts, order)...)
+
+	fromCaps, toCaps, seen, order := map[int64]map[string]float64{}, map[int64]map[string]float64{}, map[int64]bool{}, []int64{}
+	for _, budgetCap := range from.Caps {
+		fromCaps[budgetCap.ID] = capAmounts(budgetCap)
+		collect(seen, &order, budgetCap.ID)
+	}
+	for _, budgetCap := range to.Caps {
+		toCaps[budgetCap.ID] = capAmounts(budgetCap)
+		collect(seen, &order, budgetCap.ID)
+	}
+	lines = append(lines, diffLines("budget_caps", fromCaps, toCaps, order)...)
+
+	return lines
+}
+
+func (s *APIServer) GetBudgetRevisions(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	message, err := s.validateBudgetsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	revisions, err := s.Storage.BudgetRevisionsStorage.GetByBudget(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, revisions)
+
+}
+
+func (s *APIServer) GetBudgetRevision(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	number, err := strconv.Atoi(mux.Vars(r)["revision"])
+	if err != nil {
+		return respondWithError(requestLog, "invalid revision", err)
+	}
+
+	message, err := s.validateBudgetsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	revision, err := s.Storage.BudgetRevisionsStorage.GetByRevision(id, number)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if revision == nil {
+		return respondWithError(requestLog, "budget revision not found", nil)
+	}
+	return respondWithSuccess(requestLog, revision)
+
+}
+
+func (s *APIServer) CreateBudgetRevision(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reqBody := &RevisionRequest{}
+	if len(bodyBytes) > 0 {
+		if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+			return respondWithError(requestLog, "invalid data request", err)
+		}
+	}
+
+	message, err := s.validateBudgetsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	usersID, _ := s.GetUserID(r)
+	revision, err := s.Storage.BudgetRevisionsStorage.Create(id, reqBody.Note, usersID, s.newAudit(r, "budgets", "revision"))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, revision)
+
+}
+
+// loadRevisionSnapshot returns the snapshot of a revision number, or the
+// current state of the budget for "current".
+func (s *APIServer) loadRevisionSnapshot(budgetsID int64, value string) (*BudgetSnapshot, string, error) {
+	if value == "current" {
+		snapshot, err := s.Storage.BudgetRevisionsStorage.GetCurrent(budgetsID)
+		if err != nil {
+			return nil, "database error", err
+		}
+		return snapshot, "ok", nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, "invalid revision " + value, err
+	}
+	revision, err := s.Storage.BudgetRevisionsStorage.GetByRevision(budgetsID, number)
+	if err != nil {
+		return nil, "database error", err
+	}
+	if revision == nil {
+		return nil, fmt.Sprintf("budget revision %d not found", number), fmt.Errorf("budget revision not found")
+	}
+	return revision.Snapshot, "ok", nil
+}
+
+// GetBudgetRevisionDiff compares two revisions, e.g.
+// GET /budgets/{id}/revisions/diff?from=1&to=2. to defaults to "current".
+func (s *APIServer) GetBudgetRevisionDiff(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	message, err := s.validateBudgetsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	diff := &BudgetRevisionDiff{
+		From: r.URL.Query().Get("from"),
+		To:   r.URL.Query().Get("to"),
+	}
+	if diff.From == "" {
+		return respondWithError(requestLog, "from must be filled", nil)
+	}
+	if diff.To == "" {
+		diff.To = "current"
+	}
+
+	from, message, err := s.loadRevisionSnapshot(id, diff.From)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+	to, message, err := s.loadRevisionSnapshot(id, diff.To)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	diff.Lines = diffBudgetSnapshots(from, to)
+	return respondWithSuccess(requestLog, diff)
+
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+type BudgetRevisionsStorage interface {
+	Create(int64, string, int64, *Audit) (*BudgetRevisions, error)
+	GetByBudget(int64) ([]*BudgetRevisions, error)
+	GetByRevision(int64, int) (*BudgetRevisions, error)
+	GetCurrent(int64) (*BudgetSnapshot, error)
+}
+
+type BudgetRevisionsStore struct {
+	db *sql.DB
+}
+
+func NewBudgetRevisionsStorage(db *sql.DB) *BudgetRevisionsStore {
+	return &BudgetRevisionsStore{
+		db: db,
+	}
+}
+
+// loadBudgetSnapshot reads a budget with its full tree.
+func loadBudgetSnapshot(q querier, budgetsID int64) (*BudgetSnapshot, error) {
+	snapshot := &BudgetSnapshot{Budget: &Budgets{}}
+
+	var fiscalPeriodsID sql.NullInt64
+	query := `SELECT id, name, description, fiscal_periods_id, status, units_id, created_at, updated_at FROM budgets WHERE id = ?`
+	budget := snapshot.Budget
+	err := q.QueryRow(query, budgetsID).Scan(&budget.ID, &budget.Name, &budget.Description, &fiscalPeriodsID, &budget.Status, &budget.UnitsID, &budget.CreatedAt, &budget.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget: %w", err)
+	}
+	budget.FiscalPeriodsID = fiscalPeriodsID.Int64
+
+	query = `SELECT id, budgets_id, activities_id, description, target, quantity, unit_value, total, terms, created_at, updated_at FROM budget_details WHERE budgets_id = ? ORDER BY id`
+	rows, err := q.Query(query, budgetsID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget details: %w", err)
+	}
+	for rows.Next() {
+		detail := &BudgetDetails{}
+		if err := rows.Scan(&detail.ID, &detail.BudgetsID, &detail.ActivitiesID, &detail.Description, &detail.Target, &detail.Quantity, &detail.UnitValue, &detail.Total, &detail.Terms, &detail.CreatedAt, &detail.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan budget detail: %w", err)
+		}
+		snapshot.Details = append(snapshot.Details, detail)
+	}
+	rows.Close()
+
+	query = `SELECT bdp.id, bdp.budget_details_id, bdp.budget_posts_id, bdp.planned_amount, bdp.approved_amount, bdp.usage_amount, bdp.created_at, bdp.updated_at
+		FROM budget_details_posts bdp JOIN budget_details bd ON bd.id = bdp.budget_details_id
+		WHERE bd.budgets_id = ? ORDER BY bdp.id`
+	rows, err = q.Query(query, budgetsID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget details posts: %w", err)
+	}
+	for rows.Next() {
+		post := &BudgetDetailsPosts{}
+		if err := rows.Scan(&post.ID, &post.BudgetDetailsID, &post.BudgetPostsID, &post.PlannedAmount, &post.ApprovedAmount, &post.UsageAmount, &post.CreatedAt, &post.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan budget details post: %w", err)
+		}
+		snapshot.DetailPosts = append(snapshot.DetailPosts, post)
+	}
+	rows.Close()
+
+	query = `SELECT id, budgets_id, budget_posts_id, amount, created_at, updated_at FROM budget_caps WHERE budgets_id = ? ORDER BY id`
+	rows, err = q.Query(query, budgetsID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget caps: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		budgetCap := &BudgetCaps{}
+		if err := rows.Scan(&budgetCap.ID, &budgetCap.BudgetsID, &budgetCap.BudgetPostsID, &budgetCap.Amount, &budgetCap.CreatedAt, &budgetCap.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan budget cap: %w", err)
+		}
+		snapshot.Caps = append(snapshot.Caps, budgetCap)
+	}
+	return snapshot, nil
+}
+
+// createBudgetRevision stores the next numbered snapshot of a budget. The
+// budget row is locked so concurrent revisions get distinct numbers.
+func createBudgetRevision(tx *sql.Tx, budgetsID int64, note string, usersID int64) (int64, error) {
+	var lockedID int64
+	if err := tx.QueryRow(`SELECT id FROM budgets WHERE id = ? FOR UPDATE`, budgetsID).Scan(&lockedID); err != nil {
+		return 0, fmt.Errorf("failed to lock budget: %w", err)
+	}
+
+	snapshot, err := loadBudgetSnapshot(tx, budgetsID)
+	if err != nil {
+		return 0, err
+	}
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode budget snapshot: %w", err)
+	}
+
+	query := `INSERT INTO budget_revisions (budgets_id, revision, note, snapshot, users_id, created_at)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, now() FROM budget_revisions WHERE budgets_id = ?`
+	result, err := tx.Exec(query, budgetsID, note, encoded, nullInt64(usersID), budgetsID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert budget revision: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+func (s *BudgetRevisionsStore) Create(budgetsID int64, note string, usersID int64, audit *Audit) (*BudgetRevisions, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := createBudgetRevision(tx, budgetsID, note, usersID)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT id, budgets_id, revision, note, snapshot, users_id, created_at FROM budget_revisions WHERE id = ?`
+	revision, err := getBudgetRevision(tx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, budgetsID, nil, map[string]interface{}{"revision": revision.Revision, "note": revision.Note}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return revision, nil
+}
+
+func getBudgetRevision(q querier, query string, args ...any) (*BudgetRevisions, error) {
+	revision := &BudgetRevisions{}
+	var note sql.NullString
+	var snapshot []byte
+	var usersID sql.NullInt64
+	err := q.QueryRow(query, args...).Scan(&revision.ID, &revision.BudgetsID, &revision.Revision, &note, &snapshot, &usersID, &revision.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get budget revision: %w", err)
+	}
+	if err := json.Unmarshal(snapshot, &revision.Snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode budget snapshot: %w", err)
+	}
+	revision.Note = note.String
+	revision.UsersID = usersID.Int64
+	return revision, nil
+}
+
+func (s *BudgetRevisionsStore) GetByRevision(budgetsID int64, number int) (*BudgetRevisions, error) {
+	query := `SELECT id, budgets_id, revision, note, snapshot, users_id, created_at FROM budget_revisions WHERE budgets_id = ? AND revision = ?`
+	return getBudgetRevision(s.db, query, budgetsID, number)
+}
+
+// GetByBudget lists the revisions of a budget without their snapshots.
+func (s *BudgetRevisionsStore) GetByBudget(budgetsID int64) ([]*BudgetRevisions, error) {
+	query := `SELECT id, budgets_id, revision, note, users_id, created_at FROM budget_revisions WHERE budgets_id = ? ORDER BY revision`
+	rows, err := s.db.Query(query, budgetsID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []*BudgetRevisions
+	for rows.Next() {
+		revision := &BudgetRevisions{}
+		var note sql.NullString
+		var usersID sql.NullInt64
+		if err := rows.Scan(&revision.ID, &revision.BudgetsID, &revision.Revision, &note, &usersID, &revision.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan budget revision: %w", err)
+		}
+		revision.Note = note.String
+		revision.UsersID = usersID.Int64
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+func (s *BudgetRevisionsStore) GetCurrent(budgetsID int64) (*BudgetSnapshot, error) {
+	return loadBudgetSnapshot(s.db, budgetsID)
+}
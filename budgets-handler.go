@@ -14,8 +14,8 @@ func validateBudgetsRequest(reqBody *Budgets) error {
 		return fmt.Errorf("max length name 255")
 	} else if len(reqBody.Description) > 255 {
 		return fmt.Errorf("max length description 255")
-	} else if reqBody.Periode == "" {
-		return fmt.Errorf("periode must be filled")
+	} else if reqBody.FiscalPeriodsID <= 0 {
+		return fmt.Errorf("fiscal periods id must be filled")
 	} else if reqBody.UnitsID == 0 {
 		return fmt.Errorf("unitsID must be filled and valid")
 	}
@@ -40,9 +40,84 @@ func (s *APIServer) validateBudgetsForeignKey(primaryKey *PrimaryKeyID, validate
 	return "ok", nil
 }
 
+func (s *APIServer) validateBudgetsScope(r *http.Request, budgetsID int64) (string, error) {
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return "unauthorized", err
+	}
+
+	budget, err := s.Storage.BudgetsStorage.GetByIdInScope(budgetsID, usersID)
+	if err != nil {
+		return "database error", err
+	}
+
+	if budget == nil {
+		return "budgets not found", fmt.Errorf("budgets not found")
+	}
+
+	return "ok", nil
+}
+
+// checkBudgetEditable rejects changes to anything under a budget that is no
+// longer in draft or revision_requested.
+func (s *APIServer) checkBudgetEditable(budgetsID int64) (string, error) {
+
+	budget, err := s.Storage.BudgetsStorage.GetById(budgetsID)
+	if err != nil {
+		return "database error", err
+	}
+	if budget == nil {
+		return "budgets not found", fmt.Errorf("budgets not found")
+	}
+	if !budgetEditable(budget.Status) {
+		message := "budget can only be changed in status draft or revision_requested"
+		return message, fmt.Errorf("%s", message)
+	}
+
+	return "ok", nil
+}
+
+func (s *APIServer) checkBudgetDetailEditable(budgetDetailsID int64) (string, error) {
+
+	budgetDetail, err := s.Storage.BudgetDetailsStorage.GetById(budgetDetailsID)
+	if err != nil {
+		return "database error", err
+	}
+	if budgetDetail == nil {
+		return "data budget details not found", fmt.Errorf("data budget details not found")
+	}
+
+	return s.checkBudgetEditable(budgetDetail.BudgetsID)
+}
+
+func (s *APIServer) validateUnitsScope(r *http.Request, unitsID int64) (string, error) {
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return "unauthorized", err
+	}
+
+	inScope, err := s.Storage.UnitsStorage.InScope(usersID, unitsID)
+	if err != nil {
+		return "database error", err
+	}
+
+	if !inScope {
+		return "access to unit denied", fmt.Errorf("access to unit denied")
+	}
+
+	return "ok", nil
+}
+
 func (s *APIServer) GetAllBudgets(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
 
-	budgets, err := s.Storage.BudgetsStorage.GetAll()
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budgets, err := s.Storage.BudgetsStorage.GetAllInScope(usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -57,10 +132,18 @@ func (s *APIServer) GetBudgetByID(w http.ResponseWriter, r *http.Request, bodyBy
 		return respondWithError(requestLog, "invalid ID", err)
 	}
 
-	budget, err := s.Storage.BudgetsStorage.GetById(id)
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	budget, err := s.Storage.BudgetsStorage.GetByIdInScope(id, usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+	if budget == nil {
+		return respondWithError(requestLog, "budgets not found", nil)
+	}
 	return respondWithSuccess(requestLog, budget)
 
 }
@@ -76,6 +159,11 @@ func (s *APIServer) CreateBudget(w http.ResponseWriter, r *http.Request, bodyByt
 		return respondWithError(requestLog, err.Error(), nil)
 	}
 
+	message, err := s.validateUnitsScope(r, reqBody.UnitsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
 	budgetByName, err := s.Storage.BudgetsStorage.GetByName(reqBody.Name)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
@@ -85,7 +173,12 @@ func (s *APIServer) CreateBudget(w http.ResponseWriter, r *http.Request, bodyByt
 		return respondWithError(requestLog, "name already in use", nil)
 	}
 
-	budget, err := s.Storage.BudgetsStorage.Create(reqBody)
+	message, err = s.checkPeriodWritable(r, reqBody.FiscalPeriodsID, true)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	budget, err := s.Storage.BudgetsStorage.Create(reqBody, s.newAudit(r, "budgets", auditActionCreate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -119,6 +212,16 @@ func (s *APIServer) UpdateBudget(w http.ResponseWriter, r *http.Request, bodyByt
 		return respondWithError(requestLog, message, err)
 	}
 
+	message, err = s.validateBudgetsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateUnitsScope(r, reqBody.UnitsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
 	budgetByName, err := s.Storage.BudgetsStorage.GetByName(reqBody.Name)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
@@ -128,7 +231,27 @@ func (s *APIServer) UpdateBudget(w http.ResponseWriter, r *http.Request, bodyByt
 		return respondWithError(requestLog, "name already in use", nil)
 	}
 
-	updatedBudget, err := s.Storage.BudgetsStorage.Update(id, reqBody)
+	before, err := s.Storage.BudgetsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if !budgetEditable(before.Status) {
+		return respondWithError(requestLog, "budget can only be changed in status draft or revision_requested", nil)
+	}
+
+	if before.FiscalPeriodsID != 0 {
+		message, err = s.checkPeriodWritable(r, before.FiscalPeriodsID, false)
+		if err != nil {
+			return respondWithError(requestLog, message, err)
+		}
+	}
+
+	message, err = s.checkPeriodWritable(r, reqBody.FiscalPeriodsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	updatedBudget, err := s.Storage.BudgetsStorage.Update(id, reqBody, s.newAudit(r, "budgets", auditActionUpdate))
 	if err != nil {
 		return respondWithError(requestLog, "error updating budget", err)
 	}
@@ -156,10 +279,29 @@ func (s *APIServer) DeleteBudget(w http.ResponseWriter, r *http.Request, bodyByt
 		return respondWithError(requestLog, message, err)
 	}
 
-	deletedBudget, err := s.Storage.BudgetsStorage.Delete(id)
+	message, err = s.validateBudgetsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	budget, err := s.Storage.BudgetsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if budget.Status != budgetStatusDraft {
+		return respondWithError(requestLog, "only draft budgets can be deleted", nil)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, id, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	deletedBudget, err := s.Storage.BudgetsStorage.Delete(id, s.newAudit(r, "budgets", auditActionDelete))
 	if err != nil {
 		return respondWithError(requestLog, "error deleting budget", err)
 	}
+
 	// if deletedBudget == nil {
 	// 	return respondWithError(requestLog, "data budgets not found", err)
 	// }
@@ -167,32 +309,168 @@ func (s *APIServer) DeleteBudget(w http.ResponseWriter, r *http.Request, bodyByt
 
 }
 
-func (s *APIServer) UpdateBudgetApproval(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+// BudgetTransition returns the handler for one workflow action, e.g.
+// POST /budgets/{id}/approve.
+func (s *APIServer) BudgetTransition(action string) func(http.ResponseWriter, *http.Request, []byte, map[string]interface{}) (interface{}, error) {
+	return func(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+		id, err := s.GetID(r)
+		if err != nil {
+			return respondWithError(requestLog, "invalid ID", err)
+		}
+
+		claims, err := s.GetUserClaims(r)
+		if err != nil {
+			return respondWithError(requestLog, "unauthorized", err)
+		}
+
+		reqBody := &TransitionRequest{}
+		if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+			return respondWithError(requestLog, "invalid data request", err)
+		}
+
+		message, err := s.validateBudgetsScope(r, id)
+		if err != nil {
+			return respondWithError(requestLog, message, err)
+		}
+
+		before, err := s.Storage.BudgetsStorage.GetById(id)
+		if err != nil {
+			return respondWithError(requestLog, "database error", err)
+		}
+
+		next, err := budgetWorkflow.Next(action, before.Status, reqBody.Comment)
+		if err != nil {
+			return respondWithError(requestLog, err.Error(), nil)
+		}
+		if err := budgetWorkflow.Authorize(action, claims); err != nil {
+			return respondWithError(requestLog, err.Error(), nil)
+		}
+
+		message, err = s.checkBudgetPeriodWritable(r, id, false)
+		if err != nil {
+			return respondWithError(requestLog, message, err)
+		}
+
+		usersID, _ := claims.UsersID()
+		updatedBudget, err := s.Storage.BudgetsStorage.Transition(&StatusTransitions{
+			Entity:     budgetWorkflow.Entity,
+			EntityID:   id,
+			Action:     action,
+			FromStatus: before.Status,
+			ToStatus:   next,
+			Comment:    reqBody.Comment,
+			UsersID:    usersID,
+		}, s.newAudit(r, "budgets", action))
+		if err != nil {
+			return respondWithError(requestLog, "error updating budget status", err)
+		}
+
+		return respondWithSuccess(requestLog, updatedBudget)
+	}
+}
+
+func (s *APIServer) GetBudgetTransitions(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
 
 	id, err := s.GetID(r)
 	if err != nil {
 		return respondWithError(requestLog, "invalid ID", err)
 	}
 
-	newPrimaryKey := &PrimaryKeyID{
-		BudgetsID: id,
+	message, err := s.validateBudgetsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
 	}
 
-	message, err := s.validateBudgetsForeignKey(newPrimaryKey, true)
+	transitions, err := s.Storage.StatusTransitionsStorage.GetByEntity(budgetWorkflow.Entity, id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, transitions)
+
+}
+
+func (s *APIServer) GetBudgetCapUtilization(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	message, err := s.validateBudgetsScope(r, id)
 	if err != nil {
 		return respondWithError(requestLog, message, err)
 	}
 
-	reqBody := &Budgets{}
+	utilization, err := s.Storage.BudgetCapsStorage.GetUtilization(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, utilization)
+
+}
+
+// CloneBudget copies a budget's full tree into a target fiscal period as a
+// new draft budget.
+func (s *APIServer) CloneBudget(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reqBody := &BudgetCloneRequest{}
 	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
 		return respondWithError(requestLog, "invalid data request", err)
 	}
+	if reqBody.FiscalPeriodsID <= 0 {
+		return respondWithError(requestLog, "fiscal periods id must be filled", nil)
+	} else if reqBody.UpliftPercent <= -100 {
+		return respondWithError(requestLog, "uplift percent must be greater than -100", nil)
+	}
 
-	updatedBudget, err := s.Storage.BudgetsStorage.UpdateApproved(id, reqBody)
+	message, err := s.validateBudgetsScope(r, id)
 	if err != nil {
-		return respondWithError(requestLog, "error updating budget approval", err)
+		return respondWithError(requestLog, message, err)
 	}
 
-	return respondWithSuccess(requestLog, updatedBudget)
+	source, err := s.Storage.BudgetsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	message, err = s.checkPeriodWritable(r, reqBody.FiscalPeriodsID, true)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	if reqBody.Name == "" {
+		period, err := s.Storage.FiscalPeriodsStorage.GetById(reqBody.FiscalPeriodsID)
+		if err != nil {
+			return respondWithError(requestLog, "database error", err)
+		}
+		reqBody.Name = fmt.Sprintf("%s (%s)", source.Name, period.Code)
+	}
+	if len(reqBody.Name) > 255 {
+		return respondWithError(requestLog, "max length name 255", nil)
+	}
+
+	budgetByName, err := s.Storage.BudgetsStorage.GetByName(reqBody.Name)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if budgetByName != nil {
+		return respondWithError(requestLog, "name already in use", nil)
+	}
+
+	result, err := s.Storage.BudgetsStorage.Clone(id, reqBody, source.UnitsID, s.newAudit(r, "budgets", "clone"))
+	if limitErr := limitError(err); limitErr != nil {
+		return respondWithError(requestLog, limitErr.Error(), err)
+	}
+	if err != nil {
+		return respondWithError(requestLog, "error cloning budget", err)
+	}
+
+	return respondWithSuccess(requestLog, result)
 
 }
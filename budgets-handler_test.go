@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeBudgetsStorage struct {
+	BudgetsStorage
+	budgets     map[int64]*Budgets
+	transitions []*StatusTransitions
+}
+
+func (f *fakeBudgetsStorage) GetById(id int64) (*Budgets, error) {
+	if budget, ok := f.budgets[id]; ok {
+		copied := *budget
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeBudgetsStorage) GetByIdInScope(id int64, usersID int64) (*Budgets, error) {
+	return f.GetById(id)
+}
+
+func (f *fakeBudgetsStorage) Transition(transition *StatusTransitions, audit *Audit) (*Budgets, error) {
+	budget := f.budgets[transition.EntityID]
+	if budget.Status != transition.FromStatus {
+		return nil, fmt.Errorf("status was changed by another request")
+	}
+	budget.Status = transition.ToStatus
+	f.transitions = append(f.transitions, transition)
+	return f.GetById(transition.EntityID)
+}
+
+type fakeFiscalPeriodsStorage struct {
+	FiscalPeriodsStorage
+}
+
+func (f *fakeFiscalPeriodsStorage) GetById(id int64) (*FiscalPeriods, error) {
+	return &FiscalPeriods{ID: id, Code: "FY2026", Status: fiscalPeriodOpen}, nil
+}
+
+func TestBudgetTransitionWorkflow(t *testing.T) {
+	budgets := &fakeBudgetsStorage{budgets: map[int64]*Budgets{
+		1: {ID: 1, Name: "Operations", FiscalPeriodsID: 1, Status: budgetStatusDraft, UnitsID: 1},
+	}}
+	s := &APIServer{Storage: Storage{BudgetsStorage: budgets, FiscalPeriodsStorage: &fakeFiscalPeriodsStorage{}}}
+
+	owner := &UserClaims{}
+	owner.Subject = "10"
+	reviewer := &UserClaims{Roles: []string{"budget_reviewer"}}
+	reviewer.Subject = "11"
+	approver := &UserClaims{Roles: []string{"budget_approver"}}
+	approver.Subject = "12"
+
+	// The steps run in order on one budget.
+	tests := []struct {
+		action     string
+		claims     *UserClaims
+		body       string
+		wantErr    string
+		wantStatus string
+	}{
+		{action: "approve", claims: approver, body: `{"comment":"ok"}`, wantErr: "cannot approve budgets in status draft", wantStatus: budgetStatusDraft},
+		{action: "submit", claims: owner, body: `{"comment":"first draft"}`, wantStatus: budgetStatusSubmitted},
+		{action: "review", claims: owner, body: `{"comment":"looking"}`, wantErr: "action review requires role budget_reviewer or budget_approver", wantStatus: budgetStatusSubmitted},
+		{action: "review", claims: reviewer, body: `{"comment":"looking"}`, wantStatus: budgetStatusUnderReview},
+		{action: "approve", claims: reviewer, body: `{"comment":"ok"}`, wantErr: "action approve requires role budget_approver", wantStatus: budgetStatusUnderReview},
+		{action: "approve", claims: approver, body: `{"comment":" "}`, wantErr: "comment must be filled", wantStatus: budgetStatusUnderReview},
+		{action: "approve", claims: approver, body: `{"comment":"ok"}`, wantStatus: budgetStatusApproved},
+		{action: "submit", claims: owner, body: `{"comment":"again"}`, wantErr: "cannot submit budgets in status approved", wantStatus: budgetStatusApproved},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/budgets/1/"+tt.action, nil)
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey, tt.claims))
+
+		_, err := s.BudgetTransition(tt.action)(httptest.NewRecorder(), r, []byte(tt.body), map[string]interface{}{})
+		if tt.wantErr == "" && err != nil {
+			t.Fatalf("%s: BudgetTransition() error = %v", tt.action, err)
+		}
+		if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+			t.Fatalf("%s: BudgetTransition() error = %v, want %s", tt.action, err, tt.wantErr)
+		}
+		if status := budgets.budgets[1].Status; status != tt.wantStatus {
+			t.Fatalf("%s: status = %s, want %s", tt.action, status, tt.wantStatus)
+		}
+	}
+
+	// Only the three allowed transitions are recorded, with actor and comment.
+	want := []StatusTransitions{
+		{Action: "submit", FromStatus: budgetStatusDraft, ToStatus: budgetStatusSubmitted, Comment: "first draft", UsersID: 10},
+		{Action: "review", FromStatus: budgetStatusSubmitted, ToStatus: budgetStatusUnderReview, Comment: "looking", UsersID: 11},
+		{Action: "approve", FromStatus: budgetStatusUnderReview, ToStatus: budgetStatusApproved, Comment: "ok", UsersID: 12},
+	}
+	if len(budgets.transitions) != len(want) {
+		t.Fatalf("transitions = %d, want %d", len(budgets.transitions), len(want))
+	}
+	for i, got := range budgets.transitions {
+		if got.Entity != budgetWorkflow.Entity || got.EntityID != 1 || got.Action != want[i].Action ||
+			got.FromStatus != want[i].FromStatus || got.ToStatus != want[i].ToStatus ||
+			got.Comment != want[i].Comment || got.UsersID != want[i].UsersID {
+			t.Errorf("transition %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
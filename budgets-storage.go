@@ -6,12 +6,15 @@ import (
 )
 
 type BudgetsStorage interface {
-	Create(*Budgets) (*Budgets, error)
-	Delete(int64) (*Budgets, error)
-	Update(int64, *Budgets) (*Budgets, error)
+	Create(*Budgets, *Audit) (*Budgets, error)
+	Delete(int64, *Audit) (*Budgets, error)
+	Update(int64, *Budgets, *Audit) (*Budgets, error)
 	GetById(int64) (*Budgets, error)
 	GetAll() ([]*Budgets, error)
-	UpdateApproved(int64, *Budgets) (*Budgets, error)
+	GetAllInScope(int64) ([]*Budgets, error)
+	GetByIdInScope(int64, int64) (*Budgets, error)
+	Transition(*StatusTransitions, *Audit) (*Budgets, error)
+	Clone(int64, *BudgetCloneRequest, int64, *Audit) (*BudgetCloneResult, error)
 	GetByName(string) (*Budgets, error)
 }
 
@@ -26,37 +29,33 @@ func NewBudgetsStorage(db *sql.DB) *BudgetsStore {
 }
 
 func (s *BudgetsStore) GetByName(name string) (*Budgets, error) {
-	query := `SELECT id, name, description, periode, is_approved, units_id, created_at, updated_at FROM budgets WHERE name = ?`
+	query := `SELECT id, name, description, fiscal_periods_id, status, units_id, created_at, updated_at FROM budgets WHERE name = ?`
 	row := s.db.QueryRow(query, name)
 
 	budget := &Budgets{}
-	err := row.Scan(&budget.ID, &budget.Name, &budget.Description, &budget.Periode, &budget.IsApproved, &budget.UnitsID, &budget.CreatedAt, &budget.UpdatedAt)
+	var fiscalPeriodsID sql.NullInt64
+	err := row.Scan(&budget.ID, &budget.Name, &budget.Description, &fiscalPeriodsID, &budget.Status, &budget.UnitsID, &budget.CreatedAt, &budget.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to get budget by name: %w", err)
 	}
+	budget.FiscalPeriodsID = fiscalPeriodsID.Int64
 	return budget, nil
 }
 
-func (s *BudgetsStore) GetAll() ([]*Budgets, error) {
-	query := `SELECT id, name, description, periode, is_approved, units_id, created_at, updated_at FROM budgets`
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get budgets: %w", err)
-	}
-	defer rows.Close()
-
+func scanBudgets(rows *sql.Rows) ([]*Budgets, error) {
 	var budgetsList []*Budgets
 	for rows.Next() {
 		budget := &Budgets{}
+		var fiscalPeriodsID sql.NullInt64
 		err := rows.Scan(
 			&budget.ID,
 			&budget.Name,
 			&budget.Description,
-			&budget.Periode,
-			&budget.IsApproved,
+			&fiscalPeriodsID,
+			&budget.Status,
 			&budget.UnitsID,
 			&budget.CreatedAt,
 			&budget.UpdatedAt,
@@ -64,44 +63,82 @@ func (s *BudgetsStore) GetAll() ([]*Budgets, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan budget: %w", err)
 		}
+		budget.FiscalPeriodsID = fiscalPeriodsID.Int64
 		budgetsList = append(budgetsList, budget)
 	}
 	return budgetsList, nil
 }
 
-func (s *BudgetsStore) GetById(id int64) (*Budgets, error) {
-	query := `SELECT id, name, description, periode, is_approved, units_id, created_at, updated_at FROM budgets WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+func (s *BudgetsStore) GetAll() ([]*Budgets, error) {
+	query := `SELECT id, name, description, fiscal_periods_id, status, units_id, created_at, updated_at FROM budgets`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budgets: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgets(rows)
+}
+
+func (s *BudgetsStore) GetAllInScope(usersID int64) ([]*Budgets, error) {
+	query := unitScopeCTE + `SELECT id, name, description, fiscal_periods_id, status, units_id, created_at, updated_at FROM budgets WHERE units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budgets: %w", err)
+	}
+	defer rows.Close()
+	return scanBudgets(rows)
+}
+
+func getBudgetById(q querier, id int64) (*Budgets, error) {
+	query := `SELECT id, name, description, fiscal_periods_id, status, units_id, created_at, updated_at FROM budgets WHERE id = ?`
+	row := q.QueryRow(query, id)
 
 	budget := &Budgets{}
-	err := row.Scan(&budget.ID, &budget.Name, &budget.Description, &budget.Periode, &budget.IsApproved, &budget.UnitsID, &budget.CreatedAt, &budget.UpdatedAt)
+	var fiscalPeriodsID sql.NullInt64
+	err := row.Scan(&budget.ID, &budget.Name, &budget.Description, &fiscalPeriodsID, &budget.Status, &budget.UnitsID, &budget.CreatedAt, &budget.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to get budget by id: %w", err)
 	}
+	budget.FiscalPeriodsID = fiscalPeriodsID.Int64
 	return budget, nil
 }
 
-func (s *BudgetsStore) Create(budget *Budgets) (*Budgets, error) {
-	query := `INSERT INTO budgets (name, description, periode, is_approved, units_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, now(), now())`
-	result, err := s.db.Exec(query, budget.Name, budget.Description, budget.Periode, budget.IsApproved, budget.UnitsID)
+func (s *BudgetsStore) GetById(id int64) (*Budgets, error) {
+	return getBudgetById(s.db, id)
+}
+
+func (s *BudgetsStore) GetByIdInScope(id int64, usersID int64) (*Budgets, error) {
+	query := unitScopeCTE + `SELECT id, name, description, fiscal_periods_id, status, units_id, created_at, updated_at FROM budgets WHERE id = ? AND units_id IN (SELECT id FROM scoped_units)`
+	row := s.db.QueryRow(query, usersID, id)
+
+	budget := &Budgets{}
+	var fiscalPeriodsID sql.NullInt64
+	err := row.Scan(&budget.ID, &budget.Name, &budget.Description, &fiscalPeriodsID, &budget.Status, &budget.UnitsID, &budget.CreatedAt, &budget.UpdatedAt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert budget: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get budget by id: %w", err)
 	}
-	lastInsertID, err := result.LastInsertId()
+	budget.FiscalPeriodsID = fiscalPeriodsID.Int64
+	return budget, nil
+}
+
+func (s *BudgetsStore) Create(budget *Budgets, audit *Audit) (*Budgets, error) {
+	query := `INSERT INTO budgets (name, description, fiscal_periods_id, status, units_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, now(), now())`
+	_, created, err := auditedExec(s.db, audit, getBudgetById, 0, query, budget.Name, budget.Description, nullInt64(budget.FiscalPeriodsID), budgetStatusDraft, budget.UnitsID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+		return nil, fmt.Errorf("failed to insert budget: %w", err)
 	}
-	return s.GetById(lastInsertID)
+	return created, nil
 }
 
-func (s *BudgetsStore) Delete(id int64) (*Budgets, error) {
-	deletedBudget, _ := s.GetById(id)
-
+func (s *BudgetsStore) Delete(id int64, audit *Audit) (*Budgets, error) {
 	query := `DELETE FROM budgets WHERE id = ?`
-	_, err := s.db.Exec(query, id)
+	deletedBudget, _, err := auditedExec(s.db, audit, getBudgetById, id, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete budget: %w", err)
 	}
@@ -109,22 +146,52 @@ func (s *BudgetsStore) Delete(id int64) (*Budgets, error) {
 	return deletedBudget, nil
 }
 
-func (s *BudgetsStore) Update(id int64, budget *Budgets) (*Budgets, error) {
-	query := `UPDATE budgets SET name = ?, description = ?, periode = ?, is_approved = ?, units_id = ?, updated_at = now() WHERE id = ?`
-	_, err := s.db.Exec(query, budget.Name, budget.Description, budget.Periode, budget.IsApproved, budget.UnitsID, id)
+func (s *BudgetsStore) Update(id int64, budget *Budgets, audit *Audit) (*Budgets, error) {
+	query := `UPDATE budgets SET name = ?, description = ?, fiscal_periods_id = ?, units_id = ?, updated_at = now() WHERE id = ?`
+	_, updatedBudget, err := auditedExec(s.db, audit, getBudgetById, id, query, budget.Name, budget.Description, nullInt64(budget.FiscalPeriodsID), budget.UnitsID, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update budget: %w", err)
 	}
 
-	return s.GetById(id)
+	return updatedBudget, nil
 }
 
-func (s *BudgetsStore) UpdateApproved(id int64, budget *Budgets) (*Budgets, error) {
-	query := `UPDATE budgets SET is_approved = ?, updated_at = now() WHERE id = ?`
-	_, err := s.db.Exec(query, budget.IsApproved, id)
+func (s *BudgetsStore) Transition(transition *StatusTransitions, audit *Audit) (*Budgets, error) {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to update budget approval status: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	return s.GetById(id)
+	before, err := getBudgetById(tx, transition.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	// An approved budget that is reopened is kept as a revision before it can
+	// change, so later approvals can be diffed against it.
+	if transition.FromStatus == budgetStatusApproved {
+		if _, err := createBudgetRevision(tx, transition.EntityID, transition.Comment, transition.UsersID); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyStatusTransition(tx, "budgets", transition); err != nil {
+		return nil, err
+	}
+	if transition.ToStatus == budgetStatusApproved {
+		if _, err := createBudgetRevision(tx, transition.EntityID, transition.Comment, transition.UsersID); err != nil {
+			return nil, err
+		}
+	}
+	updated, err := getBudgetById(tx, transition.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, transition.EntityID, before, updated); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, nil
 }
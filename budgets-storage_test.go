@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// captureBytes matches any []byte argument and keeps it.
+type captureBytes struct {
+	value *[]byte
+}
+
+func (c captureBytes) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	if ok {
+		*c.value = b
+	}
+	return ok
+}
+
+func expectBudgetRow(mock sqlmock.Sqlmock, budgetsID int64, status string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM budgets WHERE id = ?`)).
+		WithArgs(budgetsID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "fiscal_periods_id", "status", "units_id", "created_at", "updated_at"}).
+			AddRow(budgetsID, "Operations", "", nil, status, 1, time.Time{}, time.Time{}))
+}
+
+// expectBudgetRevision expects createBudgetRevision for a budget with one
+// detail, one detail post and one cap, and keeps the stored snapshot.
+func expectBudgetRevision(mock sqlmock.Sqlmock, budgetsID int64, status string, capAmount float64, snapshot *[]byte) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM budgets WHERE id = ? FOR UPDATE`)).
+		WithArgs(budgetsID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(budgetsID))
+	expectBudgetRow(mock, budgetsID, status)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM budget_details WHERE budgets_id = ?`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "budgets_id", "activities_id", "description", "target", "quantity", "unit_value", "total", "terms", "created_at", "updated_at"}).
+			AddRow(3, budgetsID, 4, "Travel", time.Time{}, 2, 400, 800, 1, time.Time{}, time.Time{}))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM budget_details_posts bdp`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "budget_details_id", "budget_posts_id", "planned_amount", "approved_amount", "usage_amount", "created_at", "updated_at"}).
+			AddRow(5, 3, 2, 800, 800, 0, time.Time{}, time.Time{}))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM budget_caps WHERE budgets_id = ?`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "budgets_id", "budget_posts_id", "amount", "created_at", "updated_at"}).
+			AddRow(7, budgetsID, 2, capAmount, time.Time{}, time.Time{}))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO budget_revisions`)).
+		WithArgs(budgetsID, sqlmock.AnyArg(), captureBytes{value: snapshot}, sqlmock.AnyArg(), budgetsID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestBudgetAmendmentKeepsRevisions(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := &BudgetsStore{db: db}
+	approver := &UserClaims{Roles: []string{"budget_approver"}}
+
+	// The steps run in order on one budget; the cap is raised from 1000 to
+	// 1200 while the budget is reopened.
+	var revisions [][]byte
+	tests := []struct {
+		action       string
+		from         string
+		capAmount    float64
+		wantRevision bool
+	}{
+		{action: "approve", from: budgetStatusUnderReview, capAmount: 1000, wantRevision: true},
+		{action: "amend", from: budgetStatusApproved, capAmount: 1000, wantRevision: true},
+		{action: "submit", from: budgetStatusRevisionRequested, capAmount: 1200},
+		{action: "review", from: budgetStatusSubmitted, capAmount: 1200},
+		{action: "approve", from: budgetStatusUnderReview, capAmount: 1200, wantRevision: true},
+	}
+
+	for _, tt := range tests {
+		next, err := budgetWorkflow.Next(tt.action, tt.from, "comment")
+		if err != nil {
+			t.Fatalf("%s: Next() error = %v", tt.action, err)
+		}
+		if err := budgetWorkflow.Authorize(tt.action, approver); err != nil {
+			t.Fatalf("%s: Authorize() error = %v", tt.action, err)
+		}
+
+		var snapshot []byte
+		mock.ExpectBegin()
+		expectBudgetRow(mock, 1, tt.from)
+		if tt.wantRevision && tt.from == budgetStatusApproved {
+			expectBudgetRevision(mock, 1, tt.from, tt.capAmount, &snapshot)
+		}
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE budgets SET status = ?`)).
+			WithArgs(next, int64(1), tt.from).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO status_transitions`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		if tt.wantRevision && next == budgetStatusApproved {
+			expectBudgetRevision(mock, 1, next, tt.capAmount, &snapshot)
+		}
+		expectBudgetRow(mock, 1, next)
+		mock.ExpectCommit()
+
+		if _, err := store.Transition(&StatusTransitions{Entity: budgetWorkflow.Entity, EntityID: 1, Action: tt.action, FromStatus: tt.from, ToStatus: next, Comment: "comment"}, nil); err != nil {
+			t.Fatalf("%s: Transition() error = %v", tt.action, err)
+		}
+		if tt.wantRevision {
+			if snapshot == nil {
+				t.Fatalf("%s: no revision stored", tt.action)
+			}
+			revisions = append(revisions, snapshot)
+		}
+	}
+
+	decode := func(encoded []byte) *BudgetSnapshot {
+		snapshot := &BudgetSnapshot{}
+		if err := json.Unmarshal(encoded, snapshot); err != nil {
+			t.Fatal(err)
+		}
+		return snapshot
+	}
+	approved, reopened, reapproved := decode(revisions[0]), decode(revisions[1]), decode(revisions[2])
+	if reopened.Budget.Status != budgetStatusApproved {
+		t.Errorf("amend revision status = %s, want the approved budget", reopened.Budget.Status)
+	}
+	if lines := diffBudgetSnapshots(approved, reopened); len(lines) != 0 {
+		t.Errorf("diff approve -> amend = %d lines, want none", len(lines))
+	}
+	lines := diffBudgetSnapshots(reopened, reapproved)
+	if len(lines) != 1 || lines[0].Entity != "budget_caps" || lines[0].Change != "changed" {
+		t.Fatalf("diff amend -> approve = %+v, want the changed cap", lines)
+	}
+	if change := lines[0].Amounts["amount"]; change.Before != 1000.0 || change.After != 1200.0 {
+		t.Errorf("cap amount = %v -> %v, want 1000 -> 1200", change.Before, change.After)
+	}
+}
+
+func TestBudgetAmendRequiresApprover(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   []string
+		status  string
+		comment string
+		wantErr bool
+	}{
+		{name: "approver amends", roles: []string{"budget_approver"}, status: budgetStatusApproved, comment: "mid-year change"},
+		{name: "reviewer amends", roles: []string{"budget_reviewer"}, status: budgetStatusApproved, comment: "mid-year change", wantErr: true},
+		{name: "without comment", roles: []string{"budget_approver"}, status: budgetStatusApproved, wantErr: true},
+		{name: "not approved yet", roles: []string{"budget_approver"}, status: budgetStatusUnderReview, comment: "mid-year change", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := budgetWorkflow.Next("amend", tt.status, tt.comment)
+			if err == nil {
+				err = budgetWorkflow.Authorize("amend", &UserClaims{Roles: tt.roles})
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("amend error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBudgetTransitionChangedConcurrently(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := &BudgetsStore{db: db}
+
+	// Another request moved the budget on after the handler read it, so the
+	// guarded UPDATE matches no row and nothing is recorded.
+	mock.ExpectBegin()
+	expectBudgetRow(mock, 1, budgetStatusUnderReview)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE budgets SET status = ?`)).
+		WithArgs(budgetStatusSubmitted, int64(1), budgetStatusDraft).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	_, err := store.Transition(&StatusTransitions{Entity: budgetWorkflow.Entity, EntityID: 1, Action: "submit", FromStatus: budgetStatusDraft, ToStatus: budgetStatusSubmitted, Comment: "comment"}, nil)
+	if err == nil || err.Error() != "status was changed by another request" {
+		t.Fatalf("Transition() error = %v, want status was changed by another request", err)
+	}
+}
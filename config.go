@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		AppLog("invalid duration for ", key, ", using default ", fallback.String())
+		return fallback
+	}
+	return duration
+}
+
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		AppLog("invalid number for ", key, ", using default ", fallback)
+		return fallback
+	}
+	return number
+}
+
+func boolFromEnv(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		AppLog("invalid boolean for ", key, ", using default ", fallback)
+		return fallback
+	}
+	return flag
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	consolidationFinalAuthority = "final_authority"
+	consolidationMinimum        = "minimum"
+	consolidationAverage        = "average"
+	consolidationWeighted       = "weighted"
+)
+
+var consolidationMethods = []string{consolidationFinalAuthority, consolidationMinimum, consolidationAverage, consolidationWeighted}
+
+func validConsolidationMethod(method string) bool {
+	for _, m := range consolidationMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// consolidateRecommendations applies policy to the recommendations of one
+// detail post. Only the latest recommendation per user group counts, and
+// when the policy lists groups only those groups are considered.
+func consolidateRecommendations(policy *ConsolidationPolicies, recs []*BudgetDetailsPostsRecommendations) (*RecommendationConsolidations, error) {
+	groups := map[int64]*ConsolidationPolicyGroups{}
+	for _, group := range policy.Groups {
+		groups[group.UserGroupsID] = group
+	}
+
+	latest := map[int64]*BudgetDetailsPostsRecommendations{}
+	for _, rec := range recs {
+		if len(groups) > 0 && groups[rec.UserGroupsID] == nil {
+			continue
+		}
+		if stored := latest[rec.UserGroupsID]; stored == nil || rec.UpdatedAt.After(stored.UpdatedAt) || (rec.UpdatedAt.Equal(stored.UpdatedAt) && rec.ID > stored.ID) {
+			latest[rec.UserGroupsID] = rec
+		}
+	}
+
+	result := &RecommendationConsolidations{
+		ConsolidationPoliciesID: policy.ID,
+		Method:                  policy.Method,
+	}
+	var considered []*ConsolidationSources
+	for _, rec := range recs {
+		source := &ConsolidationSources{
+			RecommendationsID: rec.ID,
+			UserGroupsID:      rec.UserGroupsID,
+			Recommendation:    rec.Recommendation,
+			Weight:            1,
+		}
+		if group := groups[rec.UserGroupsID]; group != nil {
+			source.Weight = group.Weight
+		}
+		result.Sources = append(result.Sources, source)
+		if latest[rec.UserGroupsID] == rec {
+			considered = append(considered, source)
+		}
+	}
+	if len(considered) == 0 {
+		return nil, fmt.Errorf("no recommendations to consolidate")
+	}
+
+	var amount float64
+	switch policy.Method {
+	case consolidationFinalAuthority:
+		var winner *ConsolidationSources
+		rank := math.MinInt
+		for _, source := range considered {
+			sourceRank := 0
+			if group := groups[source.UserGroupsID]; group != nil {
+				sourceRank = group.Rank
+			}
+			if sourceRank > rank {
+				winner, rank = source, sourceRank
+			}
+		}
+		winner.Used = true
+		amount = winner.Recommendation
+	case consolidationMinimum:
+		var winner *ConsolidationSources
+		for _, source := range considered {
+			if winner == nil || source.Recommendation < winner.Recommendation {
+				winner = source
+			}
+		}
+		winner.Used = true
+		amount = winner.Recommendation
+	case consolidationAverage:
+		for _, source := range considered {
+			source.Used = true
+			amount += source.Recommendation
+		}
+		amount /= float64(len(considered))
+	case consolidationWeighted:
+		var totalWeight float64
+		for _, source := range considered {
+			if source.Weight <= 0 {
+				continue
+			}
+			source.Used = true
+			amount += source.Recommendation * source.Weight
+			totalWeight += source.Weight
+		}
+		if totalWeight == 0 {
+			return nil, fmt.Errorf("no weighted recommendations to consolidate")
+		}
+		amount /= totalWeight
+	default:
+		return nil, fmt.Errorf("unknown consolidation method %s", policy.Method)
+	}
+
+	result.ApprovedAmount = math.Round(amount*100) / 100
+	return result, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func validateConsolidationPoliciesRequest(reqBody *ConsolidationPolicies) error {
+	reqBody.Name = strings.TrimSpace(reqBody.Name)
+	if reqBody.Name == "" {
+		return fmt.Errorf("name must be filled")
+	} else if !validConsolidationMethod(reqBody.Method) {
+		return fmt.Errorf("method must be one of %s", strings.Join(consolidationMethods, ", "))
+	}
+
+	seen := map[int64]bool{}
+	for _, group := range reqBody.Groups {
+		if group.UserGroupsID <= 0 {
+			return fmt.Errorf("user groups id must be filled")
+		} else if seen[group.UserGroupsID] {
+			return fmt.Errorf("user group %d is listed twice", group.UserGroupsID)
+		} else if group.Weight < 0 {
+			return fmt.Errorf("weight must not be negative")
+		}
+		seen[group.UserGroupsID] = true
+	}
+	return nil
+}
+
+func (s *APIServer) GetAllConsolidationPolicies(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	policies, err := s.Storage.ConsolidationPoliciesStorage.GetAll()
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, policies)
+
+}
+
+func (s *APIServer) GetConsolidationPolicyByID(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	policy, err := s.Storage.ConsolidationPoliciesStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if policy == nil {
+		return respondWithError(requestLog, "consolidation policy not found", nil)
+	}
+	return respondWithSuccess(requestLog, policy)
+
+}
+
+func (s *APIServer) CreateConsolidationPolicy(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &ConsolidationPolicies{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateConsolidationPoliciesRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	policy, err := s.Storage.ConsolidationPoliciesStorage.Create(reqBody, s.newAudit(r, "consolidation-policies", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, policy)
+
+}
+
+func (s *APIServer) UpdateConsolidationPolicy(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reqBody := &ConsolidationPolicies{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateConsolidationPoliciesRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	before, err := s.Storage.ConsolidationPoliciesStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if before == nil {
+		return respondWithError(requestLog, "consolidation policy not found", nil)
+	}
+
+	updatedPolicy, err := s.Storage.ConsolidationPoliciesStorage.Update(id, reqBody, s.newAudit(r, "consolidation-policies", auditActionUpdate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, updatedPolicy)
+
+}
+
+func (s *APIServer) DeleteConsolidationPolicy(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	deletedPolicy, err := s.Storage.ConsolidationPoliciesStorage.Delete(id, s.newAudit(r, "consolidation-policies", auditActionDelete))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if deletedPolicy == nil {
+		return respondWithError(requestLog, "consolidation policy not found", nil)
+	}
+
+	return respondWithSuccess(requestLog, deletedPolicy)
+
+}
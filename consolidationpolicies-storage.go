@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type ConsolidationPoliciesStorage interface {
+	Create(*ConsolidationPolicies, *Audit) (*ConsolidationPolicies, error)
+	Delete(int64, *Audit) (*ConsolidationPolicies, error)
+	Update(int64, *ConsolidationPolicies, *Audit) (*ConsolidationPolicies, error)
+	GetById(int64) (*ConsolidationPolicies, error)
+	GetDefault() (*ConsolidationPolicies, error)
+	GetAll() ([]*ConsolidationPolicies, error)
+}
+
+type ConsolidationPoliciesStore struct {
+	db *sql.DB
+}
+
+func NewConsolidationPoliciesStorage(db *sql.DB) *ConsolidationPoliciesStore {
+	return &ConsolidationPoliciesStore{
+		db: db,
+	}
+}
+
+func loadConsolidationPolicyGroups(q querier, policy *ConsolidationPolicies) error {
+	query := "SELECT user_groups_id, weight, `rank` FROM consolidation_policy_groups WHERE consolidation_policies_id = ? ORDER BY user_groups_id"
+	rows, err := q.Query(query, policy.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get consolidation policy groups: %w", err)
+	}
+	defer rows.Close()
+
+	policy.Groups = []*ConsolidationPolicyGroups{}
+	for rows.Next() {
+		group := &ConsolidationPolicyGroups{}
+		if err := rows.Scan(&group.UserGroupsID, &group.Weight, &group.Rank); err != nil {
+			return fmt.Errorf("failed to scan consolidation policy group: %w", err)
+		}
+		policy.Groups = append(policy.Groups, group)
+	}
+	return nil
+}
+
+func getConsolidationPolicy(q querier, query string, args ...any) (*ConsolidationPolicies, error) {
+	policy := &ConsolidationPolicies{}
+	err := q.QueryRow(query, args...).Scan(&policy.ID, &policy.Name, &policy.Method, &policy.IsDefault, &policy.CreatedAt, &policy.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get consolidation policy: %w", err)
+	}
+	if err := loadConsolidationPolicyGroups(q, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func getConsolidationPolicyById(q querier, id int64) (*ConsolidationPolicies, error) {
+	return getConsolidationPolicy(q, `SELECT id, name, method, is_default, created_at, updated_at FROM consolidation_policies WHERE id = ?`, id)
+}
+
+func (s *ConsolidationPoliciesStore) GetAll() ([]*ConsolidationPolicies, error) {
+	query := `SELECT id, name, method, is_default, created_at, updated_at FROM consolidation_policies ORDER BY id`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consolidation policies: %w", err)
+	}
+
+	var policies []*ConsolidationPolicies
+	for rows.Next() {
+		policy := &ConsolidationPolicies{}
+		if err := rows.Scan(&policy.ID, &policy.Name, &policy.Method, &policy.IsDefault, &policy.CreatedAt, &policy.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan consolidation policy: %w", err)
+		}
+		policies = append(policies, policy)
+	}
+	rows.Close()
+
+	for _, policy := range policies {
+		if err := loadConsolidationPolicyGroups(s.db, policy); err != nil {
+			return nil, err
+		}
+	}
+	return policies, nil
+}
+
+func (s *ConsolidationPoliciesStore) GetById(id int64) (*ConsolidationPolicies, error) {
+	return getConsolidationPolicyById(s.db, id)
+}
+
+func (s *ConsolidationPoliciesStore) GetDefault() (*ConsolidationPolicies, error) {
+	return getConsolidationPolicy(s.db, `SELECT id, name, method, is_default, created_at, updated_at FROM consolidation_policies WHERE is_default = TRUE ORDER BY id LIMIT 1`)
+}
+
+// save writes the policy row and replaces its groups. Marking a policy as
+// default clears the flag on every other policy.
+func (s *ConsolidationPoliciesStore) save(id int64, policy *ConsolidationPolicies, audit *Audit) (*ConsolidationPolicies, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var before *ConsolidationPolicies
+	if id > 0 {
+		if before, err = getConsolidationPolicyById(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if policy.IsDefault {
+		if _, err := tx.Exec(`UPDATE consolidation_policies SET is_default = FALSE WHERE id <> ?`, id); err != nil {
+			return nil, fmt.Errorf("failed to clear default consolidation policy: %w", err)
+		}
+	}
+
+	if id == 0 {
+		query := `INSERT INTO consolidation_policies (name, method, is_default, created_at, updated_at) VALUES (?, ?, ?, now(), now())`
+		result, err := tx.Exec(query, policy.Name, policy.Method, policy.IsDefault)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert consolidation policy: %w", err)
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return nil, fmt.Errorf("failed to get last insert id: %w", err)
+		}
+	} else {
+		query := `UPDATE consolidation_policies SET name = ?, method = ?, is_default = ?, updated_at = now() WHERE id = ?`
+		if _, err := tx.Exec(query, policy.Name, policy.Method, policy.IsDefault, id); err != nil {
+			return nil, fmt.Errorf("failed to update consolidation policy: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM consolidation_policy_groups WHERE consolidation_policies_id = ?`, id); err != nil {
+		return nil, fmt.Errorf("failed to delete consolidation policy groups: %w", err)
+	}
+	for _, group := range policy.Groups {
+		query := "INSERT INTO consolidation_policy_groups (consolidation_policies_id, user_groups_id, weight, `rank`) VALUES (?, ?, ?, ?)"
+		if _, err := tx.Exec(query, id, group.UserGroupsID, group.Weight, group.Rank); err != nil {
+			return nil, fmt.Errorf("failed to insert consolidation policy group: %w", err)
+		}
+	}
+
+	saved, err := getConsolidationPolicyById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, id, before, saved); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return saved, nil
+}
+
+func (s *ConsolidationPoliciesStore) Create(policy *ConsolidationPolicies, audit *Audit) (*ConsolidationPolicies, error) {
+	return s.save(0, policy, audit)
+}
+
+func (s *ConsolidationPoliciesStore) Update(id int64, policy *ConsolidationPolicies, audit *Audit) (*ConsolidationPolicies, error) {
+	return s.save(id, policy, audit)
+}
+
+func (s *ConsolidationPoliciesStore) Delete(id int64, audit *Audit) (*ConsolidationPolicies, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	policy, err := getConsolidationPolicyById(tx, id)
+	if err != nil || policy == nil {
+		return policy, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM consolidation_policy_groups WHERE consolidation_policies_id = ?`, id); err != nil {
+		return nil, fmt.Errorf("failed to delete consolidation policy groups: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM consolidation_policies WHERE id = ?`, id); err != nil {
+		return nil, fmt.Errorf("failed to delete consolidation policy: %w", err)
+	}
+	if err := audit.write(tx, id, policy, nil); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return policy, nil
+}
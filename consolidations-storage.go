@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+type RecommendationConsolidationsStorage interface {
+	Apply(*RecommendationConsolidations, *Audit) (*RecommendationConsolidations, error)
+	GetByDetailsPost(int64) ([]*RecommendationConsolidations, error)
+}
+
+type RecommendationConsolidationsStore struct {
+	db *sql.DB
+}
+
+func NewRecommendationConsolidationsStorage(db *sql.DB) *RecommendationConsolidationsStore {
+	return &RecommendationConsolidationsStore{
+		db: db,
+	}
+}
+
+// Apply sets the detail post's approved amount and records the consolidation
+// in one transaction. The approved amount takes the place of the post's
+// planned amount in the detail total and budget cap checks, so consolidation
+// can't approve more than either leaves room for. The audit record is the
+// change of the detail post.
+func (s *RecommendationConsolidationsStore) Apply(consolidation *RecommendationConsolidations, audit *Audit) (*RecommendationConsolidations, error) {
+	sources, err := json.Marshal(consolidation.Sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode consolidation sources: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	post := &BudgetDetailsPosts{PlannedAmount: consolidation.ApprovedAmount}
+	query := `SELECT budget_details_id, budget_posts_id FROM budget_details_posts WHERE id = ? FOR UPDATE`
+	if err := tx.QueryRow(query, consolidation.BudgetDetailsPostsID).Scan(&post.BudgetDetailsID, &post.BudgetPostsID); err != nil {
+		return nil, fmt.Errorf("failed to get budget details post: %w", err)
+	}
+	before, err := getBudgetDetailsPostById(tx, consolidation.BudgetDetailsPostsID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDetailsPostWithinCap(tx, consolidation.BudgetDetailsPostsID, post); err != nil {
+		return nil, err
+	}
+
+	query = `UPDATE budget_details_posts SET approved_amount = ?, updated_at = now() WHERE id = ?`
+	if _, err := tx.Exec(query, consolidation.ApprovedAmount, consolidation.BudgetDetailsPostsID); err != nil {
+		return nil, fmt.Errorf("failed to update approved amount: %w", err)
+	}
+	after, err := getBudgetDetailsPostById(tx, consolidation.BudgetDetailsPostsID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, consolidation.BudgetDetailsPostsID, before, after); err != nil {
+		return nil, err
+	}
+
+	query = `INSERT INTO recommendation_consolidations (budget_details_posts_id, consolidation_policies_id, method, approved_amount, sources, users_id, created_at) VALUES (?, ?, ?, ?, ?, ?, now())`
+	result, err := tx.Exec(query, consolidation.BudgetDetailsPostsID, consolidation.ConsolidationPoliciesID, consolidation.Method, consolidation.ApprovedAmount, sources, nullInt64(consolidation.UsersID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert consolidation: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	consolidation.ID = id
+	if err := s.db.QueryRow(`SELECT created_at FROM recommendation_consolidations WHERE id = ?`, id).Scan(&consolidation.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to get consolidation: %w", err)
+	}
+	return consolidation, nil
+}
+
+func (s *RecommendationConsolidationsStore) GetByDetailsPost(budgetDetailsPostsID int64) ([]*RecommendationConsolidations, error) {
+	query := `SELECT id, budget_details_posts_id, consolidation_policies_id, method, approved_amount, sources, users_id, created_at FROM recommendation_consolidations WHERE budget_details_posts_id = ? ORDER BY id`
+	rows, err := s.db.Query(query, budgetDetailsPostsID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consolidations: %w", err)
+	}
+	defer rows.Close()
+
+	var consolidations []*RecommendationConsolidations
+	for rows.Next() {
+		consolidation := &RecommendationConsolidations{}
+		var sources []byte
+		var usersID sql.NullInt64
+		err := rows.Scan(&consolidation.ID, &consolidation.BudgetDetailsPostsID, &consolidation.ConsolidationPoliciesID, &consolidation.Method, &consolidation.ApprovedAmount, &sources, &usersID, &consolidation.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan consolidation: %w", err)
+		}
+		if err := json.Unmarshal(sources, &consolidation.Sources); err != nil {
+			return nil, fmt.Errorf("failed to decode consolidation sources: %w", err)
+		}
+		consolidation.UsersID = usersID.Int64
+		consolidations = append(consolidations, consolidation)
+	}
+	return consolidations, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRecommendationConsolidationsStoreApply(t *testing.T) {
+	// Detail post 5 of detail 3 (total 1000) books on post 2 of budget 1;
+	// the other detail posts plan 300 of the detail and 1500 of the post.
+	tests := []struct {
+		name     string
+		approved float64
+		capTotal float64
+		wantErr  interface{}
+	}{
+		{name: "within total and cap", approved: 700, capTotal: 2500},
+		{name: "above the detail total", approved: 800, capTotal: 2500, wantErr: &PlanExceedsTotalError{}},
+		{name: "above the cap", approved: 700, capTotal: 2000, wantErr: &PlanExceedsCapError{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			store := &RecommendationConsolidationsStore{db: db}
+			postRow := func(approved float64) *sqlmock.Rows {
+				return sqlmock.NewRows([]string{"id", "budget_details_id", "budget_posts_id", "planned_amount", "approved_amount", "usage_amount", "created_at", "updated_at"}).
+					AddRow(5, 3, 2, 600, approved, 0, time.Time{}, time.Time{})
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT budget_details_id, budget_posts_id FROM budget_details_posts WHERE id = ? FOR UPDATE`)).
+				WithArgs(int64(5)).
+				WillReturnRows(sqlmock.NewRows([]string{"budget_details_id", "budget_posts_id"}).AddRow(3, 2))
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM budget_details_posts WHERE id = ?`)).
+				WithArgs(int64(5)).
+				WillReturnRows(postRow(0))
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT budgets_id, total FROM budget_details WHERE id = ? FOR UPDATE`)).
+				WithArgs(int64(3)).
+				WillReturnRows(sqlmock.NewRows([]string{"budgets_id", "total"}).AddRow(1, 1000))
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(SUM(planned_amount), 0) FROM budget_details_posts WHERE budget_details_id = ? AND id <> ?`)).
+				WithArgs(int64(3), int64(5)).
+				WillReturnRows(sqlmock.NewRows([]string{"planned"}).AddRow(300))
+			if _, ok := tt.wantErr.(*PlanExceedsTotalError); !ok {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, amount FROM budget_caps WHERE budgets_id = ? AND budget_posts_id = ? FOR UPDATE`)).
+					WithArgs(int64(1), int64(2)).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "amount"}).AddRow(7, tt.capTotal))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(SUM(bdp.planned_amount), 0) FROM budget_details_posts bdp`)).
+					WithArgs(int64(1), int64(2), int64(5)).
+					WillReturnRows(sqlmock.NewRows([]string{"planned"}).AddRow(1500))
+			}
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE budget_details_posts SET approved_amount = ?`)).
+					WithArgs(tt.approved, int64(5)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(regexp.QuoteMeta(`FROM budget_details_posts WHERE id = ?`)).
+					WithArgs(int64(5)).
+					WillReturnRows(postRow(tt.approved))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO recommendation_consolidations`)).
+					WithArgs(int64(5), int64(9), consolidationMinimum, tt.approved, []byte(`[{"recommendations_id":11,"user_groups_id":2,"recommendation":700,"weight":1,"used":true}]`), int64(10)).
+					WillReturnResult(sqlmock.NewResult(4, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT created_at FROM recommendation_consolidations WHERE id = ?`)).
+					WithArgs(int64(4)).
+					WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Time{}))
+			}
+
+			consolidation, err := store.Apply(&RecommendationConsolidations{
+				BudgetDetailsPostsID:    5,
+				ConsolidationPoliciesID: 9,
+				Method:                  consolidationMinimum,
+				ApprovedAmount:          tt.approved,
+				Sources:                 []*ConsolidationSources{{RecommendationsID: 11, UserGroupsID: 2, Recommendation: 700, Weight: 1, Used: true}},
+				UsersID:                 10,
+			}, nil)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("Apply() error = %v", err)
+				}
+				if consolidation.ID != 4 {
+					t.Errorf("Apply() id = %d, want 4", consolidation.ID)
+				}
+			case *PlanExceedsTotalError:
+				if !errors.As(err, &want) {
+					t.Fatalf("Apply() error = %v, want PlanExceedsTotalError", err)
+				}
+			case *PlanExceedsCapError:
+				if !errors.As(err, &want) {
+					t.Fatalf("Apply() error = %v, want PlanExceedsCapError", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	fiscalPeriodOpen    = "open"
+	fiscalPeriodClosing = "closing"
+	fiscalPeriodClosed  = "closed"
+)
+
+// periodOverrideHeader carries the reason an admin gives for changing data of
+// a locked fiscal period.
+const periodOverrideHeader = "X-Period-Override-Reason"
+
+func validateFiscalPeriodsRequest(reqBody *FiscalPeriods) error {
+	reqBody.Code = strings.TrimSpace(reqBody.Code)
+	if reqBody.Status == "" {
+		reqBody.Status = fiscalPeriodOpen
+	}
+	if reqBody.Code == "" {
+		return fmt.Errorf("code must be filled")
+	} else if len(reqBody.Code) > 32 {
+		return fmt.Errorf("max length code 32")
+	} else if reqBody.Name == "" {
+		return fmt.Errorf("name must be filled")
+	} else if reqBody.StartDate.IsZero() || reqBody.EndDate.IsZero() {
+		return fmt.Errorf("start date and end date must be filled")
+	} else if !reqBody.EndDate.After(reqBody.StartDate) {
+		return fmt.Errorf("end date must be after start date")
+	} else if reqBody.Status != fiscalPeriodOpen && reqBody.Status != fiscalPeriodClosing && reqBody.Status != fiscalPeriodClosed {
+		return fmt.Errorf("status must be open, closing or closed")
+	}
+	return nil
+}
+
+// checkPeriodWritable rejects changes in a closed period, and new budgets or
+// fund requests (creating) in a closing one, unless an admin sends a reason
+// in periodOverrideHeader. Every override is written to the audit trail.
+func (s *APIServer) checkPeriodWritable(r *http.Request, fiscalPeriodsID int64, creating bool) (string, error) {
+	period, err := s.Storage.FiscalPeriodsStorage.GetById(fiscalPeriodsID)
+	if err != nil {
+		return "database error", err
+	}
+	if period == nil {
+		return "fiscal period not found", fmt.Errorf("fiscal period not found")
+	}
+
+	if period.Status == fiscalPeriodOpen || (period.Status == fiscalPeriodClosing && !creating) {
+		return "ok", nil
+	}
+
+	message := fmt.Sprintf("fiscal period %s is %s", period.Code, period.Status)
+	reason := strings.TrimSpace(r.Header.Get(periodOverrideHeader))
+	claims, err := s.GetUserClaims(r)
+	if reason == "" || err != nil || !claims.HasRole("admin") {
+		return message, fmt.Errorf("%s", message)
+	}
+
+	err = s.auditEvent(r, "fiscal-periods", period.ID, "override", &PeriodOverrides{
+		Reason: reason,
+		Method: r.Method,
+		Path:   r.URL.Path,
+	})
+	if err != nil {
+		return "database error", err
+	}
+	return "ok", nil
+}
+
+// checkBudgetPeriodWritable applies checkPeriodWritable to the period of a
+// budget. Budgets without a period must get one before anything under them
+// changes.
+func (s *APIServer) checkBudgetPeriodWritable(r *http.Request, budgetsID int64, creating bool) (string, error) {
+	budget, err := s.Storage.BudgetsStorage.GetById(budgetsID)
+	if err != nil {
+		return "database error", err
+	}
+	if budget == nil {
+		return "budgets not found", fmt.Errorf("budgets not found")
+	}
+	if budget.FiscalPeriodsID == 0 {
+		return "budget has no fiscal period", fmt.Errorf("budget has no fiscal period")
+	}
+	return s.checkPeriodWritable(r, budget.FiscalPeriodsID, creating)
+}
+
+func (s *APIServer) checkBudgetDetailPeriodWritable(r *http.Request, budgetDetailsID int64) (string, error) {
+	budgetDetail, err := s.Storage.BudgetDetailsStorage.GetById(budgetDetailsID)
+	if err != nil {
+		return "database error", err
+	}
+	if budgetDetail == nil {
+		return "data budget details not found", fmt.Errorf("data budget details not found")
+	}
+	return s.checkBudgetPeriodWritable(r, budgetDetail.BudgetsID, false)
+}
+
+func (s *APIServer) checkBudgetDetailPostPeriodWritable(r *http.Request, budgetDetailsPostsID int64) (string, error) {
+	budgetDetailsPost, err := s.Storage.BudgetDetailsPostsStorage.GetById(budgetDetailsPostsID)
+	if err != nil {
+		return "database error", err
+	}
+	if budgetDetailsPost == nil {
+		return "data budget detail post not found", fmt.Errorf("data budget detail post not found")
+	}
+	return s.checkBudgetDetailPeriodWritable(r, budgetDetailsPost.BudgetDetailsID)
+}
+
+func (s *APIServer) checkFundRequestPeriodWritable(r *http.Request, fundRequestsID int64) (string, error) {
+	fundRequest, err := s.Storage.FundRequestsStorage.GetById(fundRequestsID)
+	if err != nil {
+		return "database error", err
+	}
+	if fundRequest == nil {
+		return "data fund request not found", fmt.Errorf("data fund request not found")
+	}
+	return s.checkBudgetPeriodWritable(r, fundRequest.BudgetsID, false)
+}
+
+func (s *APIServer) GetAllFiscalPeriods(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	periods, err := s.Storage.FiscalPeriodsStorage.GetAll()
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, periods)
+
+}
+
+func (s *APIServer) GetFiscalPeriodByID(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	period, err := s.Storage.FiscalPeriodsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if period == nil {
+		return respondWithError(requestLog, "fiscal period not found", nil)
+	}
+	return respondWithSuccess(requestLog, period)
+
+}
+
+func (s *APIServer) CreateFiscalPeriod(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &FiscalPeriods{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateFiscalPeriodsRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	periodByCode, err := s.Storage.FiscalPeriodsStorage.GetByCode(reqBody.Code)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if periodByCode != nil {
+		return respondWithError(requestLog, "code already in use", nil)
+	}
+
+	period, err := s.Storage.FiscalPeriodsStorage.Create(reqBody, s.newAudit(r, "fiscal-periods", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, period)
+
+}
+
+func (s *APIServer) UpdateFiscalPeriod(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reqBody := &FiscalPeriods{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateFiscalPeriodsRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	before, err := s.Storage.FiscalPeriodsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if before == nil {
+		return respondWithError(requestLog, "fiscal period not found", nil)
+	}
+
+	periodByCode, err := s.Storage.FiscalPeriodsStorage.GetByCode(reqBody.Code)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if periodByCode != nil && periodByCode.ID != id {
+		return respondWithError(requestLog, "code already in use", nil)
+	}
+
+	updatedPeriod, err := s.Storage.FiscalPeriodsStorage.Update(id, reqBody, s.newAudit(r, "fiscal-periods", auditActionUpdate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, updatedPeriod)
+
+}
+
+func (s *APIServer) DeleteFiscalPeriod(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	budgets, err := s.Storage.FiscalPeriodsStorage.CountBudgets(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if budgets > 0 {
+		return respondWithError(requestLog, "fiscal period is used by budgets", nil)
+	}
+
+	deletedPeriod, err := s.Storage.FiscalPeriodsStorage.Delete(id, s.newAudit(r, "fiscal-periods", auditActionDelete))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if deletedPeriod == nil {
+		return respondWithError(requestLog, "fiscal period not found", nil)
+	}
+
+	return respondWithSuccess(requestLog, deletedPeriod)
+
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type FiscalPeriodsStorage interface {
+	Create(*FiscalPeriods, *Audit) (*FiscalPeriods, error)
+	Delete(int64, *Audit) (*FiscalPeriods, error)
+	Update(int64, *FiscalPeriods, *Audit) (*FiscalPeriods, error)
+	GetById(int64) (*FiscalPeriods, error)
+	GetByCode(string) (*FiscalPeriods, error)
+	GetAll() ([]*FiscalPeriods, error)
+	CountBudgets(int64) (int64, error)
+}
+
+type FiscalPeriodsStore struct {
+	db *sql.DB
+}
+
+func NewFiscalPeriodsStorage(db *sql.DB) *FiscalPeriodsStore {
+	return &FiscalPeriodsStore{
+		db: db,
+	}
+}
+
+func scanFiscalPeriod(row *sql.Row) (*FiscalPeriods, error) {
+	period := &FiscalPeriods{}
+	err := row.Scan(&period.ID, &period.Code, &period.Name, &period.StartDate, &period.EndDate, &period.Status, &period.CreatedAt, &period.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get fiscal period: %w", err)
+	}
+	return period, nil
+}
+
+func (s *FiscalPeriodsStore) GetAll() ([]*FiscalPeriods, error) {
+	query := `SELECT id, code, name, start_date, end_date, status, created_at, updated_at FROM fiscal_periods ORDER BY start_date`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fiscal periods: %w", err)
+	}
+	defer rows.Close()
+
+	var periods []*FiscalPeriods
+	for rows.Next() {
+		period := &FiscalPeriods{}
+		err := rows.Scan(&period.ID, &period.Code, &period.Name, &period.StartDate, &period.EndDate, &period.Status, &period.CreatedAt, &period.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan fiscal period: %w", err)
+		}
+		periods = append(periods, period)
+	}
+	return periods, nil
+}
+
+func getFiscalPeriodById(q querier, id int64) (*FiscalPeriods, error) {
+	query := `SELECT id, code, name, start_date, end_date, status, created_at, updated_at FROM fiscal_periods WHERE id = ?`
+	return scanFiscalPeriod(q.QueryRow(query, id))
+}
+
+func (s *FiscalPeriodsStore) GetById(id int64) (*FiscalPeriods, error) {
+	return getFiscalPeriodById(s.db, id)
+}
+
+func (s *FiscalPeriodsStore) GetByCode(code string) (*FiscalPeriods, error) {
+	query := `SELECT id, code, name, start_date, end_date, status, created_at, updated_at FROM fiscal_periods WHERE code = ?`
+	return scanFiscalPeriod(s.db.QueryRow(query, code))
+}
+
+func (s *FiscalPeriodsStore) CountBudgets(id int64) (int64, error) {
+	var count int64
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM budgets WHERE fiscal_periods_id = ?`, id).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count budgets: %w", err)
+	}
+	return count, nil
+}
+
+func (s *FiscalPeriodsStore) Create(period *FiscalPeriods, audit *Audit) (*FiscalPeriods, error) {
+	query := `INSERT INTO fiscal_periods (code, name, start_date, end_date, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, now(), now())`
+	_, created, err := auditedExec(s.db, audit, getFiscalPeriodById, 0, query, period.Code, period.Name, period.StartDate, period.EndDate, period.Status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert fiscal period: %w", err)
+	}
+	return created, nil
+}
+
+func (s *FiscalPeriodsStore) Update(id int64, period *FiscalPeriods, audit *Audit) (*FiscalPeriods, error) {
+	query := `UPDATE fiscal_periods SET code = ?, name = ?, start_date = ?, end_date = ?, status = ?, updated_at = now() WHERE id = ?`
+	_, updated, err := auditedExec(s.db, audit, getFiscalPeriodById, id, query, period.Code, period.Name, period.StartDate, period.EndDate, period.Status, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update fiscal period: %w", err)
+	}
+	return updated, nil
+}
+
+func (s *FiscalPeriodsStore) Delete(id int64, audit *Audit) (*FiscalPeriods, error) {
+	period, _, err := auditedExec(s.db, audit, getFiscalPeriodById, id, `DELETE FROM fiscal_periods WHERE id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete fiscal period: %w", err)
+	}
+	return period, nil
+}
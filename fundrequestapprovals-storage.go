@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	approvalStatusPending  = "pending"
+	approvalStatusApproved = "approved"
+	approvalStatusRejected = "rejected"
+)
+
+type FundRequestApprovalsStorage interface {
+	GetByFundRequest(int64) ([]*FundRequestApprovals, error)
+}
+
+type FundRequestApprovalsStore struct {
+	db *sql.DB
+}
+
+func NewFundRequestApprovalsStorage(db *sql.DB) *FundRequestApprovalsStore {
+	return &FundRequestApprovalsStore{
+		db: db,
+	}
+}
+
+func (s *FundRequestApprovalsStore) GetByFundRequest(fundRequestsID int64) ([]*FundRequestApprovals, error) {
+	query := `SELECT id, fund_requests_id, level, approver_role, status, users_id, comment, decided_at, created_at FROM fund_request_approvals WHERE fund_requests_id = ? ORDER BY level`
+	rows, err := s.db.Query(query, fundRequestsID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fund request approvals: %w", err)
+	}
+	defer rows.Close()
+
+	var approvals []*FundRequestApprovals
+	for rows.Next() {
+		approval := &FundRequestApprovals{}
+		var usersID sql.NullInt64
+		var comment sql.NullString
+		var decidedAt sql.NullTime
+		err := rows.Scan(&approval.ID, &approval.FundRequestsID, &approval.Level, &approval.ApproverRole, &approval.Status, &usersID, &comment, &decidedAt, &approval.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan fund request approval: %w", err)
+		}
+		approval.UsersID = usersID.Int64
+		approval.Comment = comment.String
+		if decidedAt.Valid {
+			approval.DecidedAt = &decidedAt.Time
+		}
+		approvals = append(approvals, approval)
+	}
+	return approvals, nil
+}
+
+// replaceApprovalSteps sets up the approval levels of a submitted request.
+func replaceApprovalSteps(tx *sql.Tx, fundRequestsID int64, steps []*FundRequestApprovals) error {
+	_, err := tx.Exec(`DELETE FROM fund_request_approvals WHERE fund_requests_id = ?`, fundRequestsID)
+	if err != nil {
+		return fmt.Errorf("failed to delete approval steps: %w", err)
+	}
+	for _, step := range steps {
+		query := `INSERT INTO fund_request_approvals (fund_requests_id, level, approver_role, status, created_at) VALUES (?, ?, ?, ?, now())`
+		_, err := tx.Exec(query, fundRequestsID, step.Level, step.ApproverRole, approvalStatusPending)
+		if err != nil {
+			return fmt.Errorf("failed to insert approval step: %w", err)
+		}
+	}
+	return nil
+}
+
+// decideApprovalStep records a decision on the lowest pending level. It fails
+// when the step was decided meanwhile or a lower level is still pending.
+func decideApprovalStep(tx *sql.Tx, decision *FundRequestApprovals) error {
+	var lowest sql.NullInt64
+	query := `SELECT MIN(level) FROM fund_request_approvals WHERE fund_requests_id = ? AND status = ? FOR UPDATE`
+	if err := tx.QueryRow(query, decision.FundRequestsID, approvalStatusPending).Scan(&lowest); err != nil {
+		return fmt.Errorf("failed to lock approval steps: %w", err)
+	}
+	if !lowest.Valid || int(lowest.Int64) != decision.Level {
+		return fmt.Errorf("approval step is no longer pending")
+	}
+
+	query = `UPDATE fund_request_approvals SET status = ?, users_id = ?, comment = ?, decided_at = now() WHERE id = ? AND status = ?`
+	result, err := tx.Exec(query, decision.Status, decision.UsersID, decision.Comment, decision.ID, approvalStatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to update approval step: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update approval step: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("approval step is no longer pending")
+	}
+	return nil
+}
+
+// countPendingApprovalSteps counts the steps of a request still waiting for a
+// decision. decideApprovalStep has locked them already.
+func countPendingApprovalSteps(tx *sql.Tx, fundRequestsID int64) (int, error) {
+	var pending int
+	query := `SELECT COUNT(*) FROM fund_request_approvals WHERE fund_requests_id = ? AND status = ?`
+	if err := tx.QueryRow(query, fundRequestsID, approvalStatusPending).Scan(&pending); err != nil {
+		return 0, fmt.Errorf("failed to count approval steps: %w", err)
+	}
+	return pending, nil
+}
@@ -67,9 +67,33 @@ func (s *APIServer) validateFundRequestDetailsForeignKey(primaryKey *PrimaryKeyI
 	return "ok", nil
 }
 
+func (s *APIServer) validateFundRequestDetailsScope(r *http.Request, id int64) (string, error) {
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return "unauthorized", err
+	}
+
+	stored, err := s.Storage.FundRequestDetailsStorage.GetByIdInScope(id, usersID)
+	if err != nil {
+		return "database error", err
+	}
+
+	if stored == nil {
+		return "fund request details not found", fmt.Errorf("fund request details not found")
+	}
+
+	return "ok", nil
+}
+
 func (s *APIServer) GetAllFundRequestDetails(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
 
-	fundRequestDetails, err := s.Storage.FundRequestDetailsStorage.GetAll()
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	fundRequestDetails, err := s.Storage.FundRequestDetailsStorage.GetAllInScope(usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -84,10 +108,18 @@ func (s *APIServer) GetFundRequestDetailByID(w http.ResponseWriter, r *http.Requ
 		return respondWithError(requestLog, "invalid ID", err)
 	}
 
-	fundRequestDetail, err := s.Storage.FundRequestDetailsStorage.GetById(id)
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	fundRequestDetail, err := s.Storage.FundRequestDetailsStorage.GetByIdInScope(id, usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+	if fundRequestDetail == nil {
+		return respondWithError(requestLog, "fund request details not found", nil)
+	}
 	return respondWithSuccess(requestLog, fundRequestDetail)
 
 }
@@ -114,7 +146,27 @@ func (s *APIServer) CreateFundRequestDetail(w http.ResponseWriter, r *http.Reque
 		return respondWithError(requestLog, message, err)
 	}
 
-	fundRequestDetail, err := s.Storage.FundRequestDetailsStorage.Create(reqBody)
+	message, err = s.validateFundRequestsScope(r, reqBody.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateBudgetDetailsScope(r, reqBody.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkFundRequestEditable(reqBody.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkFundRequestPeriodWritable(r, reqBody.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	fundRequestDetail, err := s.Storage.FundRequestDetailsStorage.Create(reqBody, s.newAudit(r, "fund-request-details", auditActionCreate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -151,10 +203,51 @@ func (s *APIServer) UpdateFundRequestDetail(w http.ResponseWriter, r *http.Reque
 		return respondWithError(requestLog, message, err)
 	}
 
-	updatedFundRequestDetail, err := s.Storage.FundRequestDetailsStorage.Update(id, reqBody)
+	message, err = s.validateFundRequestDetailsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateFundRequestsScope(r, reqBody.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateBudgetDetailsScope(r, reqBody.BudgetDetailsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	before, err := s.Storage.FundRequestDetailsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	message, err = s.checkFundRequestEditable(before.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkFundRequestEditable(reqBody.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkFundRequestPeriodWritable(r, before.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkFundRequestPeriodWritable(r, reqBody.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	updatedFundRequestDetail, err := s.Storage.FundRequestDetailsStorage.Update(id, reqBody, s.newAudit(r, "fund-request-details", auditActionUpdate))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
 	// if updatedFundRequestDetail == nil {
 	// 	return respondWithError(requestLog, "data fund request not found", err)
 	// }
@@ -178,10 +271,31 @@ func (s *APIServer) DeleteFundRequestDetail(w http.ResponseWriter, r *http.Reque
 		return respondWithError(requestLog, message, err)
 	}
 
-	deletedFundRequestDetail, err := s.Storage.FundRequestDetailsStorage.Delete(id)
+	message, err = s.validateFundRequestDetailsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	fundRequestDetail, err := s.Storage.FundRequestDetailsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	message, err = s.checkFundRequestEditable(fundRequestDetail.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkFundRequestPeriodWritable(r, fundRequestDetail.FundRequestsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	deletedFundRequestDetail, err := s.Storage.FundRequestDetailsStorage.Delete(id, s.newAudit(r, "fund-request-details", auditActionDelete))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
 	// if deletedFundRequestDetail == nil {
 	// 	return respondWithError(requestLog, "data fund request not found", err)
 	// }
@@ -6,11 +6,13 @@ import (
 )
 
 type FundRequestDetailsStorage interface {
-	Create(*FundRequestDetails) (*FundRequestDetails, error)
-	Delete(int64) (*FundRequestDetails, error)
-	Update(int64, *FundRequestDetails) (*FundRequestDetails, error)
+	Create(*FundRequestDetails, *Audit) (*FundRequestDetails, error)
+	Delete(int64, *Audit) (*FundRequestDetails, error)
+	Update(int64, *FundRequestDetails, *Audit) (*FundRequestDetails, error)
 	GetById(int64) (*FundRequestDetails, error)
 	GetAll() ([]*FundRequestDetails, error)
+	GetAllInScope(int64) ([]*FundRequestDetails, error)
+	GetByIdInScope(int64, int64) (*FundRequestDetails, error)
 }
 
 type FundRequestDetailsStore struct {
@@ -23,14 +25,7 @@ func NewFundRequestDetailsStorage(db *sql.DB) *FundRequestDetailsStore {
 	}
 }
 
-func (s *FundRequestDetailsStore) GetAll() ([]*FundRequestDetails, error) {
-	query := `SELECT id, fund_requests_id, activities_id, budget_details_id, amount, recommendation, created_at, updated_at FROM fund_request_details`
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get fund request details: %w", err)
-	}
-	defer rows.Close()
-
+func scanFundRequestDetails(rows *sql.Rows) ([]*FundRequestDetails, error) {
 	var fundRequestDetails []*FundRequestDetails
 	for rows.Next() {
 		fundRequestDetail := &FundRequestDetails{}
@@ -52,9 +47,33 @@ func (s *FundRequestDetailsStore) GetAll() ([]*FundRequestDetails, error) {
 	return fundRequestDetails, nil
 }
 
-func (s *FundRequestDetailsStore) GetById(id int64) (*FundRequestDetails, error) {
+func (s *FundRequestDetailsStore) GetAll() ([]*FundRequestDetails, error) {
+	query := `SELECT id, fund_requests_id, activities_id, budget_details_id, amount, recommendation, created_at, updated_at FROM fund_request_details`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fund request details: %w", err)
+	}
+	defer rows.Close()
+	return scanFundRequestDetails(rows)
+}
+
+func (s *FundRequestDetailsStore) GetAllInScope(usersID int64) ([]*FundRequestDetails, error) {
+	query := unitScopeCTE + `SELECT frd.id, frd.fund_requests_id, frd.activities_id, frd.budget_details_id, frd.amount, frd.recommendation, frd.created_at, frd.updated_at
+		FROM fund_request_details frd
+		JOIN fund_requests fr ON fr.id = frd.fund_requests_id
+		JOIN budgets b ON b.id = fr.budgets_id
+		WHERE b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fund request details: %w", err)
+	}
+	defer rows.Close()
+	return scanFundRequestDetails(rows)
+}
+
+func getFundRequestDetailById(q querier, id int64) (*FundRequestDetails, error) {
 	query := `SELECT id, fund_requests_id, activities_id, budget_details_id, amount, recommendation, created_at, updated_at FROM fund_request_details WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+	row := q.QueryRow(query, id)
 
 	fundRequestDetail := &FundRequestDetails{}
 	err := row.Scan(&fundRequestDetail.ID, &fundRequestDetail.FundRequestsID, &fundRequestDetail.ActivitiesID, &fundRequestDetail.BudgetDetailsID, &fundRequestDetail.Amount, &fundRequestDetail.Recommendation, &fundRequestDetail.CreatedAt, &fundRequestDetail.UpdatedAt)
@@ -67,9 +86,38 @@ func (s *FundRequestDetailsStore) GetById(id int64) (*FundRequestDetails, error)
 	return fundRequestDetail, nil
 }
 
-func (s *FundRequestDetailsStore) Create(fundRequestDetail *FundRequestDetails) (*FundRequestDetails, error) {
+func (s *FundRequestDetailsStore) GetById(id int64) (*FundRequestDetails, error) {
+	return getFundRequestDetailById(s.db, id)
+}
+
+func (s *FundRequestDetailsStore) GetByIdInScope(id int64, usersID int64) (*FundRequestDetails, error) {
+	query := unitScopeCTE + `SELECT frd.id, frd.fund_requests_id, frd.activities_id, frd.budget_details_id, frd.amount, frd.recommendation, frd.created_at, frd.updated_at
+		FROM fund_request_details frd
+		JOIN fund_requests fr ON fr.id = frd.fund_requests_id
+		JOIN budgets b ON b.id = fr.budgets_id
+		WHERE frd.id = ? AND b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fund request detail by id: %w", err)
+	}
+	defer rows.Close()
+
+	fundRequestDetails, err := scanFundRequestDetails(rows)
+	if err != nil || len(fundRequestDetails) == 0 {
+		return nil, err
+	}
+	return fundRequestDetails[0], nil
+}
+
+func (s *FundRequestDetailsStore) Create(fundRequestDetail *FundRequestDetails, audit *Audit) (*FundRequestDetails, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `INSERT INTO fund_request_details (fund_requests_id, activities_id, budget_details_id, amount, recommendation, created_at, updated_at) VALUES (?, ?, ?, ?, ?, now(), now())`
-	result, err := s.db.Exec(query, fundRequestDetail.FundRequestsID, fundRequestDetail.ActivitiesID, fundRequestDetail.BudgetDetailsID, fundRequestDetail.Amount, fundRequestDetail.Recommendation)
+	result, err := tx.Exec(query, fundRequestDetail.FundRequestsID, fundRequestDetail.ActivitiesID, fundRequestDetail.BudgetDetailsID, fundRequestDetail.Amount, fundRequestDetail.Recommendation)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert fund request detail: %w", err)
 	}
@@ -77,27 +125,76 @@ func (s *FundRequestDetailsStore) Create(fundRequestDetail *FundRequestDetails)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
-	return s.GetById(lastInsertID)
+	created, err := getFundRequestDetailById(tx, lastInsertID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, lastInsertID, nil, created); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return created, nil
 }
 
-func (s *FundRequestDetailsStore) Delete(id int64) (*FundRequestDetails, error) {
-	deletedFundRequestDetail, _ := s.GetById(id)
+func (s *FundRequestDetailsStore) Delete(id int64, audit *Audit) (*FundRequestDetails, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	deletedFundRequestDetail, err := getFundRequestDetailById(tx, id)
+	if err != nil {
+		return nil, err
+	}
 
 	query := `DELETE FROM fund_request_details WHERE id = ?`
-	_, err := s.db.Exec(query, id)
+	_, err = tx.Exec(query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete fund request detail: %w", err)
 	}
+	if deletedFundRequestDetail != nil {
+		if err := audit.write(tx, id, deletedFundRequestDetail, nil); err != nil {
+			return nil, err
+		}
+	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return deletedFundRequestDetail, nil
 }
 
-func (s *FundRequestDetailsStore) Update(id int64, fundRequestDetail *FundRequestDetails) (*FundRequestDetails, error) {
+func (s *FundRequestDetailsStore) Update(id int64, fundRequestDetail *FundRequestDetails, audit *Audit) (*FundRequestDetails, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := getFundRequestDetailById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `UPDATE fund_request_details SET fund_requests_id = ?, activities_id = ?, budget_details_id = ?, amount = ?, recommendation = ?, updated_at = now() WHERE id = ?`
-	_, err := s.db.Exec(query, fundRequestDetail.FundRequestsID, fundRequestDetail.ActivitiesID, fundRequestDetail.BudgetDetailsID, fundRequestDetail.Amount, fundRequestDetail.Recommendation, id)
+	_, err = tx.Exec(query, fundRequestDetail.FundRequestsID, fundRequestDetail.ActivitiesID, fundRequestDetail.BudgetDetailsID, fundRequestDetail.Amount, fundRequestDetail.Recommendation, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update fund request detail: %w", err)
 	}
+	updated, err := getFundRequestDetailById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, id, before, updated); err != nil {
+		return nil, err
+	}
 
-	return s.GetById(id)
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, nil
 }
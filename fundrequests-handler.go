@@ -8,7 +8,9 @@ import (
 )
 
 func validateFundRequestsRequest(reqBody *FundRequests) error {
-	if reqBody.BudgetPostsID <= 0 {
+	if reqBody.BudgetsID <= 0 {
+		return fmt.Errorf("budgets id must be filled")
+	} else if reqBody.BudgetPostsID <= 0 {
 		return fmt.Errorf("budget posts id must be filled")
 	} else if reqBody.Date.IsZero() {
 		return fmt.Errorf("date must be filled")
@@ -16,8 +18,6 @@ func validateFundRequestsRequest(reqBody *FundRequests) error {
 		return fmt.Errorf("type must be filled")
 	} else if reqBody.Amount <= 0 {
 		return fmt.Errorf("amount must be greater than 0")
-	} else if reqBody.Status == "" {
-		return fmt.Errorf("status must be filled")
 	}
 	return nil
 }
@@ -39,6 +39,7 @@ func (s *APIServer) validateFundRequestsForeignKey(primaryKey *PrimaryKeyID, val
 
 	newKey := &PrimaryKeyID{
 		FundRequestsID: primaryKey.FundRequestsID,
+		BudgetsID:      primaryKey.BudgetsID,
 		BudgetPostsID:  primaryKey.BudgetPostsID,
 	}
 
@@ -52,6 +53,10 @@ func (s *APIServer) validateFundRequestsForeignKey(primaryKey *PrimaryKeyID, val
 	}
 	if !checkSelfOnly {
 
+		if storedKey.BudgetsID == 0 {
+			return "data budgets not found", fmt.Errorf("data budgets not found")
+		}
+
 		if storedKey.BudgetPostsID == 0 {
 			return "data budget post not found", fmt.Errorf("data budget post not found")
 		}
@@ -60,9 +65,52 @@ func (s *APIServer) validateFundRequestsForeignKey(primaryKey *PrimaryKeyID, val
 	return "ok", nil
 }
 
+func (s *APIServer) validateFundRequestsScope(r *http.Request, id int64) (string, error) {
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return "unauthorized", err
+	}
+
+	stored, err := s.Storage.FundRequestsStorage.GetByIdInScope(id, usersID)
+	if err != nil {
+		return "database error", err
+	}
+
+	if stored == nil {
+		return "fund requests not found", fmt.Errorf("fund requests not found")
+	}
+
+	return "ok", nil
+}
+
+// checkFundRequestEditable rejects changes to the details of a fund request
+// that has left draft.
+func (s *APIServer) checkFundRequestEditable(fundRequestsID int64) (string, error) {
+
+	fundRequest, err := s.Storage.FundRequestsStorage.GetById(fundRequestsID)
+	if err != nil {
+		return "database error", err
+	}
+	if fundRequest == nil {
+		return "data fund request not found", fmt.Errorf("data fund request not found")
+	}
+	if fundRequest.Status != fundRequestStatusDraft {
+		message := "fund request can only be changed in status draft"
+		return message, fmt.Errorf("%s", message)
+	}
+
+	return "ok", nil
+}
+
 func (s *APIServer) GetAllFundRequests(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
 
-	fundRequest, err := s.Storage.FundRequestsStorage.GetAll()
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	fundRequest, err := s.Storage.FundRequestsStorage.GetAllInScope(usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
@@ -77,10 +125,18 @@ func (s *APIServer) GetFundRequestByID(w http.ResponseWriter, r *http.Request, b
 		return respondWithError(requestLog, "invalid ID", err)
 	}
 
-	fundRequest, err := s.Storage.FundRequestsStorage.GetById(id)
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	fundRequest, err := s.Storage.FundRequestsStorage.GetByIdInScope(id, usersID)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+	if fundRequest == nil {
+		return respondWithError(requestLog, "fund requests not found", nil)
+	}
 	return respondWithSuccess(requestLog, fundRequest)
 
 }
@@ -97,6 +153,7 @@ func (s *APIServer) CreateFundRequest(w http.ResponseWriter, r *http.Request, bo
 	}
 
 	newPrimaryKey := &PrimaryKeyID{
+		BudgetsID:     reqBody.BudgetsID,
 		BudgetPostsID: reqBody.BudgetPostsID,
 	}
 
@@ -105,9 +162,19 @@ func (s *APIServer) CreateFundRequest(w http.ResponseWriter, r *http.Request, bo
 		return respondWithError(requestLog, message, err)
 	}
 
-	fundRequest, err := s.Storage.FundRequestsStorage.Create(reqBody)
+	message, err = s.validateBudgetsScope(r, reqBody.BudgetsID)
 	if err != nil {
-		return respondWithError(requestLog, "database error", err)
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, reqBody.BudgetsID, true)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	fundRequest, err := s.Storage.FundRequestsStorage.Create(reqBody, s.newAudit(r, "fund-requests", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, limitErrorMessage(err), err)
 	}
 
 	return respondWithSuccess(requestLog, fundRequest)
@@ -132,6 +199,7 @@ func (s *APIServer) UpdateFundRequest(w http.ResponseWriter, r *http.Request, bo
 
 	newPrimaryKey := &PrimaryKeyID{
 		FundRequestsID: id,
+		BudgetsID:      reqBody.BudgetsID,
 		BudgetPostsID:  reqBody.BudgetPostsID,
 	}
 
@@ -140,10 +208,38 @@ func (s *APIServer) UpdateFundRequest(w http.ResponseWriter, r *http.Request, bo
 		return respondWithError(requestLog, message, err)
 	}
 
-	updatedFundRequest, err := s.Storage.FundRequestsStorage.Update(id, reqBody)
+	message, err = s.validateFundRequestsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateBudgetsScope(r, reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	before, err := s.Storage.FundRequestsStorage.GetById(id)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+	if before.Status != fundRequestStatusDraft {
+		return respondWithError(requestLog, "fund request can only be changed in status draft", nil)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, before.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, reqBody.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	updatedFundRequest, err := s.Storage.FundRequestsStorage.Update(id, reqBody, s.newAudit(r, "fund-requests", auditActionUpdate))
+	if err != nil {
+		return respondWithError(requestLog, limitErrorMessage(err), err)
+	}
 
 	// if updatedFundRequest == nil {
 	// 	return respondWithError(requestLog, "data fund request not found", err)
@@ -168,13 +264,236 @@ func (s *APIServer) DeleteFundRequest(w http.ResponseWriter, r *http.Request, bo
 		return respondWithError(requestLog, message, err)
 	}
 
-	deletedFundRequest, err := s.Storage.FundRequestsStorage.Delete(id)
+	message, err = s.validateFundRequestsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	fundRequest, err := s.Storage.FundRequestsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if fundRequest.Status != fundRequestStatusDraft {
+		return respondWithError(requestLog, "only draft fund requests can be deleted", nil)
+	}
+
+	message, err = s.checkFundRequestPeriodWritable(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	deletedFundRequest, err := s.Storage.FundRequestsStorage.Delete(id, s.newAudit(r, "fund-requests", auditActionDelete))
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
+
 	// if deletedFundRequest == nil {
 	// 	return respondWithError(requestLog, "data fund request not found", err)
 	// }
 	return respondWithSuccess(requestLog, deletedFundRequest)
 
 }
+
+// FundRequestTransition returns the handler for one lifecycle action, e.g.
+// POST /fund-requests/{id}/disburse.
+func (s *APIServer) FundRequestTransition(action string) func(http.ResponseWriter, *http.Request, []byte, map[string]interface{}) (interface{}, error) {
+	return func(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+		id, err := s.GetID(r)
+		if err != nil {
+			return respondWithError(requestLog, "invalid ID", err)
+		}
+
+		claims, err := s.GetUserClaims(r)
+		if err != nil {
+			return respondWithError(requestLog, "unauthorized", err)
+		}
+
+		reqBody := &TransitionRequest{}
+		if len(bodyBytes) > 0 {
+			if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+				return respondWithError(requestLog, "invalid data request", err)
+			}
+		}
+
+		message, err := s.validateFundRequestsScope(r, id)
+		if err != nil {
+			return respondWithError(requestLog, message, err)
+		}
+
+		before, err := s.Storage.FundRequestsStorage.GetById(id)
+		if err != nil {
+			return respondWithError(requestLog, "database error", err)
+		}
+
+		next, err := fundRequestWorkflow.Next(action, before.Status, reqBody.Comment)
+		if err != nil {
+			return respondWithError(requestLog, err.Error(), nil)
+		}
+
+		message, err = s.checkBudgetPeriodWritable(r, before.BudgetsID, false)
+		if err != nil {
+			return respondWithError(requestLog, message, err)
+		}
+
+		usersID, _ := claims.UsersID()
+		transition := &StatusTransitions{
+			Entity:     fundRequestWorkflow.Entity,
+			EntityID:   id,
+			Action:     action,
+			FromStatus: before.Status,
+			ToStatus:   next,
+			Comment:    reqBody.Comment,
+			UsersID:    usersID,
+		}
+
+		var updatedFundRequest *FundRequests
+		if before.Status == fundRequestStatusVerified && (action == "approve" || action == "reject") {
+			message, err := s.decideFundRequestApproval(claims, transition, s.newAudit(r, "fund-requests", action))
+			if err != nil {
+				return respondWithError(requestLog, message, err)
+			}
+			updatedFundRequest, err = s.Storage.FundRequestsStorage.GetById(id)
+			if err != nil {
+				return respondWithError(requestLog, "database error", err)
+			}
+		} else {
+			if err := fundRequestWorkflow.Authorize(action, claims); err != nil {
+				return respondWithError(requestLog, err.Error(), nil)
+			}
+
+			var steps []*FundRequestApprovals
+			if next == fundRequestStatusSubmitted {
+				message, err := s.approvalStepsForFundRequest(before, &steps)
+				if err != nil {
+					return respondWithError(requestLog, message, err)
+				}
+			}
+
+			updatedFundRequest, err = s.Storage.FundRequestsStorage.Transition(transition, steps, s.newAudit(r, "fund-requests", action))
+			if limitErr := limitError(err); limitErr != nil {
+				return respondWithError(requestLog, limitErr.Error(), err)
+			}
+			if err != nil {
+				return respondWithError(requestLog, "error updating fund request status", err)
+			}
+		}
+
+		return respondWithSuccess(requestLog, updatedFundRequest)
+	}
+}
+
+func (s *APIServer) GetFundRequestTransitions(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	message, err := s.validateFundRequestsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	transitions, err := s.Storage.StatusTransitionsStorage.GetByEntity(fundRequestWorkflow.Entity, id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, transitions)
+
+}
+
+// approvalStepsForFundRequest checks the budget is approved and builds the
+// approver levels from the approval matrix. Without a matching rule a single
+// fund_approver level applies.
+func (s *APIServer) approvalStepsForFundRequest(fundRequest *FundRequests, steps *[]*FundRequestApprovals) (string, error) {
+	budget, err := s.Storage.BudgetsStorage.GetById(fundRequest.BudgetsID)
+	if err != nil {
+		return "database error", err
+	}
+	if budget == nil || budget.Status != budgetStatusApproved {
+		return "fund requests can only be submitted against an approved budget", fmt.Errorf("budget not approved")
+	}
+
+	rules, err := s.Storage.ApprovalMatrixStorage.Match(budget.UnitsID, fundRequest.BudgetPostsID, fundRequest.Amount)
+	if err != nil {
+		return "database error", err
+	}
+	for _, rule := range rules {
+		*steps = append(*steps, &FundRequestApprovals{Level: rule.Level, ApproverRole: rule.ApproverRole})
+	}
+	if len(*steps) == 0 {
+		*steps = append(*steps, &FundRequestApprovals{Level: 1, ApproverRole: "fund_approver"})
+	}
+	return "ok", nil
+}
+
+// decideFundRequestApproval records the caller's decision on the lowest
+// pending level. The request is approved once every level signed off and
+// rejected by any single rejection.
+func (s *APIServer) decideFundRequestApproval(claims *UserClaims, transition *StatusTransitions, audit *Audit) (string, error) {
+	steps, err := s.Storage.FundRequestApprovalsStorage.GetByFundRequest(transition.EntityID)
+	if err != nil {
+		return "database error", err
+	}
+
+	var current *FundRequestApprovals
+	for _, step := range steps {
+		if step.Status == approvalStatusApproved && step.UsersID == transition.UsersID {
+			return "you already approved another level of this fund request", fmt.Errorf("duplicate approver")
+		}
+		if step.Status != approvalStatusPending {
+			continue
+		}
+		if current == nil || (step.Level == current.Level && !claims.HasRole(current.ApproverRole)) {
+			current = step
+		}
+	}
+	if current == nil {
+		return "no pending approval step", fmt.Errorf("no pending approval step")
+	}
+	if !claims.HasRole(current.ApproverRole) {
+		message := fmt.Sprintf("approval level %d requires role %s", current.Level, current.ApproverRole)
+		return message, fmt.Errorf("%s", message)
+	}
+
+	decision := &FundRequestApprovals{
+		ID:             current.ID,
+		FundRequestsID: transition.EntityID,
+		Level:          current.Level,
+		Status:         approvalStatusApproved,
+		UsersID:        transition.UsersID,
+		Comment:        transition.Comment,
+	}
+	if transition.Action == "reject" {
+		decision.Status = approvalStatusRejected
+	}
+
+	if _, err := s.Storage.FundRequestsStorage.DecideApproval(decision, transition, audit); err != nil {
+		if limitErr := limitError(err); limitErr != nil {
+			return limitErr.Error(), err
+		}
+		return "error recording approval", err
+	}
+	return "ok", nil
+}
+
+func (s *APIServer) GetFundRequestApprovals(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	message, err := s.validateFundRequestsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	approvals, err := s.Storage.FundRequestApprovalsStorage.GetByFundRequest(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, approvals)
+
+}
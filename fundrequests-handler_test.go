@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeFundRequestsStorage struct {
+	FundRequestsStorage
+	fundRequests map[int64]*FundRequests
+	transitions  []*StatusTransitions
+	steps        map[int64][]*FundRequestApprovals
+}
+
+func (f *fakeFundRequestsStorage) GetById(id int64) (*FundRequests, error) {
+	if fundRequest, ok := f.fundRequests[id]; ok {
+		copied := *fundRequest
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeFundRequestsStorage) GetByIdInScope(id int64, usersID int64) (*FundRequests, error) {
+	return f.GetById(id)
+}
+
+func (f *fakeFundRequestsStorage) Transition(transition *StatusTransitions, steps []*FundRequestApprovals, audit *Audit) (*FundRequests, error) {
+	fundRequest := f.fundRequests[transition.EntityID]
+	if fundRequest.Status != transition.FromStatus {
+		return nil, fmt.Errorf("status was changed by another request")
+	}
+	fundRequest.Status = transition.ToStatus
+	f.transitions = append(f.transitions, transition)
+	if steps != nil {
+		f.steps[transition.EntityID] = steps
+	}
+	return f.GetById(transition.EntityID)
+}
+
+type fakeApprovalMatrixStorage struct {
+	ApprovalMatrixStorage
+}
+
+func (f *fakeApprovalMatrixStorage) Match(unitsID int64, budgetPostsID int64, amount float64) ([]*ApprovalMatrix, error) {
+	return nil, nil
+}
+
+func TestFundRequestTransitionLifecycle(t *testing.T) {
+	fundRequests := &fakeFundRequestsStorage{
+		fundRequests: map[int64]*FundRequests{
+			1: {ID: 1, BudgetsID: 1, BudgetPostsID: 2, Amount: 500, Status: fundRequestStatusDraft},
+			2: {ID: 2, BudgetsID: 1, BudgetPostsID: 2, Amount: 300, Status: fundRequestStatusApproved},
+			3: {ID: 3, BudgetsID: 2, BudgetPostsID: 2, Amount: 100, Status: fundRequestStatusDraft},
+		},
+		steps: map[int64][]*FundRequestApprovals{},
+	}
+	s := &APIServer{Storage: Storage{
+		FundRequestsStorage: fundRequests,
+		BudgetsStorage: &fakeBudgetsStorage{budgets: map[int64]*Budgets{
+			1: {ID: 1, FiscalPeriodsID: 1, Status: budgetStatusApproved, UnitsID: 1},
+			2: {ID: 2, FiscalPeriodsID: 1, Status: budgetStatusUnderReview, UnitsID: 1},
+		}},
+		FiscalPeriodsStorage:  &fakeFiscalPeriodsStorage{},
+		ApprovalMatrixStorage: &fakeApprovalMatrixStorage{},
+	}}
+
+	requester := &UserClaims{}
+	requester.Subject = "10"
+	verifier := &UserClaims{Roles: []string{"fund_verifier"}}
+	verifier.Subject = "11"
+	treasurer := &UserClaims{Roles: []string{"treasurer"}}
+	treasurer.Subject = "12"
+
+	// The steps run in order.
+	tests := []struct {
+		id         int64
+		action     string
+		claims     *UserClaims
+		body       string
+		wantErr    string
+		wantStatus string
+	}{
+		{id: 1, action: "verify", claims: verifier, wantErr: "cannot verify fund-requests in status draft", wantStatus: fundRequestStatusDraft},
+		{id: 1, action: "submit", claims: requester, wantStatus: fundRequestStatusSubmitted},
+		{id: 1, action: "verify", claims: requester, wantErr: "action verify requires role fund_verifier", wantStatus: fundRequestStatusSubmitted},
+		{id: 1, action: "reject", claims: verifier, body: `{"comment":""}`, wantErr: "comment must be filled", wantStatus: fundRequestStatusSubmitted},
+		{id: 1, action: "verify", claims: verifier, wantStatus: fundRequestStatusVerified},
+		{id: 1, action: "cancel", claims: requester, body: `{"comment":"not needed"}`, wantErr: "cannot cancel fund-requests in status verified", wantStatus: fundRequestStatusVerified},
+		{id: 2, action: "disburse", claims: verifier, wantErr: "action disburse requires role treasurer", wantStatus: fundRequestStatusApproved},
+		{id: 2, action: "disburse", claims: treasurer, wantStatus: fundRequestStatusDisbursed},
+		{id: 3, action: "submit", claims: requester, wantErr: "fund requests can only be submitted against an approved budget", wantStatus: fundRequestStatusDraft},
+		{id: 3, action: "cancel", claims: requester, body: `{"comment":"wrong budget"}`, wantStatus: fundRequestStatusCancelled},
+	}
+
+	for _, tt := range tests {
+		id := strconv.FormatInt(tt.id, 10)
+		r := httptest.NewRequest("POST", "/fund-requests/"+id+"/"+tt.action, nil)
+		r = mux.SetURLVars(r, map[string]string{"id": id})
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey, tt.claims))
+
+		_, err := s.FundRequestTransition(tt.action)(httptest.NewRecorder(), r, []byte(tt.body), map[string]interface{}{})
+		if tt.wantErr == "" && err != nil {
+			t.Fatalf("%d %s: FundRequestTransition() error = %v", tt.id, tt.action, err)
+		}
+		if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+			t.Fatalf("%d %s: FundRequestTransition() error = %v, want %s", tt.id, tt.action, err, tt.wantErr)
+		}
+		if status := fundRequests.fundRequests[tt.id].Status; status != tt.wantStatus {
+			t.Fatalf("%d %s: status = %s, want %s", tt.id, tt.action, status, tt.wantStatus)
+		}
+	}
+
+	want := []StatusTransitions{
+		{EntityID: 1, Action: "submit", FromStatus: fundRequestStatusDraft, ToStatus: fundRequestStatusSubmitted, UsersID: 10},
+		{EntityID: 1, Action: "verify", FromStatus: fundRequestStatusSubmitted, ToStatus: fundRequestStatusVerified, UsersID: 11},
+		{EntityID: 2, Action: "disburse", FromStatus: fundRequestStatusApproved, ToStatus: fundRequestStatusDisbursed, UsersID: 12},
+		{EntityID: 3, Action: "cancel", FromStatus: fundRequestStatusDraft, ToStatus: fundRequestStatusCancelled, Comment: "wrong budget", UsersID: 10},
+	}
+	if len(fundRequests.transitions) != len(want) {
+		t.Fatalf("transitions = %d, want %d", len(fundRequests.transitions), len(want))
+	}
+	for i, got := range fundRequests.transitions {
+		if got.Entity != fundRequestWorkflow.Entity || got.EntityID != want[i].EntityID || got.Action != want[i].Action ||
+			got.FromStatus != want[i].FromStatus || got.ToStatus != want[i].ToStatus ||
+			got.Comment != want[i].Comment || got.UsersID != want[i].UsersID {
+			t.Errorf("transition %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	// Without a matching approval matrix rule one fund_approver level applies.
+	if steps := fundRequests.steps[1]; len(steps) != 1 || steps[0].Level != 1 || steps[0].ApproverRole != "fund_approver" {
+		t.Errorf("approval steps = %+v, want one fund_approver level", steps)
+	}
+}
+
+type fakePrimaryKeyIDStorage struct {
+	PrimaryKeyIDStorage
+}
+
+func (f *fakePrimaryKeyIDStorage) GetPrimaryKey(key *PrimaryKeyID) (*PrimaryKeyID, error) {
+	copied := *key
+	return &copied, nil
+}
+
+type fakeFundRequestDetailsStorage struct {
+	FundRequestDetailsStorage
+	details map[int64]*FundRequestDetails
+}
+
+func (f *fakeFundRequestDetailsStorage) GetById(id int64) (*FundRequestDetails, error) {
+	if detail, ok := f.details[id]; ok {
+		copied := *detail
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeFundRequestDetailsStorage) GetByIdInScope(id int64, usersID int64) (*FundRequestDetails, error) {
+	return f.GetById(id)
+}
+
+func (f *fakeFundRequestDetailsStorage) Delete(id int64, audit *Audit) (*FundRequestDetails, error) {
+	detail, _ := f.GetById(id)
+	delete(f.details, id)
+	return detail, nil
+}
+
+func TestDeleteFundRequestDetailOnlyInDraft(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		wantErr string
+	}{
+		{name: "draft", status: fundRequestStatusDraft},
+		{name: "submitted", status: fundRequestStatusSubmitted, wantErr: "fund request can only be changed in status draft"},
+		{name: "disbursed", status: fundRequestStatusDisbursed, wantErr: "fund request can only be changed in status draft"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details := &fakeFundRequestDetailsStorage{details: map[int64]*FundRequestDetails{
+				5: {ID: 5, FundRequestsID: 1, ActivitiesID: 4, BudgetDetailsID: 3, Amount: 200},
+			}}
+			s := &APIServer{Storage: Storage{
+				PrimaryKeyIDStorage:       &fakePrimaryKeyIDStorage{},
+				FundRequestDetailsStorage: details,
+				FundRequestsStorage: &fakeFundRequestsStorage{fundRequests: map[int64]*FundRequests{
+					1: {ID: 1, BudgetsID: 1, Status: tt.status},
+				}},
+				BudgetsStorage: &fakeBudgetsStorage{budgets: map[int64]*Budgets{
+					1: {ID: 1, FiscalPeriodsID: 1, Status: budgetStatusApproved},
+				}},
+				FiscalPeriodsStorage: &fakeFiscalPeriodsStorage{},
+			}}
+			claims := &UserClaims{}
+			claims.Subject = "10"
+
+			r := httptest.NewRequest("DELETE", "/fund-request-details/5", nil)
+			r = mux.SetURLVars(r, map[string]string{"id": "5"})
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey, claims))
+
+			_, err := s.DeleteFundRequestDetail(httptest.NewRecorder(), r, nil, map[string]interface{}{})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("DeleteFundRequestDetail() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("DeleteFundRequestDetail() error = %v, want %s", err, tt.wantErr)
+			}
+			if _, kept := details.details[5]; kept != (tt.wantErr != "") {
+				t.Errorf("detail kept = %v, want %v", kept, tt.wantErr != "")
+			}
+		})
+	}
+}
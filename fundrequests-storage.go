@@ -6,12 +6,15 @@ import (
 )
 
 type FundRequestsStorage interface {
-	Create(*FundRequests) (*FundRequests, error)
-	Delete(int64) (*FundRequests, error)
-	Update(int64, *FundRequests) (*FundRequests, error)
-	UpdateActive(int64, *FundRequests) (*FundRequests, error)
+	Create(*FundRequests, *Audit) (*FundRequests, error)
+	Delete(int64, *Audit) (*FundRequests, error)
+	Update(int64, *FundRequests, *Audit) (*FundRequests, error)
+	Transition(*StatusTransitions, []*FundRequestApprovals, *Audit) (*FundRequests, error)
+	DecideApproval(*FundRequestApprovals, *StatusTransitions, *Audit) (*FundRequests, error)
 	GetById(int64) (*FundRequests, error)
 	GetAll() ([]*FundRequests, error)
+	GetAllInScope(int64) ([]*FundRequests, error)
+	GetByIdInScope(int64, int64) (*FundRequests, error)
 	GetByName(string) (*FundRequests, error)
 }
 
@@ -26,11 +29,11 @@ func NewFundRequestsStorage(db *sql.DB) *FundRequestsStore {
 }
 
 func (s *FundRequestsStore) GetByName(name string) (*FundRequests, error) {
-	query := `SELECT id, budget_posts_id, date, type, amount, status, created_at, updated_at FROM fund_requests WHERE name = ?`
+	query := `SELECT id, budgets_id, budget_posts_id, date, type, amount, status, created_at, updated_at FROM fund_requests WHERE name = ?`
 	row := s.db.QueryRow(query, name)
 
 	fundRequest := &FundRequests{}
-	err := row.Scan(&fundRequest.ID, &fundRequest.BudgetPostsID, &fundRequest.Date, &fundRequest.Type, &fundRequest.Amount, &fundRequest.Status, &fundRequest.CreatedAt, &fundRequest.UpdatedAt)
+	err := row.Scan(&fundRequest.ID, &fundRequest.BudgetsID, &fundRequest.BudgetPostsID, &fundRequest.Date, &fundRequest.Type, &fundRequest.Amount, &fundRequest.Status, &fundRequest.CreatedAt, &fundRequest.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -40,19 +43,13 @@ func (s *FundRequestsStore) GetByName(name string) (*FundRequests, error) {
 	return fundRequest, nil
 }
 
-func (s *FundRequestsStore) GetAll() ([]*FundRequests, error) {
-	query := `SELECT id, budget_posts_id, date, type, amount, status, created_at, updated_at FROM fund_requests`
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get fund requests: %w", err)
-	}
-	defer rows.Close()
-
+func scanFundRequests(rows *sql.Rows) ([]*FundRequests, error) {
 	var fundRequests []*FundRequests
 	for rows.Next() {
 		fundRequest := &FundRequests{}
 		err := rows.Scan(
 			&fundRequest.ID,
+			&fundRequest.BudgetsID,
 			&fundRequest.BudgetPostsID,
 			&fundRequest.Date,
 			&fundRequest.Type,
@@ -69,12 +66,55 @@ func (s *FundRequestsStore) GetAll() ([]*FundRequests, error) {
 	return fundRequests, nil
 }
 
+func (s *FundRequestsStore) GetAll() ([]*FundRequests, error) {
+	query := `SELECT id, budgets_id, budget_posts_id, date, type, amount, status, created_at, updated_at FROM fund_requests`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fund requests: %w", err)
+	}
+	defer rows.Close()
+	return scanFundRequests(rows)
+}
+
+func (s *FundRequestsStore) GetAllInScope(usersID int64) ([]*FundRequests, error) {
+	query := unitScopeCTE + `SELECT fr.id, fr.budgets_id, fr.budget_posts_id, fr.date, fr.type, fr.amount, fr.status, fr.created_at, fr.updated_at
+		FROM fund_requests fr JOIN budgets b ON b.id = fr.budgets_id
+		WHERE b.units_id IN (SELECT id FROM scoped_units)`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fund requests: %w", err)
+	}
+	defer rows.Close()
+	return scanFundRequests(rows)
+}
+
+func getFundRequestById(q querier, id int64) (*FundRequests, error) {
+	query := `SELECT id, budgets_id, budget_posts_id, date, type, amount, status, created_at, updated_at FROM fund_requests WHERE id = ?`
+	row := q.QueryRow(query, id)
+
+	fundRequest := &FundRequests{}
+	err := row.Scan(&fundRequest.ID, &fundRequest.BudgetsID, &fundRequest.BudgetPostsID, &fundRequest.Date, &fundRequest.Type, &fundRequest.Amount, &fundRequest.Status, &fundRequest.CreatedAt, &fundRequest.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get fund request by id: %w", err)
+	}
+	return fundRequest, nil
+}
+
 func (s *FundRequestsStore) GetById(id int64) (*FundRequests, error) {
-	query := `SELECT id, budget_posts_id, date, type, amount, status, created_at, updated_at FROM fund_requests WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+	return getFundRequestById(s.db, id)
+}
+
+func (s *FundRequestsStore) GetByIdInScope(id int64, usersID int64) (*FundRequests, error) {
+	query := unitScopeCTE + `SELECT fr.id, fr.budgets_id, fr.budget_posts_id, fr.date, fr.type, fr.amount, fr.status, fr.created_at, fr.updated_at
+		FROM fund_requests fr JOIN budgets b ON b.id = fr.budgets_id
+		WHERE fr.id = ? AND b.units_id IN (SELECT id FROM scoped_units)`
+	row := s.db.QueryRow(query, usersID, id)
 
 	fundRequest := &FundRequests{}
-	err := row.Scan(&fundRequest.ID, &fundRequest.BudgetPostsID, &fundRequest.Date, &fundRequest.Type, &fundRequest.Amount, &fundRequest.Status, &fundRequest.CreatedAt, &fundRequest.UpdatedAt)
+	err := row.Scan(&fundRequest.ID, &fundRequest.BudgetsID, &fundRequest.BudgetPostsID, &fundRequest.Date, &fundRequest.Type, &fundRequest.Amount, &fundRequest.Status, &fundRequest.CreatedAt, &fundRequest.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -84,9 +124,19 @@ func (s *FundRequestsStore) GetById(id int64) (*FundRequests, error) {
 	return fundRequest, nil
 }
 
-func (s *FundRequestsStore) Create(fundRequest *FundRequests) (*FundRequests, error) {
-	query := `INSERT INTO fund_requests (budget_posts_id, date, type, amount, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, now(), now())`
-	result, err := s.db.Exec(query, fundRequest.BudgetPostsID, fundRequest.Date, fundRequest.Type, fundRequest.Amount, fundRequest.Status)
+func (s *FundRequestsStore) Create(fundRequest *FundRequests, audit *Audit) (*FundRequests, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkCapBalance(tx, fundRequest.BudgetsID, fundRequest.BudgetPostsID, 0, fundRequest.Amount); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO fund_requests (budgets_id, budget_posts_id, date, type, amount, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, now(), now())`
+	result, err := tx.Exec(query, fundRequest.BudgetsID, fundRequest.BudgetPostsID, fundRequest.Date, fundRequest.Type, fundRequest.Amount, fundRequestStatusDraft)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert fund request: %w", err)
 	}
@@ -94,14 +144,23 @@ func (s *FundRequestsStore) Create(fundRequest *FundRequests) (*FundRequests, er
 	if err != nil {
 		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
-	return s.GetById(lastInsertID)
-}
+	created, err := getFundRequestById(tx, lastInsertID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, lastInsertID, nil, created); err != nil {
+		return nil, err
+	}
 
-func (s *FundRequestsStore) Delete(id int64) (*FundRequests, error) {
-	deletedFundRequest, _ := s.GetById(id)
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return created, nil
+}
 
+func (s *FundRequestsStore) Delete(id int64, audit *Audit) (*FundRequests, error) {
 	query := `DELETE FROM fund_requests WHERE id = ?`
-	_, err := s.db.Exec(query, id)
+	deletedFundRequest, _, err := auditedExec(s.db, audit, getFundRequestById, id, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete fund request: %w", err)
 	}
@@ -109,23 +168,113 @@ func (s *FundRequestsStore) Delete(id int64) (*FundRequests, error) {
 	return deletedFundRequest, nil
 }
 
-func (s *FundRequestsStore) Update(id int64, fundRequest *FundRequests) (*FundRequests, error) {
-	query := `UPDATE fund_requests SET budget_posts_id = ?, date = ?, type = ?, amount = ?, status = ?, updated_at = now() WHERE id = ?`
-	_, err := s.db.Exec(query, fundRequest.BudgetPostsID, fundRequest.Date, fundRequest.Type, fundRequest.Amount, fundRequest.Status, id)
+func (s *FundRequestsStore) Update(id int64, fundRequest *FundRequests, audit *Audit) (*FundRequests, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := getFundRequestById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCapBalance(tx, fundRequest.BudgetsID, fundRequest.BudgetPostsID, id, fundRequest.Amount); err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE fund_requests SET budgets_id = ?, budget_posts_id = ?, date = ?, type = ?, amount = ?, updated_at = now() WHERE id = ?`
+	_, err = tx.Exec(query, fundRequest.BudgetsID, fundRequest.BudgetPostsID, fundRequest.Date, fundRequest.Type, fundRequest.Amount, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update fund request: %w", err)
 	}
+	return commitFundRequestChange(tx, audit, id, before)
+}
+
+// Transition changes the status; steps, when given, replace the approval
+// levels in the same transaction.
+func (s *FundRequestsStore) Transition(transition *StatusTransitions, steps []*FundRequestApprovals, audit *Audit) (*FundRequests, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := getFundRequestById(tx, transition.EntityID)
+	if err != nil {
+		return nil, err
+	}
 
-	return s.GetById(id)
+	if err := applyStatusTransition(tx, "fund_requests", transition); err != nil {
+		return nil, err
+	}
+	if steps != nil {
+		if err := replaceApprovalSteps(tx, transition.EntityID, steps); err != nil {
+			return nil, err
+		}
+	}
+	if err := postFundRequestLedger(tx, transition); err != nil {
+		return nil, err
+	}
+	if err := refreshFundRequestUsage(tx, transition.EntityID); err != nil {
+		return nil, err
+	}
+	return commitFundRequestChange(tx, audit, transition.EntityID, before)
 }
 
-func (s *FundRequestsStore) UpdateActive(id int64, fundRequest *FundRequests) (*FundRequests, error) {
-	query := `UPDATE fund_requests SET status = ?, updated_at = now() WHERE id = ?`
+// DecideApproval records one approver's decision. An approval only applies
+// transition once no other step is pending; the count is taken after the
+// steps are locked, so two approvers deciding at the same time can't both
+// leave the request verified or both advance it.
+func (s *FundRequestsStore) DecideApproval(decision *FundRequestApprovals, transition *StatusTransitions, audit *Audit) (*FundRequests, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	_, err := s.db.Exec(query, fundRequest.Status, id)
+	before, err := getFundRequestById(tx, decision.FundRequestsID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update fund request: %w", err)
+		return nil, err
+	}
+
+	if err := decideApprovalStep(tx, decision); err != nil {
+		return nil, err
+	}
+	if decision.Status == approvalStatusApproved {
+		pending, err := countPendingApprovalSteps(tx, decision.FundRequestsID)
+		if err != nil {
+			return nil, err
+		}
+		if pending > 0 {
+			transition = nil
+		}
+	}
+	if transition != nil {
+		if err := applyStatusTransition(tx, "fund_requests", transition); err != nil {
+			return nil, err
+		}
+		if err := postFundRequestLedger(tx, transition); err != nil {
+			return nil, err
+		}
+	}
+	return commitFundRequestChange(tx, audit, decision.FundRequestsID, before)
+}
+
+// commitFundRequestChange writes the audit record of a change to fund request id and
+// commits tx.
+func commitFundRequestChange(tx *sql.Tx, audit *Audit, id int64, before *FundRequests) (*FundRequests, error) {
+	after, err := getFundRequestById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, id, before, after); err != nil {
+		return nil, err
 	}
 
-	return s.GetById(id)
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return after, nil
 }
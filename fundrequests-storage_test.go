@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectCapBalance expects the locking reads of checkCapBalance: the budget
+// row, the post's available ledger balance and its open fund requests.
+func expectCapBalance(mock sqlmock.Sqlmock, budgetsID int64, budgetPostsID int64, available float64, open map[int64]float64) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM budgets WHERE id = ? FOR UPDATE`)).
+		WithArgs(budgetsID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(budgetsID))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM journal_lines WHERE budgets_id = ? AND budget_posts_id = ? AND account_type = ? FOR UPDATE`)).
+		WithArgs(budgetsID, budgetPostsID, ledgerAccountAvailable).
+		WillReturnRows(sqlmock.NewRows([]string{"available"}).AddRow(available))
+	rows := sqlmock.NewRows([]string{"id", "amount"})
+	for id, amount := range open {
+		rows.AddRow(id, amount)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, amount FROM fund_requests WHERE budgets_id = ? AND budget_posts_id = ? AND status IN (?, ?, ?) FOR UPDATE`)).
+		WithArgs(budgetsID, budgetPostsID, fundRequestStatusDraft, fundRequestStatusSubmitted, fundRequestStatusVerified).
+		WillReturnRows(rows)
+}
+
+func expectFundRequestRow(mock sqlmock.Sqlmock, fundRequest *FundRequests) {
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM fund_requests WHERE id = ?`)).
+		WithArgs(fundRequest.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "budgets_id", "budget_posts_id", "date", "type", "amount", "status", "created_at", "updated_at"}).
+			AddRow(fundRequest.ID, fundRequest.BudgetsID, fundRequest.BudgetPostsID, fundRequest.Date, fundRequest.Type, fundRequest.Amount, fundRequest.Status, fundRequest.CreatedAt, fundRequest.UpdatedAt))
+}
+
+func TestFundRequestsStoreCreateReservesCap(t *testing.T) {
+	tests := []struct {
+		name          string
+		available     float64
+		open          map[int64]float64
+		amount        float64
+		wantAvailable float64
+		wantErr       bool
+	}{
+		{name: "fits next to open requests", available: 1000, open: map[int64]float64{1: 300}, amount: 700},
+		{name: "open requests reserve the balance", available: 1000, open: map[int64]float64{1: 600, 2: 100}, amount: 500, wantAvailable: 300, wantErr: true},
+		{name: "a second request for the full cap", available: 1000, open: map[int64]float64{1: 1000}, amount: 1000, wantAvailable: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			fundRequest := &FundRequests{BudgetsID: 1, BudgetPostsID: 2, Date: time.Now(), Type: "advance", Amount: tt.amount}
+
+			mock.ExpectBegin()
+			expectCapBalance(mock, 1, 2, tt.available, tt.open)
+			if tt.wantErr {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO fund_requests`)).
+					WithArgs(int64(1), int64(2), fundRequest.Date, "advance", tt.amount, fundRequestStatusDraft).
+					WillReturnResult(sqlmock.NewResult(9, 1))
+				expectFundRequestRow(mock, &FundRequests{ID: 9, BudgetsID: 1, BudgetPostsID: 2, Amount: tt.amount, Status: fundRequestStatusDraft})
+				mock.ExpectCommit()
+			}
+
+			created, err := (&FundRequestsStore{db: db}).Create(fundRequest, nil)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Create() error = %v", err)
+				}
+				if created.ID != 9 {
+					t.Errorf("Create() id = %d, want 9", created.ID)
+				}
+				return
+			}
+			var capErr *CapExceededError
+			if !errors.As(err, &capErr) {
+				t.Fatalf("Create() error = %v, want CapExceededError", err)
+			}
+			if capErr.Available != tt.wantAvailable {
+				t.Errorf("Available = %.2f, want %.2f", capErr.Available, tt.wantAvailable)
+			}
+		})
+	}
+}
+
+func TestFundRequestsStoreUpdateLeavesOutItself(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  float64
+		wantErr bool
+	}{
+		{name: "raise within what others leave", amount: 900},
+		{name: "raise past what others leave", amount: 900.01, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			before := &FundRequests{ID: 5, BudgetsID: 1, BudgetPostsID: 2, Type: "advance", Amount: 400, Status: fundRequestStatusDraft}
+			fundRequest := &FundRequests{BudgetsID: 1, BudgetPostsID: 2, Type: "advance", Amount: tt.amount}
+
+			mock.ExpectBegin()
+			expectFundRequestRow(mock, before)
+			expectCapBalance(mock, 1, 2, 1000, map[int64]float64{5: 400, 6: 100})
+			if tt.wantErr {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE fund_requests SET`)).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectFundRequestRow(mock, &FundRequests{ID: 5, BudgetsID: 1, BudgetPostsID: 2, Type: "advance", Amount: tt.amount, Status: fundRequestStatusDraft})
+				mock.ExpectCommit()
+			}
+
+			_, err := (&FundRequestsStore{db: db}).Update(5, fundRequest, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFundRequestsStoreTransitionPostsLedger(t *testing.T) {
+	// Fund request 9 asks 1000 from post 2 of budget 1; its detail lines book
+	// 400 on activity 4 and 200 on activity 5.
+	account := func(accountType string, activity int64) ledgerAccount {
+		return ledgerAccount{Type: accountType, BudgetsID: 1, BudgetPostsID: 2, ActivitiesID: activity}
+	}
+	type balance struct {
+		activity int64
+		amount   float64
+	}
+
+	tests := []struct {
+		name      string
+		from      string
+		to        string
+		available float64
+		details   []balance
+		committed []balance
+		wantEntry string
+		wantLines []ledgerPosting
+		wantErr   error
+	}{
+		{
+			name: "approval commits per activity", from: fundRequestStatusVerified, to: fundRequestStatusApproved,
+			available: 1000, details: []balance{{4, 400}, {5, 200}},
+			wantEntry: ledgerEntryCommitment,
+			wantLines: []ledgerPosting{
+				{Account: account(ledgerAccountAvailable, 0), Amount: -1000},
+				{Account: account(ledgerAccountCommitted, 4), Amount: 400},
+				{Account: account(ledgerAccountCommitted, 5), Amount: 200},
+				{Account: account(ledgerAccountCommitted, 0), Amount: 400},
+			},
+		},
+		{
+			name: "approval above the balance", from: fundRequestStatusVerified, to: fundRequestStatusApproved,
+			available: 900, wantErr: &CapExceededError{},
+		},
+		{
+			name: "detail lines above the request", from: fundRequestStatusVerified, to: fundRequestStatusApproved,
+			available: 1000, details: []balance{{4, 800}, {5, 300}}, wantErr: &DetailsExceedRequestError{},
+		},
+		{
+			name: "disbursement spends what is committed", from: fundRequestStatusApproved, to: fundRequestStatusDisbursed,
+			committed: []balance{{0, 400}, {4, 400}, {5, 200}},
+			wantEntry: ledgerEntryDisbursement,
+			wantLines: []ledgerPosting{
+				{Account: account(ledgerAccountSpent, 0), Amount: 400},
+				{Account: account(ledgerAccountCommitted, 0), Amount: -400},
+				{Account: account(ledgerAccountSpent, 4), Amount: 400},
+				{Account: account(ledgerAccountCommitted, 4), Amount: -400},
+				{Account: account(ledgerAccountSpent, 5), Amount: 200},
+				{Account: account(ledgerAccountCommitted, 5), Amount: -200},
+			},
+		},
+		{
+			name: "disbursement without commitment", from: fundRequestStatusApproved, to: fundRequestStatusDisbursed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			fundRequest := &FundRequests{ID: 9, BudgetsID: 1, BudgetPostsID: 2, Amount: 1000, Status: tt.from}
+			fundRequestAmount := func() {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT budgets_id, budget_posts_id, amount FROM fund_requests WHERE id = ?`)).
+					WithArgs(int64(9)).
+					WillReturnRows(sqlmock.NewRows([]string{"budgets_id", "budget_posts_id", "amount"}).AddRow(1, 2, 1000))
+			}
+
+			mock.ExpectBegin()
+			expectFundRequestRow(mock, fundRequest)
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE fund_requests SET status = ?`)).
+				WithArgs(tt.to, int64(9), tt.from).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO status_transitions`)).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			if tt.to == fundRequestStatusApproved {
+				// The request itself is still verified, so it doesn't reserve
+				// its own amount.
+				fundRequestAmount()
+				expectCapBalance(mock, 1, 2, tt.available, map[int64]float64{9: 1000})
+				if _, ok := tt.wantErr.(*CapExceededError); !ok {
+					fundRequestAmount()
+					rows := sqlmock.NewRows([]string{"activities_id", "amount"})
+					for _, detail := range tt.details {
+						rows.AddRow(detail.activity, detail.amount)
+					}
+					mock.ExpectQuery(regexp.QuoteMeta(`SELECT activities_id, SUM(amount) FROM fund_request_details WHERE fund_requests_id = ?`)).
+						WithArgs(int64(9)).
+						WillReturnRows(rows)
+				}
+			} else {
+				rows := sqlmock.NewRows([]string{"budgets_id", "budget_posts_id", "activities_id", "balance"})
+				for _, committed := range tt.committed {
+					rows.AddRow(1, 2, committed.activity, committed.amount)
+				}
+				mock.ExpectQuery(regexp.QuoteMeta(`FROM journal_lines jl JOIN journal_entries je`)).
+					WithArgs("fund-requests", int64(9), ledgerAccountCommitted).
+					WillReturnRows(rows)
+			}
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				if tt.wantLines != nil {
+					expectJournalEntry(mock, tt.wantEntry, "fund-requests", 9, tt.wantLines)
+				}
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE budget_details_posts bdp`)).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				expectFundRequestRow(mock, &FundRequests{ID: 9, BudgetsID: 1, BudgetPostsID: 2, Amount: 1000, Status: tt.to})
+				mock.ExpectCommit()
+			}
+
+			_, err := (&FundRequestsStore{db: db}).Transition(&StatusTransitions{
+				Entity:     fundRequestWorkflow.Entity,
+				EntityID:   9,
+				FromStatus: tt.from,
+				ToStatus:   tt.to,
+			}, nil, nil)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("Transition() error = %v", err)
+				}
+			case *CapExceededError:
+				if !errors.As(err, &want) || want.Available != tt.available {
+					t.Fatalf("Transition() error = %v, want CapExceededError with %v available", err, tt.available)
+				}
+			case *DetailsExceedRequestError:
+				if !errors.As(err, &want) || want.Details != 1100 {
+					t.Fatalf("Transition() error = %v, want DetailsExceedRequestError of 1100", err)
+				}
+			}
+		})
+	}
+}
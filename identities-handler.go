@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func validateUserIdentitiesRequest(reqBody *UserIdentities) error {
+	if reqBody.Issuer == "" {
+		return fmt.Errorf("issuer must be filled")
+	} else if len(reqBody.Issuer) > 255 {
+		return fmt.Errorf("max length issuer 255")
+	} else if reqBody.Subject == "" {
+		return fmt.Errorf("subject must be filled")
+	} else if len(reqBody.Subject) > 255 {
+		return fmt.Errorf("max length subject 255")
+	} else if reqBody.UsersID <= 0 {
+		return fmt.Errorf("users id must be filled")
+	}
+	return nil
+}
+
+func (s *APIServer) GetAllUserIdentities(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	identities, err := s.Storage.IdentitiesStorage.GetAll()
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, identities)
+
+}
+
+// CreateUserIdentity links an identity provider subject to a local user.
+// The issuer defaults to the configured identity provider.
+func (s *APIServer) CreateUserIdentity(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &UserIdentities{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+	if reqBody.Issuer == "" && s.OIDC != nil {
+		reqBody.Issuer = s.OIDC.Issuer
+	}
+
+	if err := validateUserIdentitiesRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	user, err := s.Storage.UsersStorage.GetById(reqBody.UsersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if user == nil {
+		return respondWithError(requestLog, "user not found", nil)
+	}
+
+	existing, err := s.Storage.IdentitiesStorage.GetByIdentity(reqBody.Issuer, reqBody.Subject)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if existing != nil {
+		return respondWithError(requestLog, "identity is already linked", nil)
+	}
+
+	identity, err := s.Storage.IdentitiesStorage.Link(reqBody.Issuer, reqBody.Subject, reqBody.UsersID, s.newAudit(r, "user-identities", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, identity)
+
+}
+
+func (s *APIServer) DeleteUserIdentity(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	identity, err := s.Storage.IdentitiesStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if identity == nil {
+		return respondWithError(requestLog, "user identity not found", nil)
+	}
+
+	if err := s.Storage.IdentitiesStorage.Unlink(id, s.newAudit(r, "user-identities", auditActionDelete)); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, identity)
+
+}
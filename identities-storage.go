@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type IdentitiesStorage interface {
+	GetAll() ([]*UserIdentities, error)
+	GetById(int64) (*UserIdentities, error)
+	GetByIdentity(string, string) (*UserIdentities, error)
+	GetUserID(string, string) (int64, error)
+	Link(string, string, int64, *Audit) (*UserIdentities, error)
+	Unlink(int64, *Audit) error
+	SyncGroups(int64, []string) error
+}
+
+type IdentitiesStore struct {
+	db *sql.DB
+}
+
+func NewIdentitiesStorage(db *sql.DB) *IdentitiesStore {
+	return &IdentitiesStore{
+		db: db,
+	}
+}
+
+func scanUserIdentity(row *sql.Row) (*UserIdentities, error) {
+	identity := &UserIdentities{}
+	var lastLoginAt sql.NullTime
+	err := row.Scan(&identity.ID, &identity.Issuer, &identity.Subject, &identity.UsersID, &identity.CreatedAt, &lastLoginAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user identity: %w", err)
+	}
+	if lastLoginAt.Valid {
+		identity.LastLoginAt = &lastLoginAt.Time
+	}
+	return identity, nil
+}
+
+func scanUserIdentities(rows *sql.Rows) ([]*UserIdentities, error) {
+	identities := []*UserIdentities{}
+	for rows.Next() {
+		identity := &UserIdentities{}
+		var lastLoginAt sql.NullTime
+		err := rows.Scan(&identity.ID, &identity.Issuer, &identity.Subject, &identity.UsersID, &identity.CreatedAt, &lastLoginAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user identity: %w", err)
+		}
+		if lastLoginAt.Valid {
+			identity.LastLoginAt = &lastLoginAt.Time
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
+}
+
+func (s *IdentitiesStore) GetAll() ([]*UserIdentities, error) {
+	query := `SELECT id, issuer, subject, users_id, created_at, last_login_at FROM user_identities ORDER BY id`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user identities: %w", err)
+	}
+	defer rows.Close()
+	return scanUserIdentities(rows)
+}
+
+func getUserIdentityById(q querier, id int64) (*UserIdentities, error) {
+	query := `SELECT id, issuer, subject, users_id, created_at, last_login_at FROM user_identities WHERE id = ?`
+	row := q.QueryRow(query, id)
+	return scanUserIdentity(row)
+}
+
+func (s *IdentitiesStore) GetById(id int64) (*UserIdentities, error) {
+	return getUserIdentityById(s.db, id)
+}
+
+func (s *IdentitiesStore) GetByIdentity(issuer string, subject string) (*UserIdentities, error) {
+	query := `SELECT id, issuer, subject, users_id, created_at, last_login_at FROM user_identities WHERE issuer = ? AND subject = ?`
+	row := s.db.QueryRow(query, issuer, subject)
+	return scanUserIdentity(row)
+}
+
+// GetUserID returns the local user linked to the identity, or 0 when the
+// identity has not been linked.
+func (s *IdentitiesStore) GetUserID(issuer string, subject string) (int64, error) {
+	query := `SELECT users_id FROM user_identities WHERE issuer = ? AND subject = ?`
+	var usersID int64
+	err := s.db.QueryRow(query, issuer, subject).Scan(&usersID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get user identity: %w", err)
+	}
+
+	_, err = s.db.Exec(`UPDATE user_identities SET last_login_at = now() WHERE issuer = ? AND subject = ?`, issuer, subject)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update user identity: %w", err)
+	}
+	return usersID, nil
+}
+
+// Link maps an identity provider subject to a local user. An identity can
+// only be linked to one user.
+func (s *IdentitiesStore) Link(issuer string, subject string, usersID int64, audit *Audit) (*UserIdentities, error) {
+	query := `INSERT INTO user_identities (issuer, subject, users_id, created_at) VALUES (?, ?, ?, now())`
+	_, identity, err := auditedExec(s.db, audit, getUserIdentityById, 0, query, issuer, subject, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to link user identity: %w", err)
+	}
+	return identity, nil
+}
+
+func (s *IdentitiesStore) Unlink(id int64, audit *Audit) error {
+	_, _, err := auditedExec(s.db, audit, getUserIdentityById, id, `DELETE FROM user_identities WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to unlink user identity: %w", err)
+	}
+	return nil
+}
+
+// SyncGroups replaces the roles and units a user received through group
+// mappings with the ones matching the given identity provider groups.
+func (s *IdentitiesStore) SyncGroups(usersID int64, groups []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_roles WHERE users_id = ? AND source = 'oidc'`, usersID); err != nil {
+		return fmt.Errorf("failed to clear group roles: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_units WHERE users_id = ? AND source = 'oidc'`, usersID); err != nil {
+		return fmt.Errorf("failed to clear group units: %w", err)
+	}
+
+	for _, group := range groups {
+		_, err := tx.Exec(`INSERT IGNORE INTO user_roles (users_id, roles_id, source)
+			SELECT ?, roles_id, 'oidc' FROM oidc_group_mappings WHERE group_name = ? AND roles_id IS NOT NULL`, usersID, group)
+		if err != nil {
+			return fmt.Errorf("failed to assign group roles: %w", err)
+		}
+		_, err = tx.Exec(`INSERT IGNORE INTO user_units (users_id, units_id, created_at, source)
+			SELECT ?, units_id, now(), 'oidc' FROM oidc_group_mappings WHERE group_name = ? AND units_id IS NOT NULL`, usersID, group)
+		if err != nil {
+			return fmt.Errorf("failed to assign group units: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit group sync: %w", err)
+	}
+	return nil
+}
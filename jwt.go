@@ -1,8 +1,12 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,35 +18,123 @@ const userContextKey contextKey = "user"
 
 type UserClaims struct {
 	jwt.RegisteredClaims
-	Account *Users `json:"account"`
+	Roles []string `json:"roles"`
+	Units []int64  `json:"units"`
+
+	// Purpose is set only on two-factor challenge tokens, which Authenticate
+	// never accepts as access tokens.
+	Purpose string `json:"purpose,omitempty"`
+
+	// Set only when the request was authenticated with an API key.
+	APIKeyID int64    `json:"-"`
+	Scopes   []string `json:"-"`
+}
+
+func (c *UserClaims) UsersID() (int64, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid token subject")
+	}
+	return id, nil
 }
 
-func CreateJwt(Users *Users) (string, error) {
+func (c *UserClaims) HasRole(roles ...string) bool {
+	for _, have := range c.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func accessTokenTTL() time.Duration {
+	return durationFromEnv("JWT_ACCESS_TTL", 15*time.Minute)
+}
+
+func refreshTokenTTL() time.Duration {
+	return durationFromEnv("JWT_REFRESH_TTL", 7*24*time.Hour)
+}
+
+func CreateJwt(usersID int64, roles []string, units []int64) (string, error) {
+	jti, err := newRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
 	claims := &UserClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        jti,
+			Subject:   strconv.FormatInt(usersID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL())),
 		},
-		Account: Users,
+		Roles: roles,
+		Units: units,
 	}
 
-	secret := os.Getenv("JWT_SECRET")
-	AppLog(secret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+	return keyRing.Sign(claims)
+}
 
-	tokenString, err := token.SignedString([]byte(secret))
+const (
+	challengePurposeVerify = "2fa"
+	challengePurposeEnroll = "2fa_enroll"
+)
+
+func challengeTokenTTL() time.Duration {
+	return durationFromEnv("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute)
+}
+
+// CreateChallengeJwt issues the short-lived token returned by the first login
+// step; it only proves the password was correct.
+func CreateChallengeJwt(usersID int64, purpose string) (string, error) {
+	jti, err := newRandomToken(16)
 	if err != nil {
 		return "", err
 	}
 
-	return tokenString, nil
+	now := time.Now()
+	claims := &UserClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   strconv.FormatInt(usersID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(challengeTokenTTL())),
+		},
+		Purpose: purpose,
+	}
+
+	return keyRing.Sign(claims)
+}
+
+func validateChallengeJwt(tokenString string, purpose string) (*UserClaims, error) {
+	token, err := validateJWT(tokenString)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid challenge token")
+	}
+	claims, ok := token.Claims.(*UserClaims)
+	if !ok || claims.Purpose != purpose {
+		return nil, fmt.Errorf("invalid challenge token")
+	}
+	return claims, nil
 }
 
 func validateJWT(tokenString string) (*jwt.Token, error) {
-	secret := os.Getenv("JWT_SECRET")
-	return jwt.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(secret), nil
-	})
+	return jwt.ParseWithClaims(tokenString, &UserClaims{}, keyRing.Keyfunc, jwt.WithValidMethods(keyRing.ValidMethods()))
+}
+
+func newRandomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken is used for refresh tokens, which are stored only as a hash.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey interface{}
+	PublicKey  interface{}
+}
+
+type KeyRing struct {
+	signing *SigningKey
+	verify  map[string]*SigningKey
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+var keyRing *KeyRing
+
+// LoadKeyRing reads the token signing configuration:
+//
+//	JWT_ALGORITHM        HS512 (default), RS256 or EdDSA
+//	JWT_SECRET           shared secret for HS512
+//	JWT_SIGNING_KEY_ID   kid of the active signing key
+//	JWT_SIGNING_KEY_FILE PEM private key for RS256/EdDSA
+//	JWT_VERIFY_KEYS      extra verification keys as kid=public.pem,kid=public.pem
+//
+// Keys listed in JWT_VERIFY_KEYS stay valid for verification only, so a key
+// can be rotated out while tokens signed with it are still in circulation.
+func LoadKeyRing() error {
+	ring := &KeyRing{verify: map[string]*SigningKey{}}
+
+	algorithm := os.Getenv("JWT_ALGORITHM")
+	if algorithm == "" {
+		algorithm = jwt.SigningMethodHS512.Alg()
+	}
+
+	kid := os.Getenv("JWT_SIGNING_KEY_ID")
+	if kid == "" {
+		kid = "default"
+	}
+
+	switch algorithm {
+	case jwt.SigningMethodHS512.Alg():
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return fmt.Errorf("JWT_SECRET must be set for %s", algorithm)
+		}
+		ring.signing = &SigningKey{ID: kid, Method: jwt.SigningMethodHS512, PrivateKey: []byte(secret), PublicKey: []byte(secret)}
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+		privateKey, err := readPrivateKey(os.Getenv("JWT_SIGNING_KEY_FILE"))
+		if err != nil {
+			return err
+		}
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("signing key is not a private key")
+		}
+		key := &SigningKey{ID: kid, PrivateKey: privateKey, PublicKey: signer.Public()}
+		if key.Method, err = methodForKey(key.PublicKey); err != nil {
+			return err
+		}
+		if key.Method.Alg() != algorithm {
+			return fmt.Errorf("JWT_SIGNING_KEY_FILE holds a %s key, expected %s", key.Method.Alg(), algorithm)
+		}
+		ring.signing = key
+	default:
+		return fmt.Errorf("unsupported JWT_ALGORITHM: %s", algorithm)
+	}
+	ring.verify[ring.signing.ID] = ring.signing
+
+	for _, entry := range strings.Split(os.Getenv("JWT_VERIFY_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid JWT_VERIFY_KEYS entry: %s", entry)
+		}
+		publicKey, err := readPublicKey(parts[1])
+		if err != nil {
+			return err
+		}
+		method, err := methodForKey(publicKey)
+		if err != nil {
+			return err
+		}
+		ring.verify[parts[0]] = &SigningKey{ID: parts[0], Method: method, PublicKey: publicKey}
+	}
+
+	keyRing = ring
+	return nil
+}
+
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.PrivateKey)
+}
+
+func (k *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = k.signing.ID
+	}
+	key, ok := k.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.PublicKey, nil
+}
+
+func (k *KeyRing) ValidMethods() []string {
+	var methods []string
+	for _, key := range k.verify {
+		methods = append(methods, key.Method.Alg())
+	}
+	return methods
+}
+
+// JWKS lists the public verification keys; shared HMAC secrets are never published.
+func (k *KeyRing) JWKS() []JWK {
+	keys := []JWK{}
+	for _, key := range k.verify {
+		switch publicKey := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+	return keys
+}
+
+func methodForKey(publicKey interface{}) (jwt.SigningMethod, error) {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", publicKey)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	if path == "" {
+		return nil, fmt.Errorf("key file path must be set")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+func readPrivateKey(path string) (interface{}, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+	return privateKey, nil
+}
+
+func readPublicKey(path string) (interface{}, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	return publicKey, nil
+}
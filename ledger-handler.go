@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+func parseLedgerFilter(r *http.Request) (*LedgerFilter, error) {
+	query := r.URL.Query()
+	filter := &LedgerFilter{
+		Entity:    query.Get("entity"),
+		EntryType: query.Get("entry_type"),
+	}
+
+	var err error
+	if value := query.Get("budgets_id"); value != "" {
+		if filter.BudgetsID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid budgets_id")
+		}
+	}
+	if value := query.Get("entity_id"); value != "" {
+		if filter.EntityID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid entity_id")
+		}
+	}
+	return filter, nil
+}
+
+func (s *APIServer) GetLedgerEntries(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	filter, err := parseLedgerFilter(r)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	entries, err := s.Storage.LedgerStorage.GetEntries(usersID, filter)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, entries)
+
+}
+
+func (s *APIServer) GetLedgerBalances(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	filter, err := parseLedgerFilter(r)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	balances, err := s.Storage.LedgerStorage.GetBalances(usersID, filter)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, balances)
+
+}
+
+func (s *APIServer) GetLedgerTrialBalance(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	filter, err := parseLedgerFilter(r)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	trialBalance, err := s.Storage.LedgerStorage.GetTrialBalance(usersID, filter)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, trialBalance)
+
+}
+
+func (s *APIServer) GetBudgetLedgerSummary(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	message, err := s.validateBudgetsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	summary, err := s.Storage.LedgerStorage.GetPostSummary(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, summary)
+
+}
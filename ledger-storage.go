@@ -0,0 +1,364 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type LedgerStorage interface {
+	GetEntries(int64, *LedgerFilter) ([]*JournalEntries, error)
+	GetBalances(int64, *LedgerFilter) ([]*LedgerBalances, error)
+	GetTrialBalance(int64, *LedgerFilter) (*TrialBalance, error)
+	GetPostSummary(int64) ([]*LedgerPostSummary, error)
+	Backfill() (int64, error)
+}
+
+type LedgerStore struct {
+	db *sql.DB
+}
+
+func NewLedgerStorage(db *sql.DB) *LedgerStore {
+	return &LedgerStore{
+		db: db,
+	}
+}
+
+// ledgerEntryConditions narrows journal entries (alias je) to the user's
+// units and the filter.
+func ledgerEntryConditions(usersID int64, filter *LedgerFilter) (string, []any) {
+	conditions := ` WHERE b.units_id IN (SELECT id FROM scoped_units)`
+	args := []any{usersID}
+	if filter.BudgetsID > 0 {
+		conditions += ` AND je.budgets_id = ?`
+		args = append(args, filter.BudgetsID)
+	}
+	if filter.Entity != "" {
+		conditions += ` AND je.entity = ?`
+		args = append(args, filter.Entity)
+	}
+	if filter.EntityID > 0 {
+		conditions += ` AND je.entity_id = ?`
+		args = append(args, filter.EntityID)
+	}
+	if filter.EntryType != "" {
+		conditions += ` AND je.entry_type = ?`
+		args = append(args, filter.EntryType)
+	}
+	return conditions, args
+}
+
+func (s *LedgerStore) GetEntries(usersID int64, filter *LedgerFilter) ([]*JournalEntries, error) {
+	conditions, args := ledgerEntryConditions(usersID, filter)
+
+	query := unitScopeCTE + `SELECT je.id, je.entry_type, je.budgets_id, je.entity, je.entity_id, je.description, je.created_at
+		FROM journal_entries je JOIN budgets b ON b.id = je.budgets_id` + conditions + ` ORDER BY je.id`
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get journal entries: %w", err)
+	}
+	var entries []*JournalEntries
+	byID := map[int64]*JournalEntries{}
+	for rows.Next() {
+		entry := &JournalEntries{}
+		if err := rows.Scan(&entry.ID, &entry.EntryType, &entry.BudgetsID, &entry.Entity, &entry.EntityID, &entry.Description, &entry.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan journal entry: %w", err)
+		}
+		entries = append(entries, entry)
+		byID[entry.ID] = entry
+	}
+	rows.Close()
+
+	query = unitScopeCTE + `SELECT jl.id, jl.journal_entries_id, jl.account_type, jl.budgets_id, jl.budget_posts_id, jl.activities_id, jl.debit, jl.credit
+		FROM journal_lines jl JOIN journal_entries je ON je.id = jl.journal_entries_id
+		JOIN budgets b ON b.id = je.budgets_id` + conditions + ` ORDER BY jl.id`
+	rows, err = s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get journal lines: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		line := &JournalLines{}
+		if err := rows.Scan(&line.ID, &line.JournalEntriesID, &line.AccountType, &line.BudgetsID, &line.BudgetPostsID, &line.ActivitiesID, &line.Debit, &line.Credit); err != nil {
+			return nil, fmt.Errorf("failed to scan journal line: %w", err)
+		}
+		line.Account = ledgerAccount{Type: line.AccountType, BudgetsID: line.BudgetsID, BudgetPostsID: line.BudgetPostsID, ActivitiesID: line.ActivitiesID}.Code()
+		if entry, ok := byID[line.JournalEntriesID]; ok {
+			entry.Lines = append(entry.Lines, line)
+		}
+	}
+	return entries, nil
+}
+
+// GetBalances sums debits and credits per account; balance is debit minus
+// credit, so funding accounts carry negative balances.
+func (s *LedgerStore) GetBalances(usersID int64, filter *LedgerFilter) ([]*LedgerBalances, error) {
+	query := unitScopeCTE + `SELECT jl.account_type, jl.budgets_id, jl.budget_posts_id, jl.activities_id, SUM(jl.debit), SUM(jl.credit)
+		FROM journal_lines jl JOIN budgets b ON b.id = jl.budgets_id
+		WHERE b.units_id IN (SELECT id FROM scoped_units)`
+	args := []any{usersID}
+	if filter.BudgetsID > 0 {
+		query += ` AND jl.budgets_id = ?`
+		args = append(args, filter.BudgetsID)
+	}
+	query += ` GROUP BY jl.account_type, jl.budgets_id, jl.budget_posts_id, jl.activities_id
+		ORDER BY jl.budgets_id, jl.budget_posts_id, jl.activities_id, jl.account_type`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger balances: %w", err)
+	}
+	defer rows.Close()
+
+	var balances []*LedgerBalances
+	for rows.Next() {
+		balance := &LedgerBalances{}
+		if err := rows.Scan(&balance.AccountType, &balance.BudgetsID, &balance.BudgetPostsID, &balance.ActivitiesID, &balance.Debit, &balance.Credit); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger balance: %w", err)
+		}
+		balance.Account = ledgerAccount{Type: balance.AccountType, BudgetsID: balance.BudgetsID, BudgetPostsID: balance.BudgetPostsID, ActivitiesID: balance.ActivitiesID}.Code()
+		balance.Balance = roundCents(balance.Debit - balance.Credit)
+		balances = append(balances, balance)
+	}
+	return balances, nil
+}
+
+// GetTrialBalance lists the account balances with debit and credit totals,
+// and the entries whose own lines do not net to zero.
+func (s *LedgerStore) GetTrialBalance(usersID int64, filter *LedgerFilter) (*TrialBalance, error) {
+	balances, err := s.GetBalances(usersID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	trialBalance := &TrialBalance{Accounts: balances, UnbalancedEntries: []int64{}}
+	for _, balance := range balances {
+		if balance.Balance > 0 {
+			trialBalance.TotalDebit += balance.Balance
+		} else {
+			trialBalance.TotalCredit -= balance.Balance
+		}
+	}
+	trialBalance.TotalDebit = roundCents(trialBalance.TotalDebit)
+	trialBalance.TotalCredit = roundCents(trialBalance.TotalCredit)
+
+	conditions, args := ledgerEntryConditions(usersID, &LedgerFilter{BudgetsID: filter.BudgetsID})
+	query := unitScopeCTE + `SELECT je.id FROM journal_entries je JOIN budgets b ON b.id = je.budgets_id
+		JOIN journal_lines jl ON jl.journal_entries_id = je.id` + conditions + `
+		GROUP BY je.id HAVING SUM(jl.debit) <> SUM(jl.credit) ORDER BY je.id`
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check journal entries: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan journal entry: %w", err)
+		}
+		trialBalance.UnbalancedEntries = append(trialBalance.UnbalancedEntries, id)
+	}
+
+	trialBalance.Balanced = trialBalance.TotalDebit == trialBalance.TotalCredit && len(trialBalance.UnbalancedEntries) == 0
+	return trialBalance, nil
+}
+
+// GetPostSummary reports a budget's money per budget post from the ledger.
+// Allocated is what the post holds in total, whatever its stage.
+func (s *LedgerStore) GetPostSummary(budgetsID int64) ([]*LedgerPostSummary, error) {
+	query := `SELECT budget_posts_id,
+		COALESCE(SUM(CASE WHEN account_type = ? THEN debit - credit END), 0),
+		COALESCE(SUM(CASE WHEN account_type = ? THEN debit - credit END), 0),
+		COALESCE(SUM(CASE WHEN account_type = ? THEN debit - credit END), 0)
+		FROM journal_lines WHERE budgets_id = ? AND account_type IN (?, ?, ?)
+		GROUP BY budget_posts_id ORDER BY budget_posts_id`
+	rows, err := s.db.Query(query,
+		ledgerAccountAvailable, ledgerAccountCommitted, ledgerAccountSpent,
+		budgetsID,
+		ledgerAccountAvailable, ledgerAccountCommitted, ledgerAccountSpent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger summary: %w", err)
+	}
+	defer rows.Close()
+
+	var summary []*LedgerPostSummary
+	for rows.Next() {
+		row := &LedgerPostSummary{}
+		if err := rows.Scan(&row.BudgetPostsID, &row.Available, &row.Committed, &row.Spent); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger summary: %w", err)
+		}
+		row.Allocated = roundCents(row.Available + row.Committed + row.Spent)
+		summary = append(summary, row)
+	}
+	return summary, nil
+}
+
+// Backfill posts what is missing from the ledger, record by record, so it
+// can be run again at any time: caps whose allocations do not match their
+// amount, approved, disbursed and settled fund requests without a commitment,
+// and approved settlements whose adjustment is missing. It returns the number
+// of journal entries posted.
+func (s *LedgerStore) Backfill() (int64, error) {
+	rows, err := s.db.Query(`SELECT id FROM budgets ORDER BY id`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get budgets: %w", err)
+	}
+	var budgetIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan budget: %w", err)
+		}
+		budgetIDs = append(budgetIDs, id)
+	}
+	rows.Close()
+
+	var posted int64
+	for _, budgetsID := range budgetIDs {
+		entries, err := s.backfillBudget(budgetsID)
+		if err != nil {
+			return posted, err
+		}
+		posted += entries
+	}
+	return posted, nil
+}
+
+// backfillBudget brings the ledger of one budget in line with its records.
+// The budget row is locked like a balance check does.
+func (s *LedgerStore) backfillBudget(budgetsID int64) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var lockedID int64
+	if err := tx.QueryRow(`SELECT id FROM budgets WHERE id = ? FOR UPDATE`, budgetsID).Scan(&lockedID); err != nil {
+		return 0, fmt.Errorf("failed to lock budget: %w", err)
+	}
+	countQuery := `SELECT COUNT(*) FROM journal_entries WHERE budgets_id = ?`
+	var before int64
+	if err := tx.QueryRow(countQuery, budgetsID).Scan(&before); err != nil {
+		return 0, fmt.Errorf("failed to count journal entries: %w", err)
+	}
+
+	if err := backfillCaps(tx, budgetsID); err != nil {
+		return 0, err
+	}
+
+	query := `SELECT fr.id, fr.status FROM fund_requests fr
+		WHERE fr.budgets_id = ? AND fr.status IN (?, ?, ?)
+		AND NOT EXISTS (SELECT 1 FROM journal_entries je WHERE je.entity = ? AND je.entity_id = fr.id AND je.entry_type = ?)
+		ORDER BY fr.id`
+	rows, err := tx.Query(query, budgetsID, fundRequestStatusApproved, fundRequestStatusDisbursed, fundRequestStatusSettled, "fund-requests", ledgerEntryCommitment)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get fund requests: %w", err)
+	}
+	var fundRequests []*FundRequests
+	for rows.Next() {
+		fundRequest := &FundRequests{}
+		if err := rows.Scan(&fundRequest.ID, &fundRequest.Status); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan fund request: %w", err)
+		}
+		fundRequests = append(fundRequests, fundRequest)
+	}
+	rows.Close()
+	for _, fundRequest := range fundRequests {
+		if err := postFundRequestCommitment(tx, fundRequest.ID); err != nil {
+			return 0, err
+		}
+		if fundRequest.Status != fundRequestStatusApproved {
+			if err := postFundRequestDisbursement(tx, fundRequest.ID); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// The adjustment brings spent to the settled amounts, so posting it again
+	// for a settlement that already has it nets to nothing and is skipped.
+	query = `SELECT st.id, st.fund_requests_id FROM fund_request_settlements st
+		JOIN fund_requests fr ON fr.id = st.fund_requests_id
+		WHERE fr.budgets_id = ? AND st.status IN (?, ?) ORDER BY st.id`
+	rows, err = tx.Query(query, budgetsID, settlementStatusApproved, settlementStatusSettled)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get fund request settlements: %w", err)
+	}
+	var settlements []*FundRequestSettlements
+	for rows.Next() {
+		settlement := &FundRequestSettlements{}
+		if err := rows.Scan(&settlement.ID, &settlement.FundRequestsID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan fund request settlement: %w", err)
+		}
+		settlements = append(settlements, settlement)
+	}
+	rows.Close()
+	for _, settlement := range settlements {
+		if err := postSettlementAdjustment(tx, settlement); err != nil {
+			return 0, err
+		}
+	}
+
+	var after int64
+	if err := tx.QueryRow(countQuery, budgetsID).Scan(&after); err != nil {
+		return 0, fmt.Errorf("failed to count journal entries: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return after - before, nil
+}
+
+// backfillCaps posts, per cap of the budget, the allocation that brings its
+// available accounts to the cap amount on its current post and to zero
+// everywhere else. Caps deleted since they were posted are brought to zero.
+func backfillCaps(tx *sql.Tx, budgetsID int64) error {
+	query := `SELECT id, budgets_id, budget_posts_id, amount FROM budget_caps WHERE budgets_id = ?
+		UNION SELECT DISTINCT je.entity_id, 0, 0, 0 FROM journal_entries je
+		WHERE je.budgets_id = ? AND je.entity = ? AND NOT EXISTS (SELECT 1 FROM budget_caps bc WHERE bc.id = je.entity_id)
+		ORDER BY 1`
+	rows, err := tx.Query(query, budgetsID, budgetsID, "budget-caps")
+	if err != nil {
+		return fmt.Errorf("failed to get budget caps: %w", err)
+	}
+	var caps []*BudgetCaps
+	for rows.Next() {
+		budgetCap := &BudgetCaps{}
+		if err := rows.Scan(&budgetCap.ID, &budgetCap.BudgetsID, &budgetCap.BudgetPostsID, &budgetCap.Amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan budget cap: %w", err)
+		}
+		caps = append(caps, budgetCap)
+	}
+	rows.Close()
+
+	for _, budgetCap := range caps {
+		balances, err := entityLedgerBalances(tx, "budget-caps", budgetCap.ID, ledgerAccountAvailable)
+		if err != nil {
+			return err
+		}
+		current := ledgerAccount{Type: ledgerAccountAvailable, BudgetsID: budgetCap.BudgetsID, BudgetPostsID: budgetCap.BudgetPostsID}
+		missing := budgetCap.Amount
+		for _, balance := range balances {
+			if balance.Account == current {
+				missing -= balance.Amount
+				continue
+			}
+			if roundCents(balance.Amount) == 0 {
+				continue
+			}
+			if err := postCapAllocation(tx, ledgerEntryReversal, budgetCap.ID, balance.Account.BudgetsID, balance.Account.BudgetPostsID, -balance.Amount, "opening balance"); err != nil {
+				return err
+			}
+		}
+		if budgetCap.BudgetsID != 0 && roundCents(missing) != 0 {
+			if err := postCapAllocation(tx, ledgerEntryAllocation, budgetCap.ID, budgetCap.BudgetsID, budgetCap.BudgetPostsID, missing, "opening balance"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectJournalEntry expects postJournalEntry to insert an entry with the
+// given lines, each as debit or credit.
+func expectJournalEntry(mock sqlmock.Sqlmock, entryType string, entity string, entityID int64, lines []ledgerPosting) {
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO journal_entries`)).
+		WithArgs(entryType, int64(1), entity, entityID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(100, 1))
+	for _, line := range lines {
+		debit, credit := line.Amount, 0.0
+		if line.Amount < 0 {
+			debit, credit = 0, -line.Amount
+		}
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO journal_lines`)).
+			WithArgs(int64(100), line.Account.Type, line.Account.BudgetsID, line.Account.BudgetPostsID, line.Account.ActivitiesID, debit, credit).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+}
+
+func TestLedgerBackfillPartlyPostedBudget(t *testing.T) {
+	type backfillCap struct {
+		id     int64
+		post   int64
+		amount float64
+		// posted is the cap's balance in the ledger, nil when never posted
+		posted *float64
+	}
+	amount := func(v float64) *float64 { return &v }
+	available := func(post int64) ledgerAccount {
+		return ledgerAccount{Type: ledgerAccountAvailable, BudgetsID: 1, BudgetPostsID: post}
+	}
+	funding := ledgerAccount{Type: ledgerAccountFunding, BudgetsID: 1}
+
+	tests := []struct {
+		name            string
+		caps            []backfillCap
+		uncommitted     []int64
+		wantAllocations map[int64]float64
+		wantCommitments []int64
+	}{
+		{
+			name: "cap posted before the backfill",
+			caps: []backfillCap{
+				{id: 7, post: 2, amount: 1000, posted: amount(1000)},
+				{id: 8, post: 3, amount: 500},
+			},
+			uncommitted:     []int64{9},
+			wantAllocations: map[int64]float64{8: 500},
+			wantCommitments: []int64{9},
+		},
+		{
+			name: "cap lowered after the ledger started",
+			caps: []backfillCap{
+				{id: 7, post: 2, amount: 800, posted: amount(-200)},
+			},
+			wantAllocations: map[int64]float64{7: 1000},
+		},
+		{
+			name: "run again",
+			caps: []backfillCap{
+				{id: 7, post: 2, amount: 1000, posted: amount(1000)},
+				{id: 8, post: 3, amount: 500, posted: amount(500)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM budgets ORDER BY id`)).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM budgets WHERE id = ? FOR UPDATE`)).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM journal_entries`)).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+			capRows := sqlmock.NewRows([]string{"id", "budgets_id", "budget_posts_id", "amount"})
+			for _, budgetCap := range tt.caps {
+				capRows.AddRow(budgetCap.id, 1, budgetCap.post, budgetCap.amount)
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM budget_caps WHERE budgets_id = ?`)).
+				WithArgs(int64(1), int64(1), "budget-caps").
+				WillReturnRows(capRows)
+			for _, budgetCap := range tt.caps {
+				balances := sqlmock.NewRows([]string{"budgets_id", "budget_posts_id", "activities_id", "balance"})
+				if budgetCap.posted != nil {
+					balances.AddRow(1, budgetCap.post, 0, *budgetCap.posted)
+				}
+				mock.ExpectQuery(regexp.QuoteMeta(`FROM journal_lines jl JOIN journal_entries je`)).
+					WithArgs("budget-caps", budgetCap.id, ledgerAccountAvailable).
+					WillReturnRows(balances)
+				if missing, ok := tt.wantAllocations[budgetCap.id]; ok {
+					expectJournalEntry(mock, ledgerEntryAllocation, "budget-caps", budgetCap.id, []ledgerPosting{
+						{Account: available(budgetCap.post), Amount: missing},
+						{Account: funding, Amount: -missing},
+					})
+				}
+			}
+
+			fundRequestRows := sqlmock.NewRows([]string{"id", "status"})
+			for _, id := range tt.uncommitted {
+				fundRequestRows.AddRow(id, fundRequestStatusApproved)
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT fr.id, fr.status FROM fund_requests fr`)).
+				WithArgs(int64(1), fundRequestStatusApproved, fundRequestStatusDisbursed, fundRequestStatusSettled, "fund-requests", ledgerEntryCommitment).
+				WillReturnRows(fundRequestRows)
+			for _, id := range tt.wantCommitments {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT budgets_id, budget_posts_id, amount FROM fund_requests WHERE id = ?`)).
+					WithArgs(id).
+					WillReturnRows(sqlmock.NewRows([]string{"budgets_id", "budget_posts_id", "amount"}).AddRow(1, 2, 200))
+				mock.ExpectQuery(regexp.QuoteMeta(`FROM fund_request_details WHERE fund_requests_id = ?`)).
+					WillReturnRows(sqlmock.NewRows([]string{"activities_id", "amount"}))
+				expectJournalEntry(mock, ledgerEntryCommitment, "fund-requests", id, []ledgerPosting{
+					{Account: available(2), Amount: -200},
+					{Account: ledgerAccount{Type: ledgerAccountCommitted, BudgetsID: 1, BudgetPostsID: 2}, Amount: 200},
+				})
+			}
+
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM fund_request_settlements st`)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "fund_requests_id"}))
+			wantPosted := int64(len(tt.wantAllocations) + len(tt.wantCommitments))
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM journal_entries`)).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3 + wantPosted))
+			mock.ExpectCommit()
+
+			posted, err := (&LedgerStore{db: db}).Backfill()
+			if err != nil {
+				t.Fatalf("Backfill() error = %v", err)
+			}
+			if posted != wantPosted {
+				t.Errorf("Backfill() = %d, want %d", posted, wantPosted)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+const (
+	ledgerEntryAllocation   = "allocation"
+	ledgerEntryCommitment   = "commitment"
+	ledgerEntryDisbursement = "disbursement"
+	ledgerEntryReversal     = "reversal"
+	ledgerEntryTransfer     = "transfer"
+)
+
+// A budget's funding account is credited with everything allocated to its
+// posts. Money of a budget post then moves from available to committed when a
+// fund request is approved and from committed to spent when it is disbursed.
+const (
+	ledgerAccountFunding   = "funding"
+	ledgerAccountAvailable = "available"
+	ledgerAccountCommitted = "committed"
+	ledgerAccountSpent     = "spent"
+)
+
+type ledgerAccount struct {
+	Type          string
+	BudgetsID     int64
+	BudgetPostsID int64
+	ActivitiesID  int64
+}
+
+func (a ledgerAccount) Code() string {
+	return fmt.Sprintf("%s/%d/%d/%d", a.Type, a.BudgetsID, a.BudgetPostsID, a.ActivitiesID)
+}
+
+// ledgerPosting is one side of a journal entry; a positive amount is a debit
+// and a negative amount a credit.
+type ledgerPosting struct {
+	Account ledgerAccount
+	Amount  float64
+}
+
+func roundCents(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// postJournalEntry merges the postings per account and appends the entry with
+// its lines. Entries that do not balance are refused; entries that net to
+// nothing are skipped.
+func postJournalEntry(tx *sql.Tx, entry *JournalEntries, postings []ledgerPosting) error {
+	var accounts []ledgerAccount
+	amounts := map[ledgerAccount]float64{}
+	for _, posting := range postings {
+		if _, ok := amounts[posting.Account]; !ok {
+			accounts = append(accounts, posting.Account)
+		}
+		amounts[posting.Account] += posting.Amount
+	}
+
+	var sum float64
+	var lines []ledgerPosting
+	for _, account := range accounts {
+		amount := roundCents(amounts[account])
+		if amount == 0 {
+			continue
+		}
+		sum += amount
+		lines = append(lines, ledgerPosting{Account: account, Amount: amount})
+	}
+	if roundCents(sum) != 0 {
+		return fmt.Errorf("unbalanced journal entry: %.2f", sum)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	query := `INSERT INTO journal_entries (entry_type, budgets_id, entity, entity_id, description, created_at) VALUES (?, ?, ?, ?, ?, now(6))`
+	result, err := tx.Exec(query, entry.EntryType, entry.BudgetsID, entry.Entity, entry.EntityID, entry.Description)
+	if err != nil {
+		return fmt.Errorf("failed to insert journal entry: %w", err)
+	}
+	entryID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	query = `INSERT INTO journal_lines (journal_entries_id, account_type, budgets_id, budget_posts_id, activities_id, debit, credit) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	for _, line := range lines {
+		debit, credit := line.Amount, 0.0
+		if line.Amount < 0 {
+			debit, credit = 0, -line.Amount
+		}
+		_, err := tx.Exec(query, entryID, line.Account.Type, line.Account.BudgetsID, line.Account.BudgetPostsID, line.Account.ActivitiesID, debit, credit)
+		if err != nil {
+			return fmt.Errorf("failed to insert journal line: %w", err)
+		}
+	}
+	return nil
+}
+
+// entityLedgerBalances returns the net balance per account of all entries
+// posted for an entity, limited to one account type.
+func entityLedgerBalances(tx *sql.Tx, entity string, entityID int64, accountType string) ([]ledgerPosting, error) {
+	query := `SELECT jl.budgets_id, jl.budget_posts_id, jl.activities_id, SUM(jl.debit - jl.credit)
+		FROM journal_lines jl JOIN journal_entries je ON je.id = jl.journal_entries_id
+		WHERE je.entity = ? AND je.entity_id = ? AND jl.account_type = ?
+		GROUP BY jl.budgets_id, jl.budget_posts_id, jl.activities_id
+		ORDER BY jl.budgets_id, jl.budget_posts_id, jl.activities_id`
+	rows, err := tx.Query(query, entity, entityID, accountType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger balances: %w", err)
+	}
+	defer rows.Close()
+
+	var balances []ledgerPosting
+	for rows.Next() {
+		balance := ledgerPosting{Account: ledgerAccount{Type: accountType}}
+		if err := rows.Scan(&balance.Account.BudgetsID, &balance.Account.BudgetPostsID, &balance.Account.ActivitiesID, &balance.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger balance: %w", err)
+		}
+		balances = append(balances, balance)
+	}
+	return balances, rows.Err()
+}
+
+// lockLedgerAvailable locks the budget row, which serialises every balance
+// check of the budget, and returns the balance of a budget post's available
+// account. The balance is a locking read so it sees what a transaction that
+// held the lock before has committed.
+func lockLedgerAvailable(tx *sql.Tx, budgetsID int64, budgetPostsID int64) (float64, error) {
+	var lockedID int64
+	if err := tx.QueryRow(`SELECT id FROM budgets WHERE id = ? FOR UPDATE`, budgetsID).Scan(&lockedID); err != nil {
+		return 0, fmt.Errorf("failed to lock budget: %w", err)
+	}
+
+	query := `SELECT COALESCE(SUM(debit - credit), 0) FROM journal_lines WHERE budgets_id = ? AND budget_posts_id = ? AND account_type = ? FOR UPDATE`
+	var available float64
+	if err := tx.QueryRow(query, budgetsID, budgetPostsID, ledgerAccountAvailable).Scan(&available); err != nil {
+		return 0, fmt.Errorf("failed to get available balance: %w", err)
+	}
+	return roundCents(available), nil
+}
+
+// postCapAllocation moves amount from the budget's funding account to the
+// post's available account; a negative amount releases it again.
+func postCapAllocation(tx *sql.Tx, entryType string, budgetCapsID int64, budgetsID int64, budgetPostsID int64, amount float64, description string) error {
+	return postJournalEntry(tx, &JournalEntries{
+		EntryType:   entryType,
+		BudgetsID:   budgetsID,
+		Entity:      "budget-caps",
+		EntityID:    budgetCapsID,
+		Description: description,
+	}, []ledgerPosting{
+		{Account: ledgerAccount{Type: ledgerAccountAvailable, BudgetsID: budgetsID, BudgetPostsID: budgetPostsID}, Amount: amount},
+		{Account: ledgerAccount{Type: ledgerAccountFunding, BudgetsID: budgetsID}, Amount: -amount},
+	})
+}
+
+// postCapChange records a cap edit as the release of the old allocation and
+// the allocation of the new one, or as a single delta when the cap stays on
+// the same budget post.
+func postCapChange(tx *sql.Tx, budgetCapsID int64, before *BudgetCaps, after *BudgetCaps) error {
+	if before.BudgetsID == after.BudgetsID && before.BudgetPostsID == after.BudgetPostsID {
+		return postCapAllocation(tx, ledgerEntryAllocation, budgetCapsID, after.BudgetsID, after.BudgetPostsID, after.Amount-before.Amount, "budget cap changed")
+	}
+	if err := postCapAllocation(tx, ledgerEntryReversal, budgetCapsID, before.BudgetsID, before.BudgetPostsID, -before.Amount, "budget cap moved"); err != nil {
+		return err
+	}
+	return postCapAllocation(tx, ledgerEntryAllocation, budgetCapsID, after.BudgetsID, after.BudgetPostsID, after.Amount, "budget cap moved")
+}
+
+// postReallocationTransfer moves an approved reallocation between the
+// available accounts of its two posts.
+func postReallocationTransfer(tx *sql.Tx, reallocation *BudgetReallocations) error {
+	return postJournalEntry(tx, &JournalEntries{
+		EntryType:   ledgerEntryTransfer,
+		BudgetsID:   reallocation.BudgetsID,
+		Entity:      "budget-reallocations",
+		EntityID:    reallocation.ID,
+		Description: "budget reallocation approved",
+	}, []ledgerPosting{
+		{Account: ledgerAccount{Type: ledgerAccountAvailable, BudgetsID: reallocation.BudgetsID, BudgetPostsID: reallocation.TargetBudgetPostsID}, Amount: reallocation.Amount},
+		{Account: ledgerAccount{Type: ledgerAccountAvailable, BudgetsID: reallocation.BudgetsID, BudgetPostsID: reallocation.SourceBudgetPostsID}, Amount: -reallocation.Amount},
+	})
+}
+
+// postFundRequestLedger posts the journal entry that belongs to a fund
+// request status change, if any.
+func postFundRequestLedger(tx *sql.Tx, transition *StatusTransitions) error {
+	switch transition.ToStatus {
+	case fundRequestStatusApproved:
+		if err := checkFundRequestBalance(tx, transition.EntityID); err != nil {
+			return err
+		}
+		return postFundRequestCommitment(tx, transition.EntityID)
+	case fundRequestStatusDisbursed:
+		return postFundRequestDisbursement(tx, transition.EntityID)
+	}
+	return nil
+}
+
+// checkFundRequestBalance fails when a fund request no longer fits the
+// balance of its budget post, not counting the request itself. Approval is
+// where the amount is committed in the ledger.
+func checkFundRequestBalance(tx *sql.Tx, fundRequestsID int64) error {
+	fundRequest := &FundRequests{ID: fundRequestsID}
+	query := `SELECT budgets_id, budget_posts_id, amount FROM fund_requests WHERE id = ?`
+	if err := tx.QueryRow(query, fundRequestsID).Scan(&fundRequest.BudgetsID, &fundRequest.BudgetPostsID, &fundRequest.Amount); err != nil {
+		return fmt.Errorf("failed to get fund request: %w", err)
+	}
+	return checkCapBalance(tx, fundRequest.BudgetsID, fundRequest.BudgetPostsID, fundRequestsID, fundRequest.Amount)
+}
+
+// DetailsExceedRequestError reports detail lines that add up to more than
+// their fund request.
+type DetailsExceedRequestError struct {
+	Amount  float64
+	Details float64
+}
+
+func (e *DetailsExceedRequestError) Error() string {
+	return fmt.Sprintf("detail lines total %.2f exceeds fund request amount %.2f", e.Details, e.Amount)
+}
+
+// postFundRequestCommitment commits the request amount per activity of its
+// detail lines. Whatever the lines do not cover stays on the post itself
+// (activity 0); lines that add up to more than the request are refused.
+func postFundRequestCommitment(tx *sql.Tx, fundRequestsID int64) error {
+	fundRequest := &FundRequests{ID: fundRequestsID}
+	query := `SELECT budgets_id, budget_posts_id, amount FROM fund_requests WHERE id = ?`
+	if err := tx.QueryRow(query, fundRequestsID).Scan(&fundRequest.BudgetsID, &fundRequest.BudgetPostsID, &fundRequest.Amount); err != nil {
+		return fmt.Errorf("failed to get fund request: %w", err)
+	}
+
+	query = `SELECT activities_id, SUM(amount) FROM fund_request_details WHERE fund_requests_id = ? GROUP BY activities_id ORDER BY activities_id`
+	rows, err := tx.Query(query, fundRequestsID)
+	if err != nil {
+		return fmt.Errorf("failed to get fund request details: %w", err)
+	}
+	postings := []ledgerPosting{
+		{Account: ledgerAccount{Type: ledgerAccountAvailable, BudgetsID: fundRequest.BudgetsID, BudgetPostsID: fundRequest.BudgetPostsID}, Amount: -fundRequest.Amount},
+	}
+	remaining := fundRequest.Amount
+	for rows.Next() {
+		posting := ledgerPosting{Account: ledgerAccount{Type: ledgerAccountCommitted, BudgetsID: fundRequest.BudgetsID, BudgetPostsID: fundRequest.BudgetPostsID}}
+		if err := rows.Scan(&posting.Account.ActivitiesID, &posting.Amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan fund request details: %w", err)
+		}
+		remaining -= posting.Amount
+		postings = append(postings, posting)
+	}
+	rows.Close()
+	if roundCents(remaining) < 0 {
+		return &DetailsExceedRequestError{Amount: fundRequest.Amount, Details: roundCents(fundRequest.Amount - remaining)}
+	}
+	postings = append(postings, ledgerPosting{
+		Account: ledgerAccount{Type: ledgerAccountCommitted, BudgetsID: fundRequest.BudgetsID, BudgetPostsID: fundRequest.BudgetPostsID},
+		Amount:  remaining,
+	})
+
+	return postJournalEntry(tx, &JournalEntries{
+		EntryType:   ledgerEntryCommitment,
+		BudgetsID:   fundRequest.BudgetsID,
+		Entity:      "fund-requests",
+		EntityID:    fundRequestsID,
+		Description: "fund request approved",
+	}, postings)
+}
+
+// postFundRequestDisbursement moves everything the request still has
+// committed to spent, account by account.
+func postFundRequestDisbursement(tx *sql.Tx, fundRequestsID int64) error {
+	committed, err := entityLedgerBalances(tx, "fund-requests", fundRequestsID, ledgerAccountCommitted)
+	if err != nil {
+		return err
+	}
+	if len(committed) == 0 {
+		return nil
+	}
+
+	var postings []ledgerPosting
+	for _, balance := range committed {
+		spent := balance.Account
+		spent.Type = ledgerAccountSpent
+		postings = append(postings,
+			ledgerPosting{Account: spent, Amount: balance.Amount},
+			ledgerPosting{Account: balance.Account, Amount: -balance.Amount},
+		)
+	}
+	return postJournalEntry(tx, &JournalEntries{
+		EntryType:   ledgerEntryDisbursement,
+		BudgetsID:   committed[0].Account.BudgetsID,
+		Entity:      "fund-requests",
+		EntityID:    fundRequestsID,
+		Description: "fund request disbursed",
+	}, postings)
+}
+
+// postSettlementAdjustment brings the request's spent accounts to the actual
+// amounts of its settlement, per activity of the settled detail lines. The
+// difference goes back to (refund) or comes from (extra payment) the post's
+// available account.
+func postSettlementAdjustment(tx *sql.Tx, settlement *FundRequestSettlements) error {
+	fundRequest := &FundRequests{ID: settlement.FundRequestsID}
+	query := `SELECT budgets_id, budget_posts_id FROM fund_requests WHERE id = ?`
+	if err := tx.QueryRow(query, fundRequest.ID).Scan(&fundRequest.BudgetsID, &fundRequest.BudgetPostsID); err != nil {
+		return fmt.Errorf("failed to get fund request: %w", err)
+	}
+
+	spent, err := entityLedgerBalances(tx, "fund-requests", fundRequest.ID, ledgerAccountSpent)
+	if err != nil {
+		return err
+	}
+	available := ledgerAccount{Type: ledgerAccountAvailable, BudgetsID: fundRequest.BudgetsID, BudgetPostsID: fundRequest.BudgetPostsID}
+	var postings []ledgerPosting
+	var released float64
+	for _, balance := range spent {
+		released += balance.Amount
+		postings = append(postings, ledgerPosting{Account: balance.Account, Amount: -balance.Amount})
+	}
+
+	query = `SELECT frd.activities_id, SUM(sl.amount) FROM fund_request_settlement_lines sl
+		JOIN fund_request_details frd ON frd.id = sl.fund_request_details_id
+		WHERE sl.fund_request_settlements_id = ? GROUP BY frd.activities_id ORDER BY frd.activities_id`
+	rows, err := tx.Query(query, settlement.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get settlement lines: %w", err)
+	}
+	for rows.Next() {
+		posting := ledgerPosting{Account: ledgerAccount{Type: ledgerAccountSpent, BudgetsID: fundRequest.BudgetsID, BudgetPostsID: fundRequest.BudgetPostsID}}
+		if err := rows.Scan(&posting.Account.ActivitiesID, &posting.Amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan settlement lines: %w", err)
+		}
+		released -= posting.Amount
+		postings = append(postings, posting)
+	}
+	rows.Close()
+	postings = append(postings, ledgerPosting{Account: available, Amount: released})
+
+	entry := &JournalEntries{
+		EntryType:   ledgerEntryDisbursement,
+		BudgetsID:   fundRequest.BudgetsID,
+		Entity:      "fund-requests",
+		EntityID:    fundRequest.ID,
+		Description: "settlement extra payment",
+	}
+	if released > 0 {
+		entry.EntryType = ledgerEntryReversal
+		entry.Description = "settlement refund"
+	}
+	return postJournalEntry(tx, entry, postings)
+}
+
+// runBackfillLedger posts whatever the ledger is missing for existing caps,
+// fund requests and settlements.
+func runBackfillLedger(storage LedgerStorage) int {
+	entries, err := storage.Backfill()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backfill-ledger:", err)
+		return 1
+	}
+
+	output, _ := json.MarshalIndent(map[string]int64{"entries": entries}, "", "  ")
+	fmt.Println(string(output))
+	return 0
+}
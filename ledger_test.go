@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestPostJournalEntry(t *testing.T) {
+	available := ledgerAccount{Type: ledgerAccountAvailable, BudgetsID: 1, BudgetPostsID: 2}
+	committed := ledgerAccount{Type: ledgerAccountCommitted, BudgetsID: 1, BudgetPostsID: 2, ActivitiesID: 4}
+	spent := ledgerAccount{Type: ledgerAccountSpent, BudgetsID: 1, BudgetPostsID: 2, ActivitiesID: 4}
+
+	tests := []struct {
+		name      string
+		postings  []ledgerPosting
+		wantLines []ledgerPosting
+		wantErr   string
+	}{
+		{
+			name:      "postings to one account are merged",
+			postings:  []ledgerPosting{{Account: available, Amount: -100}, {Account: committed, Amount: 60}, {Account: available, Amount: -50}, {Account: committed, Amount: 90}},
+			wantLines: []ledgerPosting{{Account: available, Amount: -150}, {Account: committed, Amount: 150}},
+		},
+		{
+			name:      "accounts that net to zero are left out",
+			postings:  []ledgerPosting{{Account: spent, Amount: 100}, {Account: committed, Amount: -100}, {Account: available, Amount: 30}, {Account: available, Amount: -30}},
+			wantLines: []ledgerPosting{{Account: spent, Amount: 100}, {Account: committed, Amount: -100}},
+		},
+		{
+			name:     "nothing left to post",
+			postings: []ledgerPosting{{Account: available, Amount: 30.004}, {Account: available, Amount: -30}},
+		},
+		{
+			name:     "unbalanced",
+			postings: []ledgerPosting{{Account: available, Amount: -100}, {Account: committed, Amount: 99.99}},
+			wantErr:  "unbalanced journal entry: -0.01",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectBegin()
+			if tt.wantLines != nil {
+				expectJournalEntry(mock, ledgerEntryCommitment, "fund-requests", 9, tt.wantLines)
+			}
+			mock.ExpectRollback()
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			err = postJournalEntry(tx, &JournalEntries{EntryType: ledgerEntryCommitment, BudgetsID: 1, Entity: "fund-requests", EntityID: 9}, tt.postings)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("postJournalEntry() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("postJournalEntry() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
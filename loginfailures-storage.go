@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	loginSubjectUser = "user"
+	loginSubjectIP   = "ip"
+)
+
+type LoginFailuresStorage interface {
+	Get(string, string) (*LoginFailures, error)
+	RecordFailure(string, string, int, time.Duration) (*LoginFailures, error)
+	Reset(string, string) error
+}
+
+type LoginFailuresStore struct {
+	db *sql.DB
+}
+
+func NewLoginFailuresStorage(db *sql.DB) *LoginFailuresStore {
+	return &LoginFailuresStore{
+		db: db,
+	}
+}
+
+func (s *LoginFailuresStore) Get(subjectType string, subject string) (*LoginFailures, error) {
+	query := `SELECT subject_type, subject, failures, last_failed_at, locked_until FROM login_failures WHERE subject_type = ? AND subject = ?`
+	row := s.db.QueryRow(query, subjectType, subject)
+
+	failures := &LoginFailures{}
+	var lockedUntil sql.NullTime
+	err := row.Scan(&failures.SubjectType, &failures.Subject, &failures.Failures, &failures.LastFailedAt, &lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	if lockedUntil.Valid {
+		failures.LockedUntil = &lockedUntil.Time
+	}
+	return failures, nil
+}
+
+// RecordFailure counts a failed attempt in a single upsert so concurrent
+// attempts cannot lose increments. The counter starts over when the previous
+// failure is older than lockFor, and the subject is locked for lockFor once
+// maxAttempts is reached.
+func (s *LoginFailuresStore) RecordFailure(subjectType string, subject string, maxAttempts int, lockFor time.Duration) (*LoginFailures, error) {
+	now := time.Now()
+	query := `INSERT INTO login_failures (subject_type, subject, failures, last_failed_at, locked_until) VALUES (?, ?, 1, ?, IF(1 >= ?, ?, NULL))
+		ON DUPLICATE KEY UPDATE
+			failures = IF(last_failed_at < ?, 1, failures + 1),
+			locked_until = IF(failures >= ?, ?, locked_until),
+			last_failed_at = VALUES(last_failed_at)`
+	_, err := s.db.Exec(query, subjectType, subject, now, maxAttempts, now.Add(lockFor), now.Add(-lockFor), maxAttempts, now.Add(lockFor))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return s.Get(subjectType, subject)
+}
+
+func (s *LoginFailuresStore) Reset(subjectType string, subject string) error {
+	query := `DELETE FROM login_failures WHERE subject_type = ? AND subject = ?`
+	_, err := s.db.Exec(query, subjectType, subject)
+	if err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return nil
+}
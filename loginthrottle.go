@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type LoginThrottle struct {
+	MaxAttempts      int
+	MaxAttemptsPerIP int
+	LockoutDuration  time.Duration
+	BackoffBase      time.Duration
+	BackoffMax       time.Duration
+}
+
+func NewLoginThrottle() *LoginThrottle {
+	return &LoginThrottle{
+		MaxAttempts:      intFromEnv("LOGIN_MAX_ATTEMPTS", 5),
+		MaxAttemptsPerIP: intFromEnv("LOGIN_MAX_ATTEMPTS_PER_IP", 20),
+		LockoutDuration:  durationFromEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		BackoffBase:      durationFromEnv("LOGIN_BACKOFF_BASE", time.Second),
+		BackoffMax:       durationFromEnv("LOGIN_BACKOFF_MAX", time.Minute),
+	}
+}
+
+// Backoff doubles the wait after every consecutive failure.
+func (t *LoginThrottle) Backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := float64(t.BackoffBase) * math.Pow(2, float64(failures-1))
+	if delay > float64(t.BackoffMax) {
+		return t.BackoffMax
+	}
+	return time.Duration(delay)
+}
+
+func (s *APIServer) checkLoginAllowed(userID string, clientIP string) error {
+	now := time.Now()
+
+	for _, subject := range [][2]string{{loginSubjectUser, userID}, {loginSubjectIP, clientIP}} {
+		failures, err := s.Storage.LoginFailuresStorage.Get(subject[0], subject[1])
+		if err != nil {
+			return fmt.Errorf("database error")
+		}
+		if failures == nil {
+			continue
+		}
+
+		if failures.LockedUntil != nil && now.Before(*failures.LockedUntil) {
+			return fmt.Errorf("too many failed login attempts, try again in %s", failures.LockedUntil.Sub(now).Round(time.Second))
+		}
+
+		// Backoff only applies per account; clients behind a shared IP
+		// should not slow each other down before the IP lockout.
+		if subject[0] == loginSubjectUser {
+			wait := s.LoginThrottle.Backoff(failures.Failures) - now.Sub(failures.LastFailedAt)
+			if wait > 0 {
+				return fmt.Errorf("too many failed login attempts, try again in %s", wait.Round(time.Second))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *APIServer) recordLoginFailure(jobID string, userID string, clientIP string) {
+	subjects := []struct {
+		subjectType string
+		subject     string
+		maxAttempts int
+	}{
+		{loginSubjectUser, userID, s.LoginThrottle.MaxAttempts},
+		{loginSubjectIP, clientIP, s.LoginThrottle.MaxAttemptsPerIP},
+	}
+
+	for _, subject := range subjects {
+		failures, err := s.Storage.LoginFailuresStorage.RecordFailure(subject.subjectType, subject.subject, subject.maxAttempts, s.LoginThrottle.LockoutDuration)
+		if err != nil {
+			AppLog("JobID ", jobID, " failed to record login failure: ", err.Error())
+			continue
+		}
+		if failures != nil && failures.LockedUntil != nil && failures.Failures >= subject.maxAttempts {
+			AppLog(fmt.Sprintf("JobID %s login locked for %s %q after %d failures until %s", jobID, subject.subjectType, subject.subject, failures.Failures, failures.LockedUntil.Format(time.RFC3339)))
+		}
+	}
+}
@@ -14,6 +14,13 @@ func main() {
 		log.Fatalf("Error loading .env file")
 	}
 	SERVER_PORT := os.Getenv("SERVER_PORT")
+	LoadRedactor()
+	if err := LoadKeyRing(); err != nil {
+		log.Fatal("Error loading JWT keys:", err)
+	}
+	if err := LoadAuditSigner(); err != nil {
+		log.Fatal("Error loading audit signing key:", err)
+	}
 	mysql, err := NewMysql()
 	if err != nil {
 		log.Fatal("Error creating MySQL connection:", err)
@@ -32,21 +39,75 @@ func main() {
 	budgetDetailPostRecStorage := NewBudgetDetailPostRecStorage(mysql.db)
 
 	primaryKeyIDStorage := NewPrimaryKeyIDStorage(mysql.db)
+	unitsStorage := NewUnitsStorage(mysql.db)
+	tokensStorage := NewTokensStorage(mysql.db)
+	apiKeysStorage := NewApiKeysStorage(mysql.db)
+	loginFailuresStorage := NewLoginFailuresStorage(mysql.db)
+	identitiesStorage := NewIdentitiesStorage(mysql.db)
+	twoFactorStorage := NewTwoFactorStorage(mysql.db)
+	rolesStorage := NewRolesStorage(mysql.db)
+	auditLogsStorage := NewAuditLogsStorage(mysql.db)
+	statusTransitionsStorage := NewStatusTransitionsStorage(mysql.db)
+	approvalMatrixStorage := NewApprovalMatrixStorage(mysql.db)
+	fundRequestApprovalsStorage := NewFundRequestApprovalsStorage(mysql.db)
+	consolidationPoliciesStorage := NewConsolidationPoliciesStorage(mysql.db)
+	recommendationConsolidationsStorage := NewRecommendationConsolidationsStorage(mysql.db)
+	fiscalPeriodsStorage := NewFiscalPeriodsStorage(mysql.db)
+	budgetRevisionsStorage := NewBudgetRevisionsStorage(mysql.db)
+	budgetReallocationsStorage := NewBudgetReallocationsStorage(mysql.db)
+	ledgerStorage := NewLedgerStorage(mysql.db)
+	fundRequestSettlementsStorage := NewFundRequestSettlementsStorage(mysql.db)
 
 	storage := &Storage{
-		ActivitiesStorage:          activitiesStorage,
-		UsersStorage:               usersStorage,
-		BudgetPostsStorage:         budgetPostsStorage,
-		BudgetCapsStorage:          budgetCapsStorage,
-		BudgetsStorage:             budgetsStorage,
-		BudgetDetailsStorage:       budgetDetailsStorage,
-		BudgetDetailsPostsStorage:  budgetDetailsPostsStorage,
-		FundRequestsStorage:        fundRequestsStorage,
-		FundRequestDetailsStorage:  fundRequestDetailsStorage,
-		BudgetDetailPostRecStorage: budgetDetailPostRecStorage,
-		PrimaryKeyIDStorage:        primaryKeyIDStorage,
+		ActivitiesStorage:                   activitiesStorage,
+		UsersStorage:                        usersStorage,
+		BudgetPostsStorage:                  budgetPostsStorage,
+		BudgetCapsStorage:                   budgetCapsStorage,
+		BudgetsStorage:                      budgetsStorage,
+		BudgetDetailsStorage:                budgetDetailsStorage,
+		BudgetDetailsPostsStorage:           budgetDetailsPostsStorage,
+		FundRequestsStorage:                 fundRequestsStorage,
+		FundRequestDetailsStorage:           fundRequestDetailsStorage,
+		BudgetDetailPostRecStorage:          budgetDetailPostRecStorage,
+		PrimaryKeyIDStorage:                 primaryKeyIDStorage,
+		UnitsStorage:                        unitsStorage,
+		TokensStorage:                       tokensStorage,
+		ApiKeysStorage:                      apiKeysStorage,
+		LoginFailuresStorage:                loginFailuresStorage,
+		IdentitiesStorage:                   identitiesStorage,
+		TwoFactorStorage:                    twoFactorStorage,
+		RolesStorage:                        rolesStorage,
+		AuditLogsStorage:                    auditLogsStorage,
+		StatusTransitionsStorage:            statusTransitionsStorage,
+		ApprovalMatrixStorage:               approvalMatrixStorage,
+		FundRequestApprovalsStorage:         fundRequestApprovalsStorage,
+		ConsolidationPoliciesStorage:        consolidationPoliciesStorage,
+		RecommendationConsolidationsStorage: recommendationConsolidationsStorage,
+		FiscalPeriodsStorage:                fiscalPeriodsStorage,
+		BudgetRevisionsStorage:              budgetRevisionsStorage,
+		BudgetReallocationsStorage:          budgetReallocationsStorage,
+		LedgerStorage:                       ledgerStorage,
+		FundRequestSettlementsStorage:       fundRequestSettlementsStorage,
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		os.Exit(runVerifyAudit(storage.AuditLogsStorage))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "recalculate-usage" {
+		os.Exit(runRecalculateUsage(storage.BudgetDetailsPostsStorage))
 	}
+	if len(os.Args) > 1 && os.Args[1] == "backfill-ledger" {
+		os.Exit(runBackfillLedger(storage.LedgerStorage))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "recalculate-totals" {
+		os.Exit(runRecalculateTotals(storage.BudgetDetailsStorage))
+	}
+
 	AppLog("service run on port ", SERVER_PORT)
-	server := NewAPIServer(SERVER_PORT, storage)
+	rateLimiter, err := NewRateLimiter(NewMemoryRateLimitStore())
+	if err != nil {
+		log.Fatal("Error configuring rate limiter:", err)
+	}
+	server := NewAPIServer(SERVER_PORT, storage, rateLimiter)
 	server.Run()
 }
@@ -2,8 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
 )
 
 // Authenticate middleware to check JWT in the header
@@ -12,10 +19,27 @@ func (s *APIServer) Authenticate(next http.Handler) http.Handler {
 		// Get the token from the Authorization header
 		_, requestLog, _ := s.prepareRequest(r)
 		jobID := r.Header.Get("jobID")
+
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			claims, err := s.authenticateAPIKey(r, apiKey)
+			if err != nil {
+				AppLogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": err.Error()})
+				WriteJSON(w, http.StatusBadRequest, APIError{
+					Status:  "error",
+					JobID:   jobID,
+					Message: err.Error(),
+				})
+				return
+			}
+			ctx := context.WithValue(r.Context(), userContextKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		tokenString := r.Header.Get("Authorization")
 		if tokenString == "" {
 
-			AppLog(LogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": "Authorization required"}))
+			AppLogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": "Authorization required"})
 			WriteJSON(w, http.StatusBadRequest, APIError{
 				Status:  "error",
 				JobID:   jobID,
@@ -31,7 +55,7 @@ func (s *APIServer) Authenticate(next http.Handler) http.Handler {
 		token, err := validateJWT(tokenString)
 		if err != nil {
 
-			AppLog(LogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": "invalid token" + err.Error()}))
+			AppLogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": "invalid token" + err.Error()})
 			WriteJSON(w, http.StatusBadRequest, APIError{
 				Status:  "error",
 				JobID:   jobID,
@@ -42,7 +66,7 @@ func (s *APIServer) Authenticate(next http.Handler) http.Handler {
 
 		// Check if token is valid
 		if !token.Valid {
-			AppLog(LogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": "invalid token"}))
+			AppLogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": "invalid token"})
 			WriteJSON(w, http.StatusBadRequest, APIError{
 				Status:  "error",
 				JobID:   jobID,
@@ -53,8 +77,8 @@ func (s *APIServer) Authenticate(next http.Handler) http.Handler {
 
 		// Set the token claims in the request context
 		claims, ok := token.Claims.(*UserClaims)
-		if !ok || !token.Valid {
-			AppLog(LogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": "Invalid token claims"}))
+		if !ok || !token.Valid || claims.Purpose != "" {
+			AppLogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": "Invalid token claims"})
 			WriteJSON(w, http.StatusBadRequest, APIError{
 				Status:  "error",
 				JobID:   jobID,
@@ -63,9 +87,144 @@ func (s *APIServer) Authenticate(next http.Handler) http.Handler {
 
 			return
 		}
+		if err := s.checkTokenRevocation(claims); err != nil {
+			AppLogRequestResponse(requestLog, map[string]interface{}{"status": "error", "message": err.Error()})
+			WriteJSON(w, http.StatusBadRequest, APIError{
+				Status:  "error",
+				JobID:   jobID,
+				Message: err.Error(),
+			})
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), userContextKey, claims)
 		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// checkTokenRevocation rejects tokens that were logged out, issued before the
+// user's last password change, or that belong to a deactivated account.
+func (s *APIServer) checkTokenRevocation(claims *UserClaims) error {
+	usersID, err := claims.UsersID()
+	if err != nil || claims.ID == "" || claims.IssuedAt == nil {
+		return fmt.Errorf("Invalid token claims")
+	}
+
+	revoked, err := s.Storage.TokensStorage.IsAccessTokenRevoked(claims.ID)
+	if err != nil {
+		return fmt.Errorf("database error")
+	}
+	if revoked {
+		return fmt.Errorf("Token revoked")
+	}
+
+	user, err := s.Storage.UsersStorage.GetById(usersID)
+	if err != nil {
+		return fmt.Errorf("database error")
+	}
+	if user == nil || !user.IsActive {
+		return fmt.Errorf("User is inactive")
+	}
+	// Both times are whole seconds, so a token issued in the second of the
+	// password change, like the one issued right after it, is accepted.
+	if user.TokensValidAfter != nil && claims.IssuedAt.Time.Before(*user.TokensValidAfter) {
+		return fmt.Errorf("Token revoked")
+	}
+
+	return nil
+}
+
+// verifyAPIKey returns the stored API key that key is the secret of, or nil
+// when there is none. Revocation, expiry and scopes are not checked.
+func (s *APIServer) verifyAPIKey(key string) (*ApiKeys, error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return nil, nil
+	}
+
+	apiKey, err := s.Storage.ApiKeysStorage.GetByPrefix(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil || subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashToken(key))) != 1 {
+		return nil, nil
+	}
+	return apiKey, nil
+}
+
+// authenticateAPIKey accepts keys of the form bk_<prefix>_<secret>. The key
+// acts as its service user, without roles, limited to its scopes.
+func (s *APIServer) authenticateAPIKey(r *http.Request, key string) (*UserClaims, error) {
+	apiKey, err := s.verifyAPIKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("database error")
+	}
+	if apiKey == nil {
+		return nil, fmt.Errorf("Invalid API key")
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, fmt.Errorf("API key revoked")
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return nil, fmt.Errorf("API key expired")
+	}
+	if !apiKeyAllows(apiKey.Scopes, r) {
+		return nil, fmt.Errorf("API key scope not permitted")
+	}
+
+	user, err := s.Storage.UsersStorage.GetById(apiKey.UsersID)
+	if err != nil {
+		return nil, fmt.Errorf("database error")
+	}
+	if user == nil || !user.IsActive {
+		return nil, fmt.Errorf("User is inactive")
+	}
+
+	if err := s.Storage.ApiKeysStorage.TouchLastUsed(apiKey.ID); err != nil {
+		AppLog("failed to update api key usage: ", err.Error())
+	}
+
+	return &UserClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.FormatInt(apiKey.UsersID, 10),
+		},
+		APIKeyID: apiKey.ID,
+		Scopes:   apiKey.Scopes,
+	}, nil
+}
+
+// apiKeyAllows maps the request to "<first path segment>:read" for GET and
+// "<first path segment>:write" otherwise, and checks it against the scopes.
+func apiKeyAllows(scopes []string, r *http.Request) bool {
+	resource := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	access := "write"
+	if r.Method == http.MethodGet {
+		access = "read"
+	}
+
+	for _, scope := range scopes {
+		if scope == "*" || scope == resource+":*" || scope == resource+":"+access {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *APIServer) RequireRole(roles ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := s.GetUserClaims(r)
+			if err != nil || !claims.HasRole(roles...) {
+				WriteJSON(w, http.StatusBadRequest, APIError{
+					Status:  "error",
+					JobID:   r.Header.Get("jobID"),
+					Message: "Access denied",
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type fakeTokensStorage struct {
+	TokensStorage
+	revoked map[string]bool
+}
+
+func (f *fakeTokensStorage) IsAccessTokenRevoked(jti string) (bool, error) {
+	return f.revoked[jti], nil
+}
+
+func (f *fakeUsersStorage) GetById(id int64) (*Users, error) {
+	for _, user := range f.users {
+		if int64(user.ID) == id {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func TestCheckTokenRevocation(t *testing.T) {
+	// The password was changed at 12:00:00.700; tokens_valid_after keeps the
+	// whole second and iat never has a fraction.
+	changedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		jti        string
+		issuedAt   time.Time
+		validAfter *time.Time
+		active     bool
+		wantErr    bool
+	}{
+		{name: "no password change", jti: "a", issuedAt: changedAt.Add(-time.Hour), active: true},
+		{name: "issued before the change", jti: "b", issuedAt: changedAt.Add(-time.Second), validAfter: &changedAt, active: true, wantErr: true},
+		{name: "issued in the second of the change", jti: "c", issuedAt: changedAt, validAfter: &changedAt, active: true},
+		{name: "issued after the change", jti: "d", issuedAt: changedAt.Add(time.Second), validAfter: &changedAt, active: true},
+		{name: "logged out", jti: "revoked", issuedAt: changedAt.Add(time.Second), validAfter: &changedAt, active: true, wantErr: true},
+		{name: "deactivated user", jti: "e", issuedAt: changedAt.Add(time.Second), active: false, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &APIServer{
+				Storage: Storage{
+					TokensStorage: &fakeTokensStorage{revoked: map[string]bool{"revoked": true}},
+					UsersStorage: &fakeUsersStorage{users: map[string]*Users{
+						"alice": {ID: 7, UserID: "alice", IsActive: tt.active, TokensValidAfter: tt.validAfter},
+					}},
+				},
+			}
+			claims := &UserClaims{RegisteredClaims: jwt.RegisteredClaims{
+				ID:       tt.jti,
+				Subject:  "7",
+				IssuedAt: jwt.NewNumericDate(tt.issuedAt),
+			}}
+
+			err := s.checkTokenRevocation(claims)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkTokenRevocation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+-- Units form a tree; a user may be attached to several units and sees the
+-- budgets of those units and every unit below them.
+ALTER TABLE units ADD COLUMN parent_id BIGINT NULL;
+ALTER TABLE units ADD CONSTRAINT fk_units_parent FOREIGN KEY (parent_id) REFERENCES units (id);
+
+CREATE TABLE user_units (
+    users_id BIGINT NOT NULL,
+    units_id BIGINT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (users_id, units_id),
+    KEY idx_user_units_units (units_id)
+);
+
+-- Fund requests are scoped through the budget they draw from. Existing
+-- requests take the budget of their detail lines, or failing that the only
+-- budget that plans their budget post. Requests that can't be mapped stay
+-- NULL and make the NOT NULL change below fail, so they must be fixed by
+-- hand before the migration is re-run.
+ALTER TABLE fund_requests ADD COLUMN budgets_id BIGINT NULL AFTER id;
+
+UPDATE fund_requests fr
+JOIN (
+    SELECT frd.fund_requests_id, MIN(bd.budgets_id) AS budgets_id
+    FROM fund_request_details frd
+    JOIN budget_details bd ON bd.id = frd.budget_details_id
+    GROUP BY frd.fund_requests_id
+    HAVING COUNT(DISTINCT bd.budgets_id) = 1
+) m ON m.fund_requests_id = fr.id
+SET fr.budgets_id = m.budgets_id
+WHERE fr.budgets_id IS NULL;
+
+UPDATE fund_requests fr
+JOIN (
+    SELECT bdp.budget_posts_id, MIN(bd.budgets_id) AS budgets_id
+    FROM budget_details_posts bdp
+    JOIN budget_details bd ON bd.id = bdp.budget_details_id
+    GROUP BY bdp.budget_posts_id
+    HAVING COUNT(DISTINCT bd.budgets_id) = 1
+) m ON m.budget_posts_id = fr.budget_posts_id
+SET fr.budgets_id = m.budgets_id
+WHERE fr.budgets_id IS NULL;
+
+ALTER TABLE fund_requests MODIFY COLUMN budgets_id BIGINT NOT NULL;
+ALTER TABLE fund_requests ADD KEY idx_fund_requests_budgets (budgets_id);
@@ -0,0 +1,23 @@
+-- Account state used by Authenticate to revoke tokens that were issued
+-- before a password change or while the account is deactivated.
+ALTER TABLE users ADD COLUMN is_active TINYINT(1) NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN tokens_valid_after DATETIME NULL;
+
+CREATE TABLE refresh_tokens (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    users_id BIGINT NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME NULL,
+    replaced_by BIGINT NULL,
+    created_at DATETIME NOT NULL,
+    UNIQUE KEY uq_refresh_tokens_hash (token_hash),
+    KEY idx_refresh_tokens_users (users_id)
+);
+
+-- Access tokens revoked before their expiry (logout). Rows can be purged
+-- once expires_at has passed.
+CREATE TABLE revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at DATETIME NOT NULL
+);
@@ -0,0 +1,14 @@
+CREATE TABLE roles (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_roles_name (name)
+);
+
+CREATE TABLE user_roles (
+    users_id BIGINT NOT NULL,
+    roles_id BIGINT NOT NULL,
+    PRIMARY KEY (users_id, roles_id)
+);
+
+INSERT INTO roles (name) VALUES ('admin');
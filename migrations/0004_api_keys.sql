@@ -0,0 +1,17 @@
+-- API keys act on behalf of a (service) user and are limited to their scopes.
+-- Only a SHA-256 hash of the key is stored; key_prefix is the public part used
+-- for lookup.
+CREATE TABLE api_keys (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    users_id BIGINT NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    scopes VARCHAR(1024) NOT NULL,
+    expires_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    last_used_at DATETIME NULL,
+    created_by BIGINT NOT NULL,
+    created_at DATETIME NOT NULL,
+    UNIQUE KEY uq_api_keys_prefix (key_prefix)
+);
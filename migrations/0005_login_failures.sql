@@ -0,0 +1,10 @@
+-- Failed login attempts, tracked separately per submitted user id and per
+-- client IP.
+CREATE TABLE login_failures (
+    subject_type VARCHAR(8) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    failures INT NOT NULL,
+    last_failed_at DATETIME NOT NULL,
+    locked_until DATETIME NULL,
+    PRIMARY KEY (subject_type, subject)
+);
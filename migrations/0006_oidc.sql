@@ -0,0 +1,24 @@
+-- Links identity provider subjects to local users.
+CREATE TABLE user_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    users_id BIGINT NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_login_at DATETIME NULL,
+    PRIMARY KEY (issuer, subject),
+    KEY idx_user_identities_users (users_id)
+);
+
+-- Identity provider groups granting local roles and/or units.
+CREATE TABLE oidc_group_mappings (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    group_name VARCHAR(255) NOT NULL,
+    roles_id BIGINT NULL,
+    units_id BIGINT NULL,
+    KEY idx_oidc_group_mappings_group (group_name)
+);
+
+-- Assignments granted through group mappings are re-synced on every SSO
+-- login; locally managed assignments are left alone.
+ALTER TABLE user_roles ADD COLUMN source VARCHAR(16) NOT NULL DEFAULT 'local';
+ALTER TABLE user_units ADD COLUMN source VARCHAR(16) NOT NULL DEFAULT 'local';
@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NULL;
+ALTER TABLE users ADD COLUMN totp_enabled TINYINT(1) NOT NULL DEFAULT 0;
+-- Last accepted time step, so a code cannot be replayed.
+ALTER TABLE users ADD COLUMN totp_last_counter BIGINT NULL;
+
+CREATE TABLE user_recovery_codes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    users_id BIGINT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    KEY idx_user_recovery_codes_users (users_id)
+);
+
+-- Members of roles with require_2fa must enroll before they get a token.
+ALTER TABLE roles ADD COLUMN require_2fa TINYINT(1) NOT NULL DEFAULT 0;
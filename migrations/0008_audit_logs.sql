@@ -0,0 +1,17 @@
+CREATE TABLE audit_logs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    users_id BIGINT NULL,
+    api_keys_id BIGINT NULL,
+    job_id VARCHAR(64) NOT NULL,
+    entity VARCHAR(64) NOT NULL,
+    entity_id BIGINT NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    -- Field level diff: {"field": {"before": ..., "after": ...}}
+    changes JSON NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    KEY idx_audit_logs_entity (entity, entity_id),
+    KEY idx_audit_logs_users (users_id),
+    KEY idx_audit_logs_created_at (created_at)
+);
+
+INSERT INTO roles (name) VALUES ('auditor');
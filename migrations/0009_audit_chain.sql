@@ -0,0 +1,15 @@
+-- Rows written before this migration keep an empty hash and are reported as
+-- unchained by verify-audit; the chain starts with the first sealed row.
+ALTER TABLE audit_logs ADD COLUMN prev_hash CHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE audit_logs ADD COLUMN hash CHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE audit_logs ADD COLUMN signature VARCHAR(128) NOT NULL DEFAULT '';
+
+-- Single row locked while appending, so concurrent writers cannot fork the
+-- chain, and compared on verification to detect a truncated tail.
+CREATE TABLE audit_chain_head (
+    id TINYINT PRIMARY KEY,
+    last_id BIGINT NOT NULL,
+    last_hash CHAR(64) NOT NULL
+);
+
+INSERT INTO audit_chain_head (id, last_id, last_hash) VALUES (1, 0, '');
@@ -0,0 +1,19 @@
+ALTER TABLE budgets ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'draft';
+UPDATE budgets SET status = 'approved' WHERE is_approved = 1;
+ALTER TABLE budgets DROP COLUMN is_approved;
+
+-- History of workflow transitions, shared by every entity with a status.
+CREATE TABLE status_transitions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    entity VARCHAR(64) NOT NULL,
+    entity_id BIGINT NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    from_status VARCHAR(32) NOT NULL,
+    to_status VARCHAR(32) NOT NULL,
+    comment TEXT NOT NULL,
+    users_id BIGINT NULL,
+    created_at DATETIME NOT NULL,
+    KEY idx_status_transitions_entity (entity, entity_id)
+);
+
+INSERT INTO roles (name) VALUES ('budget_reviewer'), ('budget_approver');
@@ -0,0 +1,6 @@
+-- Statuses used to be free text; anything unknown starts over as draft.
+UPDATE fund_requests SET status = 'draft'
+WHERE status NOT IN ('draft', 'submitted', 'verified', 'approved', 'disbursed', 'settled', 'rejected', 'cancelled');
+ALTER TABLE fund_requests MODIFY status VARCHAR(32) NOT NULL DEFAULT 'draft';
+
+INSERT INTO roles (name) VALUES ('fund_verifier'), ('fund_approver'), ('treasurer');
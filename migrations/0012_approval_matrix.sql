@@ -0,0 +1,27 @@
+-- Ordered approver levels per unit, budget post and amount band. NULL unit or
+-- post matches any; the most specific matching rule set is used.
+CREATE TABLE approval_matrix (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    units_id BIGINT NULL,
+    budget_posts_id BIGINT NULL,
+    min_amount DECIMAL(18,2) NOT NULL DEFAULT 0,
+    max_amount DECIMAL(18,2) NULL,
+    level INT NOT NULL,
+    approver_role VARCHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    KEY idx_approval_matrix_scope (units_id, budget_posts_id)
+);
+
+CREATE TABLE fund_request_approvals (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    fund_requests_id BIGINT NOT NULL,
+    level INT NOT NULL,
+    approver_role VARCHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    users_id BIGINT NULL,
+    comment TEXT NULL,
+    decided_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    KEY idx_fund_request_approvals_request (fund_requests_id, level)
+);
@@ -0,0 +1,4 @@
+-- Totals are computed by the server as quantity * unit_value * terms,
+-- rounded to two decimals. SQL ROUND on DOUBLE can round .5 boundaries
+-- differently from the server, so stored rows are brought in line by
+-- `make recalculate-totals` after migrating instead of here.
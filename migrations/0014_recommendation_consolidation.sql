@@ -0,0 +1,33 @@
+-- How the recommendations of several user groups turn into a detail post's
+-- approved amount. Groups listed on a policy restrict it to those groups;
+-- weight is used by 'weighted', rank by 'final_authority' (highest wins).
+CREATE TABLE consolidation_policies (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(128) NOT NULL,
+    method VARCHAR(32) NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE consolidation_policy_groups (
+    consolidation_policies_id BIGINT NOT NULL,
+    user_groups_id BIGINT NOT NULL,
+    weight DECIMAL(10,4) NOT NULL DEFAULT 1,
+    `rank` INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (consolidation_policies_id, user_groups_id)
+);
+
+-- One row per applied consolidation; sources lists every recommendation that
+-- was considered and whether it produced the result.
+CREATE TABLE recommendation_consolidations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    budget_details_posts_id BIGINT NOT NULL,
+    consolidation_policies_id BIGINT NOT NULL,
+    method VARCHAR(32) NOT NULL,
+    approved_amount DECIMAL(18,2) NOT NULL,
+    sources JSON NOT NULL,
+    users_id BIGINT NULL,
+    created_at DATETIME NOT NULL,
+    KEY idx_recommendation_consolidations_post (budget_details_posts_id)
+);
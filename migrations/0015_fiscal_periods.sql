@@ -0,0 +1,33 @@
+-- Fiscal periods replace the free-text budgets.periode. A 'closing' period
+-- takes no new budgets or fund requests; a 'closed' one is read-only.
+CREATE TABLE fiscal_periods (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(32) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'open',
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    UNIQUE KEY uq_fiscal_periods_code (code)
+);
+
+-- Every periode that names a year ("2026", "FY2026", "2026-01") becomes that
+-- calendar year. Budgets whose periode has no year keep a NULL period, with
+-- the original text in legacy_periode, and must be assigned one before they
+-- can be edited.
+INSERT INTO fiscal_periods (code, name, start_date, end_date, status, created_at, updated_at)
+SELECT DISTINCT CONCAT('FY', y), CONCAT('Fiscal year ', y), CONCAT(y, '-01-01'), CONCAT(y, '-12-31'), 'open', now(), now()
+FROM (SELECT REGEXP_SUBSTR(periode, '[0-9]{4}') AS y FROM budgets) years
+WHERE y IS NOT NULL;
+
+ALTER TABLE budgets ADD COLUMN fiscal_periods_id BIGINT NULL AFTER description,
+    ADD COLUMN legacy_periode VARCHAR(255) NULL AFTER fiscal_periods_id,
+    ADD KEY idx_budgets_fiscal_periods (fiscal_periods_id);
+
+UPDATE budgets b JOIN fiscal_periods fp ON fp.code = CONCAT('FY', REGEXP_SUBSTR(b.periode, '[0-9]{4}'))
+SET b.fiscal_periods_id = fp.id;
+
+UPDATE budgets SET legacy_periode = periode WHERE fiscal_periods_id IS NULL;
+
+ALTER TABLE budgets DROP COLUMN periode;
@@ -0,0 +1,12 @@
+-- Numbered snapshots of a budget with its details, detail posts and caps.
+-- A revision is taken on every approval and on request.
+CREATE TABLE budget_revisions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    budgets_id BIGINT NOT NULL,
+    revision INT NOT NULL,
+    note TEXT NULL,
+    snapshot JSON NOT NULL,
+    users_id BIGINT NULL,
+    created_at DATETIME NOT NULL,
+    UNIQUE KEY uq_budget_revisions (budgets_id, revision)
+);
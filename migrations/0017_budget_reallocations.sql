@@ -0,0 +1,16 @@
+-- Transfers of cap between two budget posts of one budget. Caps only change
+-- when a transfer is approved; the status history lives in
+-- status_transitions under entity 'budget-reallocations'.
+CREATE TABLE budget_reallocations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    budgets_id BIGINT NOT NULL,
+    source_budget_posts_id BIGINT NOT NULL,
+    target_budget_posts_id BIGINT NOT NULL,
+    amount DECIMAL(18,2) NOT NULL,
+    justification TEXT NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'submitted',
+    users_id BIGINT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    KEY idx_budget_reallocations_budget (budgets_id)
+);
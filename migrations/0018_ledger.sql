@@ -0,0 +1,37 @@
+-- Double-entry journal of budget money movements. Every entry's lines sum to
+-- zero (debit minus credit); accounts are identified by account_type plus the
+-- budget, budget post and activity they belong to (0 when not applicable).
+CREATE TABLE journal_entries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    entry_type VARCHAR(32) NOT NULL,
+    budgets_id BIGINT NOT NULL,
+    entity VARCHAR(64) NOT NULL,
+    entity_id BIGINT NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL,
+    KEY idx_journal_entries_budget (budgets_id),
+    KEY idx_journal_entries_entity (entity, entity_id)
+);
+
+CREATE TABLE journal_lines (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    journal_entries_id BIGINT NOT NULL,
+    account_type VARCHAR(32) NOT NULL,
+    budgets_id BIGINT NOT NULL,
+    budget_posts_id BIGINT NOT NULL DEFAULT 0,
+    activities_id BIGINT NOT NULL DEFAULT 0,
+    debit DECIMAL(18,2) NOT NULL DEFAULT 0,
+    credit DECIMAL(18,2) NOT NULL DEFAULT 0,
+    KEY idx_journal_lines_entry (journal_entries_id),
+    KEY idx_journal_lines_account (budgets_id, budget_posts_id, account_type)
+);
+
+-- The journal is append-only; corrections are posted as new entries.
+CREATE TRIGGER journal_entries_no_update BEFORE UPDATE ON journal_entries
+    FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'journal_entries is append-only';
+CREATE TRIGGER journal_entries_no_delete BEFORE DELETE ON journal_entries
+    FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'journal_entries is append-only';
+CREATE TRIGGER journal_lines_no_update BEFORE UPDATE ON journal_lines
+    FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'journal_lines is append-only';
+CREATE TRIGGER journal_lines_no_delete BEFORE DELETE ON journal_lines
+    FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'journal_lines is append-only';
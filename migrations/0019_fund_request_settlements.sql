@@ -0,0 +1,26 @@
+-- Realization of a disbursed fund request: what was actually spent per fund
+-- request detail, and the refund or extra payment that follows from it.
+CREATE TABLE fund_request_settlements (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    fund_requests_id BIGINT NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'draft',
+    advance_amount DECIMAL(18,2) NOT NULL,
+    actual_amount DECIMAL(18,2) NOT NULL,
+    variance DECIMAL(18,2) NOT NULL,
+    refund_amount DECIMAL(18,2) NOT NULL DEFAULT 0,
+    extra_payment_amount DECIMAL(18,2) NOT NULL DEFAULT 0,
+    note TEXT NULL,
+    users_id BIGINT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    UNIQUE KEY uq_fund_request_settlements (fund_requests_id)
+);
+
+CREATE TABLE fund_request_settlement_lines (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    fund_request_settlements_id BIGINT NOT NULL,
+    fund_request_details_id BIGINT NOT NULL,
+    amount DECIMAL(18,2) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    KEY idx_fund_request_settlement_lines (fund_request_settlements_id)
+);
@@ -0,0 +1,4 @@
+-- Identity links are managed by admins (or by the user while logged in)
+-- instead of being created on first SSO login; give them an id to address
+-- them by.
+ALTER TABLE user_identities ADD COLUMN id BIGINT NOT NULL AUTO_INCREMENT UNIQUE FIRST;
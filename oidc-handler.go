@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func (s *APIServer) UserOIDCLogin(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	if s.OIDC == nil {
+		return respondWithError(requestLog, "single sign-on is not configured", nil)
+	}
+
+	authorizationURL, err := s.OIDC.AuthorizationURL()
+	if err != nil {
+		return respondWithError(requestLog, "identity provider unavailable", err)
+	}
+
+	return respondWithSuccessStruct(requestLog, map[string]interface{}{
+		"status":            "success",
+		"authorization_url": authorizationURL,
+	})
+}
+
+// exchangeOIDCCode reads code and state from the query string or JSON body
+// and redeems them at the identity provider.
+func (s *APIServer) exchangeOIDCCode(r *http.Request, bodyBytes []byte) (*OIDCIdentity, string, error) {
+	reqBody := &OIDCCallbackRequest{
+		Code:  r.URL.Query().Get("code"),
+		State: r.URL.Query().Get("state"),
+	}
+	if reqBody.Code == "" && len(bodyBytes) > 0 {
+		if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+			return nil, "failed to decode request body", err
+		}
+	}
+	if errorCode := r.URL.Query().Get("error"); errorCode != "" {
+		message := "identity provider error: " + errorCode
+		return nil, message, fmt.Errorf("%s", message)
+	}
+	if reqBody.Code == "" || reqBody.State == "" {
+		return nil, "code and state must be filled", fmt.Errorf("code and state must be filled")
+	}
+
+	identity, err := s.OIDC.Exchange(reqBody.Code, reqBody.State)
+	if err != nil {
+		return nil, "single sign-on failed", err
+	}
+	return identity, "ok", nil
+}
+
+func (s *APIServer) UserOIDCCallback(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	if s.OIDC == nil {
+		return respondWithError(requestLog, "single sign-on is not configured", nil)
+	}
+
+	identity, message, err := s.exchangeOIDCCode(r, bodyBytes)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	usersID, err := s.Storage.IdentitiesStorage.GetUserID(identity.Issuer, identity.Subject)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	if usersID == 0 {
+		// Identities are only linked by an admin or by the user while logged
+		// in, never by matching claims the identity provider lets users edit.
+		return respondWithError(requestLog, "no local account linked to this identity", nil)
+	}
+
+	user, err := s.Storage.UsersStorage.GetById(usersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if user == nil || !user.IsActive {
+		return respondWithError(requestLog, "user is inactive", nil)
+	}
+
+	if err := s.Storage.IdentitiesStorage.SyncGroups(usersID, identity.Groups); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	result, err := s.completeLogin(user)
+	if err != nil {
+		return respondWithError(requestLog, "error creating JWT", err)
+	}
+	return respondWithSuccessStruct(requestLog, result)
+}
+
+// UserOIDCLink links the identity from a completed authorization flow to
+// the logged in user, so the user can sign in with it afterwards.
+func (s *APIServer) UserOIDCLink(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	if s.OIDC == nil {
+		return respondWithError(requestLog, "single sign-on is not configured", nil)
+	}
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	identity, message, err := s.exchangeOIDCCode(r, bodyBytes)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	existing, err := s.Storage.IdentitiesStorage.GetByIdentity(identity.Issuer, identity.Subject)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if existing != nil {
+		if existing.UsersID != usersID {
+			return respondWithError(requestLog, "identity is already linked to another account", nil)
+		}
+		return respondWithSuccess(requestLog, existing)
+	}
+
+	linked, err := s.Storage.IdentitiesStorage.Link(identity.Issuer, identity.Subject, usersID, s.newAudit(r, "user-identities", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, linked)
+
+}
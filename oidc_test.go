@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP is a minimal OpenID provider serving discovery, JWKS and a token
+// endpoint that returns whatever ID token claims the test asks for.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims func() jwt.MapClaims
+
+	// challenge of the last authorization request, checked against the
+	// code_verifier sent to the token endpoint
+	challenge string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JwksURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []JWK{{
+				Kty: "RSA",
+				Kid: "test",
+				Alg: "RS256",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims())
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:      idp.server.URL,
+		ClientID:    "budget-api",
+		RedirectURL: "http://localhost/callback",
+		Scopes:      "openid",
+		GroupsClaim: "groups",
+		httpClient:  idp.server.Client(),
+		keys:        map[string]interface{}{},
+		pending:     map[string]*oidcPendingLogin{},
+	}
+}
+
+// startLogin runs the authorization request and returns the state and nonce
+// the provider would have received.
+func (idp *mockIdP) startLogin(t *testing.T, provider *OIDCProvider) (string, string) {
+	t.Helper()
+	authorizationURL, err := provider.AuthorizationURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := parsed.Query()
+	idp.challenge = params.Get("code_challenge")
+	return params.Get("state"), params.Get("nonce")
+}
+
+func TestOIDCExchange(t *testing.T) {
+	validClaims := func(idp *mockIdP, nonce string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":                idp.server.URL,
+			"aud":                "budget-api",
+			"sub":                "subject-1",
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"nonce":              nonce,
+			"groups":             []string{"finance"},
+			"preferred_username": "admin",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		state   func(state string) string
+		claims  func(claims jwt.MapClaims)
+		wantErr bool
+	}{
+		{name: "valid", code: "good-code"},
+		{name: "unknown state", code: "good-code", state: func(string) string { return "other" }, wantErr: true},
+		{name: "rejected code", code: "bad-code", wantErr: true},
+		{name: "wrong nonce", code: "good-code", claims: func(c jwt.MapClaims) { c["nonce"] = "other" }, wantErr: true},
+		{name: "wrong audience", code: "good-code", claims: func(c jwt.MapClaims) { c["aud"] = "other-client" }, wantErr: true},
+		{name: "wrong issuer", code: "good-code", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, wantErr: true},
+		{name: "expired", code: "good-code", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, wantErr: true},
+		{name: "no subject", code: "good-code", claims: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			provider := idp.provider()
+			state, nonce := idp.startLogin(t, provider)
+			idp.claims = func() jwt.MapClaims {
+				claims := validClaims(idp, nonce)
+				if tt.claims != nil {
+					tt.claims(claims)
+				}
+				return claims
+			}
+			if tt.state != nil {
+				state = tt.state(state)
+			}
+
+			identity, err := provider.Exchange(tt.code, state)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Exchange() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if identity.Issuer != idp.server.URL || identity.Subject != "subject-1" {
+				t.Errorf("identity = %s/%s, want %s/subject-1", identity.Issuer, identity.Subject, idp.server.URL)
+			}
+			if len(identity.Groups) != 1 || identity.Groups[0] != "finance" {
+				t.Errorf("groups = %v, want [finance]", identity.Groups)
+			}
+
+			// A state can only be redeemed once.
+			if _, err := provider.Exchange(tt.code, state); err == nil {
+				t.Errorf("second Exchange() with the same state succeeded")
+			}
+		})
+	}
+}
+
+type fakeIdentitiesStorage struct {
+	IdentitiesStorage
+	links  map[string]int64
+	linked bool
+}
+
+func (f *fakeIdentitiesStorage) GetUserID(issuer string, subject string) (int64, error) {
+	return f.links[issuer+" "+subject], nil
+}
+
+func (f *fakeIdentitiesStorage) Link(issuer string, subject string, usersID int64, audit *Audit) (*UserIdentities, error) {
+	f.linked = true
+	return &UserIdentities{Issuer: issuer, Subject: subject, UsersID: usersID}, nil
+}
+
+type fakeUsersStorage struct {
+	UsersStorage
+	users map[string]*Users
+}
+
+func (f *fakeUsersStorage) GetByUserID(userID string) (*Users, error) {
+	return f.users[userID], nil
+}
+
+func TestUserOIDCCallbackDoesNotLinkByUsername(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := idp.provider()
+	state, nonce := idp.startLogin(t, provider)
+	idp.claims = func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   idp.server.URL,
+			"aud":   "budget-api",
+			"sub":   "attacker",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": nonce,
+			// Users can usually edit this claim at the identity provider.
+			"preferred_username": "admin",
+		}
+	}
+
+	identities := &fakeIdentitiesStorage{links: map[string]int64{}}
+	s := &APIServer{
+		OIDC: provider,
+		Storage: Storage{
+			IdentitiesStorage: identities,
+			UsersStorage:      &fakeUsersStorage{users: map[string]*Users{"admin": {ID: 1, UserID: "admin", IsActive: true}}},
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/user/oidc/callback?code=good-code&state="+url.QueryEscape(state), nil)
+	result, err := s.UserOIDCCallback(httptest.NewRecorder(), r, nil, map[string]interface{}{})
+	if err == nil {
+		t.Fatalf("UserOIDCCallback() = %v, want error for an unlinked identity", result)
+	}
+	if identities.linked {
+		t.Errorf("UserOIDCCallback() linked an identity by username")
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// RateLimitStore keeps the token buckets. The in-memory store only limits a
+// single instance; a shared implementation (for example Redis) can be plugged
+// in to enforce limits across instances.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	// maxWindow is the longest window any bucket was taken with; buckets idle
+	// for longer are full whatever their group.
+	maxWindow time.Duration
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(limit.Requests)
+	ratePerSecond := capacity / limit.Per.Seconds()
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, lastSeen: now}
+		m.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*ratePerSecond)
+	bucket.lastSeen = now
+
+	result := RateLimitResult{Limit: limit.Requests}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) / ratePerSecond * float64(time.Second))
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration((capacity - bucket.tokens) / ratePerSecond * float64(time.Second))
+
+	if limit.Per > m.maxWindow {
+		m.maxWindow = limit.Per
+	}
+	m.sweep(now, m.maxWindow)
+	return result, nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+func (m *MemoryRateLimitStore) sweep(now time.Time, idle time.Duration) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for key, bucket := range m.buckets {
+		if now.Sub(bucket.lastSeen) > idle {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+type rateLimitGroup struct {
+	prefix string
+	limit  RateLimit
+}
+
+type RateLimiter struct {
+	store        RateLimitStore
+	defaultLimit RateLimit
+	groups       []rateLimitGroup
+}
+
+// NewRateLimiter reads RATE_LIMIT_DEFAULT (e.g. "120/1m") and RATE_LIMIT_GROUPS,
+// a comma separated list of path prefix limits such as
+// "/fund-requests=60/1m,/user=20/1m".
+func NewRateLimiter(store RateLimitStore) (*RateLimiter, error) {
+	limiter := &RateLimiter{
+		store:        store,
+		defaultLimit: RateLimit{Requests: 120, Per: time.Minute},
+	}
+
+	if value := os.Getenv("RATE_LIMIT_DEFAULT"); value != "" {
+		limit, err := parseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_DEFAULT: %w", err)
+		}
+		limiter.defaultLimit = limit
+	}
+
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMIT_GROUPS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_GROUPS entry: %s", entry)
+		}
+		limit, err := parseRateLimit(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_GROUPS entry %s: %w", entry, err)
+		}
+		limiter.groups = append(limiter.groups, rateLimitGroup{prefix: parts[0], limit: limit})
+	}
+
+	// Longest prefix first so the most specific group wins.
+	sort.Slice(limiter.groups, func(i, j int) bool {
+		return len(limiter.groups[i].prefix) > len(limiter.groups[j].prefix)
+	})
+
+	return limiter, nil
+}
+
+func parseRateLimit(value string) (RateLimit, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("expected <requests>/<duration>, got %s", value)
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid request count %s", parts[0])
+	}
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid duration %s", parts[1])
+	}
+	return RateLimit{Requests: requests, Per: per}, nil
+}
+
+func (l *RateLimiter) limitFor(path string) (string, RateLimit) {
+	for _, group := range l.groups {
+		if path == group.prefix || strings.HasPrefix(path, strings.TrimSuffix(group.prefix, "/")+"/") {
+			return group.prefix, group.limit
+		}
+	}
+	return "default", l.defaultLimit
+}
+
+// rateLimitClient identifies the caller: the ID of an API key whose secret
+// matches, the subject of a bearer token with a valid signature, or the client
+// IP for everything else. Made-up API keys fall back to the IP, so they do not
+// get a fresh bucket each.
+func (s *APIServer) rateLimitClient(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		apiKey, err := s.verifyAPIKey(key)
+		if err != nil {
+			AppLog("JobID ", r.Header.Get("jobID"), " rate limit api key lookup error: ", err.Error())
+		} else if apiKey != nil {
+			return "key:" + strconv.FormatInt(apiKey.ID, 10)
+		}
+	}
+
+	if tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); tokenString != "" {
+		if token, err := validateJWT(tokenString); err == nil && token.Valid {
+			if claims, ok := token.Claims.(*UserClaims); ok && claims.Subject != "" {
+				return "user:" + claims.Subject
+			}
+		}
+	}
+
+	return "ip:" + ClientIP(r)
+}
+
+func (s *APIServer) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group, limit := s.RateLimiter.limitFor(r.URL.Path)
+		client := s.rateLimitClient(r)
+
+		result, err := s.RateLimiter.store.Take(group+"|"+client, limit)
+		if err != nil {
+			// Fail open: an unavailable store must not take the API down.
+			AppLog("JobID ", r.Header.Get("jobID"), " rate limit store error: ", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+
+		if !result.Allowed {
+			jobID := r.Header.Get("jobID")
+			AppLog("JobID ", jobID, " rate limit exceeded for ", client, " on ", group)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			WriteJSON(w, http.StatusTooManyRequests, APIError{
+				Status:  "error",
+				JobID:   jobID,
+				Message: "Too many requests",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
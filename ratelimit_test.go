@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeApiKeysStorage struct {
+	ApiKeysStorage
+	keys map[string]*ApiKeys
+}
+
+func (f *fakeApiKeysStorage) GetByPrefix(prefix string) (*ApiKeys, error) {
+	return f.keys[prefix], nil
+}
+
+func TestRateLimitPerAPIKey(t *testing.T) {
+	keyA, keyB := apiKeyPrefix+"_aaaa_secret-a", apiKeyPrefix+"_bbbb_secret-b"
+	s := &APIServer{
+		Storage: Storage{
+			ApiKeysStorage: &fakeApiKeysStorage{keys: map[string]*ApiKeys{
+				"aaaa": {ID: 1, Prefix: "aaaa", KeyHash: hashToken(keyA)},
+				"bbbb": {ID: 2, Prefix: "bbbb", KeyHash: hashToken(keyB)},
+			}},
+		},
+		RateLimiter: &RateLimiter{
+			store:        NewMemoryRateLimitStore(),
+			defaultLimit: RateLimit{Requests: 1, Per: time.Hour},
+		},
+	}
+	handler := s.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// The requests run in order against the same buckets.
+	tests := []struct {
+		name       string
+		key        string
+		remoteAddr string
+		wantStatus int
+	}{
+		{name: "first key", key: keyA, remoteAddr: "10.0.0.1:1000", wantStatus: http.StatusOK},
+		{name: "second key behind the same IP", key: keyB, remoteAddr: "10.0.0.1:1001", wantStatus: http.StatusOK},
+		{name: "first key from another IP", key: keyA, remoteAddr: "10.0.0.2:1000", wantStatus: http.StatusTooManyRequests},
+		{name: "made-up key uses the IP", key: apiKeyPrefix + "_cccc_guess", remoteAddr: "10.0.0.3:1000", wantStatus: http.StatusOK},
+		{name: "another made-up key from that IP", key: apiKeyPrefix + "_dddd_guess", remoteAddr: "10.0.0.3:1001", wantStatus: http.StatusTooManyRequests},
+		{name: "wrong secret for a real prefix", key: apiKeyPrefix + "_bbbb_guess", remoteAddr: "10.0.0.3:1002", wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/budgets", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-API-Key", tt.key)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRateLimitGroups(t *testing.T) {
+	t.Setenv("RATE_LIMIT_DEFAULT", "3/1h")
+	t.Setenv("RATE_LIMIT_GROUPS", "/user=1/1h,/fund-requests/settlements=2/1h")
+	limiter, err := NewRateLimiter(NewMemoryRateLimitStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &APIServer{RateLimiter: limiter}
+	handler := s.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// The requests run in order from one IP; each group has its own bucket.
+	tests := []struct {
+		path          string
+		wantStatus    int
+		wantLimit     string
+		wantRemaining string
+	}{
+		{path: "/user/login", wantStatus: http.StatusOK, wantLimit: "1", wantRemaining: "0"},
+		{path: "/user/refresh", wantStatus: http.StatusTooManyRequests, wantLimit: "1", wantRemaining: "0"},
+		{path: "/users", wantStatus: http.StatusOK, wantLimit: "3", wantRemaining: "2"},
+		{path: "/fund-requests/settlements/4", wantStatus: http.StatusOK, wantLimit: "2", wantRemaining: "1"},
+		{path: "/fund-requests/4", wantStatus: http.StatusOK, wantLimit: "3", wantRemaining: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("X-RateLimit-Limit"); got != tt.wantLimit {
+				t.Errorf("X-RateLimit-Limit = %s, want %s", got, tt.wantLimit)
+			}
+			if got := w.Header().Get("X-RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("X-RateLimit-Remaining = %s, want %s", got, tt.wantRemaining)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Errorf("Retry-After missing")
+			}
+		})
+	}
+}
+
+func TestNewRateLimiterInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  string
+		groups string
+	}{
+		{name: "no duration", limit: "120"},
+		{name: "zero requests", limit: "0/1m"},
+		{name: "bad duration", limit: "10/forever"},
+		{name: "group without slash", groups: "fund-requests=60/1m"},
+		{name: "group without limit", groups: "/fund-requests"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RATE_LIMIT_DEFAULT", tt.limit)
+			t.Setenv("RATE_LIMIT_GROUPS", tt.groups)
+			if _, err := NewRateLimiter(NewMemoryRateLimitStore()); err == nil {
+				t.Errorf("NewRateLimiter() succeeded, want error")
+			}
+		})
+	}
+}
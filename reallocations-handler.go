@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+func validateBudgetReallocationsRequest(reqBody *BudgetReallocations) error {
+	if reqBody.BudgetsID <= 0 {
+		return fmt.Errorf("budgets id must be filled")
+	} else if reqBody.SourceBudgetPostsID <= 0 {
+		return fmt.Errorf("source budget posts id must be filled")
+	} else if reqBody.TargetBudgetPostsID <= 0 {
+		return fmt.Errorf("target budget posts id must be filled")
+	} else if reqBody.SourceBudgetPostsID == reqBody.TargetBudgetPostsID {
+		return fmt.Errorf("source and target budget posts must differ")
+	} else if reqBody.Amount <= 0 {
+		return fmt.Errorf("amount must be greater than 0")
+	} else if reqBody.Justification == "" {
+		return fmt.Errorf("justification must be filled")
+	}
+	return nil
+}
+
+func parseReallocationFilter(r *http.Request) (*ReallocationFilter, error) {
+	query := r.URL.Query()
+	filter := &ReallocationFilter{
+		Status: query.Get("status"),
+	}
+
+	var err error
+	if value := query.Get("budgets_id"); value != "" {
+		if filter.BudgetsID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid budgets_id")
+		}
+	}
+	if value := query.Get("budget_posts_id"); value != "" {
+		if filter.BudgetPostsID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid budget_posts_id")
+		}
+	}
+	return filter, nil
+}
+
+func (s *APIServer) validateBudgetReallocationsScope(r *http.Request, id int64) (*BudgetReallocations, string, error) {
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return nil, "unauthorized", err
+	}
+
+	reallocation, err := s.Storage.BudgetReallocationsStorage.GetByIdInScope(id, usersID)
+	if err != nil {
+		return nil, "database error", err
+	}
+
+	if reallocation == nil {
+		return nil, "budget reallocations not found", fmt.Errorf("budget reallocations not found")
+	}
+
+	return reallocation, "ok", nil
+}
+
+func (s *APIServer) GetAllBudgetReallocations(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	filter, err := parseReallocationFilter(r)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	reallocations, err := s.Storage.BudgetReallocationsStorage.GetAllInScope(usersID, filter)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, reallocations)
+
+}
+
+func (s *APIServer) GetBudgetReallocationByID(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reallocation, message, err := s.validateBudgetReallocationsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+	return respondWithSuccess(requestLog, reallocation)
+
+}
+
+func (s *APIServer) CreateBudgetReallocation(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &BudgetReallocations{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateBudgetReallocationsRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	message, err := s.validateBudgetsScope(r, reqBody.BudgetsID)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	sourcePost, err := s.Storage.BudgetPostsStorage.GetById(reqBody.SourceBudgetPostsID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if sourcePost == nil {
+		return respondWithError(requestLog, "source budget post not found", nil)
+	}
+
+	targetPost, err := s.Storage.BudgetPostsStorage.GetById(reqBody.TargetBudgetPostsID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if targetPost == nil {
+		return respondWithError(requestLog, "target budget post not found", nil)
+	}
+	if !targetPost.IsActive {
+		return respondWithError(requestLog, "target budget post is not active", nil)
+	}
+
+	message, err = s.checkBudgetPeriodWritable(r, reqBody.BudgetsID, false)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+	reqBody.UsersID = usersID
+
+	reallocation, err := s.Storage.BudgetReallocationsStorage.Create(reqBody, s.newAudit(r, "budget-reallocations", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, reallocation)
+
+}
+
+// BudgetReallocationTransition returns the handler for one reallocation
+// action, e.g. POST /budget-reallocations/{id}/approve.
+func (s *APIServer) BudgetReallocationTransition(action string) func(http.ResponseWriter, *http.Request, []byte, map[string]interface{}) (interface{}, error) {
+	return func(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+		id, err := s.GetID(r)
+		if err != nil {
+			return respondWithError(requestLog, "invalid ID", err)
+		}
+
+		claims, err := s.GetUserClaims(r)
+		if err != nil {
+			return respondWithError(requestLog, "unauthorized", err)
+		}
+
+		reqBody := &TransitionRequest{}
+		if len(bodyBytes) > 0 {
+			if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+				return respondWithError(requestLog, "invalid data request", err)
+			}
+		}
+
+		before, message, err := s.validateBudgetReallocationsScope(r, id)
+		if err != nil {
+			return respondWithError(requestLog, message, err)
+		}
+
+		next, err := reallocationWorkflow.Next(action, before.Status, reqBody.Comment)
+		if err != nil {
+			return respondWithError(requestLog, err.Error(), nil)
+		}
+		if err := reallocationWorkflow.Authorize(action, claims); err != nil {
+			return respondWithError(requestLog, err.Error(), nil)
+		}
+
+		if next == reallocationStatusApproved {
+			message, err = s.checkBudgetPeriodWritable(r, before.BudgetsID, false)
+			if err != nil {
+				return respondWithError(requestLog, message, err)
+			}
+		}
+
+		usersID, _ := claims.UsersID()
+		updated, err := s.Storage.BudgetReallocationsStorage.Transition(&StatusTransitions{
+			Entity:     reallocationWorkflow.Entity,
+			EntityID:   id,
+			Action:     action,
+			FromStatus: before.Status,
+			ToStatus:   next,
+			Comment:    reqBody.Comment,
+			UsersID:    usersID,
+		}, s.newAudit(r, "budget-reallocations", action))
+		if err != nil {
+			return respondWithError(requestLog, limitErrorMessage(err), err)
+		}
+
+		return respondWithSuccess(requestLog, updated)
+	}
+}
+
+func (s *APIServer) GetBudgetReallocationTransitions(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	_, message, err := s.validateBudgetReallocationsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	transitions, err := s.Storage.StatusTransitionsStorage.GetByEntity(reallocationWorkflow.Entity, id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, transitions)
+
+}
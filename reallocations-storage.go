@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+)
+
+type BudgetReallocationsStorage interface {
+	Create(*BudgetReallocations, *Audit) (*BudgetReallocations, error)
+	GetById(int64) (*BudgetReallocations, error)
+	GetAllInScope(int64, *ReallocationFilter) ([]*BudgetReallocations, error)
+	GetByIdInScope(int64, int64) (*BudgetReallocations, error)
+	Transition(*StatusTransitions, *Audit) (*BudgetReallocations, error)
+}
+
+type BudgetReallocationsStore struct {
+	db *sql.DB
+}
+
+func NewBudgetReallocationsStorage(db *sql.DB) *BudgetReallocationsStore {
+	return &BudgetReallocationsStore{
+		db: db,
+	}
+}
+
+const budgetReallocationColumns = `br.id, br.budgets_id, br.source_budget_posts_id, br.target_budget_posts_id, br.amount, br.justification, br.status, br.users_id, br.created_at, br.updated_at`
+
+type budgetReallocationScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBudgetReallocation(row budgetReallocationScanner) (*BudgetReallocations, error) {
+	reallocation := &BudgetReallocations{}
+	var usersID sql.NullInt64
+	err := row.Scan(&reallocation.ID, &reallocation.BudgetsID, &reallocation.SourceBudgetPostsID, &reallocation.TargetBudgetPostsID, &reallocation.Amount, &reallocation.Justification, &reallocation.Status, &usersID, &reallocation.CreatedAt, &reallocation.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	reallocation.UsersID = usersID.Int64
+	return reallocation, nil
+}
+
+func getBudgetReallocation(q querier, query string, args ...any) (*BudgetReallocations, error) {
+	reallocation, err := scanBudgetReallocation(q.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get budget reallocation: %w", err)
+	}
+	return reallocation, nil
+}
+
+func getBudgetReallocationById(q querier, id int64) (*BudgetReallocations, error) {
+	query := `SELECT ` + budgetReallocationColumns + ` FROM budget_reallocations br WHERE br.id = ?`
+	return getBudgetReallocation(q, query, id)
+}
+
+func (s *BudgetReallocationsStore) GetById(id int64) (*BudgetReallocations, error) {
+	return getBudgetReallocationById(s.db, id)
+}
+
+func (s *BudgetReallocationsStore) GetByIdInScope(id int64, usersID int64) (*BudgetReallocations, error) {
+	query := unitScopeCTE + `SELECT ` + budgetReallocationColumns + ` FROM budget_reallocations br
+		JOIN budgets b ON b.id = br.budgets_id
+		WHERE br.id = ? AND b.units_id IN (SELECT id FROM scoped_units)`
+	return getBudgetReallocation(s.db, query, usersID, id)
+}
+
+// GetAllInScope lists reallocations of the user's units, optionally narrowed
+// by budget, budget post (as source or target) and status.
+func (s *BudgetReallocationsStore) GetAllInScope(usersID int64, filter *ReallocationFilter) ([]*BudgetReallocations, error) {
+	query := unitScopeCTE + `SELECT ` + budgetReallocationColumns + ` FROM budget_reallocations br
+		JOIN budgets b ON b.id = br.budgets_id
+		WHERE b.units_id IN (SELECT id FROM scoped_units)`
+	args := []any{usersID}
+	if filter.BudgetsID > 0 {
+		query += ` AND br.budgets_id = ?`
+		args = append(args, filter.BudgetsID)
+	}
+	if filter.BudgetPostsID > 0 {
+		query += ` AND (br.source_budget_posts_id = ? OR br.target_budget_posts_id = ?)`
+		args = append(args, filter.BudgetPostsID, filter.BudgetPostsID)
+	}
+	if filter.Status != "" {
+		query += ` AND br.status = ?`
+		args = append(args, filter.Status)
+	}
+	query += ` ORDER BY br.id`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget reallocations: %w", err)
+	}
+	defer rows.Close()
+
+	var reallocations []*BudgetReallocations
+	for rows.Next() {
+		reallocation, err := scanBudgetReallocation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan budget reallocation: %w", err)
+		}
+		reallocations = append(reallocations, reallocation)
+	}
+	return reallocations, nil
+}
+
+func (s *BudgetReallocationsStore) Create(reallocation *BudgetReallocations, audit *Audit) (*BudgetReallocations, error) {
+	query := `INSERT INTO budget_reallocations (budgets_id, source_budget_posts_id, target_budget_posts_id, amount, justification, status, users_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, now(), now())`
+	_, created, err := auditedExec(s.db, audit, getBudgetReallocationById, 0, query, reallocation.BudgetsID, reallocation.SourceBudgetPostsID, reallocation.TargetBudgetPostsID, reallocation.Amount, reallocation.Justification, reallocationStatusSubmitted, nullInt64(reallocation.UsersID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert budget reallocation: %w", err)
+	}
+	return created, nil
+}
+
+// Transition changes the status; on approval both caps are adjusted in the
+// same transaction.
+func (s *BudgetReallocationsStore) Transition(transition *StatusTransitions, audit *Audit) (*BudgetReallocations, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	reallocation, err := getBudgetReallocationById(tx, transition.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	if reallocation == nil {
+		return nil, fmt.Errorf("budget reallocation not found")
+	}
+	if err := applyStatusTransition(tx, "budget_reallocations", transition); err != nil {
+		return nil, err
+	}
+	if transition.ToStatus == reallocationStatusApproved {
+		if err := applyReallocation(tx, reallocation); err != nil {
+			return nil, err
+		}
+	}
+	updated, err := getBudgetReallocationById(tx, transition.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, transition.EntityID, reallocation, updated); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, nil
+}
+
+// applyReallocation moves amount from the source post's cap to the target
+// post's cap and posts the transfer to the ledger. The source must keep
+// enough available balance and enough cap for its planned amounts; the
+// target cap is created when missing.
+func applyReallocation(tx *sql.Tx, reallocation *BudgetReallocations) error {
+	if err := checkCapBalance(tx, reallocation.BudgetsID, reallocation.SourceBudgetPostsID, 0, reallocation.Amount); err != nil {
+		return err
+	}
+	if err := checkPlannedWithinCap(tx, reallocation.BudgetsID, reallocation.SourceBudgetPostsID, 0, -reallocation.Amount, 0, 0); err != nil {
+		return err
+	}
+
+	if err := debitBudgetCaps(tx, reallocation.BudgetsID, reallocation.SourceBudgetPostsID, reallocation.Amount); err != nil {
+		return err
+	}
+
+	if _, err := lockCapAmount(tx, reallocation.BudgetsID, reallocation.TargetBudgetPostsID, 0); err != nil {
+		return err
+	}
+	query := `UPDATE budget_caps SET amount = amount + ?, updated_at = now() WHERE budgets_id = ? AND budget_posts_id = ? ORDER BY id LIMIT 1`
+	result, err := tx.Exec(query, reallocation.Amount, reallocation.BudgetsID, reallocation.TargetBudgetPostsID)
+	if err != nil {
+		return fmt.Errorf("failed to update target budget cap: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update target budget cap: %w", err)
+	}
+	if affected == 0 {
+		query = `INSERT INTO budget_caps (budgets_id, budget_posts_id, amount, created_at, updated_at) VALUES (?, ?, ?, now(), now())`
+		if _, err := tx.Exec(query, reallocation.BudgetsID, reallocation.TargetBudgetPostsID, reallocation.Amount); err != nil {
+			return fmt.Errorf("failed to insert target budget cap: %w", err)
+		}
+	}
+	return postReallocationTransfer(tx, reallocation)
+}
+
+// debitBudgetCaps takes amount from the cap rows of a budget post in id
+// order, emptying one row before moving on to the next, so no row goes
+// below zero.
+func debitBudgetCaps(tx *sql.Tx, budgetsID int64, budgetPostsID int64, amount float64) error {
+	query := `SELECT id, amount FROM budget_caps WHERE budgets_id = ? AND budget_posts_id = ? ORDER BY id FOR UPDATE`
+	rows, err := tx.Query(query, budgetsID, budgetPostsID)
+	if err != nil {
+		return fmt.Errorf("failed to lock budget caps: %w", err)
+	}
+	var caps []*BudgetCaps
+	for rows.Next() {
+		budgetCap := &BudgetCaps{}
+		if err := rows.Scan(&budgetCap.ID, &budgetCap.Amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan budget cap: %w", err)
+		}
+		caps = append(caps, budgetCap)
+	}
+	rows.Close()
+
+	remaining := amount
+	query = `UPDATE budget_caps SET amount = amount - ?, updated_at = now() WHERE id = ?`
+	for _, budgetCap := range caps {
+		if remaining <= 0 {
+			break
+		}
+		debit := math.Min(budgetCap.Amount, remaining)
+		if debit <= 0 {
+			continue
+		}
+		if _, err := tx.Exec(query, debit, budgetCap.ID); err != nil {
+			return fmt.Errorf("failed to update source budget cap: %w", err)
+		}
+		remaining = roundCents(remaining - debit)
+	}
+	if remaining > 0 {
+		return &CapExceededError{Available: roundCents(amount - remaining)}
+	}
+	return nil
+}
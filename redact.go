@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const redactedValue = "***"
+
+// Redactor masks secrets and personal data before anything reaches the log
+// files. Key rules match a field name at any depth, path rules match a dotted
+// path from the log root (request.body.code, response.data.*.email) where *
+// matches one segment and arrays are transparent.
+type Redactor struct {
+	keys        map[string]bool
+	paths       [][]string
+	headers     map[string]bool
+	cardNumbers bool
+}
+
+var defaultRedactKeys = []string{
+	"password", "pwd", "old_password", "new_password",
+	"token", "refresh_token", "challenge_token", "api_key", "client_secret",
+	"secret", "otpauth_uri", "recovery_code", "recovery_codes",
+	"email", "phone", "address", "nik", "npwp",
+}
+
+var defaultRedactPaths = []string{
+	"request.body.code",
+	"request.body.state",
+}
+
+var defaultRedactHeaders = []string{
+	"Authorization", "Proxy-Authorization", "X-Api-Key", "Cookie", "Set-Cookie",
+}
+
+var cardNumberPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+var logRedactor = NewRedactor(defaultRedactKeys, defaultRedactPaths, defaultRedactHeaders, true)
+
+func NewRedactor(keys []string, paths []string, headers []string, cardNumbers bool) *Redactor {
+	redactor := &Redactor{
+		keys:        map[string]bool{},
+		headers:     map[string]bool{},
+		cardNumbers: cardNumbers,
+	}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			redactor.keys[strings.ToLower(key)] = true
+		}
+	}
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path != "" {
+			redactor.paths = append(redactor.paths, strings.Split(strings.ToLower(path), "."))
+		}
+	}
+	for _, header := range headers {
+		if header = strings.TrimSpace(header); header != "" {
+			redactor.headers[http.CanonicalHeaderKey(header)] = true
+		}
+	}
+	return redactor
+}
+
+// LoadRedactor extends the default rules from the environment:
+//
+//	LOG_REDACT_KEYS         field names masked at any depth
+//	LOG_REDACT_PATHS        dotted paths from the log root, * for one segment
+//	LOG_REDACT_HEADERS      header names
+//	LOG_REDACT_CARD_NUMBERS mask card-like numbers in strings and in numeric
+//	                        card fields (default true)
+//
+// All lists are comma separated.
+func LoadRedactor() {
+	logRedactor = NewRedactor(
+		append(defaultRedactKeys, strings.Split(os.Getenv("LOG_REDACT_KEYS"), ",")...),
+		append(defaultRedactPaths, strings.Split(os.Getenv("LOG_REDACT_PATHS"), ",")...),
+		append(defaultRedactHeaders, strings.Split(os.Getenv("LOG_REDACT_HEADERS"), ",")...),
+		boolFromEnv("LOG_REDACT_CARD_NUMBERS", true),
+	)
+}
+
+// Redact returns a masked copy of value; the original is left untouched.
+func (r *Redactor) Redact(value interface{}) interface{} {
+	return r.walk(nil, value)
+}
+
+func (r *Redactor) walk(path []string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool:
+		return v
+	case string:
+		return r.scrub(v)
+	case float64:
+		// Amounts can be long enough to pass the Luhn check, so numbers are
+		// only masked under a card-like field name.
+		if r.cardNumbers && isCardField(path) && v == float64(int64(v)) && isCardNumber(strconv.FormatInt(int64(v), 10)) {
+			return redactedValue
+		}
+		return v
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := append(path[:len(path):len(path)], strings.ToLower(key))
+			if r.keys[strings.ToLower(key)] || r.matchPath(itemPath) {
+				masked[key] = redactedValue
+				continue
+			}
+			if key == "url" {
+				if s, ok := item.(string); ok {
+					masked[key] = r.redactURL(s)
+					continue
+				}
+			}
+			masked[key] = r.walk(itemPath, item)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = r.walk(path, item)
+		}
+		return masked
+	case []map[string]interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = r.walk(path, item)
+		}
+		return masked
+	case http.Header:
+		masked := make(map[string]interface{}, len(v))
+		for name, values := range v {
+			if r.headers[http.CanonicalHeaderKey(name)] {
+				masked[name] = redactedValue
+				continue
+			}
+			scrubbed := make([]interface{}, len(values))
+			for i, headerValue := range values {
+				scrubbed[i] = r.scrub(headerValue)
+			}
+			masked[name] = scrubbed
+		}
+		return masked
+	default:
+		// Structs and typed slices from handlers are reduced to their JSON
+		// form, so json:"-" fields never reach the log either.
+		data, err := json.Marshal(v)
+		if err != nil {
+			return redactedValue
+		}
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return redactedValue
+		}
+		return r.walk(path, generic)
+	}
+}
+
+func (r *Redactor) matchPath(path []string) bool {
+	for _, rule := range r.paths {
+		if len(rule) != len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range rule {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL masks sensitive query parameters such as ?code= on the OIDC
+// callback.
+func (r *Redactor) redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.RawQuery == "" {
+		return r.scrub(rawURL)
+	}
+	query := parsed.Query()
+	for key := range query {
+		if r.keys[strings.ToLower(key)] || r.matchPath([]string{"request", "body", strings.ToLower(key)}) {
+			query.Set(key, redactedValue)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return r.scrub(parsed.String())
+}
+
+// scrub masks card-like numbers that pass the Luhn check, keeping the last
+// four digits.
+func (r *Redactor) scrub(s string) string {
+	if !r.cardNumbers {
+		return s
+	}
+	return cardNumberPattern.ReplaceAllStringFunc(s, func(candidate string) string {
+		digits := strings.NewReplacer(" ", "", "-", "").Replace(candidate)
+		if !isCardNumber(digits) {
+			return candidate
+		}
+		return redactedValue + digits[len(digits)-4:]
+	})
+}
+
+// isCardField reports whether the last path segment names a card number,
+// such as card, card_number or pan.
+func isCardField(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	name := path[len(path)-1]
+	return name == "pan" || strings.Contains(name, "card")
+}
+
+func isCardNumber(digits string) bool {
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRespondWithSuccessRedactsCardsNotAmounts(t *testing.T) {
+	dir := t.TempDir()
+	previous := applog
+	applog = NewLogger(dir, "log", false)
+	t.Cleanup(func() {
+		applog.End()
+		applog = previous
+	})
+
+	// 4111111111111111 passes the Luhn check, as a 16 digit amount can.
+	requestLog := map[string]interface{}{
+		"body": map[string]interface{}{
+			"amount":      4111111111111111.0,
+			"card_number": 4111111111111111.0,
+			"note":        "paid with 4111 1111 1111 1111",
+		},
+	}
+	if _, err := respondWithSuccess(requestLog, map[string]interface{}{"total_amount": 4111111111111111.0}); err != nil {
+		t.Fatalf("respondWithSuccess() error = %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "log_*.log"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("log files = %v, error = %v", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	line := string(data)
+
+	for _, want := range []string{
+		`"amount":4111111111111111`,
+		`"total_amount":4111111111111111`,
+		`"card_number":"***"`,
+		`"note":"paid with ***1111"`,
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log line does not contain %s:\n%s", want, line)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+func (s *APIServer) GetAllRoles(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	roles, err := s.Storage.RolesStorage.GetAll()
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, roles)
+
+}
+
+func (s *APIServer) UpdateRoleTwoFactor(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reqBody := &Roles{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	role, err := s.Storage.RolesStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if role == nil {
+		return respondWithError(requestLog, "role not found", nil)
+	}
+
+	updatedRole, err := s.Storage.RolesStorage.UpdateRequire2FA(id, reqBody.Require2FA, s.newAudit(r, "roles", auditActionUpdate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	AppLog("JobID ", r.Header.Get("jobID"), " role ", updatedRole.Name, " require 2fa set to ", updatedRole.Require2FA)
+	return respondWithSuccess(requestLog, updatedRole)
+
+}
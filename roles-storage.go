@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type RolesStorage interface {
+	GetAll() ([]*Roles, error)
+	GetById(int64) (*Roles, error)
+	UpdateRequire2FA(int64, bool, *Audit) (*Roles, error)
+}
+
+type RolesStore struct {
+	db *sql.DB
+}
+
+func NewRolesStorage(db *sql.DB) *RolesStore {
+	return &RolesStore{
+		db: db,
+	}
+}
+
+func (s *RolesStore) GetAll() ([]*Roles, error) {
+	query := `SELECT id, name, require_2fa, created_at FROM roles ORDER BY name`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []*Roles
+	for rows.Next() {
+		role := &Roles{}
+		if err := rows.Scan(&role.ID, &role.Name, &role.Require2FA, &role.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func getRoleById(q querier, id int64) (*Roles, error) {
+	query := `SELECT id, name, require_2fa, created_at FROM roles WHERE id = ?`
+	role := &Roles{}
+	err := q.QueryRow(query, id).Scan(&role.ID, &role.Name, &role.Require2FA, &role.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return role, nil
+}
+
+func (s *RolesStore) GetById(id int64) (*Roles, error) {
+	return getRoleById(s.db, id)
+}
+
+func (s *RolesStore) UpdateRequire2FA(id int64, required bool, audit *Audit) (*Roles, error) {
+	query := `UPDATE roles SET require_2fa = ? WHERE id = ?`
+	_, role, err := auditedExec(s.db, audit, getRoleById, id, query, required, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update role: %w", err)
+	}
+	return role, nil
+}
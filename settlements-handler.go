@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func validateSettlementRequest(reqBody *FundRequestSettlements) error {
+	if len(reqBody.Lines) == 0 {
+		return fmt.Errorf("lines must be filled")
+	}
+	seen := map[int64]bool{}
+	for _, line := range reqBody.Lines {
+		if line.FundRequestDetailsID <= 0 {
+			return fmt.Errorf("fund request details id must be filled")
+		} else if seen[line.FundRequestDetailsID] {
+			return fmt.Errorf("fund request detail %d is settled more than once", line.FundRequestDetailsID)
+		} else if line.Amount < 0 {
+			return fmt.Errorf("amount must not be negative")
+		} else if len(line.Description) > 255 {
+			return fmt.Errorf("max length description 255")
+		}
+		seen[line.FundRequestDetailsID] = true
+	}
+	return nil
+}
+
+// validateSettlementLines makes sure every line is settled against a detail
+// of the fund request itself.
+func (s *APIServer) validateSettlementLines(fundRequestsID int64, lines []*FundRequestSettlementLines) (string, error) {
+	for _, line := range lines {
+		detail, err := s.Storage.FundRequestDetailsStorage.GetById(line.FundRequestDetailsID)
+		if err != nil {
+			return "database error", err
+		}
+		if detail == nil || detail.FundRequestsID != fundRequestsID {
+			message := fmt.Sprintf("fund request detail %d not found on this fund request", line.FundRequestDetailsID)
+			return message, fmt.Errorf("%s", message)
+		}
+	}
+	return "ok", nil
+}
+
+func (s *APIServer) GetFundRequestSettlement(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	message, err := s.validateFundRequestsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	settlement, err := s.Storage.FundRequestSettlementsStorage.GetByFundRequest(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if settlement == nil {
+		return respondWithError(requestLog, "settlement not found", nil)
+	}
+	return respondWithSuccess(requestLog, settlement)
+
+}
+
+func (s *APIServer) CreateFundRequestSettlement(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reqBody := &FundRequestSettlements{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateSettlementRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	message, err := s.validateFundRequestsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	fundRequest, err := s.Storage.FundRequestsStorage.GetById(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if fundRequest.Status != fundRequestStatusDisbursed {
+		return respondWithError(requestLog, "only disbursed fund requests can be settled", nil)
+	}
+
+	existing, err := s.Storage.FundRequestSettlementsStorage.GetByFundRequest(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if existing != nil {
+		return respondWithError(requestLog, "settlement already exists", nil)
+	}
+
+	message, err = s.checkFundRequestPeriodWritable(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateSettlementLines(id, reqBody.Lines)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	usersID, err := s.GetUserID(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+	reqBody.FundRequestsID = id
+	reqBody.UsersID = usersID
+
+	settlement, err := s.Storage.FundRequestSettlementsStorage.Create(reqBody, s.newAudit(r, "fund-request-settlements", auditActionCreate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, settlement)
+
+}
+
+func (s *APIServer) UpdateFundRequestSettlement(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	reqBody := &FundRequestSettlements{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "invalid data request", err)
+	}
+
+	if err := validateSettlementRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	message, err := s.validateFundRequestsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	before, err := s.Storage.FundRequestSettlementsStorage.GetByFundRequest(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if before == nil {
+		return respondWithError(requestLog, "settlement not found", nil)
+	}
+	if !settlementEditable(before.Status) {
+		return respondWithError(requestLog, "settlement can no longer be changed", nil)
+	}
+
+	message, err = s.checkFundRequestPeriodWritable(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	message, err = s.validateSettlementLines(id, reqBody.Lines)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+	reqBody.FundRequestsID = id
+
+	settlement, err := s.Storage.FundRequestSettlementsStorage.Update(before.ID, reqBody, s.newAudit(r, "fund-request-settlements", auditActionUpdate))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, settlement)
+
+}
+
+// FundRequestSettlementTransition returns the handler for one settlement
+// action, e.g. POST /fund-requests/{id}/settlement/approve.
+func (s *APIServer) FundRequestSettlementTransition(action string) func(http.ResponseWriter, *http.Request, []byte, map[string]interface{}) (interface{}, error) {
+	return func(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+		id, err := s.GetID(r)
+		if err != nil {
+			return respondWithError(requestLog, "invalid ID", err)
+		}
+
+		claims, err := s.GetUserClaims(r)
+		if err != nil {
+			return respondWithError(requestLog, "unauthorized", err)
+		}
+
+		reqBody := &TransitionRequest{}
+		if len(bodyBytes) > 0 {
+			if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+				return respondWithError(requestLog, "invalid data request", err)
+			}
+		}
+
+		message, err := s.validateFundRequestsScope(r, id)
+		if err != nil {
+			return respondWithError(requestLog, message, err)
+		}
+
+		before, err := s.Storage.FundRequestSettlementsStorage.GetByFundRequest(id)
+		if err != nil {
+			return respondWithError(requestLog, "database error", err)
+		}
+		if before == nil {
+			return respondWithError(requestLog, "settlement not found", nil)
+		}
+
+		next, err := settlementWorkflow.Next(action, before.Status, reqBody.Comment)
+		if err != nil {
+			return respondWithError(requestLog, err.Error(), nil)
+		}
+		if err := settlementWorkflow.Authorize(action, claims); err != nil {
+			return respondWithError(requestLog, err.Error(), nil)
+		}
+
+		if next == settlementStatusApproved {
+			message, err = s.checkFundRequestPeriodWritable(r, id)
+			if err != nil {
+				return respondWithError(requestLog, message, err)
+			}
+		}
+
+		usersID, _ := claims.UsersID()
+		updated, err := s.Storage.FundRequestSettlementsStorage.Transition(&StatusTransitions{
+			Entity:     settlementWorkflow.Entity,
+			EntityID:   before.ID,
+			Action:     action,
+			FromStatus: before.Status,
+			ToStatus:   next,
+			Comment:    reqBody.Comment,
+			UsersID:    usersID,
+		}, s.newAudit(r, "fund-request-settlements", action))
+		if err != nil {
+			return respondWithError(requestLog, limitErrorMessage(err), err)
+		}
+
+		return respondWithSuccess(requestLog, updated)
+	}
+}
+
+func (s *APIServer) GetFundRequestSettlementTransitions(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	id, err := s.GetID(r)
+	if err != nil {
+		return respondWithError(requestLog, "invalid ID", err)
+	}
+
+	message, err := s.validateFundRequestsScope(r, id)
+	if err != nil {
+		return respondWithError(requestLog, message, err)
+	}
+
+	settlement, err := s.Storage.FundRequestSettlementsStorage.GetByFundRequest(id)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if settlement == nil {
+		return respondWithError(requestLog, "settlement not found", nil)
+	}
+
+	transitions, err := s.Storage.StatusTransitionsStorage.GetByEntity(settlementWorkflow.Entity, settlement.ID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, transitions)
+
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type FundRequestSettlementsStorage interface {
+	Create(*FundRequestSettlements, *Audit) (*FundRequestSettlements, error)
+	Update(int64, *FundRequestSettlements, *Audit) (*FundRequestSettlements, error)
+	GetById(int64) (*FundRequestSettlements, error)
+	GetByFundRequest(int64) (*FundRequestSettlements, error)
+	Transition(*StatusTransitions, *Audit) (*FundRequestSettlements, error)
+}
+
+type FundRequestSettlementsStore struct {
+	db *sql.DB
+}
+
+func NewFundRequestSettlementsStorage(db *sql.DB) *FundRequestSettlementsStore {
+	return &FundRequestSettlementsStore{
+		db: db,
+	}
+}
+
+func getFundRequestSettlement(q querier, where string, arg int64) (*FundRequestSettlements, error) {
+	query := `SELECT id, fund_requests_id, status, advance_amount, actual_amount, variance, refund_amount, extra_payment_amount, note, users_id, created_at, updated_at
+		FROM fund_request_settlements WHERE ` + where + ` = ?`
+	settlement := &FundRequestSettlements{}
+	var note sql.NullString
+	var usersID sql.NullInt64
+	err := q.QueryRow(query, arg).Scan(&settlement.ID, &settlement.FundRequestsID, &settlement.Status, &settlement.AdvanceAmount, &settlement.ActualAmount, &settlement.Variance, &settlement.RefundAmount, &settlement.ExtraPaymentAmount, &note, &usersID, &settlement.CreatedAt, &settlement.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get fund request settlement: %w", err)
+	}
+	settlement.Note = note.String
+	settlement.UsersID = usersID.Int64
+
+	query = `SELECT id, fund_request_settlements_id, fund_request_details_id, amount, description
+		FROM fund_request_settlement_lines WHERE fund_request_settlements_id = ? ORDER BY id`
+	rows, err := q.Query(query, settlement.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settlement lines: %w", err)
+	}
+	defer rows.Close()
+	settlement.Lines = []*FundRequestSettlementLines{}
+	for rows.Next() {
+		line := &FundRequestSettlementLines{}
+		if err := rows.Scan(&line.ID, &line.FundRequestSettlementsID, &line.FundRequestDetailsID, &line.Amount, &line.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan settlement line: %w", err)
+		}
+		settlement.Lines = append(settlement.Lines, line)
+	}
+	return settlement, nil
+}
+
+func getFundRequestSettlementById(q querier, id int64) (*FundRequestSettlements, error) {
+	return getFundRequestSettlement(q, "id", id)
+}
+
+func (s *FundRequestSettlementsStore) GetById(id int64) (*FundRequestSettlements, error) {
+	return getFundRequestSettlementById(s.db, id)
+}
+
+func (s *FundRequestSettlementsStore) GetByFundRequest(fundRequestsID int64) (*FundRequestSettlements, error) {
+	return getFundRequestSettlement(s.db, "fund_requests_id", fundRequestsID)
+}
+
+// settlementTotals compares the actual spend of the lines with the advance
+// the fund request received.
+func settlementTotals(tx *sql.Tx, settlement *FundRequestSettlements) error {
+	query := `SELECT amount FROM fund_requests WHERE id = ? FOR UPDATE`
+	if err := tx.QueryRow(query, settlement.FundRequestsID).Scan(&settlement.AdvanceAmount); err != nil {
+		return fmt.Errorf("failed to get fund request: %w", err)
+	}
+
+	settlement.ActualAmount = 0
+	for _, line := range settlement.Lines {
+		settlement.ActualAmount += line.Amount
+	}
+	settlement.ActualAmount = roundCents(settlement.ActualAmount)
+	settlement.Variance = roundCents(settlement.ActualAmount - settlement.AdvanceAmount)
+	settlement.RefundAmount, settlement.ExtraPaymentAmount = 0, 0
+	if settlement.Variance < 0 {
+		settlement.RefundAmount = -settlement.Variance
+	} else {
+		settlement.ExtraPaymentAmount = settlement.Variance
+	}
+	return nil
+}
+
+func replaceSettlementLines(tx *sql.Tx, settlementID int64, lines []*FundRequestSettlementLines) error {
+	query := `DELETE FROM fund_request_settlement_lines WHERE fund_request_settlements_id = ?`
+	if _, err := tx.Exec(query, settlementID); err != nil {
+		return fmt.Errorf("failed to delete settlement lines: %w", err)
+	}
+
+	query = `INSERT INTO fund_request_settlement_lines (fund_request_settlements_id, fund_request_details_id, amount, description) VALUES (?, ?, ?, ?)`
+	for _, line := range lines {
+		if _, err := tx.Exec(query, settlementID, line.FundRequestDetailsID, line.Amount, line.Description); err != nil {
+			return fmt.Errorf("failed to insert settlement line: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *FundRequestSettlementsStore) Create(settlement *FundRequestSettlements, audit *Audit) (*FundRequestSettlements, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := settlementTotals(tx, settlement); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO fund_request_settlements (fund_requests_id, status, advance_amount, actual_amount, variance, refund_amount, extra_payment_amount, note, users_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, now(), now())`
+	result, err := tx.Exec(query, settlement.FundRequestsID, settlementStatusDraft, settlement.AdvanceAmount, settlement.ActualAmount, settlement.Variance, settlement.RefundAmount, settlement.ExtraPaymentAmount, settlement.Note, nullInt64(settlement.UsersID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert fund request settlement: %w", err)
+	}
+	lastInsertID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+	if err := replaceSettlementLines(tx, lastInsertID, settlement.Lines); err != nil {
+		return nil, err
+	}
+	return commitSettlementChange(tx, audit, lastInsertID, nil)
+}
+
+// Update replaces the note and lines and recomputes the totals.
+func (s *FundRequestSettlementsStore) Update(id int64, settlement *FundRequestSettlements, audit *Audit) (*FundRequestSettlements, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := getFundRequestSettlementById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := settlementTotals(tx, settlement); err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE fund_request_settlements SET advance_amount = ?, actual_amount = ?, variance = ?, refund_amount = ?, extra_payment_amount = ?, note = ?, updated_at = now() WHERE id = ?`
+	_, err = tx.Exec(query, settlement.AdvanceAmount, settlement.ActualAmount, settlement.Variance, settlement.RefundAmount, settlement.ExtraPaymentAmount, settlement.Note, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update fund request settlement: %w", err)
+	}
+	if err := replaceSettlementLines(tx, id, settlement.Lines); err != nil {
+		return nil, err
+	}
+	return commitSettlementChange(tx, audit, id, before)
+}
+
+// Transition changes the settlement status. Approval checks an extra payment
+// against the budget cap, switches usage to the actual amounts and posts the
+// refund or extra payment to the ledger; settling closes the fund request.
+func (s *FundRequestSettlementsStore) Transition(transition *StatusTransitions, audit *Audit) (*FundRequestSettlements, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	settlement, err := getFundRequestSettlementById(tx, transition.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	if settlement == nil {
+		return nil, fmt.Errorf("fund request settlement not found")
+	}
+
+	if transition.ToStatus == settlementStatusApproved && settlement.ExtraPaymentAmount > 0 {
+		var budgetsID, budgetPostsID int64
+		query := `SELECT budgets_id, budget_posts_id FROM fund_requests WHERE id = ?`
+		if err := tx.QueryRow(query, settlement.FundRequestsID).Scan(&budgetsID, &budgetPostsID); err != nil {
+			return nil, fmt.Errorf("failed to get fund request: %w", err)
+		}
+		if err := checkCapBalance(tx, budgetsID, budgetPostsID, 0, settlement.ExtraPaymentAmount); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyStatusTransition(tx, "fund_request_settlements", transition); err != nil {
+		return nil, err
+	}
+
+	switch transition.ToStatus {
+	case settlementStatusApproved:
+		if err := postSettlementAdjustment(tx, settlement); err != nil {
+			return nil, err
+		}
+		if err := refreshFundRequestUsage(tx, settlement.FundRequestsID); err != nil {
+			return nil, err
+		}
+	case settlementStatusSettled:
+		err := applyStatusTransition(tx, "fund_requests", &StatusTransitions{
+			Entity:     fundRequestWorkflow.Entity,
+			EntityID:   settlement.FundRequestsID,
+			Action:     "settle",
+			FromStatus: fundRequestStatusDisbursed,
+			ToStatus:   fundRequestStatusSettled,
+			Comment:    transition.Comment,
+			UsersID:    transition.UsersID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return commitSettlementChange(tx, audit, transition.EntityID, settlement)
+}
+
+// commitSettlementChange writes the audit record of a change to settlement id
+// and commits tx.
+func commitSettlementChange(tx *sql.Tx, audit *Audit, id int64, before *FundRequestSettlements) (*FundRequestSettlements, error) {
+	after, err := getFundRequestSettlementById(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.write(tx, id, before, after); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return after, nil
+}
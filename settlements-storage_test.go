@@ -0,0 +1,207 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectSettlementRow expects getFundRequestSettlementById for settlement 3
+// of fund request 9 with one line per amount, on details 21, 22, ...
+func expectSettlementRow(mock sqlmock.Sqlmock, status string, advance float64, amounts ...float64) {
+	var actual float64
+	lines := sqlmock.NewRows([]string{"id", "fund_request_settlements_id", "fund_request_details_id", "amount", "description"})
+	for i, amount := range amounts {
+		actual += amount
+		lines.AddRow(31+i, 3, 21+i, amount, "")
+	}
+	variance := roundCents(actual - advance)
+	refund, extra := 0.0, variance
+	if variance < 0 {
+		refund, extra = -variance, 0
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM fund_request_settlements WHERE id = ?`)).
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "fund_requests_id", "status", "advance_amount", "actual_amount", "variance", "refund_amount", "extra_payment_amount", "note", "users_id", "created_at", "updated_at"}).
+			AddRow(3, 9, status, advance, actual, variance, refund, extra, nil, 10, time.Time{}, time.Time{}))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM fund_request_settlement_lines WHERE fund_request_settlements_id = ?`)).
+		WithArgs(int64(3)).
+		WillReturnRows(lines)
+}
+
+func TestFundRequestSettlementsStoreCreateTotals(t *testing.T) {
+	tests := []struct {
+		name         string
+		amounts      []float64
+		wantActual   float64
+		wantRefund   float64
+		wantExtra    float64
+		wantVariance float64
+	}{
+		{name: "refund of unused advance", amounts: []float64{600, 300.10}, wantActual: 900.10, wantRefund: 99.90, wantVariance: -99.90},
+		{name: "extra payment", amounts: []float64{700, 450}, wantActual: 1150, wantExtra: 150, wantVariance: 150},
+		{name: "spent exactly", amounts: []float64{1000}, wantActual: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			settlement := &FundRequestSettlements{FundRequestsID: 9, UsersID: 10}
+			for i, amount := range tt.amounts {
+				settlement.Lines = append(settlement.Lines, &FundRequestSettlementLines{FundRequestDetailsID: int64(21 + i), Amount: amount})
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT amount FROM fund_requests WHERE id = ? FOR UPDATE`)).
+				WithArgs(int64(9)).
+				WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(1000))
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO fund_request_settlements`)).
+				WithArgs(int64(9), settlementStatusDraft, 1000.0, tt.wantActual, tt.wantVariance, tt.wantRefund, tt.wantExtra, "", int64(10)).
+				WillReturnResult(sqlmock.NewResult(3, 1))
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM fund_request_settlement_lines WHERE fund_request_settlements_id = ?`)).
+				WithArgs(int64(3)).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			for i, amount := range tt.amounts {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO fund_request_settlement_lines`)).
+					WithArgs(int64(3), int64(21+i), amount, "").
+					WillReturnResult(sqlmock.NewResult(int64(31+i), 1))
+			}
+			expectSettlementRow(mock, settlementStatusDraft, 1000, tt.amounts...)
+			mock.ExpectCommit()
+
+			created, err := (&FundRequestSettlementsStore{db: db}).Create(settlement, nil)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if created.RefundAmount != tt.wantRefund || created.ExtraPaymentAmount != tt.wantExtra {
+				t.Errorf("Create() refund = %v, extra = %v, want %v and %v", created.RefundAmount, created.ExtraPaymentAmount, tt.wantRefund, tt.wantExtra)
+			}
+		})
+	}
+}
+
+func TestFundRequestSettlementsStoreApprove(t *testing.T) {
+	// Fund request 9 on post 2 of budget 1 spent its 1000 advance on activity
+	// 4; the settlement reports lines for details of activities 4 and 5.
+	spent := func(activity int64) ledgerAccount {
+		return ledgerAccount{Type: ledgerAccountSpent, BudgetsID: 1, BudgetPostsID: 2, ActivitiesID: activity}
+	}
+	available := ledgerAccount{Type: ledgerAccountAvailable, BudgetsID: 1, BudgetPostsID: 2}
+
+	tests := []struct {
+		name      string
+		amounts   []float64
+		available float64
+		wantEntry string
+		wantLines []ledgerPosting
+		wantErr   bool
+	}{
+		{
+			name:      "refund goes back to the cap",
+			amounts:   []float64{600, 300},
+			wantEntry: ledgerEntryReversal,
+			wantLines: []ledgerPosting{{Account: spent(4), Amount: -400}, {Account: spent(5), Amount: 300}, {Account: available, Amount: 100}},
+		},
+		{
+			name:      "extra payment within the cap",
+			amounts:   []float64{700, 450},
+			available: 200,
+			wantEntry: ledgerEntryDisbursement,
+			wantLines: []ledgerPosting{{Account: spent(4), Amount: -300}, {Account: spent(5), Amount: 450}, {Account: available, Amount: -150}},
+		},
+		{
+			name:      "extra payment above the cap",
+			amounts:   []float64{700, 450},
+			available: 100,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+
+			mock.ExpectBegin()
+			expectSettlementRow(mock, settlementStatusSubmitted, 1000, tt.amounts...)
+			if tt.available > 0 {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT budgets_id, budget_posts_id FROM fund_requests WHERE id = ?`)).
+					WithArgs(int64(9)).
+					WillReturnRows(sqlmock.NewRows([]string{"budgets_id", "budget_posts_id"}).AddRow(1, 2))
+				expectCapBalance(mock, 1, 2, tt.available, nil)
+			}
+			if tt.wantErr {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE fund_request_settlements SET status = ?`)).
+					WithArgs(settlementStatusApproved, int64(3), settlementStatusSubmitted).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO status_transitions`)).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT budgets_id, budget_posts_id FROM fund_requests WHERE id = ?`)).
+					WithArgs(int64(9)).
+					WillReturnRows(sqlmock.NewRows([]string{"budgets_id", "budget_posts_id"}).AddRow(1, 2))
+				mock.ExpectQuery(regexp.QuoteMeta(`FROM journal_lines jl JOIN journal_entries je`)).
+					WithArgs("fund-requests", int64(9), ledgerAccountSpent).
+					WillReturnRows(sqlmock.NewRows([]string{"budgets_id", "budget_posts_id", "activities_id", "balance"}).AddRow(1, 2, 4, 1000))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT frd.activities_id, SUM(sl.amount) FROM fund_request_settlement_lines sl`)).
+					WithArgs(int64(3)).
+					WillReturnRows(sqlmock.NewRows([]string{"activities_id", "amount"}).AddRow(4, tt.amounts[0]).AddRow(5, tt.amounts[1]))
+				expectJournalEntry(mock, tt.wantEntry, "fund-requests", 9, tt.wantLines)
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE budget_details_posts bdp`)).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				expectSettlementRow(mock, settlementStatusApproved, 1000, tt.amounts...)
+				mock.ExpectCommit()
+			}
+
+			_, err := (&FundRequestSettlementsStore{db: db}).Transition(&StatusTransitions{
+				Entity:     settlementWorkflow.Entity,
+				EntityID:   3,
+				Action:     "approve",
+				FromStatus: settlementStatusSubmitted,
+				ToStatus:   settlementStatusApproved,
+			}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Transition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFundRequestSettlementsStoreSettleClosesFundRequest(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectBegin()
+	expectSettlementRow(mock, settlementStatusApproved, 1000, 900)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE fund_request_settlements SET status = ?`)).
+		WithArgs(settlementStatusSettled, int64(3), settlementStatusApproved).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO status_transitions`)).
+		WithArgs(settlementWorkflow.Entity, int64(3), "settle", settlementStatusApproved, settlementStatusSettled, "refund received", int64(10)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE fund_requests SET status = ?`)).
+		WithArgs(fundRequestStatusSettled, int64(9), fundRequestStatusDisbursed).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO status_transitions`)).
+		WithArgs(fundRequestWorkflow.Entity, int64(9), "settle", fundRequestStatusDisbursed, fundRequestStatusSettled, "refund received", int64(10)).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	expectSettlementRow(mock, settlementStatusSettled, 1000, 900)
+	mock.ExpectCommit()
+
+	settled, err := (&FundRequestSettlementsStore{db: db}).Transition(&StatusTransitions{
+		Entity:     settlementWorkflow.Entity,
+		EntityID:   3,
+		Action:     "settle",
+		FromStatus: settlementStatusApproved,
+		ToStatus:   settlementStatusSettled,
+		Comment:    "refund received",
+		UsersID:    10,
+	}, nil)
+	if err != nil {
+		t.Fatalf("Transition() error = %v", err)
+	}
+	if settled.Status != settlementStatusSettled {
+		t.Errorf("Transition() status = %s, want %s", settled.Status, settlementStatusSettled)
+	}
+}
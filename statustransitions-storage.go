@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type StatusTransitionsStorage interface {
+	GetByEntity(string, int64) ([]*StatusTransitions, error)
+}
+
+type StatusTransitionsStore struct {
+	db *sql.DB
+}
+
+func NewStatusTransitionsStorage(db *sql.DB) *StatusTransitionsStore {
+	return &StatusTransitionsStore{
+		db: db,
+	}
+}
+
+func (s *StatusTransitionsStore) GetByEntity(entity string, entityID int64) ([]*StatusTransitions, error) {
+	query := `SELECT id, entity, entity_id, action, from_status, to_status, comment, users_id, created_at FROM status_transitions WHERE entity = ? AND entity_id = ? ORDER BY id`
+	rows, err := s.db.Query(query, entity, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status transitions: %w", err)
+	}
+	defer rows.Close()
+
+	var transitions []*StatusTransitions
+	for rows.Next() {
+		transition := &StatusTransitions{}
+		var usersID sql.NullInt64
+		err := rows.Scan(&transition.ID, &transition.Entity, &transition.EntityID, &transition.Action, &transition.FromStatus, &transition.ToStatus, &transition.Comment, &usersID, &transition.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan status transition: %w", err)
+		}
+		transition.UsersID = usersID.Int64
+		transitions = append(transitions, transition)
+	}
+	return transitions, nil
+}
+
+// applyStatusTransition moves a row of table from one status to the next and
+// records the history entry in the same transaction. The status is compared
+// on update, so two concurrent transitions cannot both succeed.
+func applyStatusTransition(tx *sql.Tx, table string, transition *StatusTransitions) error {
+	query := fmt.Sprintf("UPDATE %s SET status = ?, updated_at = now() WHERE id = ? AND status = ?", table)
+	result, err := tx.Exec(query, transition.ToStatus, transition.EntityID, transition.FromStatus)
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("status was changed by another request")
+	}
+
+	query = `INSERT INTO status_transitions (entity, entity_id, action, from_status, to_status, comment, users_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, now())`
+	_, err = tx.Exec(query, transition.Entity, transition.EntityID, transition.Action, transition.FromStatus, transition.ToStatus, transition.Comment, nullInt64(transition.UsersID))
+	if err != nil {
+		return fmt.Errorf("failed to insert status transition: %w", err)
+	}
+	return nil
+}
@@ -1,15 +1,42 @@
 package main
 
+import "database/sql"
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so reads can run inside
+// the transaction of a write.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 type Storage struct {
-	ActivitiesStorage          ActivitiesStorage
-	UsersStorage               UsersStorage
-	BudgetPostsStorage         BudgetPostsStorage
-	BudgetCapsStorage          BudgetCapsStorage
-	BudgetsStorage             BudgetsStorage
-	BudgetDetailsStorage       BudgetDetailsStorage
-	BudgetDetailsPostsStorage  BudgetDetailsPostsStorage
-	FundRequestsStorage        FundRequestsStorage
-	FundRequestDetailsStorage  FundRequestDetailsStorage
-	BudgetDetailPostRecStorage BudgetDetailPostRecStorage
-	PrimaryKeyIDStorage        PrimaryKeyIDStorage
+	ActivitiesStorage                   ActivitiesStorage
+	UsersStorage                        UsersStorage
+	BudgetPostsStorage                  BudgetPostsStorage
+	BudgetCapsStorage                   BudgetCapsStorage
+	BudgetsStorage                      BudgetsStorage
+	BudgetDetailsStorage                BudgetDetailsStorage
+	BudgetDetailsPostsStorage           BudgetDetailsPostsStorage
+	FundRequestsStorage                 FundRequestsStorage
+	FundRequestDetailsStorage           FundRequestDetailsStorage
+	BudgetDetailPostRecStorage          BudgetDetailPostRecStorage
+	PrimaryKeyIDStorage                 PrimaryKeyIDStorage
+	UnitsStorage                        UnitsStorage
+	TokensStorage                       TokensStorage
+	ApiKeysStorage                      ApiKeysStorage
+	LoginFailuresStorage                LoginFailuresStorage
+	IdentitiesStorage                   IdentitiesStorage
+	TwoFactorStorage                    TwoFactorStorage
+	RolesStorage                        RolesStorage
+	AuditLogsStorage                    AuditLogsStorage
+	StatusTransitionsStorage            StatusTransitionsStorage
+	ApprovalMatrixStorage               ApprovalMatrixStorage
+	FundRequestApprovalsStorage         FundRequestApprovalsStorage
+	ConsolidationPoliciesStorage        ConsolidationPoliciesStorage
+	RecommendationConsolidationsStorage RecommendationConsolidationsStorage
+	FiscalPeriodsStorage                FiscalPeriodsStorage
+	BudgetRevisionsStorage              BudgetRevisionsStorage
+	BudgetReallocationsStorage          BudgetReallocationsStorage
+	LedgerStorage                       LedgerStorage
+	FundRequestSettlementsStorage       FundRequestSettlementsStorage
 }
@@ -3,9 +3,146 @@ package main
 import "time"
 
 type Users struct {
-	ID       int    `json:"id"`
+	ID               int        `json:"id"`
+	UserID           string     `json:"userid"`
+	Password         string     `json:"password"`
+	IsActive         bool       `json:"is_active"`
+	TokensValidAfter *time.Time `json:"-"`
+}
+
+type RefreshTokens struct {
+	ID         int64      `json:"id"`
+	UsersID    int64      `json:"users_id"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy int64      `json:"replaced_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type ApiKeys struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	UsersID    int64      `json:"users_id"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedBy  int64      `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type TwoFactor struct {
+	UsersID     int64
+	Secret      string
+	Enabled     bool
+	LastCounter int64
+}
+
+type Roles struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Require2FA bool      `json:"require_2fa"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type LoginFailures struct {
+	SubjectType  string     `json:"subject_type"`
+	Subject      string     `json:"subject"`
+	Failures     int        `json:"failures"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until"`
+}
+
+type UnlockRequest struct {
 	UserID   string `json:"userid"`
-	Password string `json:"password"`
+	ClientIP string `json:"client_ip"`
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+type UserIdentities struct {
+	ID          int64      `json:"id"`
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	UsersID     int64      `json:"users_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+type TwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type TokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	All          bool   `json:"all"`
+}
+
+type PasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type AuditLogs struct {
+	ID        int64                  `json:"id"`
+	UsersID   int64                  `json:"users_id"`
+	ApiKeysID int64                  `json:"api_keys_id"`
+	JobID     string                 `json:"job_id"`
+	Entity    string                 `json:"entity"`
+	EntityID  int64                  `json:"entity_id"`
+	Action    string                 `json:"action"`
+	Changes   map[string]AuditChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+	PrevHash  string                 `json:"prev_hash"`
+	Hash      string                 `json:"hash"`
+	Signature string                 `json:"signature"`
+}
+
+type AuditVerification struct {
+	Valid     bool   `json:"valid"`
+	Checked   int    `json:"checked"`
+	Unchained int    `json:"unchained"`
+	BrokenID  int64  `json:"broken_id,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	LastHash  string `json:"last_hash,omitempty"`
+}
+
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type AuditFilter struct {
+	Entity   string
+	EntityID int64
+	UsersID  int64
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+}
+
+type StatusTransitions struct {
+	ID         int64     `json:"id"`
+	Entity     string    `json:"entity"`
+	EntityID   int64     `json:"entity_id"`
+	Action     string    `json:"action"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Comment    string    `json:"comment"`
+	UsersID    int64     `json:"users_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type TransitionRequest struct {
+	Comment string `json:"comment"`
 }
 
 type Activities struct {
@@ -18,14 +155,98 @@ type Activities struct {
 }
 
 type Budgets struct {
-	ID          int64     `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Periode     string    `json:"periode"`
-	IsApproved  bool      `json:"is_approved"`
-	UnitsID     int64     `json:"units_id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID              int64     `json:"id"`
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	FiscalPeriodsID int64     `json:"fiscal_periods_id"`
+	Status          string    `json:"status"`
+	UnitsID         int64     `json:"units_id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type FiscalPeriods struct {
+	ID        int64     `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type PeriodOverrides struct {
+	Reason string `json:"reason"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+type BudgetCloneRequest struct {
+	FiscalPeriodsID int64   `json:"fiscal_periods_id"`
+	Name            string  `json:"name"`
+	UpliftPercent   float64 `json:"uplift_percent"`
+	ShiftTarget     bool    `json:"shift_target"`
+	DropInactive    bool    `json:"drop_inactive"`
+	ResetApprovals  bool    `json:"reset_approvals"`
+}
+
+type BudgetCloneResult struct {
+	Budget *Budgets                   `json:"budget"`
+	IDMap  map[string]map[int64]int64 `json:"id_map"`
+}
+
+type BudgetRevisions struct {
+	ID        int64           `json:"id"`
+	BudgetsID int64           `json:"budgets_id"`
+	Revision  int             `json:"revision"`
+	Note      string          `json:"note"`
+	Snapshot  *BudgetSnapshot `json:"snapshot,omitempty"`
+	UsersID   int64           `json:"users_id"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type BudgetSnapshot struct {
+	Budget      *Budgets              `json:"budget"`
+	Details     []*BudgetDetails      `json:"details"`
+	DetailPosts []*BudgetDetailsPosts `json:"detail_posts"`
+	Caps        []*BudgetCaps         `json:"caps"`
+}
+
+type RevisionRequest struct {
+	Note string `json:"note"`
+}
+
+type BudgetRevisionDiff struct {
+	From  string              `json:"from"`
+	To    string              `json:"to"`
+	Lines []*RevisionDiffLine `json:"lines"`
+}
+
+type RevisionDiffLine struct {
+	Entity  string                 `json:"entity"`
+	ID      int64                  `json:"id"`
+	Change  string                 `json:"change"`
+	Amounts map[string]AuditChange `json:"amounts,omitempty"`
+}
+
+type BudgetReallocations struct {
+	ID                  int64     `json:"id"`
+	BudgetsID           int64     `json:"budgets_id"`
+	SourceBudgetPostsID int64     `json:"source_budget_posts_id"`
+	TargetBudgetPostsID int64     `json:"target_budget_posts_id"`
+	Amount              float64   `json:"amount"`
+	Justification       string    `json:"justification"`
+	Status              string    `json:"status"`
+	UsersID             int64     `json:"users_id"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+type ReallocationFilter struct {
+	BudgetsID     int64
+	BudgetPostsID int64
+	Status        string
 }
 
 type BudgetPosts struct {
@@ -46,6 +267,76 @@ type BudgetCaps struct {
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
+type CapUtilization struct {
+	BudgetPostsID  int64   `json:"budget_posts_id"`
+	BudgetPostName string  `json:"budget_post_name"`
+	Cap            float64 `json:"cap"`
+	Planned        float64 `json:"planned"`
+	Approved       float64 `json:"approved"`
+	Available      float64 `json:"available"`
+	Committed      float64 `json:"committed"`
+	Used           float64 `json:"used"`
+	Unassigned     float64 `json:"unassigned"`
+	Unplanned      float64 `json:"unplanned"`
+}
+
+type JournalEntries struct {
+	ID          int64           `json:"id"`
+	EntryType   string          `json:"entry_type"`
+	BudgetsID   int64           `json:"budgets_id"`
+	Entity      string          `json:"entity"`
+	EntityID    int64           `json:"entity_id"`
+	Description string          `json:"description"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Lines       []*JournalLines `json:"lines"`
+}
+
+type JournalLines struct {
+	ID               int64   `json:"id"`
+	JournalEntriesID int64   `json:"journal_entries_id"`
+	Account          string  `json:"account"`
+	AccountType      string  `json:"account_type"`
+	BudgetsID        int64   `json:"budgets_id"`
+	BudgetPostsID    int64   `json:"budget_posts_id"`
+	ActivitiesID     int64   `json:"activities_id"`
+	Debit            float64 `json:"debit"`
+	Credit           float64 `json:"credit"`
+}
+
+type LedgerFilter struct {
+	BudgetsID int64
+	Entity    string
+	EntityID  int64
+	EntryType string
+}
+
+type LedgerBalances struct {
+	Account       string  `json:"account"`
+	AccountType   string  `json:"account_type"`
+	BudgetsID     int64   `json:"budgets_id"`
+	BudgetPostsID int64   `json:"budget_posts_id"`
+	ActivitiesID  int64   `json:"activities_id"`
+	Debit         float64 `json:"debit"`
+	Credit        float64 `json:"credit"`
+	Balance       float64 `json:"balance"`
+}
+
+type TrialBalance struct {
+	Accounts          []*LedgerBalances `json:"accounts"`
+	TotalDebit        float64           `json:"total_debit"`
+	TotalCredit       float64           `json:"total_credit"`
+	Balanced          bool              `json:"balanced"`
+	UnbalancedEntries []int64           `json:"unbalanced_entries"`
+}
+
+type LedgerPostSummary struct {
+	BudgetPostsID int64   `json:"budget_posts_id"`
+	Allocated     float64 `json:"allocated"`
+	Available     float64 `json:"available"`
+	Committed     float64 `json:"committed"`
+	Spent         float64 `json:"spent"`
+}
+
 type BudgetDetails struct {
 	ID           int64     `json:"id"`
 	BudgetsID    int64     `json:"budgets_id"`
@@ -73,6 +364,7 @@ type BudgetDetailsPosts struct {
 
 type FundRequests struct {
 	ID            int64     `json:"id"`
+	BudgetsID     int64     `json:"budgets_id"`
 	BudgetPostsID int64     `json:"budget_posts_id"`
 	Date          time.Time `json:"date"`
 	Type          string    `json:"type"`
@@ -82,6 +374,30 @@ type FundRequests struct {
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
+type ApprovalMatrix struct {
+	ID            int64     `json:"id"`
+	UnitsID       int64     `json:"units_id"`
+	BudgetPostsID int64     `json:"budget_posts_id"`
+	MinAmount     float64   `json:"min_amount"`
+	MaxAmount     *float64  `json:"max_amount"`
+	Level         int       `json:"level"`
+	ApproverRole  string    `json:"approver_role"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type FundRequestApprovals struct {
+	ID             int64      `json:"id"`
+	FundRequestsID int64      `json:"fund_requests_id"`
+	Level          int        `json:"level"`
+	ApproverRole   string     `json:"approver_role"`
+	Status         string     `json:"status"`
+	UsersID        int64      `json:"users_id"`
+	Comment        string     `json:"comment"`
+	DecidedAt      *time.Time `json:"decided_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
 type FundRequestDetails struct {
 	ID              int64     `json:"id"`
 	FundRequestsID  int64     `json:"fund_requests_id"`
@@ -93,6 +409,30 @@ type FundRequestDetails struct {
 	UpdatedAt       time.Time `json:"updated_at"`
 }
 
+type FundRequestSettlements struct {
+	ID                 int64                         `json:"id"`
+	FundRequestsID     int64                         `json:"fund_requests_id"`
+	Status             string                        `json:"status"`
+	AdvanceAmount      float64                       `json:"advance_amount"`
+	ActualAmount       float64                       `json:"actual_amount"`
+	Variance           float64                       `json:"variance"`
+	RefundAmount       float64                       `json:"refund_amount"`
+	ExtraPaymentAmount float64                       `json:"extra_payment_amount"`
+	Note               string                        `json:"note"`
+	UsersID            int64                         `json:"users_id"`
+	CreatedAt          time.Time                     `json:"created_at"`
+	UpdatedAt          time.Time                     `json:"updated_at"`
+	Lines              []*FundRequestSettlementLines `json:"lines"`
+}
+
+type FundRequestSettlementLines struct {
+	ID                       int64   `json:"id"`
+	FundRequestSettlementsID int64   `json:"fund_request_settlements_id"`
+	FundRequestDetailsID     int64   `json:"fund_request_details_id"`
+	Amount                   float64 `json:"amount"`
+	Description              string  `json:"description"`
+}
+
 type BudgetDetailsPostsRecommendations struct {
 	ID                   int64     `json:"id"`
 	BudgetDetailsPostsID int64     `json:"budget_details_posts_id"`
@@ -102,6 +442,47 @@ type BudgetDetailsPostsRecommendations struct {
 	UpdatedAt            time.Time `json:"updated_at"`
 }
 
+type ConsolidationPolicies struct {
+	ID        int64                        `json:"id"`
+	Name      string                       `json:"name"`
+	Method    string                       `json:"method"`
+	IsDefault bool                         `json:"is_default"`
+	Groups    []*ConsolidationPolicyGroups `json:"groups"`
+	CreatedAt time.Time                    `json:"created_at"`
+	UpdatedAt time.Time                    `json:"updated_at"`
+}
+
+type ConsolidationPolicyGroups struct {
+	UserGroupsID int64   `json:"user_groups_id"`
+	Weight       float64 `json:"weight"`
+	Rank         int     `json:"rank"`
+}
+
+type RecommendationConsolidations struct {
+	ID                      int64                   `json:"id"`
+	BudgetDetailsPostsID    int64                   `json:"budget_details_posts_id"`
+	ConsolidationPoliciesID int64                   `json:"consolidation_policies_id"`
+	Method                  string                  `json:"method"`
+	ApprovedAmount          float64                 `json:"approved_amount"`
+	Sources                 []*ConsolidationSources `json:"sources"`
+	UsersID                 int64                   `json:"users_id"`
+	Preview                 bool                    `json:"preview"`
+	CreatedAt               time.Time               `json:"created_at"`
+}
+
+type ConsolidationSources struct {
+	RecommendationsID int64   `json:"recommendations_id"`
+	UserGroupsID      int64   `json:"user_groups_id"`
+	Recommendation    float64 `json:"recommendation"`
+	Weight            float64 `json:"weight"`
+	Used              bool    `json:"used"`
+}
+
+type ConsolidateRequest struct {
+	ConsolidationPoliciesID int64 `json:"consolidation_policies_id"`
+	Preview                 bool  `json:"preview"`
+}
+
 type PrimaryKeyID struct {
 	BudgetsID                           int64 `json:"budgets_id"`
 	BudgetPostsID                       int64 `json:"budget_posts_id"`
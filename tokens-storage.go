@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errRefreshTokenReused is returned when a refresh token that was already
+// rotated is presented again; every session of its user has been ended.
+var errRefreshTokenReused = errors.New("refresh token reuse detected")
+
+type TokensStorage interface {
+	CreateRefreshToken(int64, string, time.Time) (*RefreshTokens, error)
+	GetRefreshTokenByHash(string) (*RefreshTokens, error)
+	RotateRefreshToken(int64, string, time.Time) (*RefreshTokens, error)
+	RevokeRefreshToken(int64, string) error
+	RevokeAllRefreshTokens(int64) error
+	RevokeAccessToken(string, time.Time) error
+	IsAccessTokenRevoked(string) (bool, error)
+}
+
+type TokensStore struct {
+	db *sql.DB
+}
+
+func NewTokensStorage(db *sql.DB) *TokensStore {
+	return &TokensStore{
+		db: db,
+	}
+}
+
+func scanRefreshToken(row *sql.Row) (*RefreshTokens, error) {
+	token := &RefreshTokens{}
+	var revokedAt sql.NullTime
+	var replacedBy sql.NullInt64
+	err := row.Scan(&token.ID, &token.UsersID, &token.TokenHash, &token.ExpiresAt, &revokedAt, &replacedBy, &token.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to scan refresh token: %w", err)
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	token.ReplacedBy = replacedBy.Int64
+	return token, nil
+}
+
+func (s *TokensStore) getRefreshTokenById(id int64) (*RefreshTokens, error) {
+	query := `SELECT id, users_id, token_hash, expires_at, revoked_at, replaced_by, created_at FROM refresh_tokens WHERE id = ?`
+	row := s.db.QueryRow(query, id)
+	return scanRefreshToken(row)
+}
+
+func (s *TokensStore) GetRefreshTokenByHash(tokenHash string) (*RefreshTokens, error) {
+	query := `SELECT id, users_id, token_hash, expires_at, revoked_at, replaced_by, created_at FROM refresh_tokens WHERE token_hash = ?`
+	row := s.db.QueryRow(query, tokenHash)
+	return scanRefreshToken(row)
+}
+
+func (s *TokensStore) CreateRefreshToken(usersID int64, tokenHash string, expiresAt time.Time) (*RefreshTokens, error) {
+	query := `INSERT INTO refresh_tokens (users_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)`
+	result, err := s.db.Exec(query, usersID, tokenHash, expiresAt, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert refresh token: %w", err)
+	}
+	lastInsertID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+	return s.getRefreshTokenById(lastInsertID)
+}
+
+// RotateRefreshToken revokes the given refresh token and issues its successor
+// in one transaction. The old row is locked so a token can only be rotated
+// once; when it was already rotated, even by a concurrent request, all of the
+// user's refresh tokens are revoked and errRefreshTokenReused is returned.
+func (s *TokensStore) RotateRefreshToken(id int64, newTokenHash string, expiresAt time.Time) (*RefreshTokens, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var usersID int64
+	var revokedAt sql.NullTime
+	err = tx.QueryRow(`SELECT users_id, revoked_at FROM refresh_tokens WHERE id = ? FOR UPDATE`, id).Scan(&usersID, &revokedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock refresh token: %w", err)
+	}
+	if revokedAt.Valid {
+		_, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = now() WHERE users_id = ? AND revoked_at IS NULL`, usersID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit refresh token revocation: %w", err)
+		}
+		return nil, errRefreshTokenReused
+	}
+
+	result, err := tx.Exec(`INSERT INTO refresh_tokens (users_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)`, usersID, newTokenHash, expiresAt, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert refresh token: %w", err)
+	}
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = now(), replaced_by = ? WHERE id = ?`, newID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit refresh token rotation: %w", err)
+	}
+	return s.getRefreshTokenById(newID)
+}
+
+func (s *TokensStore) RevokeRefreshToken(usersID int64, tokenHash string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = now() WHERE users_id = ? AND token_hash = ? AND revoked_at IS NULL`
+	_, err := s.db.Exec(query, usersID, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	return nil
+}
+
+func (s *TokensStore) RevokeAllRefreshTokens(usersID int64) error {
+	query := `UPDATE refresh_tokens SET revoked_at = now() WHERE users_id = ? AND revoked_at IS NULL`
+	_, err := s.db.Exec(query, usersID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+func (s *TokensStore) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < now()`)
+	if err != nil {
+		return fmt.Errorf("failed to purge revoked tokens: %w", err)
+	}
+
+	query := `INSERT IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)`
+	_, err = s.db.Exec(query, jti, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	return nil
+}
+
+func (s *TokensStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	query := `SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?`
+	var count int
+	if err := s.db.QueryRow(query, jti).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check revoked token: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Budget API"
+}
+
+// completeLogin is the last step shared by password and single sign-on
+// logins. Users with 2FA, or whose role requires it, get a challenge token
+// instead of the real tokens.
+func (s *APIServer) completeLogin(user *Users) (map[string]interface{}, error) {
+	usersID := int64(user.ID)
+
+	twoFactor, err := s.Storage.TwoFactorStorage.Get(usersID)
+	if err != nil {
+		return nil, err
+	}
+
+	purpose := ""
+	status := ""
+	if twoFactor != nil && twoFactor.Enabled {
+		purpose, status = challengePurposeVerify, "2fa_required"
+	} else {
+		required, err := s.Storage.TwoFactorStorage.IsRequired(usersID)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			purpose, status = challengePurposeEnroll, "2fa_enrollment_required"
+		}
+	}
+
+	if purpose != "" {
+		challengeToken, err := CreateChallengeJwt(usersID, purpose)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"status":          status,
+			"challenge_token": challengeToken,
+			"expires_in":      int64(challengeTokenTTL().Seconds()),
+		}, nil
+	}
+
+	tokens, err := s.issueTokens(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove sensitive information
+	tokens["status"] = "success"
+	tokens["user"] = Users{
+		ID:       user.ID,
+		UserID:   user.UserID,
+		Password: "***",
+		IsActive: user.IsActive,
+	}
+	return tokens, nil
+}
+
+// twoFactorMissing reports whether one of the user's roles requires 2FA
+// that the user has not enabled.
+func (s *APIServer) twoFactorMissing(usersID int64) (bool, error) {
+	twoFactor, err := s.Storage.TwoFactorStorage.Get(usersID)
+	if err != nil {
+		return false, err
+	}
+	if twoFactor != nil && twoFactor.Enabled {
+		return false, nil
+	}
+	return s.Storage.TwoFactorStorage.IsRequired(usersID)
+}
+
+// verifySecondFactor checks either a TOTP code or an unused recovery code.
+func (s *APIServer) verifySecondFactor(twoFactor *TwoFactor, reqBody *TwoFactorRequest) (bool, error) {
+	if reqBody.RecoveryCode != "" {
+		return s.Storage.TwoFactorStorage.UseRecoveryCode(twoFactor.UsersID, hashToken(normalizeRecoveryCode(reqBody.RecoveryCode)))
+	}
+
+	counter, ok := verifyTOTP(twoFactor.Secret, reqBody.Code, twoFactor.LastCounter, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return s.Storage.TwoFactorStorage.UseCounter(twoFactor.UsersID, counter)
+}
+
+func (s *APIServer) startTwoFactorEnrollment(usersID int64) (map[string]interface{}, error) {
+	user, err := s.Storage.UsersStorage.GetById(usersID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Storage.TwoFactorStorage.SetSecret(usersID, secret); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"status":      "success",
+		"secret":      secret,
+		"otpauth_uri": totpProvisioningURI(totpIssuer(), user.UserID, secret),
+	}, nil
+}
+
+// activateTwoFactor confirms the pending secret with a first code and
+// returns freshly generated recovery codes, which are shown only once.
+func (s *APIServer) activateTwoFactor(usersID int64, code string) ([]string, error) {
+	twoFactor, err := s.Storage.TwoFactorStorage.Get(usersID)
+	if err != nil {
+		return nil, fmt.Errorf("database error")
+	}
+	if twoFactor == nil || twoFactor.Secret == "" {
+		return nil, fmt.Errorf("two factor enrollment not started")
+	}
+	if twoFactor.Enabled {
+		return nil, fmt.Errorf("two factor authentication already enabled")
+	}
+
+	counter, ok := verifyTOTP(twoFactor.Secret, code, twoFactor.LastCounter, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("invalid code")
+	}
+
+	recoveryCodes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, recoveryCode := range recoveryCodes {
+		hashes = append(hashes, hashToken(recoveryCode))
+	}
+
+	if err := s.Storage.TwoFactorStorage.Enable(usersID, counter, hashes); err != nil {
+		return nil, fmt.Errorf("database error")
+	}
+	return recoveryCodes, nil
+}
+
+func (s *APIServer) UserLoginTwoFactor(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &TwoFactorRequest{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "failed to decode request body", err)
+	}
+	if reqBody.Code == "" && reqBody.RecoveryCode == "" {
+		return respondWithError(requestLog, "code or recovery code must be filled", nil)
+	}
+
+	claims, err := validateChallengeJwt(reqBody.ChallengeToken, challengePurposeVerify)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+	if err := s.checkTokenRevocation(claims); err != nil {
+		return respondWithError(requestLog, "invalid challenge token", err)
+	}
+	usersID, _ := claims.UsersID()
+
+	user, err := s.Storage.UsersStorage.GetById(usersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if user == nil || !user.IsActive {
+		return respondWithError(requestLog, "user is inactive", nil)
+	}
+
+	// Codes share the password throttle so the six digits cannot be guessed.
+	jobID := r.Header.Get("jobID")
+	clientIP := ClientIP(r)
+	if err := s.checkLoginAllowed(user.UserID, clientIP); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	twoFactor, err := s.Storage.TwoFactorStorage.Get(usersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if twoFactor == nil || !twoFactor.Enabled {
+		return respondWithError(requestLog, "two factor authentication not enabled", nil)
+	}
+
+	verified, err := s.verifySecondFactor(twoFactor, reqBody)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if !verified {
+		s.recordLoginFailure(jobID, user.UserID, clientIP)
+		return respondWithError(requestLog, "invalid code", nil)
+	}
+	if err := s.Storage.LoginFailuresStorage.Reset(loginSubjectUser, user.UserID); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	// The challenge is single use.
+	if err := s.Storage.TokensStorage.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	tokens, err := s.issueTokens(user)
+	if err != nil {
+		return respondWithError(requestLog, "error creating JWT", err)
+	}
+	tokens["status"] = "success"
+	return respondWithSuccessStruct(requestLog, tokens)
+}
+
+func (s *APIServer) UserLoginTwoFactorEnroll(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &TwoFactorRequest{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "failed to decode request body", err)
+	}
+
+	claims, err := validateChallengeJwt(reqBody.ChallengeToken, challengePurposeEnroll)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+	if err := s.checkTokenRevocation(claims); err != nil {
+		return respondWithError(requestLog, "invalid challenge token", err)
+	}
+	usersID, _ := claims.UsersID()
+
+	enrollment, err := s.startTwoFactorEnrollment(usersID)
+	if err != nil {
+		return respondWithError(requestLog, "failed to start enrollment", err)
+	}
+	return respondWithSuccessStruct(requestLog, enrollment)
+}
+
+func (s *APIServer) UserLoginTwoFactorActivate(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &TwoFactorRequest{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "failed to decode request body", err)
+	}
+	if reqBody.Code == "" {
+		return respondWithError(requestLog, "code must be filled", nil)
+	}
+
+	claims, err := validateChallengeJwt(reqBody.ChallengeToken, challengePurposeEnroll)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+	if err := s.checkTokenRevocation(claims); err != nil {
+		return respondWithError(requestLog, "invalid challenge token", err)
+	}
+	usersID, _ := claims.UsersID()
+
+	user, err := s.Storage.UsersStorage.GetById(usersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if user == nil || !user.IsActive {
+		return respondWithError(requestLog, "user is inactive", nil)
+	}
+
+	recoveryCodes, err := s.activateTwoFactor(usersID, reqBody.Code)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	if err := s.Storage.TokensStorage.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	tokens, err := s.issueTokens(user)
+	if err != nil {
+		return respondWithError(requestLog, "error creating JWT", err)
+	}
+	tokens["status"] = "success"
+	tokens["recovery_codes"] = recoveryCodes
+	return respondWithSuccessStruct(requestLog, tokens)
+}
+
+func (s *APIServer) UserTwoFactorEnroll(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	claims, err := s.GetUserClaims(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+	if claims.APIKeyID > 0 {
+		return respondWithError(requestLog, "not available for api keys", nil)
+	}
+	usersID, err := claims.UsersID()
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	enrollment, err := s.startTwoFactorEnrollment(usersID)
+	if err != nil {
+		return respondWithError(requestLog, "failed to start enrollment", err)
+	}
+	return respondWithSuccessStruct(requestLog, enrollment)
+}
+
+func (s *APIServer) UserTwoFactorActivate(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	claims, err := s.GetUserClaims(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+	if claims.APIKeyID > 0 {
+		return respondWithError(requestLog, "not available for api keys", nil)
+	}
+	usersID, err := claims.UsersID()
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	reqBody := &TwoFactorRequest{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "failed to decode request body", err)
+	}
+	if reqBody.Code == "" {
+		return respondWithError(requestLog, "code must be filled", nil)
+	}
+
+	recoveryCodes, err := s.activateTwoFactor(usersID, reqBody.Code)
+	if err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	return respondWithSuccessStruct(requestLog, map[string]interface{}{
+		"status":         "success",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+func (s *APIServer) UserTwoFactorDisable(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	claims, err := s.GetUserClaims(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+	if claims.APIKeyID > 0 {
+		return respondWithError(requestLog, "not available for api keys", nil)
+	}
+	usersID, err := claims.UsersID()
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	reqBody := &TwoFactorRequest{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "failed to decode request body", err)
+	}
+	if reqBody.Code == "" && reqBody.RecoveryCode == "" {
+		return respondWithError(requestLog, "code or recovery code must be filled", nil)
+	}
+
+	required, err := s.Storage.TwoFactorStorage.IsRequired(usersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if required {
+		return respondWithError(requestLog, "two factor authentication is required for your role", nil)
+	}
+
+	twoFactor, err := s.Storage.TwoFactorStorage.Get(usersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if twoFactor == nil || !twoFactor.Enabled {
+		return respondWithError(requestLog, "two factor authentication not enabled", nil)
+	}
+
+	verified, err := s.verifySecondFactor(twoFactor, reqBody)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if !verified {
+		return respondWithError(requestLog, "invalid code", nil)
+	}
+
+	if err := s.Storage.TwoFactorStorage.Disable(usersID); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	return respondWithSuccess(requestLog, "two factor authentication disabled")
+}
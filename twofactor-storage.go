@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type TwoFactorStorage interface {
+	Get(int64) (*TwoFactor, error)
+	SetSecret(int64, string) error
+	Enable(int64, int64, []string) error
+	Disable(int64) error
+	UseCounter(int64, int64) (bool, error)
+	UseRecoveryCode(int64, string) (bool, error)
+	IsRequired(int64) (bool, error)
+}
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+func NewTwoFactorStorage(db *sql.DB) *TwoFactorStore {
+	return &TwoFactorStore{
+		db: db,
+	}
+}
+
+func (s *TwoFactorStore) Get(usersID int64) (*TwoFactor, error) {
+	query := `SELECT id, totp_secret, totp_enabled, totp_last_counter FROM users WHERE id = ?`
+	twoFactor := &TwoFactor{}
+	var secret sql.NullString
+	var lastCounter sql.NullInt64
+	err := s.db.QueryRow(query, usersID).Scan(&twoFactor.UsersID, &secret, &twoFactor.Enabled, &lastCounter)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get two factor settings: %w", err)
+	}
+	twoFactor.Secret = secret.String
+	twoFactor.LastCounter = lastCounter.Int64
+	return twoFactor, nil
+}
+
+// SetSecret stores a pending secret. It never overwrites an enabled one, so a
+// stolen session cannot silently re-enroll the account.
+func (s *TwoFactorStore) SetSecret(usersID int64, secret string) error {
+	query := `UPDATE users SET totp_secret = ?, totp_last_counter = NULL WHERE id = ? AND totp_enabled = 0`
+	result, err := s.db.Exec(query, secret, usersID)
+	if err != nil {
+		return fmt.Errorf("failed to set totp secret: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return fmt.Errorf("two factor authentication already enabled")
+	}
+	return nil
+}
+
+// Enable activates the pending secret and replaces the recovery codes.
+func (s *TwoFactorStore) Enable(usersID int64, counter int64, recoveryCodeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_enabled = 1, totp_last_counter = ? WHERE id = ?`, counter, usersID)
+	if err != nil {
+		return fmt.Errorf("failed to enable totp: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM user_recovery_codes WHERE users_id = ?`, usersID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(`INSERT INTO user_recovery_codes (users_id, code_hash, created_at) VALUES (?, ?, now())`, usersID, codeHash)
+		if err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *TwoFactorStore) Disable(usersID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_counter = NULL WHERE id = ?`, usersID)
+	if err != nil {
+		return fmt.Errorf("failed to disable totp: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM user_recovery_codes WHERE users_id = ?`, usersID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UseCounter records the accepted time step. It reports false when the same
+// or a later step was already used, which makes every code single use.
+func (s *TwoFactorStore) UseCounter(usersID int64, counter int64) (bool, error) {
+	query := `UPDATE users SET totp_last_counter = ? WHERE id = ? AND (totp_last_counter IS NULL OR totp_last_counter < ?)`
+	result, err := s.db.Exec(query, counter, usersID, counter)
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp counter: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp counter: %w", err)
+	}
+	return affected > 0, nil
+}
+
+func (s *TwoFactorStore) UseRecoveryCode(usersID int64, codeHash string) (bool, error) {
+	query := `UPDATE user_recovery_codes SET used_at = now() WHERE users_id = ? AND code_hash = ? AND used_at IS NULL`
+	result, err := s.db.Exec(query, usersID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// IsRequired reports whether any of the user's roles requires 2FA.
+func (s *TwoFactorStore) IsRequired(usersID int64) (bool, error) {
+	query := `SELECT COUNT(*) FROM user_roles ur JOIN roles r ON r.id = ur.roles_id WHERE ur.users_id = ? AND r.require_2fa = 1`
+	var count int
+	if err := s.db.QueryRow(query, usersID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check two factor requirement: %w", err)
+	}
+	return count > 0, nil
+}
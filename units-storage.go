@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// unitScopeCTE expands the units a user is attached to into the full set of
+// units they may access (the units themselves and all of their descendants).
+// Queries prepend it and filter with `units_id IN (SELECT id FROM scoped_units)`;
+// the user id is always the first query argument.
+const unitScopeCTE = `WITH RECURSIVE scoped_units AS (
+	SELECT units_id AS id FROM user_units WHERE users_id = ?
+	UNION
+	SELECT u.id FROM units u JOIN scoped_units su ON u.parent_id = su.id
+) `
+
+type UnitsStorage interface {
+	GetByUser(int64) ([]int64, error)
+	GetScopeByUser(int64) ([]int64, error)
+	InScope(int64, int64) (bool, error)
+}
+
+type UnitsStore struct {
+	db *sql.DB
+}
+
+func NewUnitsStorage(db *sql.DB) *UnitsStore {
+	return &UnitsStore{
+		db: db,
+	}
+}
+
+func (s *UnitsStore) GetByUser(usersID int64) ([]int64, error) {
+	query := `SELECT units_id FROM user_units WHERE users_id = ? ORDER BY units_id`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user units: %w", err)
+	}
+	defer rows.Close()
+	return scanUnitIDs(rows)
+}
+
+func (s *UnitsStore) GetScopeByUser(usersID int64) ([]int64, error) {
+	query := unitScopeCTE + `SELECT id FROM scoped_units`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user units: %w", err)
+	}
+	defer rows.Close()
+	return scanUnitIDs(rows)
+}
+
+func scanUnitIDs(rows *sql.Rows) ([]int64, error) {
+	unitsID := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user unit: %w", err)
+		}
+		unitsID = append(unitsID, id)
+	}
+	return unitsID, nil
+}
+
+func (s *UnitsStore) InScope(usersID int64, unitsID int64) (bool, error) {
+	query := unitScopeCTE + `SELECT COUNT(*) FROM scoped_units WHERE id = ?`
+	var count int
+	if err := s.db.QueryRow(query, usersID, unitsID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check unit scope: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Usage of a budget detail post is the sum of the fund request detail lines
+// booked on its budget detail by disbursed or settled fund requests of its
+// budget post. Once the request's settlement is approved, the settled amount
+// of each line counts instead (nothing for a line the settlement leaves out).
+// Each line names its budget detail, so details that share an activity do not
+// count each other's spending. The part of a request that no detail line
+// covers is not usage of any detail post; cap utilization reports it as
+// unassigned. Usage is recomputed rather than adjusted by deltas, so repeating
+// a refresh is harmless.
+const usageAmountQuery = `SELECT COALESCE(SUM(CASE WHEN st.id IS NULL THEN frd.amount ELSE COALESCE(sl.amount, 0) END), 0)
+	FROM fund_request_details frd
+	JOIN fund_requests fr ON fr.id = frd.fund_requests_id
+	LEFT JOIN fund_request_settlements st ON st.fund_requests_id = fr.id AND st.status IN (?, ?)
+	LEFT JOIN fund_request_settlement_lines sl ON sl.fund_request_settlements_id = st.id AND sl.fund_request_details_id = frd.id
+	WHERE frd.budget_details_id = bdp.budget_details_id AND fr.budget_posts_id = bdp.budget_posts_id
+	AND fr.status IN (?, ?)`
+
+// usageAmountArgs fills the placeholders of usageAmountQuery.
+var usageAmountArgs = []any{settlementStatusApproved, settlementStatusSettled, fundRequestStatusDisbursed, fundRequestStatusSettled}
+
+// refreshUsage recomputes usage for the detail posts of the given budget
+// detail and budget post.
+func refreshUsage(tx *sql.Tx, budgetDetailsID int64, budgetPostsID int64) error {
+	query := `UPDATE budget_details_posts bdp SET usage_amount = (` + usageAmountQuery + `), updated_at = now()
+		WHERE bdp.budget_details_id = ? AND bdp.budget_posts_id = ?`
+	args := append(append([]any{}, usageAmountArgs...), budgetDetailsID, budgetPostsID)
+	_, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to refresh usage: %w", err)
+	}
+	return nil
+}
+
+// refreshFundRequestUsage recomputes usage for every detail post of the
+// budget post a fund request draws on.
+func refreshFundRequestUsage(tx *sql.Tx, fundRequestsID int64) error {
+	query := `UPDATE budget_details_posts bdp
+		JOIN budget_details d ON d.id = bdp.budget_details_id
+		JOIN fund_requests fr ON fr.budgets_id = d.budgets_id AND fr.budget_posts_id = bdp.budget_posts_id
+		SET bdp.usage_amount = (` + usageAmountQuery + `), bdp.updated_at = now()
+		WHERE fr.id = ?`
+	args := append(append([]any{}, usageAmountArgs...), fundRequestsID)
+	_, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to refresh usage: %w", err)
+	}
+	return nil
+}
+
+// runRecalculateUsage rebuilds the usage of every detail post from fund
+// request history.
+func runRecalculateUsage(storage BudgetDetailsPostsStorage) int {
+	updated, err := storage.RecalculateUsage()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "recalculate-usage:", err)
+		return 1
+	}
+
+	output, _ := json.MarshalIndent(map[string]int64{"updated": updated}, "", "  ")
+	fmt.Println(string(output))
+	return 0
+}
@@ -3,41 +3,291 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 )
 
+func validatePasswordRequest(reqBody *PasswordRequest) error {
+	if reqBody.OldPassword == "" {
+		return fmt.Errorf("old password must be filled")
+	} else if len(reqBody.NewPassword) < 8 {
+		return fmt.Errorf("new password must be at least 8 characters")
+	} else if reqBody.NewPassword == reqBody.OldPassword {
+		return fmt.Errorf("new password must differ from old password")
+	}
+	return nil
+}
+
+func (s *APIServer) createAccessToken(user *Users) (string, error) {
+	usersID := int64(user.ID)
+
+	roles, err := s.Storage.UsersStorage.GetRoles(usersID)
+	if err != nil {
+		return "", err
+	}
+
+	units, err := s.Storage.UnitsStorage.GetByUser(usersID)
+	if err != nil {
+		return "", err
+	}
+
+	return CreateJwt(usersID, roles, units)
+}
+
+func (s *APIServer) issueTokens(user *Users) (map[string]interface{}, error) {
+	tokenJwt, err := s.createAccessToken(user)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.Storage.TokensStorage.CreateRefreshToken(int64(user.ID), hashToken(refreshToken), time.Now().Add(refreshTokenTTL()))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"token":         tokenJwt,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int64(accessTokenTTL().Seconds()),
+	}, nil
+}
+
 func (s *APIServer) UserLogin(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
 
 	AppLog("username login")
 
+	if !s.LocalLoginEnabled {
+		return respondWithError(requestLog, "local login is disabled, use single sign-on", nil)
+	}
+
 	reqBody := &Users{}
 	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
 		return respondWithError(requestLog, "failed to decode request body", err)
 	}
 
+	jobID := r.Header.Get("jobID")
+	clientIP := ClientIP(r)
+	if err := s.checkLoginAllowed(reqBody.UserID, clientIP); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
 	user, err := s.Storage.UsersStorage.GetByLogin(reqBody)
 	if err != nil {
 		return respondWithError(requestLog, "database error", err)
 	}
 	if user == nil {
+		s.recordLoginFailure(jobID, reqBody.UserID, clientIP)
 		return respondWithError(requestLog, "user not found", nil)
 	}
+	if err := s.Storage.LoginFailuresStorage.Reset(loginSubjectUser, reqBody.UserID); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if !user.IsActive {
+		return respondWithError(requestLog, "user is inactive", nil)
+	}
 
-	tokenJwt, err := CreateJwt(user)
+	result, err := s.completeLogin(user)
 	if err != nil {
 		return respondWithError(requestLog, "error creating JWT", err)
 	}
+	return respondWithSuccessStruct(requestLog, result)
+}
 
-	// Remove sensitive information
-	sanitizedUser := Users{
-		ID:       user.ID,
-		UserID:   user.UserID,
-		Password: "***",
+func (s *APIServer) UserRefresh(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &TokenRequest{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "failed to decode request body", err)
+	}
+	if reqBody.RefreshToken == "" {
+		return respondWithError(requestLog, "refresh token must be filled", nil)
+	}
+
+	stored, err := s.Storage.TokensStorage.GetRefreshTokenByHash(hashToken(reqBody.RefreshToken))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if stored == nil {
+		return respondWithError(requestLog, "invalid refresh token", nil)
+	}
+
+	if stored.RevokedAt != nil {
+		// A rotated token presented again has most likely leaked, so every
+		// session of the user is ended.
+		if err := s.Storage.TokensStorage.RevokeAllRefreshTokens(stored.UsersID); err != nil {
+			return respondWithError(requestLog, "database error", err)
+		}
+		return respondWithError(requestLog, "refresh token reuse detected", nil)
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return respondWithError(requestLog, "refresh token expired", nil)
+	}
+
+	user, err := s.Storage.UsersStorage.GetById(stored.UsersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if user == nil || !user.IsActive {
+		return respondWithError(requestLog, "user is inactive", nil)
+	}
+	if user.TokensValidAfter != nil && stored.CreatedAt.Before(*user.TokensValidAfter) {
+		return respondWithError(requestLog, "refresh token revoked", nil)
+	}
+
+	// A role may have started requiring 2FA after this session logged in;
+	// such users have to log in again and enroll.
+	missing, err := s.twoFactorMissing(int64(user.ID))
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if missing {
+		return respondWithError(requestLog, "2fa enrollment required, log in again", nil)
+	}
+
+	refreshToken, err := newRandomToken(32)
+	if err != nil {
+		return respondWithError(requestLog, "error creating refresh token", err)
+	}
+
+	_, err = s.Storage.TokensStorage.RotateRefreshToken(stored.ID, hashToken(refreshToken), time.Now().Add(refreshTokenTTL()))
+	if err == errRefreshTokenReused {
+		return respondWithError(requestLog, "refresh token reuse detected", nil)
+	}
+	if err != nil {
+		return respondWithError(requestLog, "invalid refresh token", err)
+	}
+
+	tokenJwt, err := s.createAccessToken(user)
+	if err != nil {
+		return respondWithError(requestLog, "error creating JWT", err)
 	}
 
 	return respondWithSuccessStruct(requestLog, map[string]interface{}{
-		"status": "success",
-		"user":   sanitizedUser,
-		"token":  tokenJwt,
+		"status":        "success",
+		"token":         tokenJwt,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int64(accessTokenTTL().Seconds()),
 	})
 }
+
+func (s *APIServer) UserLogout(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	claims, err := s.GetUserClaims(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+	if claims.APIKeyID > 0 {
+		return respondWithError(requestLog, "not available for api keys", nil)
+	}
+
+	reqBody := &TokenRequest{}
+	if len(bodyBytes) > 0 {
+		if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+			return respondWithError(requestLog, "failed to decode request body", err)
+		}
+	}
+
+	usersID, err := claims.UsersID()
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	if err := s.Storage.TokensStorage.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	if reqBody.All {
+		err = s.Storage.TokensStorage.RevokeAllRefreshTokens(usersID)
+	} else if reqBody.RefreshToken != "" {
+		err = s.Storage.TokensStorage.RevokeRefreshToken(usersID, hashToken(reqBody.RefreshToken))
+	}
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, "logged out")
+}
+
+func (s *APIServer) UserChangePassword(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	claims, err := s.GetUserClaims(r)
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+	if claims.APIKeyID > 0 {
+		return respondWithError(requestLog, "not available for api keys", nil)
+	}
+
+	usersID, err := claims.UsersID()
+	if err != nil {
+		return respondWithError(requestLog, "unauthorized", err)
+	}
+
+	reqBody := &PasswordRequest{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "failed to decode request body", err)
+	}
+
+	if err := validatePasswordRequest(reqBody); err != nil {
+		return respondWithError(requestLog, err.Error(), nil)
+	}
+
+	user, err := s.Storage.UsersStorage.GetById(usersID)
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if user == nil {
+		return respondWithError(requestLog, "user not found", nil)
+	}
+
+	verified, err := s.Storage.UsersStorage.GetByLogin(&Users{UserID: user.UserID, Password: reqBody.OldPassword})
+	if err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+	if verified == nil {
+		return respondWithError(requestLog, "old password is incorrect", nil)
+	}
+
+	if _, err := s.Storage.UsersStorage.UpdatePassword(usersID, reqBody.NewPassword); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	if err := s.Storage.TokensStorage.RevokeAllRefreshTokens(usersID); err != nil {
+		return respondWithError(requestLog, "database error", err)
+	}
+
+	return respondWithSuccess(requestLog, "password changed, please login again")
+}
+
+func (s *APIServer) UserUnlock(w http.ResponseWriter, r *http.Request, bodyBytes []byte, requestLog map[string]interface{}) (interface{}, error) {
+
+	reqBody := &UnlockRequest{}
+	if err := json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(reqBody); err != nil {
+		return respondWithError(requestLog, "failed to decode request body", err)
+	}
+	if reqBody.UserID == "" && reqBody.ClientIP == "" {
+		return respondWithError(requestLog, "userid or client ip must be filled", nil)
+	}
+
+	if reqBody.UserID != "" {
+		if err := s.Storage.LoginFailuresStorage.Reset(loginSubjectUser, reqBody.UserID); err != nil {
+			return respondWithError(requestLog, "database error", err)
+		}
+	}
+	if reqBody.ClientIP != "" {
+		if err := s.Storage.LoginFailuresStorage.Reset(loginSubjectIP, reqBody.ClientIP); err != nil {
+			return respondWithError(requestLog, "database error", err)
+		}
+	}
+
+	AppLog("JobID ", r.Header.Get("jobID"), " login unlocked for user ", reqBody.UserID, " ip ", reqBody.ClientIP)
+	return respondWithSuccess(requestLog, reqBody)
+}
@@ -5,10 +5,15 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"time"
 )
 
 type UsersStorage interface {
 	GetByLogin(*Users) (*Users, error)
+	GetById(int64) (*Users, error)
+	GetByUserID(string) (*Users, error)
+	UpdatePassword(int64, string) (*Users, error)
+	GetRoles(int64) ([]string, error)
 }
 
 type UsersStore struct {
@@ -21,23 +26,90 @@ func NewUsersStorage(db *sql.DB) *UsersStore {
 	}
 }
 
+func scanUser(row *sql.Row) (*Users, error) {
+	user := &Users{}
+	var tokensValidAfter sql.NullTime
+	err := row.Scan(&user.ID, &user.UserID, &user.Password, &user.IsActive, &tokensValidAfter)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to scan user: %w", err)
+	}
+	if tokensValidAfter.Valid {
+		user.TokensValidAfter = &tokensValidAfter.Time
+	}
+	return user, nil
+}
+
 func (s *UsersStore) GetByLogin(user *Users) (*Users, error) {
 	hashedPassword := MD5Hash(user.Password)
 
-	query := `SELECT id, userid, password FROM users WHERE userid = ? AND password = ?`
+	query := `SELECT id, userid, password, is_active, tokens_valid_after FROM users WHERE userid = ? AND password = ?`
 	row := s.db.QueryRow(query, user.UserID, hashedPassword)
 
-	retrievedUser := &Users{}
-	err := row.Scan(&retrievedUser.ID, &retrievedUser.UserID, &retrievedUser.Password)
+	retrievedUser, err := scanUser(row)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
 		return nil, fmt.Errorf("failed to get user by login: %w", err)
 	}
 	return retrievedUser, nil
 }
 
+func (s *UsersStore) GetById(id int64) (*Users, error) {
+	query := `SELECT id, userid, password, is_active, tokens_valid_after FROM users WHERE id = ?`
+	row := s.db.QueryRow(query, id)
+
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by id: %w", err)
+	}
+	return user, nil
+}
+
+func (s *UsersStore) GetByUserID(userID string) (*Users, error) {
+	query := `SELECT id, userid, password, is_active, tokens_valid_after FROM users WHERE userid = ?`
+	row := s.db.QueryRow(query, userID)
+
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by userid: %w", err)
+	}
+	return user, nil
+}
+
+// UpdatePassword also moves tokens_valid_after forward so every token issued
+// before the change stops being accepted. The time comes from the API clock in
+// UTC, truncated to whole seconds like the iat of our tokens, so it compares
+// correctly with them whatever the database time zone. Tokens issued in the
+// same second stay valid, which includes any issued right after the change.
+func (s *UsersStore) UpdatePassword(id int64, password string) (*Users, error) {
+	query := `UPDATE users SET password = ?, tokens_valid_after = ? WHERE id = ?`
+	_, err := s.db.Exec(query, MD5Hash(password), time.Now().UTC().Truncate(time.Second), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user password: %w", err)
+	}
+	return s.GetById(id)
+}
+
+func (s *UsersStore) GetRoles(usersID int64) ([]string, error) {
+	query := `SELECT r.name FROM roles r JOIN user_roles ur ON ur.roles_id = r.id WHERE ur.users_id = ? ORDER BY r.name`
+	rows, err := s.db.Query(query, usersID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("failed to scan user role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
 func MD5Hash(password string) string {
 	hash := md5.Sum([]byte(password))
 	return hex.EncodeToString(hash[:])
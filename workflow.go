@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Transition moves an entity from one of From to To. When Roles is empty any
+// user with access to the entity may perform it.
+type Transition struct {
+	From            []string
+	To              string
+	Roles           []string
+	CommentRequired bool
+}
+
+type Workflow struct {
+	Entity      string
+	Transitions map[string]Transition
+}
+
+// Next validates action against the current status and returns the target
+// status. Roles are checked separately by Authorize.
+func (w *Workflow) Next(action string, current string, comment string) (string, error) {
+	transition, ok := w.Transitions[action]
+	if !ok {
+		return "", fmt.Errorf("unknown action: %s", action)
+	}
+
+	if transition.CommentRequired && strings.TrimSpace(comment) == "" {
+		return "", fmt.Errorf("comment must be filled")
+	}
+
+	for _, from := range transition.From {
+		if from == current {
+			return transition.To, nil
+		}
+	}
+	return "", fmt.Errorf("cannot %s %s in status %s", strings.ReplaceAll(action, "-", " "), w.Entity, current)
+}
+
+func (w *Workflow) Authorize(action string, claims *UserClaims) error {
+	transition := w.Transitions[action]
+	if len(transition.Roles) > 0 && !claims.HasRole(transition.Roles...) {
+		return fmt.Errorf("action %s requires role %s", action, strings.Join(transition.Roles, " or "))
+	}
+	return nil
+}
+
+const (
+	budgetStatusDraft             = "draft"
+	budgetStatusSubmitted         = "submitted"
+	budgetStatusUnderReview       = "under_review"
+	budgetStatusRevisionRequested = "revision_requested"
+	budgetStatusApproved          = "approved"
+	budgetStatusRejected          = "rejected"
+)
+
+var budgetWorkflow = &Workflow{
+	Entity: "budgets",
+	Transitions: map[string]Transition{
+		"submit": {
+			From:            []string{budgetStatusDraft, budgetStatusRevisionRequested},
+			To:              budgetStatusSubmitted,
+			CommentRequired: true,
+		},
+		"review": {
+			From:            []string{budgetStatusSubmitted},
+			To:              budgetStatusUnderReview,
+			Roles:           []string{"budget_reviewer", "budget_approver"},
+			CommentRequired: true,
+		},
+		"request-revision": {
+			From:            []string{budgetStatusSubmitted, budgetStatusUnderReview},
+			To:              budgetStatusRevisionRequested,
+			Roles:           []string{"budget_reviewer", "budget_approver"},
+			CommentRequired: true,
+		},
+		"approve": {
+			From:            []string{budgetStatusUnderReview},
+			To:              budgetStatusApproved,
+			Roles:           []string{"budget_approver"},
+			CommentRequired: true,
+		},
+		"reject": {
+			From:            []string{budgetStatusSubmitted, budgetStatusUnderReview},
+			To:              budgetStatusRejected,
+			Roles:           []string{"budget_approver"},
+			CommentRequired: true,
+		},
+		// Amending reopens an approved budget for changes, e.g. mid-year; it
+		// goes through review and approval again.
+		"amend": {
+			From:            []string{budgetStatusApproved},
+			To:              budgetStatusRevisionRequested,
+			Roles:           []string{"budget_approver"},
+			CommentRequired: true,
+		},
+	},
+}
+
+// budgetEditable reports whether the budget's own fields may still change.
+func budgetEditable(status string) bool {
+	return status == budgetStatusDraft || status == budgetStatusRevisionRequested
+}
+
+const (
+	fundRequestStatusDraft     = "draft"
+	fundRequestStatusSubmitted = "submitted"
+	fundRequestStatusVerified  = "verified"
+	fundRequestStatusApproved  = "approved"
+	fundRequestStatusDisbursed = "disbursed"
+	fundRequestStatusSettled   = "settled"
+	fundRequestStatusRejected  = "rejected"
+	fundRequestStatusCancelled = "cancelled"
+)
+
+// A disbursed request is settled through its settlement, see
+// settlementWorkflow.
+var fundRequestWorkflow = &Workflow{
+	Entity: "fund-requests",
+	Transitions: map[string]Transition{
+		"submit": {
+			From: []string{fundRequestStatusDraft},
+			To:   fundRequestStatusSubmitted,
+		},
+		"verify": {
+			From:  []string{fundRequestStatusSubmitted},
+			To:    fundRequestStatusVerified,
+			Roles: []string{"fund_verifier"},
+		},
+		// Approve and reject of a verified request are decisions on the
+		// current approval matrix level, authorized by that level's role.
+		"approve": {
+			From: []string{fundRequestStatusVerified},
+			To:   fundRequestStatusApproved,
+		},
+		"disburse": {
+			From:  []string{fundRequestStatusApproved},
+			To:    fundRequestStatusDisbursed,
+			Roles: []string{"treasurer"},
+		},
+		"reject": {
+			From:            []string{fundRequestStatusSubmitted, fundRequestStatusVerified},
+			To:              fundRequestStatusRejected,
+			Roles:           []string{"fund_verifier", "fund_approver"},
+			CommentRequired: true,
+		},
+		"cancel": {
+			From:            []string{fundRequestStatusDraft, fundRequestStatusSubmitted},
+			To:              fundRequestStatusCancelled,
+			CommentRequired: true,
+		},
+	},
+}
+
+const (
+	reallocationStatusSubmitted = "submitted"
+	reallocationStatusApproved  = "approved"
+	reallocationStatusRejected  = "rejected"
+	reallocationStatusCancelled = "cancelled"
+)
+
+// reallocationWorkflow moves cap between budget posts; approval is what
+// changes the caps.
+var reallocationWorkflow = &Workflow{
+	Entity: "budget-reallocations",
+	Transitions: map[string]Transition{
+		"approve": {
+			From:  []string{reallocationStatusSubmitted},
+			To:    reallocationStatusApproved,
+			Roles: []string{"budget_approver"},
+		},
+		"reject": {
+			From:            []string{reallocationStatusSubmitted},
+			To:              reallocationStatusRejected,
+			Roles:           []string{"budget_approver"},
+			CommentRequired: true,
+		},
+		"cancel": {
+			From:            []string{reallocationStatusSubmitted},
+			To:              reallocationStatusCancelled,
+			CommentRequired: true,
+		},
+	},
+}
+
+const (
+	settlementStatusDraft             = "draft"
+	settlementStatusSubmitted         = "submitted"
+	settlementStatusRevisionRequested = "revision_requested"
+	settlementStatusApproved          = "approved"
+	settlementStatusSettled           = "settled"
+)
+
+// settlementWorkflow reports what a disbursed fund request actually spent.
+// Approval switches usage to the actual amounts; settle confirms the refund
+// or extra payment and closes the fund request.
+var settlementWorkflow = &Workflow{
+	Entity: "fund-request-settlements",
+	Transitions: map[string]Transition{
+		"submit": {
+			From: []string{settlementStatusDraft, settlementStatusRevisionRequested},
+			To:   settlementStatusSubmitted,
+		},
+		"request-revision": {
+			From:            []string{settlementStatusSubmitted},
+			To:              settlementStatusRevisionRequested,
+			Roles:           []string{"fund_verifier"},
+			CommentRequired: true,
+		},
+		"approve": {
+			From:  []string{settlementStatusSubmitted},
+			To:    settlementStatusApproved,
+			Roles: []string{"fund_verifier"},
+		},
+		"settle": {
+			From:  []string{settlementStatusApproved},
+			To:    settlementStatusSettled,
+			Roles: []string{"treasurer"},
+		},
+	},
+}
+
+// settlementEditable reports whether the settlement's lines may still change.
+func settlementEditable(status string) bool {
+	return status == settlementStatusDraft || status == settlementStatusRevisionRequested
+}